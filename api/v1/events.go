@@ -0,0 +1,197 @@
+// api/v1/events.go
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/core"
+	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// EventsHandler exposes the detection engine's device statuses and event stream.
+type EventsHandler struct {
+	engine *core.Engine
+}
+
+// DeviceStatusResponse is the API representation of a device's merged health status.
+type DeviceStatusResponse struct {
+	DeviceType     string                `json:"device_type"`
+	DeviceID       string                `json:"device_id"`
+	Status         string                `json:"status"`
+	Confidence     float64               `json:"confidence"`
+	Recommendation string                `json:"recommendation"`
+	Since          string                `json:"since"`
+	Findings       []core.FindingPayload `json:"findings"`
+}
+
+// EventResponse is the API representation of an engine event.
+type EventResponse struct {
+	Type      string               `json:"type"`
+	Timestamp string               `json:"timestamp"`
+	Previous  string               `json:"previous_status"`
+	Device    DeviceStatusResponse `json:"device"`
+}
+
+// ContributionResponse is one device's share of the host health score.
+type ContributionResponse struct {
+	DeviceType    string   `json:"device_type"`
+	DeviceID      string   `json:"device_id"`
+	Tier          string   `json:"service_tier"`
+	Status        string   `json:"status"`
+	Score         float64  `json:"score"`
+	Impact        float64  `json:"impact"`
+	InheritedFrom string   `json:"inherited_from,omitempty"`
+	Reasons       []string `json:"reasons,omitempty"`
+}
+
+// HostScoreResponse is the API representation of the host's composite health score.
+type HostScoreResponse struct {
+	Host      string                 `json:"host"`
+	Score     float64                `json:"score"`
+	Status    string                 `json:"status"`
+	ByType    map[string]float64     `json:"by_device_type"`
+	Breakdown []ContributionResponse `json:"breakdown"`
+}
+
+// NewEventsHandler creates a new EventsHandler instance.
+func NewEventsHandler(engine *core.Engine) *EventsHandler {
+	return &EventsHandler{
+		engine: engine,
+	}
+}
+
+// RegisterRoutes registers engine API routes.
+func (h *EventsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/health", h.handleHealth)
+	mux.HandleFunc("/api/v1/events", h.handleEvents)
+	mux.HandleFunc("/api/v1/health/score", h.handleScore)
+}
+
+// handleScore handles requests to /api/v1/health/score with the host's 0-100 health score
+// and the devices that contributed to it.
+func (h *EventsHandler) handleScore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	score := h.engine.HostScore()
+	response := HostScoreResponse{
+		Host:      score.Host,
+		Score:     score.Score,
+		Status:    score.Status.String(),
+		ByType:    make(map[string]float64, len(score.ByType)),
+		Breakdown: make([]ContributionResponse, 0, len(score.Breakdown)),
+	}
+	for t, s := range score.ByType {
+		response.ByType[t.String()] = s
+	}
+	for _, c := range score.Breakdown {
+		contribution := ContributionResponse{
+			DeviceType: c.DeviceType.String(),
+			DeviceID:   c.DeviceID,
+			Tier:       string(c.Tier),
+			Status:     c.Status.String(),
+			Score:      c.Score,
+			Impact:     c.Impact,
+		}
+		if c.InheritedFrom != nil {
+			contribution.InheritedFrom = c.InheritedFrom.DeviceType.String() + "/" + c.InheritedFrom.DeviceID
+		}
+		for _, f := range c.Findings {
+			contribution.Reasons = append(contribution.Reasons, f.Explain())
+		}
+		response.Breakdown = append(response.Breakdown, contribution)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("failed to encode health score response", zap.Error(err))
+	}
+}
+
+// handleHealth handles requests to /api/v1/health with the latest status of every device.
+func (h *EventsHandler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := h.engine.Statuses()
+	response := make([]DeviceStatusResponse, 0, len(statuses))
+	for _, status := range statuses {
+		response = append(response, toDeviceStatusResponse(status))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("failed to encode health response", zap.Error(err))
+	}
+}
+
+// handleEvents handles requests to /api/v1/events, streaming state changes as server-sent
+// events. Pass all=true to also receive every check.
+func (h *EventsHandler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	types := []core.EventType{core.EventStateChange}
+	if r.URL.Query().Get("all") == "true" {
+		types = nil
+	}
+	events, unsubscribe := h.engine.Subscribe(types...)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(EventResponse{
+				Type:      event.Type.String(),
+				Timestamp: event.At.UTC().Format(time.RFC3339),
+				Previous:  event.Previous.String(),
+				Device:    toDeviceStatusResponse(event.Status),
+			})
+			if err != nil {
+				logger.Error("failed to encode event", zap.Error(err))
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func toDeviceStatusResponse(status detection.HealthStatus) DeviceStatusResponse {
+	return DeviceStatusResponse{
+		DeviceType:     status.DeviceType.String(),
+		DeviceID:       status.DeviceID,
+		Status:         status.Status.String(),
+		Confidence:     status.Confidence,
+		Recommendation: status.Recommendation,
+		Since:          status.Since.UTC().Format(time.RFC3339),
+		Findings:       core.NewFindingPayloads(status.Findings),
+	}
+}
@@ -9,7 +9,10 @@ import (
 	"time"
 
 	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core"
 	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
 )
 
 // NetworkAPI handles network-related REST API endpoints.
@@ -23,10 +26,12 @@ type NetworkAPI struct {
 
 // NetworkHealthResponse represents the response for the /api/v1/network/health endpoint.
 type NetworkHealthResponse struct {
+	Interface   string  `json:"interface"`
 	Timestamp   string  `json:"timestamp"`
 	LatencyMs   float64 `json:"latency_ms"`
 	PacketLoss  float64 `json:"packet_loss_percent"`
 	IsHealthy   bool    `json:"is_healthy"`
+	Findings    []core.FindingPayload `json:"findings,omitempty"`
 	Error       string  `json:"error,omitempty"`
 }
 
@@ -60,20 +65,32 @@ func (n *NetworkAPI) handleHealth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	iface := r.URL.Query().Get("interface")
+	if iface == "" {
+		http.Error(w, "interface is required", http.StatusBadRequest)
+		return
+	}
+
 	// Perform network health check
-	latency, packetLoss, err := n.detector.CheckHealth()
 	response := NetworkHealthResponse{
-		Timestamp:  time.Now().UTC().Format(time.RFC3339),
-		LatencyMs:  latency,
-		PacketLoss: packetLoss,
-		IsHealthy:  err == nil,
+		Interface: iface,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	status, err := n.detector.CheckSubHealth(iface)
+	if err == nil {
+		if metrics, ok := status.Metrics.(*ebpf.NetworkMetrics); ok {
+			response.LatencyMs = float64(metrics.LatencyP95) / float64(time.Millisecond)
+			response.PacketLoss = metrics.PacketLossRate * 100
+		}
+		response.IsHealthy = status.Status == enum.Healthy
+		response.Findings = core.NewFindingPayloads(status.Findings)
 	}
 
 	if err != nil {
 		response.Error = err.Error()
 		n.logger.Error("Network health check failed", "error", err)
 	} else {
-		n.logger.Info("Network health check completed", "latency_ms", latency, "packet_loss_percent", packetLoss)
+		n.logger.Info("Network health check completed", "interface", iface, "latency_ms", response.LatencyMs, "packet_loss_percent", response.PacketLoss)
 	}
 
 	// Send response
@@ -0,0 +1,216 @@
+// api/v1/prediction.go
+package v1
+
+import (
+	"encoding/json"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/prediction"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PredictionHandler serves failure predictions for disks, RAID controllers and network
+// interfaces alike.
+type PredictionHandler struct {
+	predictor *prediction.ModelPredictor
+}
+
+// AttributionResponse is one feature's contribution to a prediction.
+type AttributionResponse struct {
+	Feature      string  `json:"feature"`
+	Value        float64 `json:"value"`
+	Contribution float64 `json:"contribution"`
+}
+
+// PredictionResponse is the API representation of a device's failure prediction.
+type PredictionResponse struct {
+	DeviceType            string                `json:"device_type"`
+	DeviceID              string                `json:"device_id"`
+	FailureProbability    float64               `json:"failure_probability"`
+	Horizons              map[string]float64    `json:"horizon_probabilities"`
+	RiskLevel             string                `json:"risk_level"`
+	RemainingLifeDays     float64               `json:"remaining_life_days"`
+	RemainingLifeLowDays  float64               `json:"remaining_life_p10_days"`
+	RemainingLifeHighDays float64               `json:"remaining_life_p90_days"`
+	PredictedFailureTime  string                `json:"predicted_failure_time,omitempty"`
+	Confidence            float64               `json:"confidence"`
+	DriftedFeatures       []string              `json:"drifted_features,omitempty"` // Inputs whose fleet-wide distribution left the training data's
+	ModelVersion          string                `json:"model_version"`
+	ContributingFactors   []string              `json:"contributing_factors,omitempty"`
+	Recommendations       []string              `json:"recommendations,omitempty"`
+	Attributions          []AttributionResponse `json:"attributions,omitempty"`
+}
+
+// CalibrationBinResponse compares the predicted and observed failure rates of one
+// probability bin.
+type CalibrationBinResponse struct {
+	Lower         float64 `json:"lower"`
+	Upper         float64 `json:"upper"`
+	Count         int     `json:"count"`
+	MeanPredicted float64 `json:"mean_predicted"`
+	ObservedRate  float64 `json:"observed_rate"`
+}
+
+// QualityResponse is the API representation of how well a model version's predictions
+// came true.
+type QualityResponse struct {
+	DeviceType   string                   `json:"device_type"`
+	ModelVersion string                   `json:"model_version"`
+	Resolved     int                      `json:"resolved"`
+	Failed       int                      `json:"failed"`
+	Pending      int                      `json:"pending"`
+	Threshold    float64                  `json:"threshold"`
+	Precision    float64                  `json:"precision"`
+	Recall       float64                  `json:"recall"`
+	AUC          float64                  `json:"auc"`
+	Brier        float64                  `json:"brier"`
+	Calibration  []CalibrationBinResponse `json:"calibration"`
+}
+
+// NewPredictionHandler creates a new PredictionHandler instance.
+func NewPredictionHandler(predictor *prediction.ModelPredictor) *PredictionHandler {
+	return &PredictionHandler{
+		predictor: predictor,
+	}
+}
+
+// RegisterRoutes registers prediction API routes.
+func (h *PredictionHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/predictions", h.handlePrediction)
+	mux.HandleFunc("/api/v1/predictions/quality", h.handleQuality)
+}
+
+// handlePrediction handles requests to /api/v1/predictions?device_type=raid&device_id=host0.
+// An optional model_version predicts with a registered model other than the active one.
+func (h *PredictionHandler) handlePrediction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceType, err := enum.ParseDeviceType(r.URL.Query().Get("device_type"))
+	if err != nil {
+		http.Error(w, "device_type must be disk, raid or network", http.StatusBadRequest)
+		return
+	}
+	deviceID := r.URL.Query().Get("device_id")
+	if deviceID == "" {
+		http.Error(w, "device_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var result prediction.PredictionResult
+	if version := r.URL.Query().Get("model_version"); version != "" {
+		result, err = h.predictor.PredictVersion(deviceType, deviceID, version)
+	} else {
+		result, err = h.predictor.Predict(deviceType, deviceID)
+	}
+	if err != nil {
+		logger.Error("failed to predict device failure",
+			zap.String("device_type", deviceType.String()),
+			zap.String("device_id", deviceID),
+			zap.Error(err))
+		if errors.Is(err, errors.NewNotFound("", nil)) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	response := PredictionResponse{
+		DeviceType:            result.DeviceType.String(),
+		DeviceID:              result.DeviceID,
+		FailureProbability:    result.FailureProbability,
+		Horizons:              make(map[string]float64, len(result.Horizons)),
+		RiskLevel:             string(result.RiskLevel),
+		RemainingLifeDays:     result.RemainingLife.Hours() / 24,
+		RemainingLifeLowDays:  result.RemainingLifeLow.Hours() / 24,
+		RemainingLifeHighDays: result.RemainingLifeHigh.Hours() / 24,
+		Confidence:            result.Confidence,
+		ModelVersion:          result.ModelVersion,
+		ContributingFactors:   result.ContributingFactors,
+		Recommendations:       result.Recommendations,
+	}
+	for _, p := range result.Horizons {
+		response.Horizons[strconv.Itoa(p.Days)+"d"] = p.Probability
+	}
+	if !result.PredictedFailureTime.IsZero() {
+		response.PredictedFailureTime = result.PredictedFailureTime.Format(time.RFC3339)
+	}
+	for _, f := range result.Drift.Features {
+		response.DriftedFeatures = append(response.DriftedFeatures, f.Feature)
+	}
+	for _, a := range result.Attributions {
+		response.Attributions = append(response.Attributions, AttributionResponse{
+			Feature:      a.Feature,
+			Value:        a.Value,
+			Contribution: a.Contribution,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("failed to encode prediction response", zap.Error(err))
+	}
+}
+
+// handleQuality handles requests to /api/v1/predictions/quality, optionally filtered by
+// device_type and model_version.
+func (h *PredictionHandler) handleQuality(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := h.predictor.Tracker()
+	if tracker == nil {
+		http.Error(w, "prediction quality tracking is not enabled", http.StatusNotFound)
+		return
+	}
+
+	typeName, version := r.URL.Query().Get("device_type"), r.URL.Query().Get("model_version")
+	if typeName != "" {
+		if _, err := enum.ParseDeviceType(typeName); err != nil {
+			http.Error(w, "device_type must be disk, raid or network", http.StatusBadRequest)
+			return
+		}
+	}
+
+	response := []QualityResponse{}
+	for _, report := range tracker.Reports(time.Now()) {
+		if (typeName != "" && report.DeviceType.String() != typeName) || (version != "" && report.ModelVersion != version) {
+			continue
+		}
+		q := QualityResponse{
+			DeviceType:   report.DeviceType.String(),
+			ModelVersion: report.ModelVersion,
+			Resolved:     report.Samples,
+			Failed:       report.Positives,
+			Pending:      report.Pending,
+			Threshold:    report.Threshold,
+			Precision:    report.Precision,
+			Recall:       report.Recall,
+			AUC:          report.AUC,
+			Brier:        report.Brier,
+		}
+		for _, b := range report.Calibration {
+			q.Calibration = append(q.Calibration, CalibrationBinResponse{
+				Lower:         b.Lower,
+				Upper:         b.Upper,
+				Count:         b.Count,
+				MeanPredicted: b.MeanPredicted,
+				ObservedRate:  b.ObservedRate,
+			})
+		}
+		response = append(response, q)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("failed to encode prediction quality response", zap.Error(err))
+	}
+}
@@ -9,20 +9,19 @@ import (
 	"github.com/turtacn/ioshelfer/internal/common/errors"
 	"github.com/turtacn/ioshelfer/internal/common/logger"
 	"github.com/turtacn/ioshelfer/internal/common/types/enum"
-	"github.com/turtacn/ioshelfer/internal/core/detection"
 	"github.com/turtacn/ioshelfer/pkg/raid"
 	"go.uber.org/zap"
 )
 
 // RAIDHandler handles RAID-related API requests.
 type RAIDHandler struct {
-	detector *detection.Detector
+	controller raid.Controller
 }
 
 // NewRAIDHandler creates a new RAIDHandler instance.
-func NewRAIDHandler(detector *detection.Detector) *RAIDHandler {
+func NewRAIDHandler(controller raid.Controller) *RAIDHandler {
 	return &RAIDHandler{
-		detector: detector,
+		controller: controller,
 	}
 }
 
@@ -47,7 +46,7 @@ func (h *RAIDHandler) handleControllersHealth(w http.ResponseWriter, r *http.Req
 	}
 
 	// Perform health check using the detector
-	healthStatus, err := h.detector.CheckRAIDHealth(controllerID)
+	healthStatus, err := h.controller.CheckHealth(controllerID)
 	if err != nil {
 		logger.Error("failed to check RAID controller health",
 			zap.String("controller_id", controllerID),
@@ -106,7 +105,7 @@ func (h *RAIDHandler) handleControllersMetrics(w http.ResponseWriter, r *http.Re
 	}
 
 	// Get RAID metrics
-	metrics, err := h.detector.GetRAIDMetrics(controllerID)
+	metrics, err := h.controller.GetMetrics(controllerID)
 	if err != nil {
 		logger.Error("failed to get RAID controller metrics",
 			zap.String("controller_id", controllerID),
@@ -0,0 +1,349 @@
+// api/v1/remediation.go
+package v1
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/remediation"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RemediationHandler serves the remediation approval queue, the persisted remediation
+// actions and their audit log. Approvals and rejections are recorded under the identity the
+// request authenticates with: the common name of a verified TLS client certificate, or the
+// approver a bearer token is issued to. Without either, decisions are refused.
+type RemediationHandler struct {
+	workflow  *remediation.Workflow
+	state     *remediation.StateStore
+	approvers map[string]string // Approver name by bearer token
+}
+
+// RemediationResultResponse is the API representation of an executed remediation action.
+type RemediationResultResponse struct {
+	Action  string   `json:"action"`
+	Success bool     `json:"success"`
+	DryRun  bool     `json:"dry_run,omitempty"`
+	Steps   []string `json:"steps,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// PendingActionResponse is the API representation of an isolation queued for approval.
+type PendingActionResponse struct {
+	ID          string                     `json:"id"`
+	DeviceType  string                     `json:"device_type"`
+	DeviceID    string                     `json:"device_id"`
+	ServiceTier string                     `json:"service_tier"`
+	Strategy    string                     `json:"strategy"`
+	Reason      string                     `json:"reason"`
+	State       string                     `json:"state"`
+	Created     string                     `json:"created"`
+	Expires     string                     `json:"expires"`
+	DecidedBy   string                     `json:"decided_by,omitempty"`
+	DecidedAt   string                     `json:"decided_at,omitempty"`
+	Comment     string                     `json:"comment,omitempty"`
+	Result      *RemediationResultResponse `json:"result,omitempty"`
+}
+
+// ActionRecordResponse is the API representation of a persisted remediation action.
+type ActionRecordResponse struct {
+	ID         string                `json:"id"`
+	DeviceType string                `json:"device_type"`
+	DeviceID   string                `json:"device_id"`
+	Strategy   string                `json:"strategy"`
+	Status     string                `json:"status"`
+	Trigger    string                `json:"trigger"`
+	Evidence   *remediation.Evidence `json:"evidence,omitempty"`
+	Message    string                `json:"message,omitempty"`
+	Steps      []string              `json:"steps,omitempty"`
+	Error      string                `json:"error,omitempty"`
+	Created    string                `json:"created"`
+	Updated    string                `json:"updated"`
+}
+
+// AuditEntryResponse is the API representation of a remediation action's state transition.
+type AuditEntryResponse struct {
+	Timestamp  string `json:"timestamp"`
+	ActionID   string `json:"action_id"`
+	DeviceType string `json:"device_type"`
+	DeviceID   string `json:"device_id"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to"`
+	Trigger    string `json:"trigger"`
+	Message    string `json:"message,omitempty"`
+}
+
+// NewRemediationHandler creates a new RemediationHandler instance. Approvers maps bearer
+// tokens to the names decisions are recorded under; with none, only clients with verified
+// TLS certificates can decide pending actions. Without a workflow, the action routes answer
+// 404, and without state, as when actions are not persisted, so do the state and audit
+// routes.
+func NewRemediationHandler(workflow *remediation.Workflow, state *remediation.StateStore, approvers map[string]string) *RemediationHandler {
+	return &RemediationHandler{
+		workflow:  workflow,
+		state:     state,
+		approvers: approvers,
+	}
+}
+
+// RegisterRoutes registers remediation API routes.
+func (h *RemediationHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/remediation/actions", h.handleActions)
+	mux.HandleFunc("/api/v1/remediation/actions/approve", h.handleApprove)
+	mux.HandleFunc("/api/v1/remediation/actions/reject", h.handleReject)
+	mux.HandleFunc("/api/v1/remediation/state", h.handleState)
+	mux.HandleFunc("/api/v1/remediation/audit", h.handleAudit)
+}
+
+// handleActions handles requests to /api/v1/remediation/actions with the pending actions,
+// including recently decided ones with all=true.
+func (h *RemediationHandler) handleActions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.workflow == nil {
+		http.Error(w, "remediation workflow is not enabled", http.StatusNotFound)
+		return
+	}
+	actions := h.workflow.Actions(r.URL.Query().Get("all") == "true")
+	response := make([]PendingActionResponse, 0, len(actions))
+	for _, a := range actions {
+		response = append(response, toPendingActionResponse(a))
+	}
+	h.writeJSON(w, response)
+}
+
+// handleApprove handles authenticated POST requests to
+// /api/v1/remediation/actions/approve?id=..., executing the action.
+func (h *RemediationHandler) handleApprove(w http.ResponseWriter, r *http.Request) {
+	id, by, ok := h.decision(w, r)
+	if !ok {
+		return
+	}
+	if h.workflow == nil {
+		http.Error(w, "remediation workflow is not enabled", http.StatusNotFound)
+		return
+	}
+	action, err := h.workflow.Approve(id, by)
+	if action.ID == "" {
+		h.writeError(w, id, err)
+		return
+	}
+	if err != nil {
+		logger.Error("approved action failed", zap.String("id", id), zap.Error(err))
+	}
+	h.writeJSON(w, toPendingActionResponse(action))
+}
+
+// handleReject handles authenticated POST requests to
+// /api/v1/remediation/actions/reject?id=...&comment=....
+func (h *RemediationHandler) handleReject(w http.ResponseWriter, r *http.Request) {
+	id, by, ok := h.decision(w, r)
+	if !ok {
+		return
+	}
+	if h.workflow == nil {
+		http.Error(w, "remediation workflow is not enabled", http.StatusNotFound)
+		return
+	}
+	action, err := h.workflow.Reject(id, by, r.URL.Query().Get("comment"))
+	if err != nil {
+		h.writeError(w, id, err)
+		return
+	}
+	h.writeJSON(w, toPendingActionResponse(action))
+}
+
+// handleState handles requests to /api/v1/remediation/state with the persisted actions,
+// oldest first.
+func (h *RemediationHandler) handleState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.state == nil {
+		http.Error(w, "remediation state is not enabled", http.StatusNotFound)
+		return
+	}
+	records := h.state.Actions()
+	response := make([]ActionRecordResponse, 0, len(records))
+	for _, a := range records {
+		response = append(response, ActionRecordResponse{
+			ID:         a.ID,
+			DeviceType: a.DeviceType.String(),
+			DeviceID:   a.DeviceID,
+			Strategy:   a.Strategy.String(),
+			Status:     string(a.Status),
+			Trigger:    a.Trigger,
+			Evidence:   a.Evidence,
+			Message:    a.Message,
+			Steps:      a.Steps,
+			Error:      a.Error,
+			Created:    a.Created.UTC().Format(time.RFC3339),
+			Updated:    a.Updated.UTC().Format(time.RFC3339),
+		})
+	}
+	h.writeJSON(w, response)
+}
+
+// handleAudit handles requests to
+// /api/v1/remediation/audit?device_type=...&device_id=...&action_id=...&since=...&limit=...
+// with the matching state transitions, oldest first. since is an RFC 3339 time and limit
+// keeps the most recent entries.
+func (h *RemediationHandler) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.state == nil {
+		http.Error(w, "remediation state is not enabled", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	q := remediation.AuditQuery{DeviceID: query.Get("device_id"), ActionID: query.Get("action_id")}
+	if name := query.Get("device_type"); name != "" {
+		deviceType, err := enum.ParseDeviceType(name)
+		if err != nil {
+			http.Error(w, "device_type must be disk, raid or network", http.StatusBadRequest)
+			return
+		}
+		q.DeviceTypes = []enum.DeviceType{deviceType}
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		q.Since = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	entries, err := h.state.Audit(q)
+	if err != nil {
+		logger.Error("failed to query remediation audit log", zap.Error(err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	response := make([]AuditEntryResponse, 0, len(entries))
+	for _, e := range entries {
+		response = append(response, AuditEntryResponse{
+			Timestamp:  e.At.UTC().Format(time.RFC3339),
+			ActionID:   e.ActionID,
+			DeviceType: e.DeviceType.String(),
+			DeviceID:   e.DeviceID,
+			From:       string(e.From),
+			To:         string(e.To),
+			Trigger:    e.Trigger,
+			Message:    e.Message,
+		})
+	}
+	h.writeJSON(w, response)
+}
+
+// decision validates an approval or rejection request and returns the action and the
+// authenticated approver deciding it.
+func (h *RemediationHandler) decision(w http.ResponseWriter, r *http.Request) (id, by string, ok bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", "", false
+	}
+	by, ok = h.approver(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "deciding pending actions requires an approver token or client certificate", http.StatusUnauthorized)
+		return "", "", false
+	}
+	id = r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return "", "", false
+	}
+	return id, by, true
+}
+
+// approver returns the identity a request authenticates with, if any.
+func (h *RemediationHandler) approver(r *http.Request) (string, bool) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if name := r.TLS.VerifiedChains[0][0].Subject.CommonName; name != "" {
+			return name, true
+		}
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return "", false
+	}
+	for t, name := range h.approvers {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (h *RemediationHandler) writeError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, errors.NewNotFound("", nil)):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errors.NewInvalidState("", nil)):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		logger.Error("failed to decide pending action", zap.String("id", id), zap.Error(err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *RemediationHandler) writeJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("failed to encode remediation response", zap.Error(err))
+	}
+}
+
+func toPendingActionResponse(a remediation.PendingAction) PendingActionResponse {
+	response := PendingActionResponse{
+		ID:          a.ID,
+		DeviceType:  a.DeviceType.String(),
+		DeviceID:    a.DeviceID,
+		ServiceTier: string(a.Tier),
+		Strategy:    a.Strategy.String(),
+		Reason:      a.Reason,
+		State:       string(a.State),
+		Created:     a.Created.UTC().Format(time.RFC3339),
+		Expires:     a.Expires.UTC().Format(time.RFC3339),
+		DecidedBy:   a.DecidedBy,
+		Comment:     a.Comment,
+	}
+	if !a.DecidedAt.IsZero() {
+		response.DecidedAt = a.DecidedAt.UTC().Format(time.RFC3339)
+	}
+	if a.Result != nil {
+		response.Result = &RemediationResultResponse{
+			Action:  a.Result.Action,
+			Success: a.Result.Success,
+			DryRun:  a.Result.DryRun,
+			Steps:   a.Result.Steps,
+		}
+		if a.Result.Error != nil {
+			response.Result.Error = a.Result.Error.Error()
+		}
+	}
+	return response
+}
@@ -4,16 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	v1 "github.com/turtacn/ioshelfer/api/v1"
 	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
 	"github.com/turtacn/ioshelfer/internal/core/detection"
 	"github.com/turtacn/ioshelfer/internal/core/prediction"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
 )
 
 // 版本信息
@@ -28,7 +35,7 @@ var (
 	cfgFile   string
 	cfg       *config.Config
 	detector  *detection.Detector
-	predictor *prediction.Predictor
+	predictor *prediction.ModelPredictor
 	log       = logger.NewLogger()
 )
 
@@ -67,6 +74,11 @@ func init() {
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(trainCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(backtestCmd)
+	rootCmd.AddCommand(modelCmd)
+	rootCmd.AddCommand(remediationCmd)
 }
 
 // initConfig 初始化配置
@@ -174,16 +186,18 @@ func runHealthcheck(cmd *cobra.Command, args []string) error {
 // predictCmd 预测命令
 var predictCmd = &cobra.Command{
 	Use:   "predict [device]",
-	Short: "Predict disk failure probability",
-	Long: `Analyze disk metrics and predict failure probability using machine learning models.
-This command provides failure risk assessment and recommended actions.`,
+	Short: "Predict device failure probability",
+	Long: `Analyze disk, RAID controller or network interface metrics and predict failure
+probability using machine learning models. This command provides failure risk assessment
+and recommended actions.`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: runPredict,
 }
 
 func init() {
 	predictCmd.Flags().Bool("all", false, "predict for all devices")
-	predictCmd.Flags().String("model", "default", "prediction model to use")
+	predictCmd.Flags().String("type", "disk", "device type to predict for (disk, raid, network)")
+	predictCmd.Flags().String("model", "default", "model version to predict with (default: the active model)")
 	predictCmd.Flags().Int("days", 30, "prediction time horizon in days")
 	predictCmd.Flags().Bool("explain", false, "include prediction explanation")
 }
@@ -194,6 +208,11 @@ func runPredict(cmd *cobra.Command, args []string) error {
 	}
 
 	all, _ := cmd.Flags().GetBool("all")
+	typeName, _ := cmd.Flags().GetString("type")
+	deviceType, err := enum.ParseDeviceType(typeName)
+	if err != nil {
+		return err
+	}
 	model, _ := cmd.Flags().GetString("model")
 	days, _ := cmd.Flags().GetInt("days")
 	explain, _ := cmd.Flags().GetBool("explain")
@@ -210,7 +229,7 @@ func runPredict(cmd *cobra.Command, args []string) error {
 	results := make([]*PredictionResult, 0)
 
 	for _, device := range devices {
-		result, err := performPrediction(device, model, days, explain)
+		result, err := performPrediction(deviceType, device, model, days, explain)
 		if err != nil {
 			log.Errorf("Prediction failed for %s: %v", device, err)
 			continue
@@ -221,6 +240,307 @@ func runPredict(cmd *cobra.Command, args []string) error {
 	return outputResults(cmd, "prediction", results)
 }
 
+// trainCmd 训练命令
+var trainCmd = &cobra.Command{
+	Use:   "train",
+	Short: "Train a failure prediction model from stored history",
+	Long: `Build time-windowed training samples from stored device history and a labels file
+of failures and replacements, fit a model, evaluate it on the most recent samples and
+add the versioned model to the registry in the output directory. Active models serve
+predictions at once; shadow models are scored alongside the active one for comparison.
+
+The labels file is a CSV with device_type,device_id,event,timestamp columns, where event
+is "failure" or "replacement" and timestamp is RFC 3339.`,
+	RunE: runTrain,
+}
+
+func init() {
+	defaults := prediction.DefaultTrainingConfig(enum.Disk)
+	trainCmd.Flags().String("type", "disk", "device type to train for (disk, raid, network)")
+	trainCmd.Flags().String("labels", "", "labels CSV file of failures and replacements")
+	trainCmd.Flags().String("data-dir", "/var/lib/ioshelfer", "metrics storage directory")
+	trainCmd.Flags().String("output", "/var/lib/ioshelfer/models", "model output directory")
+	trainCmd.Flags().StringSlice("devices", nil, "additional devices to sample")
+	trainCmd.Flags().Duration("history", defaults.History, "how far back history is read, unless --from is given")
+	trainCmd.Flags().String("from", "", "first day of history to read, e.g., of imported data (YYYY-MM-DD)")
+	trainCmd.Flags().String("to", "", "last day of history to read (YYYY-MM-DD, default: all)")
+	trainCmd.Flags().Duration("lookback", defaults.Lookback, "history window features are extracted from")
+	trainCmd.Flags().Duration("horizon", defaults.Horizon, "failures within this horizon label a sample positive")
+	trainCmd.Flags().Duration("stride", defaults.Stride, "interval between samples of one device")
+	trainCmd.Flags().Float64("holdout", defaults.HoldoutFraction, "share of the most recent samples held out for evaluation")
+	trainCmd.Flags().Float64("threshold", defaults.Threshold, "probability at which a device counts as predicted to fail")
+	trainCmd.Flags().Bool("balance", defaults.Balance, "weight failed and healthy samples equally")
+	trainCmd.Flags().String("base-model", "", "model file to fine-tune, e.g., one trained on imported public data")
+	trainCmd.Flags().String("stage", string(defaults.Stage), "register the model as active, shadow or candidate")
+	trainCmd.MarkFlagRequired("labels")
+}
+
+// TrainingResult 训练结果
+type TrainingResult struct {
+	DeviceType     string    `json:"device_type"`
+	ModelVersion   string    `json:"model_version"`
+	ModelPath      string    `json:"model_path"`
+	Samples        int       `json:"samples"`
+	Positives      int       `json:"positives"`
+	TrainSamples   int       `json:"train_samples"`
+	HoldoutSamples int       `json:"holdout_samples"`
+	Precision      float64   `json:"holdout_precision"`
+	Recall         float64   `json:"holdout_recall"`
+	AUC            float64   `json:"holdout_auc"`
+	TrainedAt      time.Time `json:"trained_at"`
+}
+
+func runTrain(cmd *cobra.Command, args []string) error {
+	typeName, _ := cmd.Flags().GetString("type")
+	deviceType, err := enum.ParseDeviceType(typeName)
+	if err != nil {
+		return err
+	}
+
+	config := prediction.DefaultTrainingConfig(deviceType)
+	config.Devices, _ = cmd.Flags().GetStringSlice("devices")
+	config.History, _ = cmd.Flags().GetDuration("history")
+	if from, _ := cmd.Flags().GetString("from"); from != "" {
+		if config.From, err = time.Parse("2006-01-02", from); err != nil {
+			return fmt.Errorf("invalid --from date: %w", err)
+		}
+	}
+	if to, _ := cmd.Flags().GetString("to"); to != "" {
+		if config.To, err = time.Parse("2006-01-02", to); err != nil {
+			return fmt.Errorf("invalid --to date: %w", err)
+		}
+		config.To = config.To.Add(24*time.Hour - time.Nanosecond)
+	}
+	config.Lookback, _ = cmd.Flags().GetDuration("lookback")
+	config.Horizon, _ = cmd.Flags().GetDuration("horizon")
+	config.Stride, _ = cmd.Flags().GetDuration("stride")
+	config.HoldoutFraction, _ = cmd.Flags().GetFloat64("holdout")
+	config.Threshold, _ = cmd.Flags().GetFloat64("threshold")
+	config.Balance, _ = cmd.Flags().GetBool("balance")
+	stage, _ := cmd.Flags().GetString("stage")
+	config.Stage = prediction.ModelStatus(stage)
+
+	if baseModel, _ := cmd.Flags().GetString("base-model"); baseModel != "" {
+		model, err := prediction.LoadModel(baseModel)
+		if err != nil {
+			return fmt.Errorf("failed to load base model: %w", err)
+		}
+		initial, ok := model.(*prediction.LogisticModel)
+		if !ok {
+			return fmt.Errorf("base model kind %s cannot be fine-tuned", model.Info().Kind)
+		}
+		config.Train.Initial = initial
+	}
+
+	labelsPath, _ := cmd.Flags().GetString("labels")
+	labels, err := prediction.LoadLabels(labelsPath)
+	if err != nil {
+		return fmt.Errorf("failed to load labels: %w", err)
+	}
+
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	store, err := storage.NewFileStorage(dataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	// Outcomes are only known up to the end of the range, so samples are censored there.
+	censored := time.Now()
+	if !config.To.IsZero() && config.To.Before(censored) {
+		censored = config.To
+	}
+	output, _ := cmd.Flags().GetString("output")
+	report, err := prediction.Train(store, labels, config, output, censored)
+	if err != nil {
+		return fmt.Errorf("failed to train model: %w", err)
+	}
+
+	info := report.Model.Info()
+	return outputResults(cmd, "training", &TrainingResult{
+		DeviceType:     deviceType.String(),
+		ModelVersion:   info.Version,
+		ModelPath:      report.Path,
+		Samples:        report.Samples,
+		Positives:      report.Positives,
+		TrainSamples:   report.TrainSamples,
+		HoldoutSamples: report.HoldoutSamples,
+		Precision:      report.Holdout.Precision,
+		Recall:         report.Holdout.Recall,
+		AUC:            report.Holdout.AUC,
+		TrainedAt:      info.TrainedAt,
+	})
+}
+
+// importCmd 公共数据集导入命令
+var importCmd = &cobra.Command{
+	Use:   "import-backblaze [file...]",
+	Short: "Import Backblaze drive stats as disk SMART history",
+	Long: `Stream Backblaze-format daily drive stats CSVs into storage as disk SMART history and
+write the drive failures they record to a labels file, so a disk model can be bootstrapped
+on public data with "train" and later fine-tuned on local history with --base-model.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runImport,
+}
+
+func init() {
+	importCmd.Flags().String("data-dir", "/var/lib/ioshelfer", "metrics storage directory")
+	importCmd.Flags().String("labels-out", "backblaze-labels.csv", "labels CSV file to write")
+	importCmd.Flags().StringSlice("models", nil, "only import these drive models")
+	importCmd.Flags().String("since", "", "skip days before this date (YYYY-MM-DD)")
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	store, err := storage.NewFileStorage(dataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	options := prediction.ImportOptions{}
+	options.Models, _ = cmd.Flags().GetStringSlice("models")
+	if since, _ := cmd.Flags().GetString("since"); since != "" {
+		if options.Since, err = time.Parse("2006-01-02", since); err != nil {
+			return fmt.Errorf("invalid --since date: %w", err)
+		}
+	}
+
+	importer := prediction.NewBackblazeImporter(store, options)
+	for _, path := range args {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		err = importer.Import(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %w", path, err)
+		}
+	}
+
+	report := importer.Report()
+	labelsOut, _ := cmd.Flags().GetString("labels-out")
+	f, err := os.Create(labelsOut)
+	if err != nil {
+		return fmt.Errorf("failed to create labels file: %w", err)
+	}
+	defer f.Close()
+	if err := prediction.WriteLabels(f, report.Labels); err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d of %d rows for %d drives, %d failures labelled in %s\n",
+		report.Records, report.Rows, report.Devices, report.Failures, labelsOut)
+	return nil
+}
+
+// backtestCmd 回测命令
+var backtestCmd = &cobra.Command{
+	Use:   "backtest",
+	Short: "Replay stored history through a model as if it had run live",
+	Long: `Score every device at regular intervals between --from and --to on the history it had
+at the time, resolve each prediction against the failures and replacements in the labels
+file, and report precision, recall, Brier score and calibration as they would have been
+observed live. Predictions whose horizon has not passed yet are left out.`,
+	RunE: runBacktest,
+}
+
+func init() {
+	defaults := prediction.DefaultTrainingConfig(enum.Disk)
+	backtestCmd.Flags().String("type", "disk", "device type to backtest (disk, raid, network)")
+	backtestCmd.Flags().String("labels", "", "labels CSV file of failures and replacements")
+	backtestCmd.Flags().String("data-dir", "/var/lib/ioshelfer", "metrics storage directory")
+	backtestCmd.Flags().String("model-dir", "/var/lib/ioshelfer/models", "model registry directory")
+	backtestCmd.Flags().String("model", "", "model version to backtest (default: the active model)")
+	backtestCmd.Flags().String("from", "", "first day to replay (YYYY-MM-DD)")
+	backtestCmd.Flags().String("to", "", "last day to replay (YYYY-MM-DD, default: today)")
+	backtestCmd.Flags().Duration("lookback", defaults.Lookback, "history window features are extracted from")
+	backtestCmd.Flags().Duration("stride", defaults.Stride, "interval between predictions of one device")
+	backtestCmd.Flags().Float64("threshold", defaults.Threshold, "probability at which a device counts as predicted to fail")
+	backtestCmd.Flags().Int("bins", 10, "calibration bins")
+	backtestCmd.MarkFlagRequired("labels")
+	backtestCmd.MarkFlagRequired("from")
+}
+
+// BacktestResult 回测结果
+type BacktestResult struct {
+	DeviceType   string                      `json:"device_type"`
+	ModelVersion string                      `json:"model_version"`
+	From         time.Time                   `json:"from"`
+	To           time.Time                   `json:"to"`
+	Evaluation   prediction.Evaluation       `json:"evaluation"`
+	Calibration  []prediction.CalibrationBin `json:"calibration"`
+}
+
+func runBacktest(cmd *cobra.Command, args []string) error {
+	typeName, _ := cmd.Flags().GetString("type")
+	deviceType, err := enum.ParseDeviceType(typeName)
+	if err != nil {
+		return err
+	}
+
+	config := prediction.BacktestConfig{Training: prediction.DefaultTrainingConfig(deviceType)}
+	config.Training.Lookback, _ = cmd.Flags().GetDuration("lookback")
+	config.Training.Stride, _ = cmd.Flags().GetDuration("stride")
+	config.Threshold, _ = cmd.Flags().GetFloat64("threshold")
+	config.Bins, _ = cmd.Flags().GetInt("bins")
+	from, _ := cmd.Flags().GetString("from")
+	if config.From, err = time.Parse("2006-01-02", from); err != nil {
+		return fmt.Errorf("invalid --from date: %w", err)
+	}
+	config.To = time.Now()
+	if to, _ := cmd.Flags().GetString("to"); to != "" {
+		if config.To, err = time.Parse("2006-01-02", to); err != nil {
+			return fmt.Errorf("invalid --to date: %w", err)
+		}
+		config.To = config.To.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	registry, err := openRegistry(cmd)
+	if err != nil {
+		return err
+	}
+	var model prediction.Model
+	if version, _ := cmd.Flags().GetString("model"); version != "" {
+		if model, err = registry.Load(deviceType, version); err != nil {
+			return fmt.Errorf("failed to load model: %w", err)
+		}
+	} else {
+		var ok bool
+		if model, ok = registry.Active(deviceType); !ok {
+			return fmt.Errorf("no active %s model", deviceType)
+		}
+	}
+
+	labelsPath, _ := cmd.Flags().GetString("labels")
+	labels, err := prediction.LoadLabels(labelsPath)
+	if err != nil {
+		return fmt.Errorf("failed to load labels: %w", err)
+	}
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	store, err := storage.NewFileStorage(dataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	// Outcomes are only known up to the end of the range, so samples are censored there.
+	censored := time.Now()
+	if config.To.Before(censored) {
+		censored = config.To
+	}
+	report, _, err := prediction.Backtest(store, model, labels, config, censored)
+	if err != nil {
+		return fmt.Errorf("failed to backtest model: %w", err)
+	}
+	return outputResults(cmd, "backtest", &BacktestResult{
+		DeviceType:   deviceType.String(),
+		ModelVersion: report.ModelVersion,
+		From:         config.From,
+		To:           config.To,
+		Evaluation:   report.Evaluation,
+		Calibration:  report.Calibration,
+	})
+}
+
 // statusCmd 状态命令
 var statusCmd = &cobra.Command{
 	Use:   "status",
@@ -256,6 +576,10 @@ Available resources: devices, alerts, predictions, models`,
 	RunE: runList,
 }
 
+func init() {
+	listCmd.Flags().String("model-dir", "/var/lib/ioshelfer/models", "model registry directory, for listing models")
+}
+
 func runList(cmd *cobra.Command, args []string) error {
 	resource := args[0]
 
@@ -273,6 +597,145 @@ func runList(cmd *cobra.Command, args []string) error {
 	}
 }
 
+// modelCmd 模型管理命令
+var modelCmd = &cobra.Command{
+	Use:   "model [action] [type] [version]",
+	Short: "Manage registered prediction models",
+	Long: `Manage the prediction model registry. Changes take effect in running servers
+without a restart. Actions:
+  promote [type] [version]   make a version the active model
+  rollback [type]            reactivate the previously active model
+  shadow [type] [version]    score a version alongside the active model
+  unshadow [type] [version]  stop scoring a version in shadow`,
+	Args: cobra.RangeArgs(2, 3),
+	RunE: runModel,
+}
+
+func init() {
+	modelCmd.Flags().String("model-dir", "/var/lib/ioshelfer/models", "model registry directory")
+}
+
+func runModel(cmd *cobra.Command, args []string) error {
+	registry, err := openRegistry(cmd)
+	if err != nil {
+		return err
+	}
+
+	deviceType, err := enum.ParseDeviceType(args[1])
+	if err != nil {
+		return err
+	}
+	action, version := args[0], ""
+	if len(args) > 2 {
+		version = args[2]
+	} else if action != "rollback" {
+		return fmt.Errorf("%s requires a model version", action)
+	}
+
+	var record prediction.ModelRecord
+	switch action {
+	case "promote":
+		record, err = registry.Promote(deviceType, version)
+	case "rollback":
+		record, err = registry.Rollback(deviceType)
+	case "shadow":
+		record, err = registry.SetShadow(deviceType, version, true)
+	case "unshadow":
+		record, err = registry.SetShadow(deviceType, version, false)
+	default:
+		return fmt.Errorf("unknown action: %s", action)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s model: %w", action, err)
+	}
+	return outputResults(cmd, "model", record)
+}
+
+// remediationCmd 修复审批命令
+var remediationCmd = &cobra.Command{
+	Use:   "remediation [action] [id]",
+	Short: "Review remediation actions awaiting approval",
+	Long: `Review the isolations a running server queued for approval because their policy,
+change windows or rate limits did not allow them to run automatically. Actions:
+  list          list pending actions (--all includes recently decided ones)
+  approve [id]  execute a pending action
+  reject [id]   decline a pending action
+Decisions are authenticated with an approver token issued by the server and recorded
+under the approver's name.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runRemediation,
+}
+
+func init() {
+	remediationCmd.Flags().String("server", "http://localhost:8080", "ioshelfer API address")
+	remediationCmd.Flags().String("token", os.Getenv("IOSHELFER_TOKEN"), "approver token; decisions are recorded under the approver it is issued to")
+	remediationCmd.Flags().String("comment", "", "reason for a rejection")
+	remediationCmd.Flags().Bool("all", false, "include recently decided actions")
+	remediationCmd.Flags().Duration("timeout", 30*time.Second, "API request timeout")
+}
+
+func runRemediation(cmd *cobra.Command, args []string) error {
+	server, _ := cmd.Flags().GetString("server")
+	token, _ := cmd.Flags().GetString("token")
+	comment, _ := cmd.Flags().GetString("comment")
+	all, _ := cmd.Flags().GetBool("all")
+	client := &http.Client{Timeout: getTimeout(cmd)}
+
+	action := args[0]
+	query := url.Values{}
+	method, path := http.MethodPost, "/api/v1/remediation/actions/"+action
+	switch action {
+	case "list":
+		method, path = http.MethodGet, "/api/v1/remediation/actions"
+		if all {
+			query.Set("all", "true")
+		}
+	case "approve", "reject":
+		if len(args) < 2 {
+			return fmt.Errorf("%s requires an action ID", action)
+		}
+		if token == "" {
+			return fmt.Errorf("%s requires --token", action)
+		}
+		query.Set("id", args[1])
+		if comment != "" {
+			query.Set("comment", comment)
+		}
+	default:
+		return fmt.Errorf("unknown action: %s", action)
+	}
+
+	req, err := http.NewRequestWithContext(cmd.Context(), method, strings.TrimRight(server, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", server, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s failed: %s", action, strings.TrimSpace(string(body)))
+	}
+
+	var actions []v1.PendingActionResponse
+	if action == "list" {
+		err = json.NewDecoder(resp.Body).Decode(&actions)
+	} else {
+		var decided v1.PendingActionResponse
+		err = json.NewDecoder(resp.Body).Decode(&decided)
+		actions = append(actions, decided)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return outputResults(cmd, "actions", actions)
+}
+
 // configCmd 配置命令
 var configCmd = &cobra.Command{
 	Use:   "config [action]",
@@ -310,13 +773,23 @@ type HealthCheckResult struct {
 }
 
 type PredictionResult struct {
-	Device             string    `json:"device"`
-	FailureProbability float64   `json:"failure_probability"`
-	RiskLevel          string    `json:"risk_level"`
-	TimeHorizon        int       `json:"time_horizon_days"`
-	Factors            []string  `json:"contributing_factors,omitempty"`
-	Recommendations    []string  `json:"recommendations,omitempty"`
-	PredictionTime     time.Time `json:"prediction_time"`
+	DeviceType            string              `json:"device_type"`
+	Device                string              `json:"device"`
+	FailureProbability    float64             `json:"failure_probability"`
+	RiskLevel             string              `json:"risk_level"`
+	TimeHorizon           int                 `json:"time_horizon_days"`
+	Horizons              map[string]float64  `json:"horizon_probabilities"`
+	RemainingLifeDays     float64             `json:"remaining_life_days"`
+	RemainingLifeLowDays  float64             `json:"remaining_life_p10_days"`
+	RemainingLifeHighDays float64             `json:"remaining_life_p90_days"`
+	PredictedFailureTime  *time.Time          `json:"predicted_failure_time,omitempty"`
+	Factors               []string            `json:"contributing_factors,omitempty"`
+	Recommendations       []string            `json:"recommendations,omitempty"`
+	Confidence            float64             `json:"confidence"`
+	DriftedFeatures       []string            `json:"drifted_features,omitempty"`
+	ModelVersion          string              `json:"model_version"`
+	PredictionTime        time.Time           `json:"prediction_time"`
+	survival              prediction.Survival // 用于汇总预计故障数
 }
 
 type SystemStatus struct {
@@ -367,7 +840,9 @@ func performHealthCheck(ctx context.Context, device string, includeSMART, detail
 	result.Score = health.Score
 	result.Temperature = health.Temperature
 	if detailed {
-		result.Issues = health.Issues
+		for _, finding := range health.Findings {
+			result.Issues = append(result.Issues, finding.Explain())
+		}
 		if includeSMART {
 			result.SMARTData = health.SMARTData
 		}
@@ -376,24 +851,47 @@ func performHealthCheck(ctx context.Context, device string, includeSMART, detail
 	return result, nil
 }
 
-func performPrediction(device, model string, days int, explain bool) (*PredictionResult, error) {
+func performPrediction(deviceType enum.DeviceType, device, model string, days int, explain bool) (*PredictionResult, error) {
 	result := &PredictionResult{
+		DeviceType:     deviceType.String(),
 		Device:         device,
 		TimeHorizon:    days,
 		PredictionTime: time.Now(),
 	}
 
 	// 执行预测
-	prediction, err := predictor.Predict(device, model, days)
+	var pred prediction.PredictionResult
+	var err error
+	if model == "" || model == "default" {
+		pred, err = predictor.Predict(deviceType, device)
+	} else {
+		pred, err = predictor.PredictVersion(deviceType, device, model)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to predict for device %s: %w", device, err)
 	}
 
-	result.FailureProbability = prediction.Probability
-	result.RiskLevel = prediction.RiskLevel
+	result.FailureProbability = pred.Survival.FailureWithin(time.Duration(days) * 24 * time.Hour)
+	result.RiskLevel = string(pred.RiskLevel)
+	result.Horizons = make(map[string]float64, len(pred.Horizons))
+	for _, h := range pred.Horizons {
+		result.Horizons[fmt.Sprintf("%dd", h.Days)] = h.Probability
+	}
+	result.RemainingLifeDays = pred.RemainingLife.Hours() / 24
+	result.RemainingLifeLowDays = pred.RemainingLifeLow.Hours() / 24
+	result.RemainingLifeHighDays = pred.RemainingLifeHigh.Hours() / 24
+	if !pred.PredictedFailureTime.IsZero() {
+		result.PredictedFailureTime = &pred.PredictedFailureTime
+	}
+	result.Confidence = pred.Confidence
+	for _, f := range pred.Drift.Features {
+		result.DriftedFeatures = append(result.DriftedFeatures, f.Feature)
+	}
+	result.ModelVersion = pred.ModelVersion
+	result.survival = pred.Survival
 	if explain {
-		result.Factors = prediction.ContributingFactors
-		result.Recommendations = prediction.Recommendations
+		result.Factors = pred.ContributingFactors
+		result.Recommendations = pred.Recommendations
 	}
 
 	return result, nil
@@ -427,7 +925,11 @@ func listPredictions(cmd *cobra.Command) error {
 }
 
 func listModels(cmd *cobra.Command) error {
-	models, err := predictor.ListModels()
+	registry, err := openRegistry(cmd)
+	if err != nil {
+		return err
+	}
+	models, err := registry.List()
 	if err != nil {
 		return fmt.Errorf("failed to list models: %w", err)
 	}
@@ -435,6 +937,16 @@ func listModels(cmd *cobra.Command) error {
 	return outputResults(cmd, "models", models)
 }
 
+// openRegistry 打开 --model-dir 指定的模型注册表
+func openRegistry(cmd *cobra.Command) (*prediction.Registry, error) {
+	modelDir, _ := cmd.Flags().GetString("model-dir")
+	registry, err := prediction.NewRegistry(modelDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open model registry: %w", err)
+	}
+	return registry, nil
+}
+
 func viewConfig(cmd *cobra.Command) error {
 	configData, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
@@ -596,8 +1108,47 @@ func outputTable(resultType string, data interface{}) error {
 		if !ok {
 			return fmt.Errorf("invalid data type for prediction")
 		}
+		forecast := make([]prediction.PredictionResult, 0, len(results))
 		for _, r := range results {
-			fmt.Printf("Device: %s, Probability: %.2f%%, Risk: %s, Time: %s\n", r.Device, r.FailureProbability*100, r.RiskLevel, r.PredictionTime.Format(time.RFC3339))
+			fmt.Printf("Device: %s, Probability (%dd): %.2f%%, Risk: %s, Remaining life: %.0f days (%.0f-%.0f), Time: %s\n",
+				r.Device, r.TimeHorizon, r.FailureProbability*100, r.RiskLevel,
+				r.RemainingLifeDays, r.RemainingLifeLowDays, r.RemainingLifeHighDays, r.PredictionTime.Format(time.RFC3339))
+			for _, f := range r.Factors {
+				fmt.Printf("  Factor: %s\n", f)
+			}
+			for _, rec := range r.Recommendations {
+				fmt.Printf("  Recommendation: %s\n", rec)
+			}
+			if len(r.DriftedFeatures) > 0 {
+				fmt.Printf("  Warning: inputs drifted from the training data (%s), confidence %.2f\n",
+					strings.Join(r.DriftedFeatures, ", "), r.Confidence)
+			}
+			forecast = append(forecast, prediction.PredictionResult{Survival: r.survival})
+		}
+		if len(results) > 0 {
+			f := prediction.ForecastFailures(forecast, time.Duration(results[0].TimeHorizon)*24*time.Hour)
+			fmt.Printf("Expected failures within %d days: %.1f (90%% range %.1f-%.1f) of %d devices\n",
+				results[0].TimeHorizon, f.Expected, f.Low, f.High, f.Devices)
+		}
+	case "training":
+		r, ok := data.(*TrainingResult)
+		if !ok {
+			return fmt.Errorf("invalid data type for training")
+		}
+		fmt.Printf("Model: %s (%s), Samples: %d (%d failed), Holdout: precision %.2f, recall %.2f, AUC %.3f\n",
+			r.ModelVersion, r.DeviceType, r.Samples, r.Positives, r.Precision, r.Recall, r.AUC)
+		fmt.Printf("Written to: %s\n", r.ModelPath)
+	case "backtest":
+		r, ok := data.(*BacktestResult)
+		if !ok {
+			return fmt.Errorf("invalid data type for backtest")
+		}
+		fmt.Printf("Model: %s (%s), %s to %s, Predictions: %d (%d failed), Precision: %.2f, Recall: %.2f, AUC: %.3f, Brier: %.4f\n",
+			r.ModelVersion, r.DeviceType, r.From.Format("2006-01-02"), r.To.Format("2006-01-02"),
+			r.Evaluation.Samples, r.Evaluation.Positives, r.Evaluation.Precision, r.Evaluation.Recall, r.Evaluation.AUC, r.Evaluation.Brier)
+		for _, b := range r.Calibration {
+			fmt.Printf("  Predicted %.0f-%.0f%%: %d predictions, mean %.2f, observed %.2f\n",
+				b.Lower*100, b.Upper*100, b.Count, b.MeanPredicted, b.ObservedRate)
 		}
 	case "status":
 		status, ok := data.(*SystemStatus)
@@ -624,13 +1175,41 @@ func outputTable(resultType string, data interface{}) error {
 	case "predictions":
 		// 类似 alerts 处理
 	case "models":
-		models, ok := data.([]string)
+		models, ok := data.([]prediction.ModelRecord)
 		if !ok {
 			return fmt.Errorf("invalid data type for models")
 		}
 		for _, m := range models {
-			fmt.Println(m)
+			auc := 0.0
+			if m.Info.Evaluation != nil {
+				auc = m.Info.Evaluation.AUC
+			}
+			fmt.Printf("Type: %s, Version: %s, Status: %s, Trained: %s, Data: %s to %s, AUC: %.3f, Schema: %s\n",
+				m.Info.DeviceType, m.Info.Version, m.Status, m.Info.TrainedAt.Format(time.RFC3339),
+				m.Info.DataFrom.Format("2006-01-02"), m.Info.DataTo.Format("2006-01-02"), auc, m.Schema)
+		}
+	case "actions":
+		actions, ok := data.([]v1.PendingActionResponse)
+		if !ok {
+			return fmt.Errorf("invalid data type for actions")
+		}
+		for _, a := range actions {
+			fmt.Printf("ID: %s, Device: %s/%s, Tier: %s, Strategy: %s, State: %s, Created: %s\n",
+				a.ID, a.DeviceType, a.DeviceID, a.ServiceTier, a.Strategy, a.State, a.Created)
+			fmt.Printf("  Reason: %s\n", a.Reason)
+			if a.DecidedBy != "" {
+				fmt.Printf("  Decided by %s at %s %s\n", a.DecidedBy, a.DecidedAt, a.Comment)
+			}
+			if a.Result != nil {
+				fmt.Printf("  Result: %s, Success: %v %s\n", a.Result.Action, a.Result.Success, a.Result.Error)
+			}
+		}
+	case "model":
+		m, ok := data.(prediction.ModelRecord)
+		if !ok {
+			return fmt.Errorf("invalid data type for model")
 		}
+		fmt.Printf("Type: %s, Version: %s, Status: %s\n", m.Info.DeviceType, m.Info.Version, m.Status)
 	}
 	return nil
 }
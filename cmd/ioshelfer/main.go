@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -12,6 +13,7 @@ import (
 
 	api "github.com/turtacn/ioshelfer/api/v1"
 	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/core"
 	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
 )
 
@@ -52,7 +54,7 @@ func main() {
 	defer ebpfMonitor.Close()
 
 	// 初始化核心引擎
-	engine, err := core.NewEngine(cfg.Core, ebpfMonitor, log)
+	engine, err := core.NewEngine(cfg.Core, ebpfMonitor)
 	if err != nil {
 		log.Fatalf("Failed to initialize core engine: %v", err)
 	}
@@ -64,7 +66,9 @@ func main() {
 	}
 
 	// 启动核心引擎
-	if err := engine.Start(); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := engine.Start(ctx); err != nil {
 		log.Fatalf("Failed to start core engine: %v", err)
 	}
 
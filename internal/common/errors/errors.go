@@ -12,6 +12,9 @@ const (
 	ErrCodeHighLatency       = "ERR_HIGH_LATENCY"
 	ErrCodeStorageFailure    = "ERR_STORAGE_FAILURE"
 	ErrCodeNetworkPacketLoss = "ERR_NETWORK_PACKET_LOSS"
+	ErrCodeNotFound          = "ERR_NOT_FOUND"
+	ErrCodeFeatureDrift      = "ERR_FEATURE_DRIFT"
+	ErrCodeInvalidState      = "ERR_INVALID_STATE"
 )
 
 // CustomError wraps an error with a specific code and message.
@@ -79,6 +82,35 @@ func NewNetworkPacketLoss(msg string, cause error) error {
 	}
 }
 
+// NewNotFound creates a new error for unknown devices or resources.
+func NewNotFound(msg string, cause error) error {
+	return &CustomError{
+		Code:    ErrCodeNotFound,
+		Message: msg,
+		Cause:   cause,
+	}
+}
+
+// NewFeatureDrift creates a new error for prediction inputs that drifted away from the
+// model's training data.
+func NewFeatureDrift(msg string, cause error) error {
+	return &CustomError{
+		Code:    ErrCodeFeatureDrift,
+		Message: msg,
+		Cause:   cause,
+	}
+}
+
+// NewInvalidState creates a new error for operations a resource's current state does not
+// allow, e.g., approving an action that was already decided.
+func NewInvalidState(msg string, cause error) error {
+	return &CustomError{
+		Code:    ErrCodeInvalidState,
+		Message: msg,
+		Cause:   cause,
+	}
+}
+
 // Is checks if the target error matches the CustomError by code.
 func Is(err, target error) bool {
 	if customErr, ok := err.(*CustomError); ok {
@@ -104,6 +136,10 @@ func Wrap(err error, msg string) error {
 	return errors.Wrap(err, msg)
 }
 
+// New creates an error with the given message, wrapping err when it is non-nil.
 func New(msg string, err error) error {
+	if err == nil {
+		return errors.New(msg)
+	}
 	return Wrap(err, msg)
 }
@@ -1 +1,18 @@
 package errors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWithoutCause(t *testing.T) {
+	err := New("device not healthy for recovery", nil)
+	assert.EqualError(t, err, "device not healthy for recovery")
+}
+
+func TestIsMatchesByCode(t *testing.T) {
+	err := Wrap(NewNotFound("disk sda not found", nil), "lookup")
+	assert.True(t, Is(err, NewNotFound("", nil)))
+	assert.False(t, Is(err, NewStorageFailure("", nil)))
+}
@@ -44,6 +44,11 @@ func Get() *zap.Logger {
 	return logger
 }
 
+// Debug logs a message at Debug level.
+func Debug(msg string, fields ...zap.Field) {
+	Get().Debug(msg, fields...)
+}
+
 // Info logs a message at Info level.
 func Info(msg string, fields ...zap.Field) {
 	Get().Info(msg, fields...)
@@ -25,6 +25,20 @@ func (s HealthStatus) String() string {
 	}
 }
 
+// ParseHealthStatus converts a string representation back to a HealthStatus.
+func ParseHealthStatus(s string) (HealthStatus, error) {
+	switch s {
+	case "healthy":
+		return Healthy, nil
+	case "subhealthy":
+		return SubHealthy, nil
+	case "failed":
+		return Failed, nil
+	default:
+		return Healthy, fmt.Errorf("unknown health status %q", s)
+	}
+}
+
 // DeviceType represents the type of monitored device.
 type DeviceType int
 
@@ -48,6 +62,20 @@ func (d DeviceType) String() string {
 	}
 }
 
+// ParseDeviceType converts a string representation back to a DeviceType.
+func ParseDeviceType(s string) (DeviceType, error) {
+	switch s {
+	case "raid":
+		return RAID, nil
+	case "disk":
+		return Disk, nil
+	case "network":
+		return Network, nil
+	default:
+		return RAID, fmt.Errorf("unknown device type %q", s)
+	}
+}
+
 // IsolationStrategy represents the remediation isolation strategy.
 type IsolationStrategy int
 
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of engine event.
+type EventType int
+
+const (
+	// EventCheck carries the de-duplicated result of a device check.
+	EventCheck EventType = iota
+	// EventStateChange is published when a device's overall health status changes.
+	EventStateChange
+	// EventEscalation is published when a temporary isolation is escalated to permanent.
+	EventEscalation
+)
+
+// String returns the string representation of EventType.
+func (t EventType) String() string {
+	switch t {
+	case EventCheck:
+		return "check"
+	case EventStateChange:
+		return "state_change"
+	case EventEscalation:
+		return "escalation"
+	default:
+		return "unknown"
+	}
+}
+
+// lossy reports whether events of the type may be dropped for a slow subscriber. Only
+// checks are: they are repeated every interval, whereas a lost state change or escalation
+// would never be seen again.
+func (t EventType) lossy() bool {
+	return t == EventCheck
+}
+
+// Event is a detection outcome published on the engine bus.
+type Event struct {
+	Type       EventType
+	DeviceType enum.DeviceType
+	DeviceID   string
+	Previous   enum.HealthStatus // Status before the change; equal to Status.Status for checks
+	Status     detection.HealthStatus
+	At         time.Time
+}
+
+// subscriber is a bus subscription filtered by event type.
+type subscriber struct {
+	ch       chan Event
+	types    map[EventType]bool // Empty means every type
+	backlog  []Event            // State changes and escalations waiting for buffer space
+	flushing bool               // A goroutine is draining the backlog
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Bus fans engine events out to subscribers.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[int]*subscriber
+	nextID      int
+}
+
+// NewBus creates an empty Bus.
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[int]*subscriber),
+	}
+}
+
+// Subscribe returns a channel receiving events of the given types (all types when none are
+// given) and a function that cancels the subscription. Checks are dropped for subscribers
+// whose buffer is full so a slow consumer never stalls detection; state changes and
+// escalations are queued behind the buffer instead and delivered in order.
+func (b *Bus) Subscribe(buffer int, types ...EventType) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	sub := &subscriber{ch: make(chan Event, buffer), types: make(map[EventType]bool), done: make(chan struct{})}
+	for _, t := range types {
+		sub.types[t] = true
+	}
+	b.subscribers[id] = sub
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, id)
+			close(sub.done)
+			b.mu.Unlock()
+			sub.wg.Wait()
+			close(sub.ch)
+		})
+	}
+}
+
+// Publish delivers an event to every matching subscriber.
+func (b *Bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, sub := range b.subscribers {
+		if len(sub.types) > 0 && !sub.types[event.Type] {
+			continue
+		}
+		if len(sub.backlog) == 0 {
+			select {
+			case sub.ch <- event:
+				continue
+			default:
+			}
+		}
+		if event.Type.lossy() {
+			logger.Warn("dropped engine event for slow subscriber",
+				zap.String("event", event.Type.String()),
+				zap.String("device_id", event.DeviceID),
+			)
+			continue
+		}
+		sub.backlog = append(sub.backlog, event)
+		if !sub.flushing {
+			sub.flushing = true
+			sub.wg.Add(1)
+			go b.flush(sub)
+		}
+	}
+}
+
+// flush delivers a subscriber's backlog as its buffer frees up, until the backlog is empty
+// or the subscription is cancelled.
+func (b *Bus) flush(sub *subscriber) {
+	defer sub.wg.Done()
+	for {
+		b.mu.Lock()
+		if len(sub.backlog) == 0 {
+			sub.flushing = false
+			b.mu.Unlock()
+			return
+		}
+		event := sub.backlog[0]
+		b.mu.Unlock()
+
+		select {
+		case sub.ch <- event:
+		case <-sub.done:
+			return
+		}
+		b.mu.Lock()
+		sub.backlog = sub.backlog[1:]
+		b.mu.Unlock()
+	}
+}
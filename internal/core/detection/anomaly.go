@@ -0,0 +1,338 @@
+package detection
+
+import (
+	"encoding/json"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+	"go.uber.org/zap"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AnomalyConfig defines how per-device baselines are learned and deviations scored.
+type AnomalyConfig struct {
+	Alpha             float64            // EWMA smoothing factor (0, 1]
+	ScoreThreshold    float64            // Deviation score at which a metric is flagged
+	MinSamples        int                // Baseline samples required before a metric is scored
+	MaxHistory        int                // Recent values kept per metric for median/MAD
+	MinRelativeSpread float64            // Lower bound on the spread as a fraction of the baseline, so flat baselines do not yield infinite scores
+	MinAbsoluteSpread map[string]float64 // Per-metric lower bound on the spread in the metric's unit, so a blip on a zero baseline is not a huge deviation
+	Seasonal          bool               // Keep hour-of-week baselines in addition to the global one
+	SeasonalHistory   int                // Recent values kept per hour-of-week bucket; at least MinSamples
+	HistoryWindow     time.Duration      // Stored history used to seed baselines
+}
+
+// DefaultAnomalyConfig returns conservative anomaly detection settings.
+func DefaultAnomalyConfig() *AnomalyConfig {
+	return &AnomalyConfig{
+		Alpha:             0.1,
+		ScoreThreshold:    4.0,
+		MinSamples:        30,
+		MaxHistory:        1000,
+		MinRelativeSpread: 0.05,
+		MinAbsoluteSpread: map[string]float64{
+			ebpf.MetricQueueDepth:         1,
+			ebpf.MetricAvgLatency:         0.0002,
+			ebpf.MetricLatencyP95:         0.0002,
+			ebpf.MetricIOPS:               10,
+			ebpf.MetricBytesPerSecond:     1 << 20,
+			ebpf.MetricErrorRetryRate:     0.5,
+			ebpf.MetricCacheEventRate:     0.5,
+			ebpf.MetricInterfaceErrorRate: 0.5,
+			ebpf.MetricRetransmitRate:     0.5,
+			ebpf.MetricCarrierChangeRate:  0.1,
+			ebpf.MetricPacketLossRate:     0.001,
+		},
+		SeasonalHistory: 64,
+		HistoryWindow:   7 * 24 * time.Hour,
+	}
+}
+
+// Anomaly describes a metric deviating from its learned baseline.
+type Anomaly struct {
+	Metric   string
+	Value    float64
+	Expected float64 // Baseline the value was compared against
+	Spread   float64 // Robust standard deviation of the baseline, after the configured floors
+	Score    float64 // Absolute deviation in robust standard deviations
+	Method   string  // "seasonal", "robust_zscore" or "ewma"
+}
+
+// metricBaseline is the learned baseline of one metric on one device.
+type metricBaseline struct {
+	count    int
+	ewmaMean float64
+	ewmaVar  float64
+	history  []float64         // Most recent values, oldest first
+	seasonal map[int][]float64 // Hour-of-week bucket to recent values
+}
+
+// baselineKey identifies one metric on one device.
+type baselineKey struct {
+	deviceType enum.DeviceType
+	deviceID   string
+	metric     string
+}
+
+// AnomalyDetector learns per-device metric baselines and scores new observations against them.
+type AnomalyDetector struct {
+	config  *AnomalyConfig
+	storage storage.Storage // Optional; seeds baselines from stored history
+
+	mu        sync.Mutex
+	baselines map[baselineKey]*metricBaseline
+	seeded    map[string]bool // deviceType/deviceID already seeded from storage
+}
+
+// NewAnomalyDetector creates a new AnomalyDetector. store may be nil, in which case
+// baselines are learned from live observations only.
+func NewAnomalyDetector(config *AnomalyConfig, store storage.Storage) *AnomalyDetector {
+	return &AnomalyDetector{
+		config:    config,
+		storage:   store,
+		baselines: make(map[baselineKey]*metricBaseline),
+		seeded:    make(map[string]bool),
+	}
+}
+
+// Evaluate scores values against the device's baselines and then folds the values that are
+// not anomalous into the baselines.
+// Anomalies at or above ScoreThreshold are returned, highest score first.
+func (a *AnomalyDetector) Evaluate(deviceType enum.DeviceType, deviceID string, values map[string]float64, at time.Time) []Anomaly {
+	var anomalies []Anomaly
+	for _, anomaly := range a.Score(deviceType, deviceID, values, at) {
+		if anomaly.Score >= a.config.ScoreThreshold {
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+	return anomalies
+}
+
+// Score scores values against the device's baselines and then folds the values below
+// ScoreThreshold into the baselines.
+// Every metric with enough baseline samples is returned, whatever its score, highest first.
+func (a *AnomalyDetector) Score(deviceType enum.DeviceType, deviceID string, values map[string]float64, at time.Time) []Anomaly {
+	return a.scoreAll(deviceType, deviceID, values, at, true)
+}
+
+// Peek scores values like Score without folding them into the baselines.
+func (a *AnomalyDetector) Peek(deviceType enum.DeviceType, deviceID string, values map[string]float64, at time.Time) []Anomaly {
+	return a.scoreAll(deviceType, deviceID, values, at, false)
+}
+
+func (a *AnomalyDetector) scoreAll(deviceType enum.DeviceType, deviceID string, values map[string]float64, at time.Time, learn bool) []Anomaly {
+	a.seed(deviceType, deviceID)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var anomalies []Anomaly
+	for metric, value := range values {
+		b := a.baseline(baselineKey{deviceType, deviceID, metric})
+		anomaly, ok := a.score(b, metric, value, at)
+		if ok {
+			anomalies = append(anomalies, anomaly)
+		}
+		// Anomalous values are kept out of the baseline so a sustained fault is not
+		// learned as normal.
+		if learn && (!ok || anomaly.Score < a.config.ScoreThreshold) {
+			a.observe(b, value, at)
+		}
+	}
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Score > anomalies[j].Score })
+	return anomalies
+}
+
+// Learn folds a historical observation into the device's baselines without scoring it.
+func (a *AnomalyDetector) Learn(deviceType enum.DeviceType, deviceID string, values map[string]float64, at time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for metric, value := range values {
+		a.observe(a.baseline(baselineKey{deviceType, deviceID, metric}), value, at)
+	}
+}
+
+// seed loads the device's stored history into its baselines once; a failed query is
+// retried on the next call.
+func (a *AnomalyDetector) seed(deviceType enum.DeviceType, deviceID string) {
+	if a.storage == nil {
+		return
+	}
+	key := stateKey(deviceType, deviceID)
+	a.mu.Lock()
+	done := a.seeded[key]
+	a.seeded[key] = true
+	a.mu.Unlock()
+	if done {
+		return
+	}
+
+	history, err := a.storage.Query(deviceType, deviceID, a.config.HistoryWindow)
+	if err != nil {
+		// Retry on the next observation rather than learning from live data alone.
+		a.mu.Lock()
+		delete(a.seeded, key)
+		a.mu.Unlock()
+		logger.Warn("failed to seed anomaly baselines",
+			zap.String("device_type", deviceType.String()),
+			zap.String("device_id", deviceID),
+			zap.Error(err),
+		)
+		return
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Timestamp.Before(history[j].Timestamp) })
+	for _, m := range history {
+		if values, err := MetricValues(deviceType, m.Value); err == nil {
+			a.Learn(deviceType, deviceID, values, m.Timestamp)
+		}
+	}
+}
+
+func (a *AnomalyDetector) baseline(key baselineKey) *metricBaseline {
+	b, ok := a.baselines[key]
+	if !ok {
+		b = &metricBaseline{seasonal: make(map[int][]float64)}
+		a.baselines[key] = b
+	}
+	return b
+}
+
+// observe updates a baseline with a new value.
+func (a *AnomalyDetector) observe(b *metricBaseline, value float64, at time.Time) {
+	if b.count == 0 {
+		b.ewmaMean = value
+	} else {
+		diff := value - b.ewmaMean
+		incr := a.config.Alpha * diff
+		b.ewmaMean += incr
+		b.ewmaVar = (1 - a.config.Alpha) * (b.ewmaVar + diff*incr)
+	}
+	b.count++
+
+	b.history = appendBounded(b.history, value, a.config.MaxHistory)
+	if a.config.Seasonal {
+		bucket := hourOfWeek(at)
+		size := a.config.SeasonalHistory
+		if size < a.config.MinSamples {
+			size = a.config.MinSamples
+		}
+		b.seasonal[bucket] = appendBounded(b.seasonal[bucket], value, size)
+	}
+}
+
+// score compares value with the baseline, preferring the seasonal baseline, then the
+// robust median/MAD baseline, then the EWMA baseline.
+func (a *AnomalyDetector) score(b *metricBaseline, metric string, value float64, at time.Time) (Anomaly, bool) {
+	if b.count < a.config.MinSamples {
+		return Anomaly{}, false
+	}
+
+	if a.config.Seasonal {
+		if bucket := b.seasonal[hourOfWeek(at)]; len(bucket) >= a.config.MinSamples {
+			median, mad := medianMAD(bucket)
+			return a.anomaly(metric, value, median, 1.4826*mad, "seasonal"), true
+		}
+	}
+	if len(b.history) >= a.config.MinSamples {
+		median, mad := medianMAD(b.history)
+		return a.anomaly(metric, value, median, 1.4826*mad, "robust_zscore"), true
+	}
+	return a.anomaly(metric, value, b.ewmaMean, math.Sqrt(b.ewmaVar), "ewma"), true
+}
+
+func (a *AnomalyDetector) anomaly(metric string, value, expected, spread float64, method string) Anomaly {
+	floor := math.Max(math.Abs(expected)*a.config.MinRelativeSpread, a.config.MinAbsoluteSpread[metric])
+	spread = math.Max(spread, math.Max(floor, 1e-9))
+	return Anomaly{
+		Metric:   metric,
+		Value:    value,
+		Expected: expected,
+		Spread:   spread,
+		Score:    math.Abs(value-expected) / spread,
+		Method:   method,
+	}
+}
+
+// anomalyConfidence maps a deviation score to a confidence in [0.5, 0.99].
+func anomalyConfidence(score, threshold float64) float64 {
+	if threshold <= 0 || score < threshold {
+		return 0
+	}
+	return math.Min(0.5+0.5*(1-threshold/score), 0.99)
+}
+
+// MetricValues converts a stored metric value back into metric values keyed by Metric* name.
+// Values read from storage have lost their concrete type, so they are re-decoded by device type.
+func MetricValues(deviceType enum.DeviceType, value interface{}) (map[string]float64, error) {
+	switch v := value.(type) {
+	case *ebpf.RAIDMetrics:
+		return v.Values(), nil
+	case *ebpf.DiskMetrics:
+		return v.Values(), nil
+	case *ebpf.NetworkMetrics:
+		return v.Values(), nil
+	case PeerComparison:
+		return MetricValues(deviceType, v.Metrics)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.New("failed to re-encode stored metric", err)
+	}
+	switch deviceType {
+	case enum.RAID:
+		var m ebpf.RAIDMetrics
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, errors.New("failed to decode stored RAID metric", err)
+		}
+		return m.Values(), nil
+	case enum.Disk:
+		var m ebpf.DiskMetrics
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, errors.New("failed to decode stored disk metric", err)
+		}
+		return m.Values(), nil
+	case enum.Network:
+		var m ebpf.NetworkMetrics
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, errors.New("failed to decode stored network metric", err)
+		}
+		return m.Values(), nil
+	default:
+		return nil, errors.New("unsupported device type", nil)
+	}
+}
+
+// medianMAD returns the median and median absolute deviation of values.
+func medianMAD(values []float64) (float64, float64) {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	median := ebpf.Percentile(sorted, 0.5)
+
+	deviations := make([]float64, len(sorted))
+	for i, v := range sorted {
+		deviations[i] = math.Abs(v - median)
+	}
+	sort.Float64s(deviations)
+	return median, ebpf.Percentile(deviations, 0.5)
+}
+
+func appendBounded(values []float64, value float64, max int) []float64 {
+	values = append(values, value)
+	if max > 0 && len(values) > max {
+		values = values[len(values)-max:]
+	}
+	return values
+}
+
+// hourOfWeek returns the hour-of-week bucket (0-167) of t in UTC.
+func hourOfWeek(t time.Time) int {
+	t = t.UTC()
+	return int(t.Weekday())*24 + t.Hour()
+}
@@ -1,175 +1,319 @@
 package detection
 
 import (
+	"fmt"
+	"sort"
 	"time"
 	"github.com/turtacn/ioshelfer/internal/common/errors"
 	"github.com/turtacn/ioshelfer/internal/common/types/enum"
 	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
 )
 
 // Config defines the configuration for the detection engine.
 type Config struct {
-	QueueThreshold    int           // Maximum queue depth before sub-health is detected
-	LatencyThreshold  time.Duration // Maximum I/O latency before sub-health is detected
-	MonitorInterval   time.Duration // Interval for periodic health checks
-	IOPSVarThreshold  float64       // IOPS variance threshold for disk sub-health
-	PacketLossThreshold float64     // Packet loss rate threshold for network sub-health
+	QueueThreshold      int           // Maximum queue depth before sub-health is detected
+	LatencyThreshold    time.Duration // Maximum I/O latency before sub-health is detected
+	MonitorInterval     time.Duration // Interval for periodic health checks
+	IOPSVarThreshold    float64       // IOPS variance threshold for disk sub-health
+	PacketLossThreshold float64       // Packet loss rate threshold for network sub-health
+	Sustain             Window        // Evidence required before a threshold breach is raised or cleared
+	HysteresisRatio     float64       // Exit thresholds as a fraction of enter thresholds (e.g., 0.8); 0 disables
 }
 
-// HealthStatus represents the result of a sub-health check.
+// HealthStatus represents the result of a sub-health check. Status, Confidence and
+// Recommendation are derived from Findings.
 type HealthStatus struct {
-	DeviceType      enum.DeviceType
-	Status          enum.HealthStatus
-	Confidence      float64
-	Recommendation  string
-	Metrics         interface{} // Device-specific metrics (RAID, Disk, or Network)
+	DeviceType     enum.DeviceType
+	DeviceID       string
+	Status         enum.HealthStatus
+	Confidence     float64
+	Recommendation string
+	Since          time.Time   // When the device entered its current status
+	Findings       []Finding   // Evidence behind the status, most severe first
+	Metrics        interface{} // Device-specific metrics (RAID, Disk, or Network)
 }
 
-// Detector defines the interface for sub-health detection.
+// Detector defines the interface for sub-health detection. CheckAll is the periodic check:
+// it advances sustain windows, hysteresis, baselines and tracked states. CheckSubHealth is
+// an ad-hoc check of one device that leaves them untouched.
 type Detector interface {
-	CheckSubHealth() (HealthStatus, error)
+	CheckSubHealth(deviceID string) (HealthStatus, error)
+	CheckAll() ([]HealthStatus, error)
+}
+
+// StoreStatus persists the metrics behind a health check under the checked device's ID.
+func StoreStatus(s storage.Storage, status HealthStatus) error {
+	return s.Store(storage.Metric{
+		Timestamp:  time.Now(),
+		DeviceType: status.DeviceType,
+		DeviceID:   status.DeviceID,
+		Value:      status.Metrics,
+	})
+}
+
+// assessor applies the rule set to one device type and tracks the resulting device states.
+type assessor struct {
+	deviceType enum.DeviceType
+	rules      *RuleEngine
+	conditions *conditionSet
+	states     *StateTracker
+	anomalies  *AnomalyDetector
+}
+
+func newAssessor(config *Config, monitor ebpf.Monitor, rules *RuleEngine, deviceType enum.DeviceType) *assessor {
+	if rules == nil {
+		// The built-in rules are derived from validated config thresholds and always compile.
+		rules, _ = NewRuleEngine(DefaultRules(config))
+	}
+	return &assessor{
+		deviceType: deviceType,
+		rules:      rules,
+		conditions: newConditionSet(config, monitor, deviceType),
+		states:     NewStateTracker(),
+	}
+}
+
+// SetAnomalyDetector enables baseline anomaly scoring alongside the rule set; nil disables it.
+func (a *assessor) SetAnomalyDetector(detector *AnomalyDetector) {
+	a.anomalies = detector
+}
+
+// assess evaluates every rule, and the anomaly detector when set, against a device's metric
+// values and derives the device's status from the resulting findings. Unless record is set
+// the evaluation leaves the tracked conditions, states and baselines untouched, so ad-hoc
+// checks do not advance the periodic checks' sustain windows or hysteresis.
+func (a *assessor) assess(deviceID string, values map[string]float64, metrics interface{}, record bool) HealthStatus {
+	now := time.Now()
+	conditions := a.conditions
+	if !record {
+		conditions = conditions.snapshot()
+	}
+	findings := a.rules.evaluate(conditions, a.deviceType, deviceID, values, now)
+	if a.anomalies != nil {
+		score := a.anomalies.Score
+		if !record {
+			score = a.anomalies.Peek
+		}
+		for _, anomaly := range score(a.deviceType, deviceID, values, now) {
+			if finding, ok := a.anomalyFinding(conditions, deviceID, anomaly, now); ok {
+				findings = append(findings, finding)
+			}
+		}
+		sortFindings(findings)
+	}
+	status, confidence, recommendation := DeriveStatus(findings)
+
+	var state DeviceState
+	if record {
+		state, _ = a.states.Update(a.deviceType, deviceID, status, now)
+	} else {
+		state = a.states.Peek(a.deviceType, deviceID, status, now)
+	}
+	return HealthStatus{
+		DeviceType:     a.deviceType,
+		DeviceID:       deviceID,
+		Status:         status,
+		Confidence:     confidence,
+		Recommendation: recommendation,
+		Since:          state.Since,
+		Findings:       findings,
+		Metrics:        metrics,
+	}
+}
+
+// anomalyFinding returns the finding for a scored metric if it deviates from its baseline.
+// Deviations above and below the baseline are tracked as separate conditions, so they are
+// sustained and cleared with hysteresis like rule breaches.
+func (a *assessor) anomalyFinding(conditions *conditionSet, deviceID string, anomaly Anomaly, now time.Time) (Finding, bool) {
+	ratio := conditions.config.HysteresisRatio
+	if ratio <= 0 || ratio >= 1 {
+		ratio = 1
+	}
+	enter := a.anomalies.config.ScoreThreshold * anomaly.Spread
+	exit := enter * ratio
+	high, highBreached := conditions.breached(deviceID, "anomaly:"+anomaly.Metric+":high", anomaly.Metric, anomaly.Value,
+		anomaly.Expected+enter, anomaly.Expected+exit, func(v, t float64) bool { return v > t }, now)
+	low, lowBreached := conditions.breached(deviceID, "anomaly:"+anomaly.Metric+":low", anomaly.Metric, anomaly.Value,
+		anomaly.Expected-enter, anomaly.Expected-exit, func(v, t float64) bool { return v < t }, now)
+
+	operator, since := ">", high
+	switch {
+	case highBreached:
+	case lowBreached:
+		operator, since = "<", low
+	default:
+		return Finding{}, false
+	}
+	return Finding{
+		Code:       codeForMetric(a.deviceType, anomaly.Metric),
+		Severity:   enum.SubHealthy,
+		Source:     SourceAnomaly,
+		Name:       anomaly.Method,
+		Metric:     anomaly.Metric,
+		Operator:   operator,
+		Threshold:  anomaly.Expected,
+		Observed:   anomaly.Value,
+		Confidence: anomalyConfidence(anomaly.Score, a.anomalies.config.ScoreThreshold),
+		Start:      since,
+		End:        now,
+		Action: fmt.Sprintf("investigate %s on %s %s: %.4g deviates from baseline %.4g",
+			anomaly.Metric, a.deviceType, deviceID, anomaly.Value, anomaly.Expected),
+	}, true
+}
+
+// State returns the tracked health state of a device.
+func (a *assessor) State(deviceID string) (DeviceState, bool) {
+	return a.states.State(a.deviceType, deviceID)
 }
 
 // RAIDDetector implements Detector for RAID controllers.
 type RAIDDetector struct {
-	config     *Config
-	ebpfMonitor *ebpf.Monitor
+	*assessor
+	config      *Config
+	ebpfMonitor ebpf.Monitor
 }
 
-// NewRAIDDetector creates a new RAIDDetector instance.
-func NewRAIDDetector(config *Config, monitor *ebpf.Monitor) *RAIDDetector {
+// NewRAIDDetector creates a new RAIDDetector instance. When rules is nil the
+// thresholds in config are applied as the built-in rule set.
+func NewRAIDDetector(config *Config, monitor ebpf.Monitor, rules *RuleEngine) *RAIDDetector {
 	return &RAIDDetector{
-		config:     config,
+		assessor:    newAssessor(config, monitor, rules, enum.RAID),
+		config:      config,
 		ebpfMonitor: monitor,
 	}
 }
 
-// CheckSubHealth performs a sub-health check for a RAID controller.
-func (d *RAIDDetector) CheckSubHealth() (HealthStatus, error) {
-	metrics, err := d.ebpfMonitor.GetRAIDMetrics()
+// CheckSubHealth performs an ad-hoc sub-health check for a RAID controller.
+func (d *RAIDDetector) CheckSubHealth(deviceID string) (HealthStatus, error) {
+	all, err := d.ebpfMonitor.GetRAIDMetrics()
 	if err != nil {
 		return HealthStatus{}, errors.NewQueueOverflow("failed to get RAID metrics", err)
 	}
-
-	status := enum.Healthy
-	confidence := 1.0
-	recommendation := "no action required"
-
-	if metrics.QueueDepth >= d.config.QueueThreshold {
-		status = enum.SubHealthy
-		confidence = 0.95
-		recommendation = "temporary isolation recommended"
+	metrics, ok := all[deviceID]
+	if !ok {
+		return HealthStatus{}, errors.NewNotFound("RAID controller "+deviceID+" not found", nil)
 	}
+	return d.evaluate(deviceID, metrics, false), nil
+}
 
-	if metrics.AvgLatency > d.config.LatencyThreshold {
-		status = enum.SubHealthy
-		confidence = 0.90
-		recommendation = "check controller firmware and isolate if persistent"
+// CheckAll performs a sub-health check for every RAID controller on the host.
+func (d *RAIDDetector) CheckAll() ([]HealthStatus, error) {
+	all, err := d.ebpfMonitor.GetRAIDMetrics()
+	if err != nil {
+		return nil, errors.NewQueueOverflow("failed to get RAID metrics", err)
 	}
-
-	if metrics.ErrorRetryRate > 100 { // Example threshold: 100 retries/hour
-		status = enum.Failed
-		confidence = 0.99
-		recommendation = "immediate isolation and replacement"
+	results := make([]HealthStatus, 0, len(all))
+	for _, id := range sortedKeys(all) {
+		results = append(results, d.evaluate(id, all[id], true))
 	}
+	return results, nil
+}
 
-	return HealthStatus{
-		DeviceType:     enum.RAID,
-		Status:         status,
-		Confidence:     confidence,
-		Recommendation: recommendation,
-		Metrics:        metrics,
-	}, nil
+func (d *RAIDDetector) evaluate(deviceID string, metrics *ebpf.RAIDMetrics, record bool) HealthStatus {
+	return d.assess(deviceID, metrics.Values(), metrics, record)
 }
 
 // DiskDetector implements Detector for disk devices.
 type DiskDetector struct {
-	config     *Config
-	ebpfMonitor *ebpf.Monitor
+	*assessor
+	config      *Config
+	ebpfMonitor ebpf.Monitor
 }
 
-// NewDiskDetector creates a new DiskDetector instance.
-func NewDiskDetector(config *Config, monitor *ebpf.Monitor) *DiskDetector {
+// NewDiskDetector creates a new DiskDetector instance. When rules is nil the
+// thresholds in config are applied as the built-in rule set.
+func NewDiskDetector(config *Config, monitor ebpf.Monitor, rules *RuleEngine) *DiskDetector {
 	return &DiskDetector{
-		config:     config,
+		assessor:    newAssessor(config, monitor, rules, enum.Disk),
+		config:      config,
 		ebpfMonitor: monitor,
 	}
 }
 
-// CheckSubHealth performs a sub-health check for a disk device.
-func (d *DiskDetector) CheckSubHealth() (HealthStatus, error) {
-	metrics, err := d.ebpfMonitor.GetDiskMetrics()
+// CheckSubHealth performs an ad-hoc sub-health check for a disk device.
+func (d *DiskDetector) CheckSubHealth(deviceID string) (HealthStatus, error) {
+	all, err := d.ebpfMonitor.GetDiskMetrics()
 	if err != nil {
 		return HealthStatus{}, errors.NewStorageFailure("failed to get disk metrics", err)
 	}
-
-	status := enum.Healthy
-	confidence := 1.0
-	recommendation := "no action required"
-
-	if metrics.IOPSVariance > d.config.IOPSVarThreshold {
-		status = enum.SubHealthy
-		confidence = 0.92
-		recommendation = "monitor disk performance closely"
+	metrics, ok := all[deviceID]
+	if !ok {
+		return HealthStatus{}, errors.NewNotFound("disk "+deviceID+" not found", nil)
 	}
+	return d.evaluate(deviceID, metrics, false), nil
+}
 
-	if metrics.SMART.ReallocatedSectors > 100 { // Example threshold
-		status = enum.SubHealthy
-		confidence = 0.95
-		recommendation = "schedule disk replacement"
+// CheckAll performs a sub-health check for every disk on the host.
+func (d *DiskDetector) CheckAll() ([]HealthStatus, error) {
+	all, err := d.ebpfMonitor.GetDiskMetrics()
+	if err != nil {
+		return nil, errors.NewStorageFailure("failed to get disk metrics", err)
 	}
+	results := make([]HealthStatus, 0, len(all))
+	for _, id := range sortedKeys(all) {
+		results = append(results, d.evaluate(id, all[id], true))
+	}
+	return results, nil
+}
 
-	return HealthStatus{
-		DeviceType:     enum.Disk,
-		Status:         status,
-		Confidence:     confidence,
-		Recommendation: recommendation,
-		Metrics:        metrics,
-	}, nil
+func (d *DiskDetector) evaluate(deviceID string, metrics *ebpf.DiskMetrics, record bool) HealthStatus {
+	return d.assess(deviceID, metrics.Values(), metrics, record)
 }
 
 // NetworkDetector implements Detector for network I/O.
 type NetworkDetector struct {
-	config     *Config
-	ebpfMonitor *ebpf.Monitor
+	*assessor
+	config      *Config
+	ebpfMonitor ebpf.Monitor
 }
 
-// NewNetworkDetector creates a new NetworkDetector instance.
-func NewNetworkDetector(config *Config, monitor *ebpf.Monitor) *NetworkDetector {
+// NewNetworkDetector creates a new NetworkDetector instance. When rules is nil the
+// thresholds in config are applied as the built-in rule set.
+func NewNetworkDetector(config *Config, monitor ebpf.Monitor, rules *RuleEngine) *NetworkDetector {
 	return &NetworkDetector{
-		config:     config,
+		assessor:    newAssessor(config, monitor, rules, enum.Network),
+		config:      config,
 		ebpfMonitor: monitor,
 	}
 }
 
-// CheckSubHealth performs a sub-health check for network I/O.
-func (d *NetworkDetector) CheckSubHealth() (HealthStatus, error) {
-	metrics, err := d.ebpfMonitor.GetNetworkMetrics()
+// CheckSubHealth performs an ad-hoc sub-health check for a network interface.
+func (d *NetworkDetector) CheckSubHealth(deviceID string) (HealthStatus, error) {
+	all, err := d.ebpfMonitor.GetNetworkMetrics()
 	if err != nil {
 		return HealthStatus{}, errors.NewNetworkPacketLoss("failed to get network metrics", err)
 	}
-
-	status := enum.Healthy
-	confidence := 1.0
-	recommendation := "no action required"
-
-	if metrics.PacketLossRate > d.config.PacketLossThreshold {
-		status = enum.SubHealthy
-		confidence = 0.93
-		recommendation = "check network interface and routing"
+	metrics, ok := all[deviceID]
+	if !ok {
+		return HealthStatus{}, errors.NewNotFound("network interface "+deviceID+" not found", nil)
 	}
+	return d.evaluate(deviceID, metrics, false), nil
+}
 
-	if metrics.LatencyP95 > d.config.LatencyThreshold {
-		status = enum.SubHealthy
-		confidence = 0.90
-		recommendation = "investigate network congestion"
+// CheckAll performs a sub-health check for every network interface on the host.
+func (d *NetworkDetector) CheckAll() ([]HealthStatus, error) {
+	all, err := d.ebpfMonitor.GetNetworkMetrics()
+	if err != nil {
+		return nil, errors.NewNetworkPacketLoss("failed to get network metrics", err)
+	}
+	results := make([]HealthStatus, 0, len(all))
+	for _, id := range sortedKeys(all) {
+		results = append(results, d.evaluate(id, all[id], true))
 	}
+	return results, nil
+}
 
-	return HealthStatus{
-		DeviceType:     enum.Network,
-		Status:         status,
-		Confidence:     confidence,
-		Recommendation: recommendation,
-		Metrics:        metrics,
-	}, nil
-}
\ No newline at end of file
+func (d *NetworkDetector) evaluate(deviceID string, metrics *ebpf.NetworkMetrics, record bool) HealthStatus {
+	return d.assess(deviceID, metrics.Values(), metrics, record)
+}
+
+// sortedKeys returns the device IDs of a per-device metric map in stable order.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1 +1,694 @@
 package detection
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/slo"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+)
+
+// fakeMonitor serves fixed per-device metrics and whatever samples are recorded into it.
+type fakeMonitor struct {
+	*ebpf.SampleBuffer
+	raid    map[string]*ebpf.RAIDMetrics
+	disk    map[string]*ebpf.DiskMetrics
+	network map[string]*ebpf.NetworkMetrics
+}
+
+func (f *fakeMonitor) ListDevices(deviceType enum.DeviceType) ([]string, error) {
+	switch deviceType {
+	case enum.RAID:
+		return sortedKeys(f.raid), nil
+	case enum.Disk:
+		return sortedKeys(f.disk), nil
+	default:
+		return sortedKeys(f.network), nil
+	}
+}
+func (f *fakeMonitor) GetRAIDMetrics() (map[string]*ebpf.RAIDMetrics, error) { return f.raid, nil }
+func (f *fakeMonitor) GetDiskMetrics() (map[string]*ebpf.DiskMetrics, error) { return f.disk, nil }
+func (f *fakeMonitor) GetNetworkMetrics() (map[string]*ebpf.NetworkMetrics, error) {
+	return f.network, nil
+}
+func (f *fakeMonitor) StartMonitor(ctx context.Context) error { return nil }
+func (f *fakeMonitor) Stop() error                            { return nil }
+
+// checkAll runs the periodic check of every device and returns the status of one.
+func checkAll(d Detector, deviceID string) (HealthStatus, error) {
+	all, err := d.CheckAll()
+	if err != nil {
+		return HealthStatus{}, err
+	}
+	for _, status := range all {
+		if status.DeviceID == deviceID {
+			return status, nil
+		}
+	}
+	return HealthStatus{}, errors.NewNotFound(deviceID+" not checked", nil)
+}
+
+func testConfig() *Config {
+	return &Config{
+		QueueThreshold:      100,
+		LatencyThreshold:    50 * time.Millisecond,
+		IOPSVarThreshold:    1000,
+		PacketLossThreshold: 0.01,
+	}
+}
+
+func TestRAIDDetectorPerDevice(t *testing.T) {
+	monitor := &fakeMonitor{SampleBuffer: ebpf.NewSampleBuffer(0), raid: map[string]*ebpf.RAIDMetrics{
+		"host0": {DeviceID: "host0", QueueDepth: 10},
+		"host1": {DeviceID: "host1", QueueDepth: 150},
+	}}
+	d := NewRAIDDetector(testConfig(), monitor, nil)
+
+	status, err := d.CheckSubHealth("host1")
+	require.NoError(t, err)
+	assert.Equal(t, "host1", status.DeviceID)
+	assert.Equal(t, enum.SubHealthy, status.Status)
+
+	all, err := d.CheckAll()
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, "host0", all[0].DeviceID)
+	assert.Equal(t, enum.Healthy, all[0].Status)
+
+	_, err = d.CheckSubHealth("host9")
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+}
+
+func TestWindowSatisfied(t *testing.T) {
+	now := time.Now()
+	points := func(values ...float64) []ebpf.Point {
+		out := make([]ebpf.Point, len(values))
+		for i, v := range values {
+			out[i] = ebpf.Point{Time: now.Add(time.Duration(i-len(values)+1) * time.Minute), Value: v}
+		}
+		return out
+	}
+	high := func(v float64) bool { return v > 10 }
+
+	assert.True(t, Window{}.satisfied(points(1, 20), high))
+	assert.False(t, Window{}.satisfied(points(20, 1), high))
+	assert.True(t, Window{Required: 3, Samples: 5}.satisfied(points(0, 20, 1, 20, 20), high))
+	assert.False(t, Window{Required: 3, Samples: 5}.satisfied(points(20, 1, 1, 1, 20), high))
+	assert.True(t, Window{Duration: 3 * time.Minute}.satisfied(points(1, 20, 20, 20, 20), high))
+	assert.False(t, Window{Duration: 3 * time.Minute}.satisfied(points(20, 20, 1, 20, 20), high))
+	// Not enough history to cover the duration.
+	assert.False(t, Window{Duration: 10 * time.Minute}.satisfied(points(20, 20), high))
+}
+
+func TestRAIDDetectorHysteresis(t *testing.T) {
+	monitor := &fakeMonitor{SampleBuffer: ebpf.NewSampleBuffer(0), raid: map[string]*ebpf.RAIDMetrics{
+		"host0": {DeviceID: "host0"},
+	}}
+	config := testConfig()
+	config.Sustain = Window{Required: 2, Samples: 3}
+	config.HysteresisRatio = 0.8
+	d := NewRAIDDetector(config, monitor, nil)
+
+	start := time.Now().Add(-time.Hour)
+	check := func(depth int) enum.HealthStatus {
+		start = start.Add(time.Second)
+		monitor.Record(ebpf.Sample{DeviceType: enum.RAID, DeviceID: "host0", Time: start,
+			Values: map[string]float64{ebpf.MetricQueueDepth: float64(depth)}})
+		status, err := checkAll(d, "host0")
+		require.NoError(t, err)
+		return status.Status
+	}
+
+	assert.Equal(t, enum.Healthy, check(150), "a single breach is not sustained")
+	assert.Equal(t, enum.SubHealthy, check(150))
+	assert.Equal(t, enum.SubHealthy, check(90), "above the exit threshold keeps the condition")
+	assert.Equal(t, enum.SubHealthy, check(50))
+	assert.Equal(t, enum.SubHealthy, check(90))
+	assert.Equal(t, enum.Healthy, check(50))
+
+	state, ok := d.State("host0")
+	require.True(t, ok)
+	require.Len(t, state.Transitions, 2)
+	assert.Equal(t, enum.SubHealthy, state.Transitions[0].To)
+	assert.Equal(t, enum.Healthy, state.Transitions[1].To)
+	assert.Equal(t, state.Transitions[1].At, state.Since)
+}
+
+func TestAdHocChecksHaveNoSideEffects(t *testing.T) {
+	monitor := &fakeMonitor{SampleBuffer: ebpf.NewSampleBuffer(0), raid: map[string]*ebpf.RAIDMetrics{
+		"host0": {DeviceID: "host0"},
+	}}
+	config := testConfig()
+	config.Sustain = Window{Required: 2, Samples: 3}
+	config.HysteresisRatio = 0.8
+	d := NewRAIDDetector(config, monitor, nil)
+
+	start := time.Now().Add(-time.Hour)
+	record := func(depth int) {
+		start = start.Add(time.Second)
+		monitor.Record(ebpf.Sample{DeviceType: enum.RAID, DeviceID: "host0", Time: start,
+			Values: map[string]float64{ebpf.MetricQueueDepth: float64(depth)}})
+	}
+	record(150)
+	record(150)
+	status, err := checkAll(d, "host0")
+	require.NoError(t, err)
+	require.Equal(t, enum.SubHealthy, status.Status)
+	since := status.Since
+
+	// Ad-hoc checks report what the periodic check would, but change nothing: between
+	// exit and enter thresholds the condition holds, and neither the state nor its
+	// transitions move however often a device is checked.
+	record(90)
+	for i := 0; i < 5; i++ {
+		status, err = d.CheckSubHealth("host0")
+		require.NoError(t, err)
+		assert.Equal(t, enum.SubHealthy, status.Status)
+		assert.Equal(t, since, status.Since)
+	}
+	record(50)
+	record(50)
+	status, err = d.CheckSubHealth("host0")
+	require.NoError(t, err)
+	assert.Equal(t, enum.Healthy, status.Status)
+	state, _ := d.State("host0")
+	assert.Equal(t, enum.SubHealthy, state.Status)
+	assert.Len(t, state.Transitions, 1)
+
+	status, err = checkAll(d, "host0")
+	require.NoError(t, err)
+	assert.Equal(t, enum.Healthy, status.Status)
+	state, _ = d.State("host0")
+	assert.Len(t, state.Transitions, 2)
+}
+
+func TestRuleEngineReportsEveryMatch(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+rules:
+  - name: queue
+    device_type: raid
+    metric: queue_depth
+    operator: ">="
+    threshold: 100
+    severity: subhealthy
+    confidence: 0.95
+    recommendation: "queue depth {{.Value}} on {{.DeviceID}}"
+  - name: latency
+    device_type: raid
+    metric: avg_latency_seconds
+    operator: ">"
+    threshold: 0.05
+    severity: subhealthy
+    confidence: 0.90
+    recommendation: "check firmware"
+  - name: host1_only
+    device_type: raid
+    devices: ["host1"]
+    metric: queue_depth
+    operator: ">"
+    threshold: 0
+    severity: failed
+    confidence: 0.5
+    recommendation: "never for host0"
+`))
+	require.NoError(t, err)
+	engine, err := NewRuleEngine(rules)
+	require.NoError(t, err)
+
+	monitor := &fakeMonitor{SampleBuffer: ebpf.NewSampleBuffer(0), raid: map[string]*ebpf.RAIDMetrics{
+		"host0": {DeviceID: "host0", QueueDepth: 150, AvgLatency: 80 * time.Millisecond},
+	}}
+	d := NewRAIDDetector(testConfig(), monitor, engine)
+
+	status, err := d.CheckSubHealth("host0")
+	require.NoError(t, err)
+	require.Len(t, status.Findings, 2)
+	assert.Equal(t, enum.SubHealthy, status.Status)
+	// The latency rule no longer overrides the more confident queue rule.
+	assert.Equal(t, 0.95, status.Confidence)
+	assert.Equal(t, "queue depth 150 on host0", status.Recommendation)
+	assert.Equal(t, "latency", status.Findings[1].Name)
+	assert.Equal(t, errors.ErrCodeQueueOverflow, status.Findings[0].Code)
+	assert.Equal(t, errors.ErrCodeHighLatency, status.Findings[1].Code)
+	assert.Equal(t, 0.08, status.Findings[1].Observed)
+	assert.Equal(t, 0.05, status.Findings[1].Threshold)
+	assert.False(t, status.Findings[1].Start.After(status.Findings[1].End))
+}
+
+func TestParseRulesRejectsInvalidRules(t *testing.T) {
+	_, err := ParseRules([]byte(`
+rules:
+  - name: bad
+    device_type: raid
+    metric: queue_depth
+    operator: "=>"
+    threshold: 1
+    severity: subhealthy
+    confidence: 0.9
+`))
+	assert.Error(t, err)
+
+	_, err = ParseRules([]byte(`
+rules:
+  - name: bad
+    device_type: tape
+    metric: queue_depth
+    operator: ">"
+    threshold: 1
+    severity: subhealthy
+    confidence: 0.9
+`))
+	assert.Error(t, err)
+
+	_, err = ParseRules([]byte(`
+rules:
+  - name: bad
+    device_type: raid
+    metric: queue_depth
+    operator: ">"
+    threshold: 1
+    severity: subhealthy
+    code: ERR_NOT_A_CODE
+    confidence: 0.9
+`))
+	assert.Error(t, err)
+}
+
+func TestDeriveStatus(t *testing.T) {
+	status, confidence, recommendation := DeriveStatus(nil)
+	assert.Equal(t, enum.Healthy, status)
+	assert.Equal(t, 1.0, confidence)
+	assert.Equal(t, "no action required", recommendation)
+
+	findings := []Finding{
+		{Severity: enum.SubHealthy, Source: SourceRule, Confidence: 0.9, Action: "rule"},
+		{Severity: enum.SubHealthy, Source: SourcePeer, Confidence: 0.6, Action: "peer"},
+		{Severity: enum.Failed, Source: SourceRule, Confidence: 0.5, Action: "replace"},
+		{Severity: enum.SubHealthy, Source: SourceRule, Confidence: 0.8, Action: "other rule"},
+	}
+	sortFindings(findings)
+	status, confidence, recommendation = DeriveStatus(findings)
+	assert.Equal(t, enum.Failed, status)
+	assert.Equal(t, 0.5, confidence)
+	assert.Equal(t, "replace", recommendation)
+
+	// Corroboration counts each independent source once.
+	status, confidence, recommendation = DeriveStatus(findings[1:])
+	assert.Equal(t, enum.SubHealthy, status)
+	assert.InDelta(t, 1-(1-0.9)*(1-0.6), confidence, 1e-9)
+	assert.Equal(t, "rule", recommendation)
+}
+
+func TestRuleEngineReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	write := func(threshold string) {
+		require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - name: queue
+    device_type: raid
+    metric: queue_depth
+    operator: ">"
+    threshold: `+threshold+`
+    severity: subhealthy
+    confidence: 0.9
+    recommendation: "isolate"
+`), 0644))
+	}
+	write("10")
+	engine, err := LoadRuleFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, engine.Rules()[0].Threshold)
+
+	write("20")
+	require.NoError(t, engine.Reload())
+	assert.Equal(t, 20.0, engine.Rules()[0].Threshold)
+
+	// An invalid file leaves the previous rules active.
+	require.NoError(t, os.WriteFile(path, []byte("rules: [{name: x}]"), 0644))
+	assert.Error(t, engine.Reload())
+	assert.Equal(t, 20.0, engine.Rules()[0].Threshold)
+}
+
+func TestDefaultRuleFileParses(t *testing.T) {
+	data, err := os.ReadFile("../../../configs/rules.yaml")
+	require.NoError(t, err)
+	_, err = ParseRules(data)
+	assert.NoError(t, err)
+}
+
+func TestDiskDetectorLatencyAnomaly(t *testing.T) {
+	monitor := &fakeMonitor{SampleBuffer: ebpf.NewSampleBuffer(0), disk: map[string]*ebpf.DiskMetrics{
+		"sda": {DeviceID: "sda"},
+	}}
+	d := NewDiskDetector(testConfig(), monitor, nil)
+	d.SetAnomalyDetector(NewAnomalyDetector(DefaultAnomalyConfig(), nil))
+
+	// Learn a 0.3ms baseline with a little jitter.
+	for i := 0; i < 60; i++ {
+		monitor.disk["sda"].AvgLatency = 300*time.Microsecond + time.Duration(i%5)*10*time.Microsecond
+		status, err := checkAll(d, "sda")
+		require.NoError(t, err)
+		assert.Equal(t, enum.Healthy, status.Status)
+	}
+
+	// 4ms is far below the 50ms threshold but far outside the baseline.
+	monitor.disk["sda"].AvgLatency = 4 * time.Millisecond
+	status, err := checkAll(d, "sda")
+	require.NoError(t, err)
+	assert.Equal(t, enum.SubHealthy, status.Status)
+	require.NotEmpty(t, status.Findings)
+	assert.Equal(t, SourceAnomaly, status.Findings[0].Source)
+	assert.Equal(t, errors.ErrCodeHighLatency, status.Findings[0].Code)
+	assert.Equal(t, ebpf.MetricAvgLatency, status.Findings[0].Metric)
+	assert.InDelta(t, 0.00032, status.Findings[0].Threshold, 0.00003)
+	assert.GreaterOrEqual(t, status.Confidence, 0.5)
+	assert.LessOrEqual(t, status.Confidence, 0.99)
+}
+
+func TestDiskDetectorSustainsAnomalies(t *testing.T) {
+	monitor := &fakeMonitor{SampleBuffer: ebpf.NewSampleBuffer(0), disk: map[string]*ebpf.DiskMetrics{
+		"sda": {DeviceID: "sda"},
+	}}
+	config := testConfig()
+	config.Sustain = Window{Required: 2, Samples: 2}
+	d := NewDiskDetector(config, monitor, nil)
+	d.SetAnomalyDetector(NewAnomalyDetector(DefaultAnomalyConfig(), nil))
+
+	start := time.Now()
+	check := func(i int, latency time.Duration) enum.HealthStatus {
+		monitor.disk["sda"].AvgLatency = latency
+		monitor.Record(ebpf.Sample{
+			DeviceType: enum.Disk,
+			DeviceID:   "sda",
+			Time:       start.Add(time.Duration(i) * time.Second),
+			Values:     monitor.disk["sda"].Values(),
+		})
+		status, err := checkAll(d, "sda")
+		require.NoError(t, err)
+		return status.Status
+	}
+	for i := 0; i < 60; i++ {
+		require.Equal(t, enum.Healthy, check(i, 300*time.Microsecond+time.Duration(i%5)*10*time.Microsecond))
+	}
+
+	// A single slow sample is not enough; a second one in a row is.
+	assert.Equal(t, enum.Healthy, check(60, 4*time.Millisecond))
+	assert.Equal(t, enum.Healthy, check(61, 300*time.Microsecond))
+	assert.Equal(t, enum.Healthy, check(62, 4*time.Millisecond))
+	assert.Equal(t, enum.SubHealthy, check(63, 4*time.Millisecond))
+	// Clearing needs the same evidence.
+	assert.Equal(t, enum.SubHealthy, check(64, 300*time.Microsecond))
+	assert.Equal(t, enum.Healthy, check(65, 300*time.Microsecond))
+}
+
+func TestAnomalyDetectorMinAbsoluteSpread(t *testing.T) {
+	a := NewAnomalyDetector(DefaultAnomalyConfig(), nil)
+	at := time.Now()
+	for i := 0; i < 40; i++ {
+		a.Learn(enum.RAID, "host0", map[string]float64{ebpf.MetricErrorRetryRate: 0}, at)
+	}
+
+	// A lone retry on a clean controller is within the absolute floor; a burst is not.
+	assert.Empty(t, a.Evaluate(enum.RAID, "host0", map[string]float64{ebpf.MetricErrorRetryRate: 1}, at))
+	anomalies := a.Evaluate(enum.RAID, "host0", map[string]float64{ebpf.MetricErrorRetryRate: 5}, at)
+	require.Len(t, anomalies, 1)
+	assert.Equal(t, 10.0, anomalies[0].Score)
+}
+
+func TestAnomalyDetectorSeedsFromStorage(t *testing.T) {
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	for i := 0; i < 40; i++ {
+		require.NoError(t, store.Store(storage.Metric{
+			Timestamp:  time.Now().Add(-time.Duration(40-i) * time.Minute),
+			DeviceType: enum.Network,
+			DeviceID:   "eth0",
+			Value:      &ebpf.NetworkMetrics{DeviceID: "eth0", LatencyP95: time.Millisecond, BytesPerSecond: 1000 + uint64(i%3)},
+		}))
+	}
+
+	a := NewAnomalyDetector(DefaultAnomalyConfig(), store)
+	anomalies := a.Evaluate(enum.Network, "eth0", map[string]float64{ebpf.MetricLatencyP95: 0.001}, time.Now())
+	assert.Empty(t, anomalies)
+
+	anomalies = a.Evaluate(enum.Network, "eth0", map[string]float64{ebpf.MetricLatencyP95: 0.02}, time.Now())
+	require.Len(t, anomalies, 1)
+	assert.Equal(t, "robust_zscore", anomalies[0].Method)
+	assert.Greater(t, anomalies[0].Score, 4.0)
+}
+
+// flakyStorage fails its first queries before delegating to the wrapped storage.
+type flakyStorage struct {
+	storage.Storage
+	failures int
+}
+
+func (s *flakyStorage) Query(deviceType enum.DeviceType, deviceID string, window time.Duration) ([]storage.Metric, error) {
+	if s.failures > 0 {
+		s.failures--
+		return nil, errors.New("storage unavailable", nil)
+	}
+	return s.Storage.Query(deviceType, deviceID, window)
+}
+
+func TestAnomalyDetectorRetriesFailedSeeding(t *testing.T) {
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	for i := 0; i < 40; i++ {
+		require.NoError(t, store.Store(storage.Metric{
+			Timestamp:  time.Now().Add(-time.Duration(40-i) * time.Minute),
+			DeviceType: enum.Network,
+			DeviceID:   "eth0",
+			Value:      &ebpf.NetworkMetrics{DeviceID: "eth0", LatencyP95: time.Millisecond},
+		}))
+	}
+
+	a := NewAnomalyDetector(DefaultAnomalyConfig(), &flakyStorage{Storage: store, failures: 1})
+	// Without history there is no baseline to score against yet.
+	assert.Empty(t, a.Score(enum.Network, "eth0", map[string]float64{ebpf.MetricLatencyP95: 0.02}, time.Now()))
+	anomalies := a.Evaluate(enum.Network, "eth0", map[string]float64{ebpf.MetricLatencyP95: 0.02}, time.Now())
+	require.Len(t, anomalies, 1)
+	assert.Equal(t, "robust_zscore", anomalies[0].Method)
+}
+
+func TestAnomalyDetectorDoesNotLearnAnomalies(t *testing.T) {
+	a := NewAnomalyDetector(DefaultAnomalyConfig(), nil)
+	at := time.Now()
+	for i := 0; i < 40; i++ {
+		a.Learn(enum.Disk, "sda", map[string]float64{ebpf.MetricAvgLatency: 0.0003 + float64(i%5)*0.00001}, at)
+	}
+
+	// A sustained fault keeps being flagged instead of becoming the baseline.
+	for i := 0; i < 200; i++ {
+		require.Len(t, a.Evaluate(enum.Disk, "sda", map[string]float64{ebpf.MetricAvgLatency: 0.004}, at), 1)
+	}
+}
+
+func TestAnomalyDetectorSeasonalBaseline(t *testing.T) {
+	config := DefaultAnomalyConfig()
+	config.Seasonal = true
+	a := NewAnomalyDetector(config, nil)
+
+	// Nightly backups make 02:00 busy every week; other hours are quiet. Four weeks of
+	// five-minute samples fill every hour-of-week bucket past MinSamples.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4*168*12; i++ {
+		at := start.Add(time.Duration(i) * 5 * time.Minute)
+		value := 10.0
+		if at.Hour() == 2 {
+			value = 500
+		}
+		a.Learn(enum.Disk, "sda", map[string]float64{ebpf.MetricIOPS: value + float64(i%2)}, at)
+	}
+
+	backup := start.Add(time.Duration(4*168+2) * time.Hour)
+	assert.Empty(t, a.Evaluate(enum.Disk, "sda", map[string]float64{ebpf.MetricIOPS: 500}, backup))
+
+	quiet := backup.Add(10 * time.Hour)
+	anomalies := a.Evaluate(enum.Disk, "sda", map[string]float64{ebpf.MetricIOPS: 500}, quiet)
+	require.Len(t, anomalies, 1)
+	assert.Equal(t, "seasonal", anomalies[0].Method)
+}
+
+func TestPeerDetectorFlagsFailSlowDisk(t *testing.T) {
+	monitor := &fakeMonitor{SampleBuffer: ebpf.NewSampleBuffer(0), disk: map[string]*ebpf.DiskMetrics{}}
+	ids := []string{"sda", "sdb", "sdc", "sdd"}
+	for _, id := range ids {
+		monitor.disk[id] = &ebpf.DiskMetrics{DeviceID: id, AvgLatency: 2 * time.Millisecond, IOPS: 200}
+	}
+	// sdc is ~6x slower than its siblings, yet well inside the 50ms absolute threshold.
+	monitor.disk["sdc"].AvgLatency = 12 * time.Millisecond
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		for _, id := range ids {
+			latency := 0.002 + float64(i%4)*0.0001
+			if id == "sdc" {
+				latency = 0.012 + float64(i%4)*0.0005
+			}
+			monitor.Record(ebpf.Sample{
+				DeviceType: enum.Disk,
+				DeviceID:   id,
+				Time:       now.Add(time.Duration(i-20) * time.Second),
+				Values:     map[string]float64{ebpf.MetricAvgLatency: latency, ebpf.MetricIOPS: 200},
+			})
+		}
+	}
+
+	groups := StaticGroups{enum.Disk: {"md:md0": ids}}
+	d := NewPeerDetector(DefaultPeerConfig(), monitor, groups, enum.Disk)
+
+	all, err := d.CheckAll()
+	require.NoError(t, err)
+	require.Len(t, all, 4)
+	for _, status := range all {
+		if status.DeviceID != "sdc" {
+			assert.Equal(t, enum.Healthy, status.Status, status.DeviceID)
+		}
+	}
+
+	status, err := d.CheckSubHealth("sdc")
+	require.NoError(t, err)
+	assert.Equal(t, enum.SubHealthy, status.Status)
+	assert.Greater(t, status.Confidence, 0.5)
+
+	comparison, ok := status.Metrics.(PeerComparison)
+	require.True(t, ok)
+	assert.Same(t, monitor.disk["sdc"], comparison.Metrics)
+	var latency *PeerEvidence
+	for i := range comparison.Evidence {
+		if comparison.Evidence[i].Metric == ebpf.MetricAvgLatency {
+			latency = &comparison.Evidence[i]
+		}
+	}
+	require.NotNil(t, latency)
+	assert.True(t, latency.Outlier)
+	assert.True(t, latency.Tested)
+	assert.Equal(t, "md:md0", latency.Group)
+	assert.Equal(t, 3, latency.Peers)
+	assert.Greater(t, latency.Ratio, 5.0)
+	assert.Less(t, latency.PValue, 0.01)
+}
+
+func TestPeerDetectorComparesThroughputOnlyUnderSharedLoad(t *testing.T) {
+	monitor := &fakeMonitor{SampleBuffer: ebpf.NewSampleBuffer(0), disk: map[string]*ebpf.DiskMetrics{}}
+	ids := []string{"sda", "sdb", "sdc", "sdd"}
+	for _, id := range ids {
+		monitor.disk[id] = &ebpf.DiskMetrics{DeviceID: id, AvgLatency: 2 * time.Millisecond, IOPS: 200}
+	}
+	// sdd is idle: it serves no I/O at all.
+	monitor.disk["sdd"].IOPS = 0
+
+	for group, flagged := range map[string]bool{"model:ST4000NM": false, "md:md0": true} {
+		d := NewPeerDetector(DefaultPeerConfig(), monitor, StaticGroups{enum.Disk: {group: ids}}, enum.Disk)
+		status, err := checkAll(d, "sdd")
+		require.NoError(t, err)
+		var iops *PeerEvidence
+		for i, e := range status.Metrics.(PeerComparison).Evidence {
+			if e.Metric == ebpf.MetricIOPS {
+				iops = &status.Metrics.(PeerComparison).Evidence[i]
+			}
+		}
+		if !flagged {
+			assert.Equal(t, enum.Healthy, status.Status, group)
+			assert.Nil(t, iops, group)
+			continue
+		}
+		assert.Equal(t, enum.SubHealthy, status.Status, group)
+		require.NotNil(t, iops, group)
+		assert.True(t, iops.Outlier)
+	}
+}
+
+func TestPeerDetectorSkipsSmallGroups(t *testing.T) {
+	monitor := &fakeMonitor{SampleBuffer: ebpf.NewSampleBuffer(0), network: map[string]*ebpf.NetworkMetrics{
+		"eth0": {DeviceID: "eth0", LatencyP95: time.Millisecond},
+		"eth1": {DeviceID: "eth1", LatencyP95: 50 * time.Millisecond},
+	}}
+	d := NewPeerDetector(DefaultPeerConfig(), monitor, StaticGroups{enum.Network: {"bond:bond0": {"eth0", "eth1"}}}, enum.Network)
+
+	status, err := d.CheckSubHealth("eth1")
+	require.NoError(t, err)
+	assert.Equal(t, enum.Healthy, status.Status)
+	assert.Empty(t, status.Metrics.(PeerComparison).Evidence)
+
+	_, err = d.CheckSubHealth("eth9")
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+}
+
+func TestScoreHostPropagatesDependencies(t *testing.T) {
+	controller := DeviceRef{enum.RAID, "host0"}
+	topology := Topology{
+		Host:  "node1",
+		Tiers: map[DeviceRef]slo.ServiceTier{{enum.Network, "eth0"}: slo.Critical},
+		DependsOn: map[DeviceRef][]DeviceRef{
+			{enum.Disk, "sda"}: {controller},
+			{enum.Disk, "sdb"}: {controller},
+		},
+	}
+	statuses := []HealthStatus{
+		{DeviceType: enum.Disk, DeviceID: "sda", Status: enum.Healthy, Confidence: 1},
+		{DeviceType: enum.Disk, DeviceID: "sdb", Status: enum.Healthy, Confidence: 1},
+		{DeviceType: enum.RAID, DeviceID: "host0", Status: enum.SubHealthy, Confidence: 1},
+		{DeviceType: enum.Network, DeviceID: "eth0", Status: enum.Healthy, Confidence: 1},
+	}
+
+	score := ScoreHost(DefaultScoreConfig(), topology, statuses)
+	assert.Equal(t, "node1", score.Host)
+	assert.Equal(t, enum.SubHealthy, score.Status)
+	// Weights: host0 3, sda 1, sdb 1, eth0 2*2; penalties: 40, 40 inherited, 40 inherited, 0.
+	assert.InDelta(t, 100-200.0/9, score.Score, 1e-9)
+	assert.InDelta(t, 60, score.ByType[enum.RAID], 1e-9)
+	assert.InDelta(t, 60, score.ByType[enum.Disk], 1e-9)
+	assert.InDelta(t, 100, score.ByType[enum.Network], 1e-9)
+
+	require.Len(t, score.Breakdown, 4)
+	assert.Equal(t, "host0", score.Breakdown[0].DeviceID)
+	assert.InDelta(t, 120.0/9, score.Breakdown[0].Impact, 1e-9)
+	assert.Equal(t, "sda", score.Breakdown[1].DeviceID)
+	assert.Equal(t, enum.SubHealthy, score.Breakdown[1].Status)
+	assert.Zero(t, score.Breakdown[1].Penalty)
+	assert.InDelta(t, 40, score.Breakdown[1].Inherited, 1e-9)
+	assert.Equal(t, &controller, score.Breakdown[1].InheritedFrom)
+	assert.Equal(t, "eth0", score.Breakdown[3].DeviceID)
+	assert.Equal(t, slo.Critical, score.Breakdown[3].Tier)
+	assert.InDelta(t, 4, score.Breakdown[3].Weight, 1e-9)
+	assert.Zero(t, score.Breakdown[3].Impact)
+}
+
+func TestScoreHostCutsDependencyCycles(t *testing.T) {
+	sda, sdb := DeviceRef{enum.Disk, "sda"}, DeviceRef{enum.Disk, "sdb"}
+	topology := Topology{DependsOn: map[DeviceRef][]DeviceRef{sda: {sdb}, sdb: {sda}}}
+	statuses := []HealthStatus{
+		{DeviceType: enum.Disk, DeviceID: "sda", Status: enum.Failed, Confidence: 0.5},
+		{DeviceType: enum.Disk, DeviceID: "sdb", Status: enum.Healthy, Confidence: 1},
+	}
+
+	score := ScoreHost(DefaultScoreConfig(), topology, statuses)
+	assert.Equal(t, enum.Failed, score.Status)
+	assert.InDelta(t, 50, score.Score, 1e-9)
+
+	empty := ScoreHost(DefaultScoreConfig(), Topology{}, nil)
+	assert.Equal(t, 100.0, empty.Score)
+	assert.Equal(t, enum.Healthy, empty.Status)
+}
+
+func TestScoreCluster(t *testing.T) {
+	cluster := ScoreCluster([]HostScore{
+		{Host: "node1", Score: 100, Status: enum.Healthy},
+		{Host: "node2", Score: 60, Status: enum.SubHealthy},
+		{Host: "node3", Score: 80, Status: enum.SubHealthy},
+	})
+	assert.InDelta(t, 80, cluster.Score, 1e-9)
+	assert.Equal(t, enum.SubHealthy, cluster.Status)
+	require.Len(t, cluster.Hosts, 3)
+	assert.Equal(t, "node2", cluster.Hosts[0].Host)
+	assert.Equal(t, "node1", cluster.Hosts[2].Host)
+}
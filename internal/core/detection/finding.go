@@ -0,0 +1,120 @@
+package detection
+
+import (
+	"fmt"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"sort"
+	"time"
+)
+
+// Finding sources.
+const (
+	SourceRule    = "rule"
+	SourceAnomaly = "anomaly"
+	SourcePeer    = "peer"
+)
+
+// Finding is one piece of evidence that a device is degraded.
+type Finding struct {
+	Code       string            // One of the ErrCode* codes in internal/common/errors
+	Severity   enum.HealthStatus // SubHealthy or Failed
+	Source     string            // SourceRule, SourceAnomaly or SourcePeer
+	Name       string            // Rule name, anomaly method or peer group
+	Metric     string
+	Operator   string  // Comparison that triggered the finding, e.g. ">"
+	Threshold  float64 // Threshold, learned baseline or peer median the value was compared with
+	Observed   float64
+	Confidence float64
+	Start      time.Time // When the condition was first observed
+	End        time.Time // When the condition was last observed
+	Action     string    // Recommended action
+}
+
+// Explain returns a one-line, human-readable explanation of the finding.
+func (f Finding) Explain() string {
+	var basis string
+	switch f.Source {
+	case SourceAnomaly:
+		basis = fmt.Sprintf("deviates from its %s baseline %.4g", f.Name, f.Threshold)
+	case SourcePeer:
+		basis = fmt.Sprintf("deviates from the %s peer median %.4g", f.Name, f.Threshold)
+	default:
+		basis = fmt.Sprintf("%s %.4g (rule %s)", f.Operator, f.Threshold, f.Name)
+	}
+	return fmt.Sprintf("[%s] %s: %s=%.4g %s since %s",
+		f.Severity, f.Code, f.Metric, f.Observed, basis, f.Start.UTC().Format(time.RFC3339))
+}
+
+// metricCodes maps each metric to the error code reported for findings on it.
+var metricCodes = map[string]string{
+	ebpf.MetricQueueDepth:         errors.ErrCodeQueueOverflow,
+	ebpf.MetricAvgLatency:         errors.ErrCodeHighLatency,
+	ebpf.MetricLatencyP95:         errors.ErrCodeHighLatency,
+	ebpf.MetricErrorRetryRate:     errors.ErrCodeStorageFailure,
+	ebpf.MetricIOPS:               errors.ErrCodeStorageFailure,
+	ebpf.MetricIOPSVariance:       errors.ErrCodeStorageFailure,
+	ebpf.MetricReallocatedSectors: errors.ErrCodeStorageFailure,
+	ebpf.MetricReadErrorRate:      errors.ErrCodeStorageFailure,
+	ebpf.MetricTemperature:        errors.ErrCodeStorageFailure,
+	ebpf.MetricPacketLossRate:     errors.ErrCodeNetworkPacketLoss,
+	ebpf.MetricBytesPerSecond:     errors.ErrCodeNetworkPacketLoss,
+}
+
+// findingCodes lists the codes a rule may declare.
+var findingCodes = map[string]bool{
+	errors.ErrCodeQueueOverflow:     true,
+	errors.ErrCodeFirmwareMismatch:  true,
+	errors.ErrCodeHighLatency:       true,
+	errors.ErrCodeStorageFailure:    true,
+	errors.ErrCodeNetworkPacketLoss: true,
+}
+
+// codeForMetric returns the error code for findings on a metric.
+func codeForMetric(deviceType enum.DeviceType, metric string) string {
+	if code, ok := metricCodes[metric]; ok {
+		return code
+	}
+	switch deviceType {
+	case enum.RAID:
+		return errors.ErrCodeQueueOverflow
+	case enum.Network:
+		return errors.ErrCodeNetworkPacketLoss
+	default:
+		return errors.ErrCodeStorageFailure
+	}
+}
+
+// sortFindings orders findings most severe, then most confident, first.
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Severity != findings[j].Severity {
+			return findings[i].Severity > findings[j].Severity
+		}
+		return findings[i].Confidence > findings[j].Confidence
+	})
+}
+
+// DeriveStatus derives the overall status from sorted findings. The status is that of the
+// most severe finding, so a lower-severity finding never masks a worse one. Findings of that
+// severity from independent sources (rules, baselines, peers) corroborate each other and raise
+// the confidence; within a source the most confident finding counts.
+func DeriveStatus(findings []Finding) (enum.HealthStatus, float64, string) {
+	if len(findings) == 0 {
+		return enum.Healthy, 1.0, "no action required"
+	}
+
+	top := findings[0]
+	bySource := make(map[string]float64)
+	for _, f := range findings {
+		if f.Severity == top.Severity && f.Confidence > bySource[f.Source] {
+			bySource[f.Source] = f.Confidence
+		}
+	}
+	doubt := 1.0
+	for _, confidence := range bySource {
+		doubt *= 1 - confidence
+	}
+	return top.Severity, 1 - doubt, top.Action
+}
@@ -0,0 +1,402 @@
+package detection
+
+import (
+	"fmt"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PeerConfig defines how devices are compared with their peers.
+type PeerConfig struct {
+	Window            time.Duration // Sample history compared per device
+	MinSamples        int           // Samples per device required for the distribution test
+	MinGroupSize      int           // Devices present in a group before it is compared
+	ScoreThreshold    float64       // Robust z-score against the peer median at which a device is an outlier
+	MinRatio          float64       // Minimum degradation relative to the peer median (e.g., 3 for 3x slower)
+	Significance      float64       // p-value below which a device's distribution differs from its peers
+	MinRelativeSpread float64       // Lower bound on the peer spread as a fraction of the peer median
+}
+
+// DefaultPeerConfig returns the default fail-slow comparison settings.
+func DefaultPeerConfig() *PeerConfig {
+	return &PeerConfig{
+		Window:            10 * time.Minute,
+		MinSamples:        10,
+		MinGroupSize:      3,
+		ScoreThreshold:    3.5,
+		MinRatio:          3,
+		Significance:      0.01,
+		MinRelativeSpread: 0.05,
+	}
+}
+
+// PeerGrouper discovers groups of devices expected to perform alike, keyed by group name.
+type PeerGrouper interface {
+	PeerGroups(deviceType enum.DeviceType) (map[string][]string, error)
+}
+
+// StaticGroups is a PeerGrouper backed by configured groups.
+type StaticGroups map[enum.DeviceType]map[string][]string
+
+// PeerGroups returns the configured groups for a device type.
+func (g StaticGroups) PeerGroups(deviceType enum.DeviceType) (map[string][]string, error) {
+	return g[deviceType], nil
+}
+
+// PeerEvidence records how a device compares with its peers on one metric.
+type PeerEvidence struct {
+	Group      string
+	Metric     string
+	Value      float64 // Device's median over the window, or its current value
+	PeerMedian float64
+	Ratio      float64 // Degradation relative to the peer median; >1 is worse
+	Score      float64 // Robust z-score against the peers
+	Peers      int
+	Tested     bool    // Whether sampled distributions were compared
+	PValue     float64 // One-sided Mann-Whitney p-value; only meaningful when Tested
+	Outlier    bool
+}
+
+// PeerComparison is the HealthStatus.Metrics payload of a peer check.
+type PeerComparison struct {
+	Metrics  interface{}    // Device-specific metrics (RAID, Disk, or Network)
+	Evidence []PeerEvidence // Comparisons in every group the device belongs to
+}
+
+// peerMetric is a metric compared between peers and the direction in which it degrades.
+type peerMetric struct {
+	name          string
+	higherIsWorse bool
+	sharedLoad    bool // Only compared in groups whose members share one workload
+}
+
+// sharesLoad reports whether a group's members serve one workload, so that their
+// throughput is comparable: md array members and bond slaves. Members of other groups,
+// such as disks of the same model, carry unrelated workloads and an idle one is not slow.
+func sharesLoad(group string) bool {
+	return strings.HasPrefix(group, "md:") || strings.HasPrefix(group, "bond:")
+}
+
+// peerMetrics lists the latency and throughput metrics compared per device type.
+var peerMetrics = map[enum.DeviceType][]peerMetric{
+	enum.RAID: {
+		{ebpf.MetricAvgLatency, true, false},
+		{ebpf.MetricQueueDepth, true, false},
+	},
+	enum.Disk: {
+		{ebpf.MetricAvgLatency, true, false},
+		{ebpf.MetricIOPS, false, true},
+	},
+	enum.Network: {
+		{ebpf.MetricLatencyP95, true, false},
+		{ebpf.MetricPacketLossRate, true, false},
+	},
+}
+
+// PeerDetector implements Detector by flagging devices that are fail-slow relative to the
+// other members of their groups, even when every absolute value is within thresholds.
+type PeerDetector struct {
+	config     *PeerConfig
+	monitor    ebpf.Monitor
+	grouper    PeerGrouper
+	deviceType enum.DeviceType
+	states     *StateTracker
+}
+
+// NewPeerDetector creates a new PeerDetector for one device type.
+func NewPeerDetector(config *PeerConfig, monitor ebpf.Monitor, grouper PeerGrouper, deviceType enum.DeviceType) *PeerDetector {
+	return &PeerDetector{
+		config:     config,
+		monitor:    monitor,
+		grouper:    grouper,
+		deviceType: deviceType,
+		states:     NewStateTracker(),
+	}
+}
+
+// CheckSubHealth compares a device with its peers without updating its tracked state.
+func (d *PeerDetector) CheckSubHealth(deviceID string) (HealthStatus, error) {
+	metrics, evidence, err := d.compare()
+	if err != nil {
+		return HealthStatus{}, err
+	}
+	if _, ok := metrics[deviceID]; !ok {
+		return HealthStatus{}, errors.NewNotFound(d.deviceType.String()+" device "+deviceID+" not found", nil)
+	}
+	return d.assess(deviceID, metrics[deviceID], evidence[deviceID], false), nil
+}
+
+// CheckAll compares every device of the detector's type with its peers and tracks the
+// resulting states.
+func (d *PeerDetector) CheckAll() ([]HealthStatus, error) {
+	metrics, evidence, err := d.compare()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]HealthStatus, 0, len(metrics))
+	for _, id := range sortedKeys(metrics) {
+		results = append(results, d.assess(id, metrics[id], evidence[id], true))
+	}
+	return results, nil
+}
+
+// State returns the tracked peer-comparison state of a device.
+func (d *PeerDetector) State(deviceID string) (DeviceState, bool) {
+	return d.states.State(d.deviceType, deviceID)
+}
+
+// assess derives a device's status from its peer evidence; the device's state is only
+// updated with record set.
+func (d *PeerDetector) assess(deviceID string, metrics interface{}, evidence []PeerEvidence, record bool) HealthStatus {
+	now := time.Now()
+	var findings []Finding
+	for _, e := range evidence {
+		if e.Outlier {
+			findings = append(findings, d.finding(deviceID, e, now))
+		}
+	}
+	sortFindings(findings)
+	status, confidence, recommendation := DeriveStatus(findings)
+
+	var state DeviceState
+	if record {
+		state, _ = d.states.Update(d.deviceType, deviceID, status, now)
+	} else {
+		state = d.states.Peek(d.deviceType, deviceID, status, now)
+	}
+	return HealthStatus{
+		DeviceType:     d.deviceType,
+		DeviceID:       deviceID,
+		Status:         status,
+		Confidence:     confidence,
+		Recommendation: recommendation,
+		Since:          state.Since,
+		Findings:       findings,
+		Metrics:        PeerComparison{Metrics: metrics, Evidence: evidence},
+	}
+}
+
+func (d *PeerDetector) finding(deviceID string, e PeerEvidence, now time.Time) Finding {
+	operator := ">"
+	if e.Value < e.PeerMedian {
+		operator = "<"
+	}
+	confidence := anomalyConfidence(e.Score, d.config.ScoreThreshold)
+	start := now
+	if e.Tested {
+		confidence = math.Max(confidence, math.Min(1-e.PValue, 0.99))
+		start = now.Add(-d.config.Window)
+	}
+	return Finding{
+		Code:       codeForMetric(d.deviceType, e.Metric),
+		Severity:   enum.SubHealthy,
+		Source:     SourcePeer,
+		Name:       e.Group,
+		Metric:     e.Metric,
+		Operator:   operator,
+		Threshold:  e.PeerMedian,
+		Observed:   e.Value,
+		Confidence: confidence,
+		Start:      start,
+		End:        now,
+		Action: fmt.Sprintf("%s %s is fail-slow: %s is %.1fx worse than the median of %d peers in %s; consider isolating or replacing it",
+			d.deviceType, deviceID, e.Metric, e.Ratio, e.Peers, e.Group),
+	}
+}
+
+// compare snapshots the current metrics and compares every grouped device with its peers.
+func (d *PeerDetector) compare() (map[string]interface{}, map[string][]PeerEvidence, error) {
+	metrics, values, err := d.snapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	groups, err := d.grouper.PeerGroups(d.deviceType)
+	if err != nil {
+		return nil, nil, errors.New("failed to discover peer groups", err)
+	}
+
+	evidence := make(map[string][]PeerEvidence)
+	for _, group := range sortedKeys(groups) {
+		var members []string
+		for _, id := range groups[group] {
+			if _, ok := values[id]; ok {
+				members = append(members, id)
+			}
+		}
+		if len(members) < d.config.MinGroupSize || len(members) < 2 {
+			continue
+		}
+		for _, metric := range peerMetrics[d.deviceType] {
+			if metric.sharedLoad && !sharesLoad(group) {
+				continue
+			}
+			for _, e := range d.compareMetric(group, metric, members, values) {
+				evidence[e.id] = append(evidence[e.id], e.PeerEvidence)
+			}
+		}
+	}
+	return metrics, evidence, nil
+}
+
+type memberEvidence struct {
+	id string
+	PeerEvidence
+}
+
+// compareMetric compares each member with the others of its group on one metric. Each
+// member is judged against its peers only, so an outlier does not skew its own baseline.
+func (d *PeerDetector) compareMetric(group string, metric peerMetric, members []string, values map[string]map[string]float64) []memberEvidence {
+	samples := make(map[string][]float64, len(members))
+	representative := make(map[string]float64, len(members))
+	tested := true
+	for _, id := range members {
+		for _, p := range d.monitor.Samples(d.deviceType, id, metric.name, d.config.Window) {
+			samples[id] = append(samples[id], p.Value)
+		}
+		if len(samples[id]) < d.config.MinSamples || len(samples[id]) == 0 {
+			tested = false
+		}
+		if len(samples[id]) > 0 {
+			sorted := append([]float64(nil), samples[id]...)
+			sort.Float64s(sorted)
+			representative[id] = ebpf.Percentile(sorted, 0.5)
+		} else {
+			representative[id] = values[id][metric.name]
+		}
+	}
+
+	results := make([]memberEvidence, 0, len(members))
+	for _, id := range members {
+		var peers, pooled []float64
+		for _, peer := range members {
+			if peer != id {
+				peers = append(peers, representative[peer])
+				pooled = append(pooled, samples[peer]...)
+			}
+		}
+		median, mad := medianMAD(peers)
+		spread := math.Max(1.4826*mad, math.Max(math.Abs(median)*d.config.MinRelativeSpread, 1e-9))
+
+		value := representative[id]
+		deviation := value - median
+		ratio := ratioTo(value, median)
+		if !metric.higherIsWorse {
+			deviation = -deviation
+			ratio = ratioTo(median, value)
+		}
+
+		e := PeerEvidence{
+			Group:      group,
+			Metric:     metric.name,
+			Value:      value,
+			PeerMedian: median,
+			Ratio:      ratio,
+			Score:      deviation / spread,
+			Peers:      len(peers),
+			Tested:     tested,
+		}
+		e.Outlier = e.Score >= d.config.ScoreThreshold && e.Ratio >= d.config.MinRatio
+		if tested {
+			e.PValue = mannWhitneyP(samples[id], pooled, metric.higherIsWorse)
+			e.Outlier = e.Outlier && e.PValue < d.config.Significance
+		}
+		results = append(results, memberEvidence{id, e})
+	}
+	return results
+}
+
+// snapshot returns the current metrics of every device of the detector's type.
+func (d *PeerDetector) snapshot() (map[string]interface{}, map[string]map[string]float64, error) {
+	metrics := make(map[string]interface{})
+	values := make(map[string]map[string]float64)
+	switch d.deviceType {
+	case enum.RAID:
+		all, err := d.monitor.GetRAIDMetrics()
+		if err != nil {
+			return nil, nil, errors.NewQueueOverflow("failed to get RAID metrics", err)
+		}
+		for id, m := range all {
+			metrics[id], values[id] = m, m.Values()
+		}
+	case enum.Disk:
+		all, err := d.monitor.GetDiskMetrics()
+		if err != nil {
+			return nil, nil, errors.NewStorageFailure("failed to get disk metrics", err)
+		}
+		for id, m := range all {
+			metrics[id], values[id] = m, m.Values()
+		}
+	case enum.Network:
+		all, err := d.monitor.GetNetworkMetrics()
+		if err != nil {
+			return nil, nil, errors.NewNetworkPacketLoss("failed to get network metrics", err)
+		}
+		for id, m := range all {
+			metrics[id], values[id] = m, m.Values()
+		}
+	default:
+		return nil, nil, errors.New("unsupported device type", nil)
+	}
+	return metrics, values, nil
+}
+
+// ratioTo returns a/b, treating a zero denominator as an unbounded ratio when a is positive.
+func ratioTo(a, b float64) float64 {
+	if b <= 0 {
+		if a > 0 {
+			return math.Inf(1)
+		}
+		return 1
+	}
+	return a / b
+}
+
+// mannWhitneyP returns the one-sided p-value that x is stochastically greater than y
+// (or less, when greater is false), using the normal approximation of the U statistic.
+func mannWhitneyP(x, y []float64, greater bool) float64 {
+	n1, n2 := float64(len(x)), float64(len(y))
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	type ranked struct {
+		value float64
+		fromX bool
+	}
+	all := make([]ranked, 0, len(x)+len(y))
+	for _, v := range x {
+		all = append(all, ranked{v, true})
+	}
+	for _, v := range y {
+		all = append(all, ranked{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+
+	// Sum the ranks of x, averaging ranks across ties.
+	rankSum := 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromX {
+				rankSum += rank
+			}
+		}
+		i = j
+	}
+
+	u := rankSum - n1*(n1+1)/2
+	z := (u - n1*n2/2) / math.Sqrt(n1*n2*(n1+n2+1)/12)
+	if !greater {
+		z = -z
+	}
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
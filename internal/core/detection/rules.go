@@ -0,0 +1,389 @@
+package detection
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Rule is a declarative detection rule as loaded from YAML.
+type Rule struct {
+	Name           string   `yaml:"name"`
+	DeviceType     string   `yaml:"device_type"`              // raid, disk or network
+	Devices        []string `yaml:"devices,omitempty"`        // Optional device ID globs; empty matches all devices
+	Metric         string   `yaml:"metric"`                   // Metric name, e.g. "queue_depth"
+	Operator       string   `yaml:"operator"`                 // One of >, >=, <, <=, ==, !=
+	Threshold      float64  `yaml:"threshold"`                // Value at which the rule starts matching
+	ExitThreshold  *float64 `yaml:"exit_threshold,omitempty"` // Value at which a matching rule clears; derived from HysteresisRatio when unset
+	Severity       string   `yaml:"severity"`                 // subhealthy or failed
+	Code           string   `yaml:"code,omitempty"`           // Finding code (ERR_*); derived from the metric when unset
+	Confidence     float64  `yaml:"confidence"`
+	Recommendation string   `yaml:"recommendation"` // text/template over DeviceType, DeviceID, Metric, Value and Threshold
+}
+
+// RuleSet is the top-level structure of a rule file.
+type RuleSet struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// operators maps rule operators to their comparison functions.
+var operators = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// compiledRule is a validated Rule ready for evaluation.
+type compiledRule struct {
+	Rule
+	deviceType     enum.DeviceType
+	severity       enum.HealthStatus
+	cmp            func(v, threshold float64) bool
+	recommendation *template.Template
+}
+
+// recommendationData is the data passed to recommendation templates.
+type recommendationData struct {
+	DeviceType string
+	DeviceID   string
+	Metric     string
+	Value      float64
+	Threshold  float64
+}
+
+// exit returns the threshold at which a matching rule clears.
+func (r *compiledRule) exit(hysteresisRatio float64) float64 {
+	if r.ExitThreshold != nil {
+		return *r.ExitThreshold
+	}
+	if hysteresisRatio <= 0 || hysteresisRatio >= 1 {
+		return r.Threshold
+	}
+	switch r.Operator {
+	case ">", ">=":
+		return r.Threshold * hysteresisRatio
+	case "<", "<=":
+		return r.Threshold / hysteresisRatio
+	default:
+		return r.Threshold
+	}
+}
+
+// appliesTo reports whether the rule selects the given device.
+func (r *compiledRule) appliesTo(deviceType enum.DeviceType, deviceID string) bool {
+	if r.deviceType != deviceType {
+		return false
+	}
+	if len(r.Devices) == 0 {
+		return true
+	}
+	for _, pattern := range r.Devices {
+		if ok, _ := path.Match(pattern, deviceID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func compileRule(rule Rule) (compiledRule, error) {
+	if rule.Name == "" {
+		return compiledRule{}, errors.New("rule name is required", nil)
+	}
+	deviceType, err := enum.ParseDeviceType(rule.DeviceType)
+	if err != nil {
+		return compiledRule{}, errors.New("rule "+rule.Name, err)
+	}
+	if rule.Metric == "" {
+		return compiledRule{}, errors.New("rule "+rule.Name+": metric is required", nil)
+	}
+	cmp, ok := operators[rule.Operator]
+	if !ok {
+		return compiledRule{}, errors.New(fmt.Sprintf("rule %s: unsupported operator %q", rule.Name, rule.Operator), nil)
+	}
+	severity, err := enum.ParseHealthStatus(rule.Severity)
+	if err != nil || severity == enum.Healthy {
+		return compiledRule{}, errors.New(fmt.Sprintf("rule %s: severity must be subhealthy or failed, got %q", rule.Name, rule.Severity), nil)
+	}
+	if rule.Code != "" && !findingCodes[rule.Code] {
+		return compiledRule{}, errors.New(fmt.Sprintf("rule %s: unknown code %q", rule.Name, rule.Code), nil)
+	}
+	if rule.Confidence <= 0 || rule.Confidence > 1 {
+		return compiledRule{}, errors.New(fmt.Sprintf("rule %s: confidence must be in (0, 1]", rule.Name), nil)
+	}
+	for _, pattern := range rule.Devices {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return compiledRule{}, errors.New(fmt.Sprintf("rule %s: invalid device pattern %q", rule.Name, pattern), err)
+		}
+	}
+	tmpl, err := template.New(rule.Name).Option("missingkey=error").Parse(rule.Recommendation)
+	if err != nil {
+		return compiledRule{}, errors.New("rule "+rule.Name+": invalid recommendation template", err)
+	}
+	return compiledRule{
+		Rule:           rule,
+		deviceType:     deviceType,
+		severity:       severity,
+		cmp:            cmp,
+		recommendation: tmpl,
+	}, nil
+}
+
+// ParseRules decodes and validates a YAML rule file.
+func ParseRules(data []byte) ([]Rule, error) {
+	var set RuleSet
+	if err := yaml.Unmarshal(data, &set); err != nil {
+		return nil, errors.New("failed to parse detection rules", err)
+	}
+	if _, err := compileRules(set.Rules); err != nil {
+		return nil, err
+	}
+	return set.Rules, nil
+}
+
+func compileRules(rules []Rule) ([]compiledRule, error) {
+	names := make(map[string]bool, len(rules))
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		if names[rule.Name] {
+			return nil, errors.New("duplicate rule name "+rule.Name, nil)
+		}
+		names[rule.Name] = true
+		c, err := compileRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// DefaultRules expresses the detection thresholds of a Config as rules.
+func DefaultRules(config *Config) []Rule {
+	return []Rule{
+		{
+			Name: "raid_queue_depth", DeviceType: "raid", Metric: ebpf.MetricQueueDepth,
+			Operator: ">=", Threshold: float64(config.QueueThreshold), Severity: "subhealthy", Confidence: 0.95,
+			Recommendation: "temporary isolation recommended",
+		},
+		{
+			Name: "raid_latency", DeviceType: "raid", Metric: ebpf.MetricAvgLatency,
+			Operator: ">", Threshold: config.LatencyThreshold.Seconds(), Severity: "subhealthy", Confidence: 0.90,
+			Recommendation: "check controller firmware and isolate if persistent",
+		},
+		{
+			Name: "raid_error_retries", DeviceType: "raid", Metric: ebpf.MetricErrorRetryRate,
+			Operator: ">", Threshold: 100, Severity: "failed", Confidence: 0.99,
+			Recommendation: "immediate isolation and replacement",
+		},
+		{
+			Name: "disk_iops_variance", DeviceType: "disk", Metric: ebpf.MetricIOPSVariance,
+			Operator: ">", Threshold: config.IOPSVarThreshold, Severity: "subhealthy", Confidence: 0.92,
+			Recommendation: "monitor disk performance closely",
+		},
+		{
+			Name: "disk_reallocated_sectors", DeviceType: "disk", Metric: ebpf.MetricReallocatedSectors,
+			Operator: ">", Threshold: 100, Severity: "subhealthy", Confidence: 0.95,
+			Recommendation: "schedule disk replacement",
+		},
+		{
+			Name: "network_packet_loss", DeviceType: "network", Metric: ebpf.MetricPacketLossRate,
+			Operator: ">", Threshold: config.PacketLossThreshold, Severity: "subhealthy", Confidence: 0.93,
+			Recommendation: "check network interface and routing",
+		},
+		{
+			Name: "network_latency", DeviceType: "network", Metric: ebpf.MetricLatencyP95,
+			Operator: ">", Threshold: config.LatencyThreshold.Seconds(), Severity: "subhealthy", Confidence: 0.90,
+			Recommendation: "investigate network congestion",
+		},
+	}
+}
+
+// RuleEngine holds the active detection rules and supports replacing them at runtime.
+type RuleEngine struct {
+	path string // Rule file backing Reload; empty for in-memory rule sets
+
+	mu      sync.RWMutex
+	rules   []compiledRule
+	modTime time.Time
+}
+
+// NewRuleEngine creates a RuleEngine from an in-memory rule set.
+func NewRuleEngine(rules []Rule) (*RuleEngine, error) {
+	e := &RuleEngine{}
+	if err := e.Replace(rules); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// LoadRuleFile creates a RuleEngine backed by a YAML rule file.
+func LoadRuleFile(path string) (*RuleEngine, error) {
+	e := &RuleEngine{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Replace atomically swaps the active rules. The current rules stay in place if any
+// new rule is invalid.
+func (e *RuleEngine) Replace(rules []Rule) error {
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.rules = compiled
+	e.mu.Unlock()
+	return nil
+}
+
+// Reload re-reads the backing rule file.
+func (e *RuleEngine) Reload() error {
+	if e.path == "" {
+		return errors.New("rule engine has no backing file", nil)
+	}
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return errors.New("failed to stat rule file", err)
+	}
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		return errors.New("failed to read rule file", err)
+	}
+	rules, err := ParseRules(data)
+	if err != nil {
+		return err
+	}
+	if err := e.Replace(rules); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.modTime = info.ModTime()
+	e.mu.Unlock()
+
+	logger.Info("loaded detection rules", zap.String("path", e.path), zap.Int("count", len(rules)))
+	return nil
+}
+
+// Watch reloads the rule file whenever its modification time changes, until ctx is done.
+// Invalid rule files are logged and the previous rules stay active.
+func (e *RuleEngine) Watch(ctx context.Context, interval time.Duration) {
+	if e.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(e.path)
+			if err != nil {
+				logger.Warn("failed to stat rule file", zap.String("path", e.path), zap.Error(err))
+				continue
+			}
+			e.mu.RLock()
+			changed := !info.ModTime().Equal(e.modTime)
+			e.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := e.Reload(); err != nil {
+				logger.Error("failed to reload detection rules", zap.String("path", e.path), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Rules returns the active rules.
+func (e *RuleEngine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	rules := make([]Rule, len(e.rules))
+	for i, r := range e.rules {
+		rules[i] = r.Rule
+	}
+	return rules
+}
+
+// evaluate applies every rule selecting the device and returns a finding per match, most
+// severe and most confident first.
+func (e *RuleEngine) evaluate(conditions *conditionSet, deviceType enum.DeviceType, deviceID string, values map[string]float64, now time.Time) []Finding {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	var findings []Finding
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.appliesTo(deviceType, deviceID) {
+			continue
+		}
+		value, ok := values[rule.Metric]
+		if !ok {
+			continue
+		}
+		exit := rule.exit(conditions.config.HysteresisRatio)
+		since, breached := conditions.breached(deviceID, rule.Name, rule.Metric, value, rule.Threshold, exit, rule.cmp, now)
+		if !breached {
+			continue
+		}
+		code := rule.Code
+		if code == "" {
+			code = codeForMetric(deviceType, rule.Metric)
+		}
+		findings = append(findings, Finding{
+			Code:       code,
+			Severity:   rule.severity,
+			Source:     SourceRule,
+			Name:       rule.Name,
+			Metric:     rule.Metric,
+			Operator:   rule.Operator,
+			Threshold:  rule.Threshold,
+			Observed:   value,
+			Confidence: rule.Confidence,
+			Start:      since,
+			End:        now,
+			Action:     rule.render(deviceType, deviceID, value),
+		})
+	}
+
+	sortFindings(findings)
+	return findings
+}
+
+// render executes the recommendation template, falling back to the raw text on error.
+func (r *compiledRule) render(deviceType enum.DeviceType, deviceID string, value float64) string {
+	var buf bytes.Buffer
+	err := r.recommendation.Execute(&buf, recommendationData{
+		DeviceType: deviceType.String(),
+		DeviceID:   deviceID,
+		Metric:     r.Metric,
+		Value:      value,
+		Threshold:  r.Threshold,
+	})
+	if err != nil {
+		logger.Warn("failed to render rule recommendation", zap.String("rule", r.Name), zap.Error(err))
+		return r.Recommendation
+	}
+	return buf.String()
+}
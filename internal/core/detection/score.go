@@ -0,0 +1,211 @@
+package detection
+
+import (
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/slo"
+	"sort"
+)
+
+// ScoreConfig defines how device statuses are weighted into 0-100 health scores.
+type ScoreConfig struct {
+	TypeWeights       map[enum.DeviceType]float64   // Weight of each device type
+	TierWeights       map[slo.ServiceTier]float64   // Weight of the service tier a device serves
+	StatusPenalties   map[enum.HealthStatus]float64 // Points deducted from a device at full confidence
+	PropagationFactor float64                       // Fraction of a dependency's penalty inherited by its dependents
+}
+
+// DefaultScoreConfig returns the default scoring weights.
+func DefaultScoreConfig() *ScoreConfig {
+	return &ScoreConfig{
+		TypeWeights: map[enum.DeviceType]float64{
+			enum.RAID:    3,
+			enum.Disk:    1,
+			enum.Network: 2,
+		},
+		TierWeights: map[slo.ServiceTier]float64{
+			slo.Critical:    2,
+			slo.NonCritical: 1,
+		},
+		StatusPenalties: map[enum.HealthStatus]float64{
+			enum.Healthy:    0,
+			enum.SubHealthy: 40,
+			enum.Failed:     100,
+		},
+		PropagationFactor: 1,
+	}
+}
+
+// DeviceRef identifies a device on a host.
+type DeviceRef struct {
+	DeviceType enum.DeviceType
+	DeviceID   string
+}
+
+// Topology describes the devices of a host for scoring.
+type Topology struct {
+	Host      string
+	Tiers     map[DeviceRef]slo.ServiceTier // Tier served by each device; NonCritical when unset
+	DependsOn map[DeviceRef][]DeviceRef     // Devices each device depends on, e.g., a disk on its controller
+}
+
+// Contribution is one device's share of a health score.
+type Contribution struct {
+	DeviceType    enum.DeviceType
+	DeviceID      string
+	Tier          slo.ServiceTier
+	Status        enum.HealthStatus // Effective status after propagation
+	Weight        float64
+	Penalty       float64    // Points deducted for the device's own status
+	Inherited     float64    // Points deducted because of a degraded dependency
+	InheritedFrom *DeviceRef // Dependency the inherited penalty came from
+	Score         float64    // Device score, 0-100
+	Impact        float64    // Points the device deducts from the host score
+	Findings      []Finding
+}
+
+// HostScore is the composite health score of one host.
+type HostScore struct {
+	Host      string
+	Score     float64                     // 0-100
+	Status    enum.HealthStatus           // Worst effective device status
+	ByType    map[enum.DeviceType]float64 // Score per device type
+	Breakdown []Contribution              // Largest impact first
+}
+
+// ClusterScore is the composite health score of a set of hosts.
+type ClusterScore struct {
+	Score  float64 // 0-100
+	Status enum.HealthStatus
+	Hosts  []HostScore // Lowest score first
+}
+
+// ScoreHost aggregates per-device statuses into a host health score. A device inherits the
+// penalty of any degraded device it depends on, so a degraded controller degrades every disk
+// behind it.
+func ScoreHost(config *ScoreConfig, topology Topology, statuses []HealthStatus) HostScore {
+	byRef := make(map[DeviceRef]HealthStatus, len(statuses))
+	for _, s := range statuses {
+		byRef[DeviceRef{s.DeviceType, s.DeviceID}] = s
+	}
+
+	own := func(ref DeviceRef) float64 {
+		s, ok := byRef[ref]
+		if !ok {
+			return 0
+		}
+		return config.StatusPenalties[s.Status] * s.Confidence
+	}
+
+	// effective resolves a device's penalty including its dependencies; cycles are cut.
+	type resolved struct {
+		penalty float64
+		from    *DeviceRef
+	}
+	memo := make(map[DeviceRef]resolved)
+	visiting := make(map[DeviceRef]bool)
+	var effective func(ref DeviceRef) resolved
+	effective = func(ref DeviceRef) resolved {
+		if r, ok := memo[ref]; ok {
+			return r
+		}
+		if visiting[ref] {
+			return resolved{penalty: own(ref)}
+		}
+		visiting[ref] = true
+		defer delete(visiting, ref)
+
+		r := resolved{penalty: own(ref)}
+		for _, dep := range topology.DependsOn[ref] {
+			inherited := effective(dep).penalty * config.PropagationFactor
+			if inherited > r.penalty {
+				dep := dep
+				r = resolved{penalty: inherited, from: &dep}
+			}
+		}
+		memo[ref] = r
+		return r
+	}
+
+	score := HostScore{
+		Host:   topology.Host,
+		Score:  100,
+		Status: enum.Healthy,
+		ByType: make(map[enum.DeviceType]float64),
+	}
+	typeWeight := make(map[enum.DeviceType]float64)
+	typePenalty := make(map[enum.DeviceType]float64)
+	totalWeight, totalPenalty := 0.0, 0.0
+	for _, s := range statuses {
+		ref := DeviceRef{s.DeviceType, s.DeviceID}
+		tier, ok := topology.Tiers[ref]
+		if !ok {
+			tier = slo.NonCritical
+		}
+		r := effective(ref)
+		c := Contribution{
+			DeviceType: s.DeviceType,
+			DeviceID:   s.DeviceID,
+			Tier:       tier,
+			Status:     s.Status,
+			Weight:     config.TypeWeights[s.DeviceType] * config.TierWeights[tier],
+			Penalty:    own(ref),
+			Score:      100 - r.penalty,
+			Findings:   s.Findings,
+		}
+		if r.from != nil {
+			c.Inherited = r.penalty
+			c.InheritedFrom = r.from
+			if upstream, ok := byRef[*r.from]; ok && upstream.Status > c.Status {
+				c.Status = upstream.Status
+			}
+		}
+		if c.Status > score.Status {
+			score.Status = c.Status
+		}
+		totalWeight += c.Weight
+		totalPenalty += c.Weight * r.penalty
+		typeWeight[s.DeviceType] += c.Weight
+		typePenalty[s.DeviceType] += c.Weight * r.penalty
+		score.Breakdown = append(score.Breakdown, c)
+	}
+
+	if totalWeight > 0 {
+		score.Score = 100 - totalPenalty/totalWeight
+		for i := range score.Breakdown {
+			c := &score.Breakdown[i]
+			c.Impact = c.Weight * (100 - c.Score) / totalWeight
+		}
+	}
+	for t, w := range typeWeight {
+		if w > 0 {
+			score.ByType[t] = 100 - typePenalty[t]/w
+		}
+	}
+	sort.SliceStable(score.Breakdown, func(i, j int) bool {
+		if score.Breakdown[i].Impact != score.Breakdown[j].Impact {
+			return score.Breakdown[i].Impact > score.Breakdown[j].Impact
+		}
+		return score.Breakdown[i].DeviceID < score.Breakdown[j].DeviceID
+	})
+	return score
+}
+
+// ScoreCluster aggregates host scores into a cluster score, the mean of the host scores.
+func ScoreCluster(hosts []HostScore) ClusterScore {
+	cluster := ClusterScore{Score: 100, Status: enum.Healthy}
+	if len(hosts) == 0 {
+		return cluster
+	}
+
+	total := 0.0
+	for _, h := range hosts {
+		total += h.Score
+		if h.Status > cluster.Status {
+			cluster.Status = h.Status
+		}
+	}
+	cluster.Score = total / float64(len(hosts))
+	cluster.Hosts = append([]HostScore(nil), hosts...)
+	sort.SliceStable(cluster.Hosts, func(i, j int) bool { return cluster.Hosts[i].Score < cluster.Hosts[j].Score })
+	return cluster
+}
@@ -0,0 +1,114 @@
+package detection
+
+import (
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"sync"
+	"time"
+)
+
+// maxTransitions bounds the transition history kept per device.
+const maxTransitions = 32
+
+// Transition records a change in a device's health status.
+type Transition struct {
+	From enum.HealthStatus
+	To   enum.HealthStatus
+	At   time.Time
+}
+
+// DeviceState is the tracked health state of a single device.
+type DeviceState struct {
+	DeviceType  enum.DeviceType
+	DeviceID    string
+	Status      enum.HealthStatus
+	Since       time.Time    // When the device entered its current status
+	LastChecked time.Time    // When the device was last evaluated
+	Transitions []Transition // Most recent transitions, oldest first
+}
+
+// StateTracker keeps a Healthy → SubHealthy → Failed state machine per device.
+type StateTracker struct {
+	mu     sync.RWMutex
+	states map[string]*DeviceState // Key: deviceType/deviceID
+}
+
+// NewStateTracker creates an empty StateTracker.
+func NewStateTracker() *StateTracker {
+	return &StateTracker{
+		states: make(map[string]*DeviceState),
+	}
+}
+
+// Update feeds the latest evaluated status of a device into its state machine and
+// returns the resulting state and whether a transition occurred.
+func (t *StateTracker) Update(deviceType enum.DeviceType, deviceID string, status enum.HealthStatus, now time.Time) (DeviceState, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := stateKey(deviceType, deviceID)
+	state, ok := t.states[key]
+	if !ok {
+		state = initialState(deviceType, deviceID, now)
+		t.states[key] = state
+	}
+	changed := state.advance(status, now)
+	return state.copy(), changed
+}
+
+// Peek returns the state Update would produce for a status without recording it.
+func (t *StateTracker) Peek(deviceType enum.DeviceType, deviceID string, status enum.HealthStatus, now time.Time) DeviceState {
+	state, ok := t.State(deviceType, deviceID)
+	if !ok {
+		state = *initialState(deviceType, deviceID, now)
+	}
+	state.advance(status, now)
+	return state
+}
+
+// initialState returns the state of a device seen for the first time. Devices start out
+// healthy; the first degraded check is a transition.
+func initialState(deviceType enum.DeviceType, deviceID string, now time.Time) *DeviceState {
+	return &DeviceState{
+		DeviceType: deviceType,
+		DeviceID:   deviceID,
+		Status:     enum.Healthy,
+		Since:      now,
+	}
+}
+
+// advance moves the state to status, reporting whether that is a transition.
+func (s *DeviceState) advance(status enum.HealthStatus, now time.Time) bool {
+	s.LastChecked = now
+	if s.Status == status {
+		return false
+	}
+	s.Transitions = append(s.Transitions, Transition{From: s.Status, To: status, At: now})
+	if len(s.Transitions) > maxTransitions {
+		s.Transitions = s.Transitions[len(s.Transitions)-maxTransitions:]
+	}
+	s.Status = status
+	s.Since = now
+	return true
+}
+
+// State returns the tracked state of a device.
+func (t *StateTracker) State(deviceType enum.DeviceType, deviceID string) (DeviceState, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	state, ok := t.states[stateKey(deviceType, deviceID)]
+	if !ok {
+		return DeviceState{}, false
+	}
+	return state.copy(), true
+}
+
+func (s *DeviceState) copy() DeviceState {
+	c := *s
+	c.Transitions = append([]Transition(nil), s.Transitions...)
+	return c
+}
+
+func stateKey(deviceType enum.DeviceType, deviceID string) string {
+	return deviceType.String() + "/" + deviceID
+}
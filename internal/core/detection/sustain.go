@@ -0,0 +1,144 @@
+package detection
+
+import (
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"sync"
+	"time"
+)
+
+// Window defines how much recent evidence a condition needs before it changes state.
+// The zero value judges each check on the latest sample alone.
+type Window struct {
+	Required int           // N: samples within the last M that must satisfy the condition
+	Samples  int           // M: number of most recent samples considered
+	Duration time.Duration // When set, every sample over this duration must satisfy the condition instead
+}
+
+// lookback bounds the sample history queried for sustained checks; the ring buffers
+// hold less than this in practice.
+const lookback = 24 * time.Hour
+
+// satisfied reports whether points (oldest first) satisfy pred according to the window.
+func (w Window) satisfied(points []ebpf.Point, pred func(float64) bool) bool {
+	if len(points) == 0 {
+		return false
+	}
+
+	if w.Duration > 0 {
+		cutoff := points[len(points)-1].Time.Add(-w.Duration)
+		// The history must reach back to the start of the window.
+		if points[0].Time.After(cutoff) {
+			return false
+		}
+		for _, p := range points {
+			if !p.Time.Before(cutoff) && !pred(p.Value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	m := w.Samples
+	if m <= 0 {
+		m = 1
+	}
+	n := w.Required
+	if n <= 0 || n > m {
+		n = m
+	}
+	if len(points) > m {
+		points = points[len(points)-m:]
+	}
+	count := 0
+	for _, p := range points {
+		if pred(p.Value) {
+			count++
+		}
+	}
+	return count >= n
+}
+
+// start returns the time at which the window over points (oldest first) begins.
+func (w Window) start(points []ebpf.Point) time.Time {
+	if w.Duration > 0 {
+		return points[len(points)-1].Time.Add(-w.Duration)
+	}
+	m := w.Samples
+	if m <= 0 {
+		m = 1
+	}
+	if len(points) > m {
+		points = points[len(points)-m:]
+	}
+	return points[0].Time
+}
+
+// conditionKey identifies one rule evaluated against one device.
+type conditionKey struct {
+	deviceID string
+	rule     string
+}
+
+// conditionSet tracks which rule conditions are active per device, applying the
+// configured window and hysteresis so that a single noisy sample neither raises nor clears one.
+type conditionSet struct {
+	config     *Config
+	monitor    ebpf.Monitor
+	deviceType enum.DeviceType
+
+	mu     sync.Mutex
+	active map[conditionKey]time.Time // When each active condition began
+}
+
+func newConditionSet(config *Config, monitor ebpf.Monitor, deviceType enum.DeviceType) *conditionSet {
+	return &conditionSet{
+		config:     config,
+		monitor:    monitor,
+		deviceType: deviceType,
+		active:     make(map[conditionKey]time.Time),
+	}
+}
+
+// snapshot returns a copy of the set whose conditions evolve independently, for evaluations
+// that must not affect the tracked conditions.
+func (c *conditionSet) snapshot() *conditionSet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	active := make(map[conditionKey]time.Time, len(c.active))
+	for k, v := range c.active {
+		active[k] = v
+	}
+	return &conditionSet{config: c.config, monitor: c.monitor, deviceType: c.deviceType, active: active}
+}
+
+// breached reports whether rule is in breach of its enter threshold for deviceID, and since
+// when. The sampled history of metric is used when the monitor has one; otherwise current is
+// judged on its own. An active condition clears only once values stop breaching the exit threshold.
+func (c *conditionSet) breached(deviceID, rule, metric string, current, enter, exit float64, cmp func(v, threshold float64) bool, now time.Time) (time.Time, bool) {
+	window := c.config.Sustain
+	points := c.monitor.Samples(c.deviceType, deviceID, metric, lookback)
+	if len(points) == 0 {
+		points = []ebpf.Point{{Time: now, Value: current}}
+		window = Window{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := conditionKey{deviceID, rule}
+	if since, ok := c.active[key]; ok {
+		if !window.satisfied(points, func(v float64) bool { return !cmp(v, exit) }) {
+			return since, true
+		}
+		delete(c.active, key)
+		return time.Time{}, false
+	}
+	if !window.satisfied(points, func(v float64) bool { return cmp(v, enter) }) {
+		return time.Time{}, false
+	}
+	since := window.start(points)
+	c.active[key] = since
+	return since, true
+}
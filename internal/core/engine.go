@@ -0,0 +1,678 @@
+package core
+
+import (
+	"context"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"github.com/turtacn/ioshelfer/internal/core/prediction"
+	"github.com/turtacn/ioshelfer/internal/core/remediation"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+	"go.uber.org/zap"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config defines the configuration for the detection engine.
+type Config struct {
+	Detection   detection.Config         // MonitorInterval is the default interval of every detector
+	RulesFile   string                   // Optional YAML rule file; the built-in rules apply when empty
+	RunTimeout  time.Duration            // Per-run timeout of a detector (default: its interval)
+	EventBuffer int                      // Per-subscriber event buffer (default 64)
+	Score       *detection.ScoreConfig   // Health score weights (default DefaultScoreConfig)
+	Topology    detection.Topology       // Service tiers and dependencies of the host's devices
+	Remediation *RemediationConfig       // Remediates devices on state changes; disabled when nil
+	Anomaly     *detection.AnomalyConfig // Scores metrics against learned per-device baselines alongside the rules; disabled when nil
+	History     storage.Storage          // Stored metrics seeding the anomaly baselines; learned from live checks only when nil
+	Peers       *PeerConfig              // Compares devices with their peers; disabled when nil
+	Predictions *PredictionConfig        // Resolves the outcomes of tracked failure predictions; disabled when nil
+}
+
+// PredictionConfig defines how the engine resolves the outcomes of failure predictions.
+type PredictionConfig struct {
+	Tracker    *prediction.QualityTracker // Tracker the predictor records its predictions in
+	LabelsFile string                     // Failure and replacement labels reconciled periodically; only observed failures count when empty
+	Interval   time.Duration              // Time between reconciliations; 1h when zero
+}
+
+// PeerConfig defines how the engine compares devices with their peers.
+type PeerConfig struct {
+	Comparison *detection.PeerConfig // Outlier thresholds; DefaultPeerConfig when nil
+	Groups     detection.PeerGrouper // Groups of devices expected to perform alike
+}
+
+// RemediationConfig defines how the engine remediates the devices it checks.
+type RemediationConfig struct {
+	Remediator      remediation.Config          // Settings shared by every device type's remediator
+	Workflow        *remediation.WorkflowConfig // Authorises isolations by policy; executed directly when nil
+	StateDir        string                      // Directory persisting actions and their audit log; not persisted when empty
+	StateRetention  time.Duration               // Time finished actions are kept; 30 days when zero
+	SysfsRoot       string                      // sysfs mount point; /sys when empty
+	StorCLI         string                      // StorCLI binary for RAID drives; storcli64 when empty
+	RAIDControllers map[string]string           // StorCLI controller index by controller ID; RAID drives are not remediated when empty
+	Throttle        *remediation.ThrottleConfig // Throttles sub-healthy disks instead of isolating them; only failed disks are isolated. Disabled when nil
+	PlaybookFile    string                      // YAML playbooks run for findings whose code they handle; devices are isolated directly when empty
+}
+
+// controllerDiscoverer is implemented by monitors that can map disks to their RAID controllers.
+type controllerDiscoverer interface {
+	DiskControllers() (map[string]string, error)
+}
+
+// Sink consumes engine events, e.g., to persist, export or act on them.
+type Sink interface {
+	Handle(ctx context.Context, event Event) error
+}
+
+// registration is a detector scheduled by the engine.
+type registration struct {
+	name     string
+	detector detection.Detector
+	interval time.Duration
+	running  int32 // Set while a CheckAll is in flight, so slow runs never overlap
+}
+
+// sinkRegistration is a sink and the event types it receives.
+type sinkRegistration struct {
+	name  string
+	sink  Sink
+	types []EventType
+}
+
+// Engine schedules the detectors, merges their results per device and publishes events.
+type Engine struct {
+	config  *Config
+	monitor ebpf.Monitor
+	rules   *detection.RuleEngine
+	bus     *Bus
+
+	mu        sync.Mutex
+	detectors []*registration
+	sinks     []sinkRegistration
+	results   map[string]map[string]detection.HealthStatus // Detector name to device key to result
+	published map[string]detection.HealthStatus            // Device key to last merged result
+
+	remediators map[enum.DeviceType]*remediation.DeviceRemediator
+	workflow    *remediation.Workflow   // Nil without a workflow config
+	state       *remediation.StateStore // Nil when actions are not persisted
+
+	runMu  sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewEngine creates a new Engine with the RAID, disk and network detectors registered, scoring
+// anomalies when configured, a peer detector per device type when peer groups are configured,
+// and their remediators when remediation is configured.
+func NewEngine(config *Config, monitor ebpf.Monitor) (*Engine, error) {
+	if config.Detection.MonitorInterval <= 0 {
+		return nil, errors.New("monitor interval must be positive", nil)
+	}
+	if config.EventBuffer <= 0 {
+		config.EventBuffer = 64
+	}
+	if config.Score == nil {
+		config.Score = detection.DefaultScoreConfig()
+	}
+	if config.Topology.Host == "" {
+		config.Topology.Host, _ = os.Hostname()
+	}
+
+	var (
+		rules *detection.RuleEngine
+		err   error
+	)
+	if config.RulesFile != "" {
+		rules, err = detection.LoadRuleFile(config.RulesFile)
+	} else {
+		rules, err = detection.NewRuleEngine(detection.DefaultRules(&config.Detection))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load detection rules")
+	}
+
+	e := &Engine{
+		config:    config,
+		monitor:   monitor,
+		rules:     rules,
+		bus:       NewBus(),
+		results:   make(map[string]map[string]detection.HealthStatus),
+		published: make(map[string]detection.HealthStatus),
+	}
+	raid := detection.NewRAIDDetector(&config.Detection, monitor, rules)
+	disk := detection.NewDiskDetector(&config.Detection, monitor, rules)
+	network := detection.NewNetworkDetector(&config.Detection, monitor, rules)
+	if config.Anomaly != nil {
+		anomalies := detection.NewAnomalyDetector(config.Anomaly, config.History)
+		raid.SetAnomalyDetector(anomalies)
+		disk.SetAnomalyDetector(anomalies)
+		network.SetAnomalyDetector(anomalies)
+	}
+	e.detectors = []*registration{
+		{name: "raid", detector: raid, interval: config.Detection.MonitorInterval},
+		{name: "disk", detector: disk, interval: config.Detection.MonitorInterval},
+		{name: "network", detector: network, interval: config.Detection.MonitorInterval},
+	}
+	if config.Peers != nil {
+		comparison := config.Peers.Comparison
+		if comparison == nil {
+			comparison = detection.DefaultPeerConfig()
+		}
+		for _, deviceType := range []enum.DeviceType{enum.RAID, enum.Disk, enum.Network} {
+			if err := e.Register("peer_"+deviceType.String(), detection.NewPeerDetector(comparison, monitor, config.Peers.Groups, deviceType), 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if config.Predictions != nil {
+		if config.Predictions.Tracker == nil {
+			return nil, errors.New("prediction outcomes need a quality tracker", nil)
+		}
+		if config.Predictions.Interval <= 0 {
+			config.Predictions.Interval = time.Hour
+		}
+		e.sinks = append(e.sinks, sinkRegistration{name: "prediction_outcomes", sink: NewPredictionOutcomeSink(config.Predictions.Tracker), types: []EventType{EventStateChange}})
+	}
+	if config.Remediation != nil {
+		if err := e.setupRemediation(config.Remediation); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// setupRemediation creates a remediator for each device type the built-in detectors check,
+// confirming actions with that detector, with sub-healthy disks throttled rather than
+// isolated when throttling is configured. State changes are delivered through the workflow
+// when one is configured. Actions are persisted when a state directory is set, and findings
+// with a playbook are remediated by it when a playbook file is set.
+func (e *Engine) setupRemediation(config *RemediationConfig) error {
+	system := remediation.NewHostSystem(config.SysfsRoot)
+	executors := map[enum.DeviceType]remediation.Executor{
+		enum.Disk:    remediation.NewDiskExecutor(system),
+		enum.Network: remediation.NewNetworkExecutor(system),
+	}
+	if config.Throttle != nil {
+		throttle, err := remediation.NewThrottleExecutor(*config.Throttle, system)
+		if err != nil {
+			return err
+		}
+		executors[enum.Disk] = remediation.NewTieredDiskExecutor(throttle, remediation.NewDiskExecutor(system))
+	}
+	if len(config.RAIDControllers) > 0 {
+		executors[enum.RAID] = remediation.NewRAIDExecutor(system, remediation.NewStorCLIBackend(config.StorCLI, config.RAIDControllers))
+	}
+	detectors := map[enum.DeviceType]detection.Detector{
+		enum.RAID:    e.detectors[0].detector,
+		enum.Disk:    e.detectors[1].detector,
+		enum.Network: e.detectors[2].detector,
+	}
+
+	if config.StateDir != "" {
+		store, err := storage.NewFileStorage(config.StateDir)
+		if err != nil {
+			return errors.Wrap(err, "failed to open remediation state")
+		}
+		if e.state, err = remediation.NewStateStore(store, config.StateRetention); err != nil {
+			return err
+		}
+	}
+
+	e.remediators = make(map[enum.DeviceType]*remediation.DeviceRemediator, len(executors))
+	remediators := make(map[enum.DeviceType]remediation.Remediator, len(executors))
+	for deviceType, executor := range executors {
+		r := remediation.NewRemediator(&config.Remediator, deviceType, detectors[deviceType], executor)
+		if e.state != nil {
+			r.SetState(e.state)
+		}
+		r.OnEscalate(e.Escalated)
+		e.remediators[deviceType] = r
+		remediators[deviceType] = r
+	}
+
+	if config.Workflow != nil {
+		var err error
+		if e.workflow, err = remediation.NewWorkflow(config.Workflow, remediators); err != nil {
+			return err
+		}
+		if e.state != nil {
+			e.workflow.SetState(e.state)
+		}
+		remediators = make(map[enum.DeviceType]remediation.Remediator, len(e.remediators))
+		for deviceType := range e.remediators {
+			remediators[deviceType] = e.workflow
+		}
+	}
+
+	var playbooks *remediation.PlaybookEngine
+	if config.PlaybookFile != "" {
+		p, err := remediation.ReadPlaybookFile(config.PlaybookFile)
+		if err != nil {
+			return err
+		}
+		if playbooks, err = remediation.NewPlaybookEngine(p, system, remediators); err != nil {
+			return err
+		}
+	}
+	e.sinks = append(e.sinks, sinkRegistration{name: "remediation", sink: NewRemediationSink(remediators, playbooks), types: []EventType{EventStateChange}})
+	return nil
+}
+
+// Register adds a detector run every interval (MonitorInterval when zero). Detectors must be
+// registered before the engine starts.
+func (e *Engine) Register(name string, detector detection.Detector, interval time.Duration) error {
+	if e.running() {
+		return errors.New("cannot register detector while the engine is running", nil)
+	}
+	if interval <= 0 {
+		interval = e.config.Detection.MonitorInterval
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, reg := range e.detectors {
+		if reg.name == name {
+			return errors.New("detector "+name+" already registered", nil)
+		}
+	}
+	e.detectors = append(e.detectors, &registration{name: name, detector: detector, interval: interval})
+	return nil
+}
+
+// AddSink delivers events of the given types (all types when none are given) to sink while
+// the engine runs. Sinks must be added before the engine starts.
+func (e *Engine) AddSink(name string, sink Sink, types ...EventType) error {
+	if e.running() {
+		return errors.New("cannot add sink while the engine is running", nil)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sinks = append(e.sinks, sinkRegistration{name: name, sink: sink, types: types})
+	return nil
+}
+
+// Subscribe returns a channel of engine events; see Bus.Subscribe.
+func (e *Engine) Subscribe(types ...EventType) (<-chan Event, func()) {
+	return e.bus.Subscribe(e.config.EventBuffer, types...)
+}
+
+// Escalated publishes an EventEscalation for a temporary isolation escalated to permanent,
+// carrying the device's latest status with the reason as its recommendation. Pass it to
+// DeviceRemediator.OnEscalate.
+func (e *Engine) Escalated(escalation remediation.Escalation) {
+	status, ok := e.Status(escalation.DeviceType, escalation.DeviceID)
+	if !ok {
+		status = detection.HealthStatus{DeviceType: escalation.DeviceType, DeviceID: escalation.DeviceID, Status: enum.Failed}
+	}
+	status.Recommendation = escalation.Reason
+	e.bus.Publish(Event{
+		Type:       EventEscalation,
+		DeviceType: escalation.DeviceType,
+		DeviceID:   escalation.DeviceID,
+		Previous:   status.Status,
+		Status:     status,
+		At:         escalation.At,
+	})
+}
+
+// Remediator returns the remediator of a device type, if remediation is configured for it.
+func (e *Engine) Remediator(deviceType enum.DeviceType) (*remediation.DeviceRemediator, bool) {
+	r, ok := e.remediators[deviceType]
+	return r, ok
+}
+
+// Workflow returns the remediation workflow, nil unless one is configured.
+func (e *Engine) Workflow() *remediation.Workflow {
+	return e.workflow
+}
+
+// RemediationState returns the persisted remediation actions, nil unless a state directory
+// is configured.
+func (e *Engine) RemediationState() *remediation.StateStore {
+	return e.state
+}
+
+// Rules returns the rule engine shared by the built-in detectors.
+func (e *Engine) Rules() *detection.RuleEngine {
+	return e.rules
+}
+
+// Status returns the latest merged health status of a device.
+func (e *Engine) Status(deviceType enum.DeviceType, deviceID string) (detection.HealthStatus, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status, ok := e.published[deviceKey(deviceType, deviceID)]
+	return status, ok
+}
+
+// Statuses returns the latest merged health status of every checked device.
+func (e *Engine) Statuses() []detection.HealthStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	statuses := make([]detection.HealthStatus, 0, len(e.published))
+	for _, status := range e.published {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return deviceKey(statuses[i].DeviceType, statuses[i].DeviceID) < deviceKey(statuses[j].DeviceType, statuses[j].DeviceID)
+	})
+	return statuses
+}
+
+// HostScore computes the host's composite health score from the latest device statuses.
+// Disks inherit the status of the RAID controller they are attached to when the monitor can
+// discover it, in addition to the configured dependencies.
+func (e *Engine) HostScore() detection.HostScore {
+	topology := e.config.Topology
+	if d, ok := e.monitor.(controllerDiscoverer); ok {
+		controllers, err := d.DiskControllers()
+		if err != nil {
+			logger.Warn("failed to discover disk controllers", zap.Error(err))
+		}
+		if len(controllers) > 0 {
+			dependsOn := make(map[detection.DeviceRef][]detection.DeviceRef, len(topology.DependsOn)+len(controllers))
+			for ref, deps := range topology.DependsOn {
+				dependsOn[ref] = append([]detection.DeviceRef(nil), deps...)
+			}
+			for disk, controller := range controllers {
+				ref := detection.DeviceRef{DeviceType: enum.Disk, DeviceID: disk}
+				dependsOn[ref] = append(dependsOn[ref], detection.DeviceRef{DeviceType: enum.RAID, DeviceID: controller})
+			}
+			topology.DependsOn = dependsOn
+		}
+	}
+	return detection.ScoreHost(e.config.Score, topology, e.Statuses())
+}
+
+// Start runs every detector on its interval and delivers events to the sinks until ctx is
+// cancelled or Stop is called. Persisted remediation actions are first reconciled with the
+// devices; isolated devices are probed, and tracked predictions reconciled with their
+// labels, while the engine runs.
+func (e *Engine) Start(ctx context.Context) error {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	if e.cancel != nil {
+		return errors.New("engine already running", nil)
+	}
+	now := time.Now()
+	for _, r := range e.remediators {
+		if err := r.Reconcile(now); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	e.cancel = cancel
+
+	e.mu.Lock()
+	detectors := append([]*registration(nil), e.detectors...)
+	sinks := append([]sinkRegistration(nil), e.sinks...)
+	e.mu.Unlock()
+
+	for _, s := range sinks {
+		events, unsubscribe := e.bus.Subscribe(e.config.EventBuffer, s.types...)
+		e.wg.Add(1)
+		go e.deliver(ctx, s, events, unsubscribe)
+	}
+	for _, reg := range detectors {
+		e.wg.Add(1)
+		go e.schedule(ctx, reg)
+	}
+	for _, r := range e.remediators {
+		e.wg.Add(1)
+		go func(r *remediation.DeviceRemediator) {
+			defer e.wg.Done()
+			r.Watch(ctx, e.config.Detection.MonitorInterval)
+		}(r)
+	}
+	if e.config.RulesFile != "" {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.rules.Watch(ctx, e.config.Detection.MonitorInterval)
+		}()
+	}
+	if e.config.Predictions != nil {
+		e.wg.Add(1)
+		go e.reconcilePredictions(ctx)
+	}
+
+	logger.Info("detection engine started",
+		zap.Int("detectors", len(detectors)),
+		zap.Int("sinks", len(sinks)),
+	)
+	return nil
+}
+
+// Stop stops the detectors and sinks and waits for them to exit.
+func (e *Engine) Stop() error {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	if e.cancel == nil {
+		return nil
+	}
+	e.cancel()
+	e.wg.Wait()
+	e.cancel = nil
+
+	logger.Info("detection engine stopped")
+	return nil
+}
+
+// RunOnce runs every detector once, concurrently, and waits for them to finish.
+func (e *Engine) RunOnce(ctx context.Context) {
+	e.mu.Lock()
+	detectors := append([]*registration(nil), e.detectors...)
+	e.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, reg := range detectors {
+		wg.Add(1)
+		go func(reg *registration) {
+			defer wg.Done()
+			e.run(ctx, reg)
+		}(reg)
+	}
+	wg.Wait()
+}
+
+func (e *Engine) running() bool {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	return e.cancel != nil
+}
+
+// reconcilePredictions resolves tracked predictions against the labels immediately and then
+// on every tick of the configured interval; predictions whose horizon passed without a
+// failure survive even without labels.
+func (e *Engine) reconcilePredictions(ctx context.Context) {
+	defer e.wg.Done()
+
+	config := e.config.Predictions
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for {
+		var labels []prediction.Label
+		var err error
+		if config.LabelsFile != "" {
+			labels, err = prediction.LoadLabels(config.LabelsFile)
+		}
+		if err == nil {
+			err = config.Tracker.Reconcile(labels, time.Now())
+		}
+		if err != nil {
+			logger.Error("failed to reconcile failure predictions", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// schedule runs a detector immediately and then on every tick of its interval.
+func (e *Engine) schedule(ctx context.Context, reg *registration) {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(reg.interval)
+	defer ticker.Stop()
+
+	e.run(ctx, reg)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.run(ctx, reg)
+		}
+	}
+}
+
+// run performs one CheckAll of a detector within the run timeout. A run that times out is
+// abandoned; the next run is skipped until it completes.
+func (e *Engine) run(ctx context.Context, reg *registration) {
+	if !atomic.CompareAndSwapInt32(&reg.running, 0, 1) {
+		logger.Warn("skipping detector run, previous run still in progress", zap.String("detector", reg.name))
+		return
+	}
+
+	type outcome struct {
+		statuses []detection.HealthStatus
+		err      error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer atomic.StoreInt32(&reg.running, 0)
+		statuses, err := reg.detector.CheckAll()
+		done <- outcome{statuses, err}
+	}()
+
+	timeout := e.config.RunTimeout
+	if timeout <= 0 {
+		timeout = reg.interval
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case <-runCtx.Done():
+		if ctx.Err() == nil {
+			logger.Warn("detector run timed out", zap.String("detector", reg.name), zap.Duration("timeout", timeout))
+		}
+	case o := <-done:
+		if o.err != nil {
+			logger.Error("detector run failed", zap.String("detector", reg.name), zap.Error(o.err))
+			return
+		}
+		for _, event := range e.merge(reg.name, o.statuses) {
+			e.bus.Publish(event)
+		}
+	}
+}
+
+// merge records a detector's results and returns the events for the affected devices. When
+// several detectors report on the same device the most severe, then most confident, result wins.
+func (e *Engine) merge(name string, statuses []detection.HealthStatus) []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	latest := make(map[string]detection.HealthStatus, len(statuses))
+	for _, s := range statuses {
+		latest[deviceKey(s.DeviceType, s.DeviceID)] = s
+	}
+	e.results[name] = latest
+
+	now := time.Now()
+	events := make([]Event, 0, len(statuses))
+	for _, key := range sortedKeys(latest) {
+		merged := e.mergedLocked(key)
+		previous := enum.Healthy
+		if last, ok := e.published[key]; ok {
+			previous = last.Status
+		}
+		e.published[key] = merged
+
+		events = append(events, Event{
+			Type:       EventCheck,
+			DeviceType: merged.DeviceType,
+			DeviceID:   merged.DeviceID,
+			Previous:   previous,
+			Status:     merged,
+			At:         now,
+		})
+		if merged.Status != previous {
+			events = append(events, Event{
+				Type:       EventStateChange,
+				DeviceType: merged.DeviceType,
+				DeviceID:   merged.DeviceID,
+				Previous:   previous,
+				Status:     merged,
+				At:         now,
+			})
+		}
+	}
+	return events
+}
+
+func (e *Engine) mergedLocked(key string) detection.HealthStatus {
+	var (
+		merged detection.HealthStatus
+		found  bool
+	)
+	for _, name := range sortedKeys(e.results) {
+		s, ok := e.results[name][key]
+		if !ok {
+			continue
+		}
+		if !found || s.Status > merged.Status || (s.Status == merged.Status && s.Confidence > merged.Confidence) {
+			merged, found = s, true
+		}
+	}
+	return merged
+}
+
+// deliver hands subscribed events to a sink until ctx is cancelled.
+func (e *Engine) deliver(ctx context.Context, s sinkRegistration, events <-chan Event, unsubscribe func()) {
+	defer e.wg.Done()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			if err := s.sink.Handle(ctx, event); err != nil {
+				logger.Error("engine sink failed",
+					zap.String("sink", s.name),
+					zap.String("event", event.Type.String()),
+					zap.String("device_id", event.DeviceID),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+func deviceKey(deviceType enum.DeviceType, deviceID string) string {
+	return deviceType.String() + "/" + deviceID
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
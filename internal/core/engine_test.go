@@ -0,0 +1,593 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"github.com/turtacn/ioshelfer/internal/core/prediction"
+	"github.com/turtacn/ioshelfer/internal/core/remediation"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+)
+
+// stubDetector reports fixed statuses, optionally blocking until released.
+type stubDetector struct {
+	mu       sync.Mutex
+	statuses []detection.HealthStatus
+	block    chan struct{}
+}
+
+func (d *stubDetector) set(statuses ...detection.HealthStatus) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statuses = statuses
+}
+
+func (d *stubDetector) CheckSubHealth(deviceID string) (detection.HealthStatus, error) {
+	return detection.HealthStatus{}, nil
+}
+
+func (d *stubDetector) CheckAll() ([]detection.HealthStatus, error) {
+	if d.block != nil {
+		<-d.block
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]detection.HealthStatus(nil), d.statuses...), nil
+}
+
+// recordingSink collects the events it receives.
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *recordingSink) Handle(ctx context.Context, event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *recordingSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.events)
+}
+
+func newTestEngine(t *testing.T, interval time.Duration) *Engine {
+	monitor := ebpf.NewEBPFMonitor(&ebpf.Config{SysfsRoot: t.TempDir()})
+	e, err := NewEngine(&Config{Detection: detection.Config{
+		QueueThreshold:      100,
+		LatencyThreshold:    50 * time.Millisecond,
+		MonitorInterval:     interval,
+		IOPSVarThreshold:    1000,
+		PacketLossThreshold: 0.01,
+	}}, monitor)
+	require.NoError(t, err)
+	return e
+}
+
+func status(id string, s enum.HealthStatus, confidence float64) detection.HealthStatus {
+	return detection.HealthStatus{DeviceType: enum.Disk, DeviceID: id, Status: s, Confidence: confidence}
+}
+
+func drain(events <-chan Event) []Event {
+	var out []Event
+	for {
+		select {
+		case e := <-events:
+			out = append(out, e)
+		default:
+			return out
+		}
+	}
+}
+
+func TestEngineMergesResultsAndPublishesStateChanges(t *testing.T) {
+	e := newTestEngine(t, time.Minute)
+	rules, peers := &stubDetector{}, &stubDetector{}
+	rules.set(status("sda", enum.Healthy, 1), status("sdb", enum.Healthy, 1))
+	peers.set(status("sda", enum.SubHealthy, 0.7))
+	require.NoError(t, e.Register("rules", rules, 0))
+	require.NoError(t, e.Register("peers", peers, 0))
+	assert.Error(t, e.Register("peers", peers, 0))
+
+	events, unsubscribe := e.Subscribe()
+	defer unsubscribe()
+
+	e.RunOnce(context.Background())
+	merged, ok := e.Status(enum.Disk, "sda")
+	require.True(t, ok)
+	assert.Equal(t, enum.SubHealthy, merged.Status)
+	assert.Equal(t, 0.7, merged.Confidence)
+	assert.Len(t, e.Statuses(), 2)
+
+	var changes []Event
+	for _, event := range drain(events) {
+		if event.Type == EventStateChange {
+			changes = append(changes, event)
+		}
+	}
+	require.Len(t, changes, 1)
+	assert.Equal(t, "sda", changes[0].DeviceID)
+	assert.Equal(t, enum.Healthy, changes[0].Previous)
+
+	// An unchanged status produces checks but no further state change.
+	e.RunOnce(context.Background())
+	for _, event := range drain(events) {
+		assert.Equal(t, EventCheck, event.Type)
+	}
+
+	peers.set(status("sda", enum.Healthy, 1))
+	e.RunOnce(context.Background())
+	changes = nil
+	for _, event := range drain(events) {
+		if event.Type == EventStateChange {
+			changes = append(changes, event)
+		}
+	}
+	require.Len(t, changes, 1)
+	assert.Equal(t, enum.SubHealthy, changes[0].Previous)
+	assert.Equal(t, enum.Healthy, changes[0].Status.Status)
+}
+
+func TestEngineRunTimeout(t *testing.T) {
+	e := newTestEngine(t, time.Minute)
+	e.config.RunTimeout = 20 * time.Millisecond
+	slow := &stubDetector{block: make(chan struct{})}
+	slow.set(status("sda", enum.Failed, 1))
+	require.NoError(t, e.Register("slow", slow, 0))
+
+	start := time.Now()
+	e.RunOnce(context.Background())
+	assert.Less(t, time.Since(start), time.Second)
+	_, ok := e.Status(enum.Disk, "sda")
+	assert.False(t, ok)
+
+	// The abandoned run is still in flight, so the next run is skipped.
+	e.RunOnce(context.Background())
+	_, ok = e.Status(enum.Disk, "sda")
+	assert.False(t, ok)
+
+	close(slow.block)
+	assert.Eventually(t, func() bool {
+		e.RunOnce(context.Background())
+		_, ok := e.Status(enum.Disk, "sda")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestEngineStartStop(t *testing.T) {
+	e := newTestEngine(t, 10*time.Millisecond)
+	d := &stubDetector{}
+	d.set(status("sda", enum.SubHealthy, 0.9))
+	require.NoError(t, e.Register("stub", d, 0))
+
+	checks, changes := &recordingSink{}, &recordingSink{}
+	require.NoError(t, e.AddSink("checks", checks, EventCheck))
+	require.NoError(t, e.AddSink("changes", changes, EventStateChange))
+
+	require.NoError(t, e.Start(context.Background()))
+	assert.Error(t, e.Start(context.Background()))
+	assert.Error(t, e.Register("late", d, 0))
+
+	assert.Eventually(t, func() bool { return checks.count() >= 3 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, e.Stop())
+	require.NoError(t, e.Stop())
+
+	assert.Equal(t, 1, changes.count())
+	for _, event := range checks.events {
+		assert.Equal(t, EventCheck, event.Type)
+	}
+}
+
+func TestEngineHostScore(t *testing.T) {
+	e := newTestEngine(t, time.Minute)
+	e.config.Topology.DependsOn = map[detection.DeviceRef][]detection.DeviceRef{
+		{DeviceType: enum.Disk, DeviceID: "sdb"}: {{DeviceType: enum.Disk, DeviceID: "sda"}},
+	}
+	stub := &stubDetector{}
+	stub.set(status("sda", enum.Failed, 1), status("sdb", enum.Healthy, 1))
+	require.NoError(t, e.Register("stub", stub, 0))
+	e.RunOnce(context.Background())
+
+	score := e.HostScore()
+	assert.NotEmpty(t, score.Host)
+	assert.Equal(t, enum.Failed, score.Status)
+	assert.InDelta(t, 0, score.Score, 1e-9)
+	require.Len(t, score.Breakdown, 2)
+	assert.Equal(t, enum.Failed, score.Breakdown[1].Status)
+	assert.Equal(t, "sda", score.Breakdown[1].InheritedFrom.DeviceID)
+}
+
+func TestWebhookSink(t *testing.T) {
+	received := make(chan WebhookPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload WebhookPayload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		received <- payload
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL)
+	require.NoError(t, sink.Handle(context.Background(), Event{Type: EventCheck}))
+	require.NoError(t, sink.Handle(context.Background(), Event{
+		Type:       EventStateChange,
+		DeviceType: enum.Disk,
+		DeviceID:   "sda",
+		Previous:   enum.Healthy,
+		Status: detection.HealthStatus{
+			DeviceType: enum.Disk,
+			DeviceID:   "sda",
+			Status:     enum.SubHealthy,
+			Confidence: 0.8,
+			Findings: []detection.Finding{{
+				Code:      "ERR_HIGH_LATENCY",
+				Severity:  enum.SubHealthy,
+				Source:    detection.SourceRule,
+				Name:      "disk_latency",
+				Metric:    "avg_latency_seconds",
+				Operator:  ">",
+				Threshold: 0.05,
+				Observed:  0.12,
+			}},
+		},
+		At: time.Now(),
+	}))
+
+	payload := <-received
+	assert.Equal(t, "state_change", payload.Event)
+	assert.Equal(t, "sda", payload.DeviceID)
+	assert.Equal(t, "healthy", payload.Previous)
+	assert.Equal(t, "subhealthy", payload.Status)
+	require.Len(t, payload.Findings, 1)
+	assert.Equal(t, "ERR_HIGH_LATENCY", payload.Findings[0].Code)
+	assert.Equal(t, 0.12, payload.Findings[0].Observed)
+	assert.Contains(t, payload.Findings[0].Explanation, "avg_latency_seconds=0.12 > 0.05")
+}
+
+func TestPredictionOutcomeSink(t *testing.T) {
+	tracker, err := prediction.NewQualityTracker(prediction.QualityConfig{})
+	require.NoError(t, err)
+	now := time.Now()
+	for _, id := range []string{"sda", "sdb"} {
+		require.NoError(t, tracker.Record(prediction.PredictionResult{
+			DeviceType:         enum.Disk,
+			DeviceID:           id,
+			FailureProbability: 0.9,
+			ModelVersion:       "v1",
+			Survival:           prediction.Survival{Horizon: 30 * 24 * time.Hour},
+		}, now.Add(-time.Hour)))
+	}
+
+	sink := NewPredictionOutcomeSink(tracker)
+	require.NoError(t, sink.Handle(context.Background(), Event{
+		Type: EventStateChange, DeviceType: enum.Disk, DeviceID: "sdb",
+		Status: detection.HealthStatus{Status: enum.SubHealthy}, At: now,
+	}))
+	require.NoError(t, sink.Handle(context.Background(), Event{
+		Type: EventStateChange, DeviceType: enum.Disk, DeviceID: "sda",
+		Status: detection.HealthStatus{Status: enum.Failed}, At: now,
+	}))
+
+	outcomes := make(map[string]prediction.Outcome)
+	for _, r := range tracker.Records() {
+		outcomes[r.DeviceID] = r.Outcome
+	}
+	assert.Equal(t, map[string]prediction.Outcome{"sda": prediction.OutcomeFailed, "sdb": prediction.OutcomePending}, outcomes)
+}
+
+// recordingRemediator records the isolations and recoveries it is asked for.
+type recordingRemediator struct {
+	actions []string
+}
+
+func (r *recordingRemediator) Isolate(deviceType enum.DeviceType, deviceID string, strategy enum.IsolationStrategy) (remediation.RemediationResult, error) {
+	r.actions = append(r.actions, "isolate "+deviceID+" "+strategy.String())
+	return remediation.RemediationResult{Success: true, Action: "isolated"}, nil
+}
+
+func (r *recordingRemediator) Recover(deviceType enum.DeviceType, deviceID string) (remediation.RemediationResult, error) {
+	r.actions = append(r.actions, "recover "+deviceID)
+	return remediation.RemediationResult{Success: true, Action: "recovered"}, nil
+}
+
+func TestRemediationSinkRunsPlaybooks(t *testing.T) {
+	r := &recordingRemediator{}
+	remediators := map[enum.DeviceType]remediation.Remediator{enum.Disk: r}
+	playbooks, err := remediation.NewPlaybookEngine([]remediation.Playbook{{
+		Name:  "retire",
+		Codes: []string{"ERR_MEDIA"},
+		Steps: []remediation.PlaybookStep{{Name: "isolate", Action: remediation.StepIsolate, Strategy: "permanent"}},
+	}}, nil, remediators)
+	require.NoError(t, err)
+	sink := NewRemediationSink(remediators, playbooks)
+
+	for id, code := range map[string]string{"sda": "ERR_MEDIA", "sdb": "ERR_HIGH_LATENCY"} {
+		require.NoError(t, sink.Handle(context.Background(), Event{
+			Type: EventStateChange, DeviceType: enum.Disk, DeviceID: id, Previous: enum.Healthy,
+			Status: detection.HealthStatus{
+				DeviceType: enum.Disk, DeviceID: id, Status: enum.SubHealthy,
+				Findings: []detection.Finding{{Code: code, Severity: enum.SubHealthy}},
+			},
+			At: time.Now(),
+		}))
+	}
+	assert.ElementsMatch(t, []string{"isolate sda permanent", "isolate sdb temporary"}, r.actions)
+}
+
+func TestEngineEscalated(t *testing.T) {
+	engine := newTestEngine(t, time.Minute)
+	events, unsubscribe := engine.Subscribe(EventEscalation)
+	defer unsubscribe()
+
+	at := time.Now()
+	engine.Escalated(remediation.Escalation{
+		Isolation: remediation.Isolation{DeviceType: enum.Disk, DeviceID: "sda"},
+		At:        at,
+		Reason:    "device did not pass 3 consecutive probes within 1h0m0s",
+	})
+
+	event := <-events
+	assert.Equal(t, "escalation", event.Type.String())
+	assert.Equal(t, "sda", event.DeviceID)
+	assert.Equal(t, enum.Failed, event.Status.Status)
+	assert.Equal(t, "device did not pass 3 consecutive probes within 1h0m0s", event.Status.Recommendation)
+	assert.Equal(t, at, event.At)
+}
+
+func TestBusNeverDropsStateChanges(t *testing.T) {
+	bus := NewBus()
+	events, unsubscribe := bus.Subscribe(4)
+	defer unsubscribe()
+
+	for i := 0; i < 50; i++ {
+		for j := 0; j < 10; j++ {
+			bus.Publish(Event{Type: EventCheck, DeviceID: "sda"})
+		}
+		bus.Publish(Event{Type: EventStateChange, DeviceID: "sda", At: time.Unix(int64(i), 0)})
+	}
+	bus.Publish(Event{Type: EventEscalation, DeviceID: "sdb"})
+
+	var changes []Event
+	timeout := time.After(5 * time.Second)
+	for escalated := false; !escalated; {
+		select {
+		case event := <-events:
+			switch event.Type {
+			case EventStateChange:
+				changes = append(changes, event)
+			case EventEscalation:
+				escalated = true
+			}
+		case <-timeout:
+			t.Fatalf("received %d of 50 state changes", len(changes))
+		}
+	}
+	require.Len(t, changes, 50)
+	for i, event := range changes {
+		assert.Equal(t, int64(i), event.At.Unix())
+	}
+}
+
+func TestEngineRemediation(t *testing.T) {
+	sysfs, stateDir := t.TempDir(), t.TempDir()
+	for disk, state := range map[string]string{"sda": "running", "sdb": "offline"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(sysfs, "block", disk, "device"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sysfs, "block", disk, "device", "state"), []byte(state+"\n"), 0644))
+	}
+
+	// Actions persisted before a restart.
+	store, err := storage.NewFileStorage(stateDir)
+	require.NoError(t, err)
+	state, err := remediation.NewStateStore(store, 0)
+	require.NoError(t, err)
+	now := time.Now()
+	interrupted, err := state.Create(remediation.ActionRecord{DeviceType: enum.Disk, DeviceID: "sda", Strategy: enum.Temporary, Status: remediation.StatusExecuting}, now)
+	require.NoError(t, err)
+	isolated, err := state.Create(remediation.ActionRecord{DeviceType: enum.Disk, DeviceID: "sdb", Strategy: enum.Temporary, Status: remediation.StatusIsolated}, now)
+	require.NoError(t, err)
+
+	monitor := ebpf.NewEBPFMonitor(&ebpf.Config{SysfsRoot: t.TempDir()})
+	e, err := NewEngine(&Config{
+		Detection: detection.Config{MonitorInterval: time.Hour},
+		Remediation: &RemediationConfig{
+			Workflow:  &remediation.WorkflowConfig{},
+			StateDir:  stateDir,
+			SysfsRoot: sysfs,
+		},
+	}, monitor)
+	require.NoError(t, err)
+	require.NotNil(t, e.Workflow())
+	require.NotNil(t, e.RemediationState())
+	_, ok := e.Remediator(enum.RAID)
+	assert.False(t, ok, "RAID drives need controllers configured")
+	disk, ok := e.Remediator(enum.Disk)
+	require.True(t, ok)
+
+	require.NoError(t, e.Start(context.Background()))
+	defer e.Stop()
+
+	record, ok := e.RemediationState().Get(interrupted.ID)
+	require.True(t, ok)
+	assert.Equal(t, remediation.StatusFailed, record.Status)
+	record, ok = e.RemediationState().Get(isolated.ID)
+	require.True(t, ok)
+	assert.Equal(t, remediation.StatusIsolated, record.Status)
+	require.Len(t, disk.Isolations(), 1)
+	assert.Equal(t, "sdb", disk.Isolations()[0].DeviceID)
+}
+
+// diskMonitor reports fixed disk metrics.
+type diskMonitor struct {
+	ebpf.Monitor
+	disks map[string]*ebpf.DiskMetrics
+}
+
+func (m *diskMonitor) GetDiskMetrics() (map[string]*ebpf.DiskMetrics, error) {
+	return m.disks, nil
+}
+
+func TestEngineThrottlesSubHealthyAndIsolatesFailedDisks(t *testing.T) {
+	sysfs, cgroups := t.TempDir(), t.TempDir()
+	for disk, dev := range map[string]string{"sda": "8:0", "sdb": "8:16", "sdc": "8:32"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(sysfs, "block", disk, "device"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sysfs, "block", disk, "device", "state"), []byte("running\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sysfs, "block", disk, "dev"), []byte(dev+"\n"), 0644))
+	}
+	// sdb and sdc are the paths of a multipath device, so sdb can be taken out of service.
+	for _, dir := range []string{"block/sdb/holders/dm-0", "block/dm-0/dm", "block/dm-0/slaves/sdb", "block/dm-0/slaves/sdc"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(sysfs, dir), 0755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(sysfs, "block", "dm-0", "dm", "uuid"), []byte("mpath-3600a0b80\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(cgroups, "batch.slice"), 0755))
+
+	monitor := &diskMonitor{
+		Monitor: ebpf.NewEBPFMonitor(&ebpf.Config{SysfsRoot: t.TempDir()}),
+		disks: map[string]*ebpf.DiskMetrics{
+			"sda": {DeviceID: "sda", IOPSVariance: 5000},
+			"sdb": {DeviceID: "sdb", IOPSVariance: 5000},
+		},
+	}
+	e, err := NewEngine(&Config{
+		Detection: detection.Config{MonitorInterval: time.Hour, IOPSVarThreshold: 1000},
+		Remediation: &RemediationConfig{
+			Remediator: remediation.Config{AutoIsolation: true},
+			SysfsRoot:  sysfs,
+			Throttle:   &remediation.ThrottleConfig{CgroupRoot: cgroups, Limits: []remediation.CgroupIOLimit{{Cgroup: "batch.slice", Weight: 10}}},
+		},
+	}, monitor)
+	require.NoError(t, err)
+
+	var sink Sink
+	for _, s := range e.sinks {
+		if s.name == "remediation" {
+			sink = s.sink
+		}
+	}
+	require.NotNil(t, sink)
+	for id, s := range map[string]enum.HealthStatus{"sda": enum.SubHealthy, "sdb": enum.Failed} {
+		require.NoError(t, sink.Handle(context.Background(), Event{
+			Type: EventStateChange, DeviceType: enum.Disk, DeviceID: id, Previous: enum.Healthy,
+			Status: status(id, s, 0.9), At: time.Now(),
+		}))
+	}
+
+	weight, err := os.ReadFile(filepath.Join(cgroups, "batch.slice", "io.weight"))
+	require.NoError(t, err)
+	assert.Equal(t, "8:0 10", string(weight), "the sub-healthy disk is throttled")
+	state, err := os.ReadFile(filepath.Join(sysfs, "block", "sda", "device", "state"))
+	require.NoError(t, err)
+	assert.Equal(t, "running\n", string(state), "the sub-healthy disk stays in service")
+	state, err = os.ReadFile(filepath.Join(sysfs, "block", "sdb", "device", "state"))
+	require.NoError(t, err)
+	assert.Equal(t, "offline", string(state), "the failed disk is isolated")
+}
+
+func TestEngineRegistersAnomalyAndPeerDetection(t *testing.T) {
+	history, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, history.Store(storage.Metric{
+			Timestamp:  now.Add(time.Duration(i-10) * time.Minute),
+			DeviceType: enum.Disk,
+			DeviceID:   "sda",
+			Value:      &ebpf.DiskMetrics{DeviceID: "sda", QueueDepth: 2, AvgLatency: time.Millisecond},
+		}))
+	}
+
+	anomaly := detection.DefaultAnomalyConfig()
+	anomaly.MinSamples = 5
+	monitor := &diskMonitor{
+		Monitor: ebpf.NewEBPFMonitor(&ebpf.Config{SysfsRoot: t.TempDir()}),
+		disks: map[string]*ebpf.DiskMetrics{
+			"sda": {DeviceID: "sda", QueueDepth: 60, AvgLatency: time.Millisecond},
+			"sdb": {DeviceID: "sdb", QueueDepth: 2, AvgLatency: time.Millisecond},
+			"sdc": {DeviceID: "sdc", QueueDepth: 2, AvgLatency: time.Millisecond},
+			"sdd": {DeviceID: "sdd", QueueDepth: 2, AvgLatency: 40 * time.Millisecond},
+		},
+	}
+	e, err := NewEngine(&Config{
+		Detection: detection.Config{MonitorInterval: time.Hour, QueueThreshold: 100},
+		Anomaly:   anomaly,
+		History:   history,
+		Peers:     &PeerConfig{Groups: detection.StaticGroups{enum.Disk: {"md0": {"sda", "sdb", "sdc", "sdd"}}}},
+	}, monitor)
+	require.NoError(t, err)
+
+	var names []string
+	for _, reg := range e.detectors {
+		names = append(names, reg.name)
+	}
+	assert.Equal(t, []string{"raid", "disk", "network", "peer_raid", "peer_disk", "peer_network"}, names)
+
+	e.RunOnce(context.Background())
+	sources := func(id string) []string {
+		s, ok := e.Status(enum.Disk, id)
+		require.True(t, ok)
+		var sources []string
+		for _, f := range s.Findings {
+			sources = append(sources, f.Source)
+		}
+		return sources
+	}
+	assert.Contains(t, sources("sda"), detection.SourceAnomaly, "sda deviates from its stored history")
+	assert.Contains(t, sources("sdd"), detection.SourcePeer, "sdd is slower than its peers")
+	assert.Empty(t, sources("sdb"))
+}
+
+func TestEngineReconcilesPredictions(t *testing.T) {
+	tracker, err := prediction.NewQualityTracker(prediction.QualityConfig{})
+	require.NoError(t, err)
+	now := time.Now()
+	for _, id := range []string{"sda", "sdb", "sdc"} {
+		require.NoError(t, tracker.Record(prediction.PredictionResult{
+			DeviceType:         enum.Disk,
+			DeviceID:           id,
+			FailureProbability: 0.9,
+			ModelVersion:       "v1",
+			Survival:           prediction.Survival{Horizon: 30 * 24 * time.Hour},
+		}, now.Add(-time.Hour)))
+	}
+	labels := filepath.Join(t.TempDir(), "labels.csv")
+	require.NoError(t, os.WriteFile(labels, []byte("disk,sda,failure,"+now.Add(-time.Minute).Format(time.RFC3339)+"\n"), 0644))
+
+	monitor := ebpf.NewEBPFMonitor(&ebpf.Config{SysfsRoot: t.TempDir()})
+	e, err := NewEngine(&Config{
+		Detection:   detection.Config{MonitorInterval: time.Hour},
+		Predictions: &PredictionConfig{Tracker: tracker, LabelsFile: labels},
+	}, monitor)
+	require.NoError(t, err)
+	d := &stubDetector{}
+	d.set(status("sdb", enum.Failed, 0.99))
+	require.NoError(t, e.Register("stub", d, 0))
+	require.NoError(t, e.Start(context.Background()))
+	defer e.Stop()
+
+	outcomes := func() map[string]prediction.Outcome {
+		outcomes := make(map[string]prediction.Outcome)
+		for _, r := range tracker.Records() {
+			outcomes[r.DeviceID] = r.Outcome
+		}
+		return outcomes
+	}
+	// sda failed according to its label, sdb was observed failing.
+	assert.Eventually(t, func() bool {
+		o := outcomes()
+		return o["sda"] == prediction.OutcomeFailed && o["sdb"] == prediction.OutcomeFailed
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, prediction.OutcomePending, outcomes()["sdc"])
+}
@@ -0,0 +1,155 @@
+package prediction
+
+import (
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+	"github.com/turtacn/ioshelfer/pkg/disk"
+	"go.uber.org/zap"
+	"io"
+	"time"
+)
+
+// ImportOptions filters and batches a Backblaze drive stats import.
+type ImportOptions struct {
+	Models    []string  // Drive models to import; all when empty
+	Since     time.Time // Days before this are skipped
+	BatchSize int       // Records buffered before they are written; 10000 when zero
+}
+
+// ImportReport summarises an import.
+type ImportReport struct {
+	Rows     int // Rows read
+	Records  int // SMART records stored
+	Devices  int // Distinct drives stored
+	Failures int
+	Labels   []Label // One failure label per failed drive
+}
+
+// batchStorer is implemented by storages that write many metrics at once more cheaply than
+// one at a time.
+type batchStorer interface {
+	StoreBatch(metrics []storage.Metric) error
+}
+
+// BackblazeImporter stores Backblaze drive stats as disk SMART history with failure labels,
+// so disk models can be bootstrapped on public data before being fine-tuned on local
+// history. Drives are identified by serial number.
+type BackblazeImporter struct {
+	storage storage.Storage
+	options ImportOptions
+	models  map[string]bool
+	devices map[string]bool
+	failed  map[string]bool
+	pending []storage.Metric
+	report  ImportReport
+}
+
+// NewBackblazeImporter creates a new BackblazeImporter.
+func NewBackblazeImporter(store storage.Storage, options ImportOptions) *BackblazeImporter {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 10000
+	}
+	i := &BackblazeImporter{
+		storage: store,
+		options: options,
+		devices: make(map[string]bool),
+		failed:  make(map[string]bool),
+	}
+	if len(options.Models) > 0 {
+		i.models = make(map[string]bool, len(options.Models))
+		for _, m := range options.Models {
+			i.models[m] = true
+		}
+	}
+	return i
+}
+
+// Import streams one drive stats CSV into storage. Files may be imported in any number
+// of calls; Report covers all of them.
+func (i *BackblazeImporter) Import(r io.Reader) error {
+	reader, err := disk.NewBackblazeReader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		i.report.Rows++
+
+		smart := record.SMART
+		if i.models != nil && !i.models[smart.Model] {
+			continue
+		}
+		if smart.Timestamp.Before(i.options.Since) {
+			continue
+		}
+
+		i.pending = append(i.pending, storage.Metric{
+			Timestamp:  smart.Timestamp,
+			DeviceType: enum.Disk,
+			DeviceID:   smart.DeviceID,
+			Value:      smart,
+		})
+		i.report.Records++
+		if !i.devices[smart.DeviceID] {
+			i.devices[smart.DeviceID] = true
+			i.report.Devices++
+		}
+		if record.Failed && !i.failed[smart.DeviceID] {
+			i.failed[smart.DeviceID] = true
+			i.report.Failures++
+			i.report.Labels = append(i.report.Labels, Label{
+				DeviceType: enum.Disk,
+				DeviceID:   smart.DeviceID,
+				Event:      EventFailure,
+				Time:       smart.Timestamp,
+			})
+		}
+		if len(i.pending) >= i.options.BatchSize {
+			if err := i.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return i.Flush()
+}
+
+// Flush writes buffered records to storage.
+func (i *BackblazeImporter) Flush() error {
+	if len(i.pending) == 0 {
+		return nil
+	}
+	if b, ok := i.storage.(batchStorer); ok {
+		if err := b.StoreBatch(i.pending); err != nil {
+			return err
+		}
+	} else {
+		for _, m := range i.pending {
+			if err := i.storage.Store(m); err != nil {
+				return errors.NewStorageFailure("failed to store imported SMART data", err)
+			}
+		}
+	}
+	logger.Info("imported Backblaze drive stats",
+		zap.Int("records", len(i.pending)),
+		zap.Int("total_records", i.report.Records),
+		zap.Int("devices", i.report.Devices),
+		zap.Int("failures", i.report.Failures),
+	)
+	i.pending = i.pending[:0]
+	return nil
+}
+
+// Report returns what has been imported so far.
+func (i *BackblazeImporter) Report() ImportReport {
+	report := i.report
+	report.Labels = append([]Label(nil), i.report.Labels...)
+	return report
+}
@@ -0,0 +1,235 @@
+package prediction
+
+import (
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// distributionBins is the number of quantile bins feature distributions are summarised in.
+const distributionBins = 10
+
+// driftEpsilon stands in for empty bins' shares, which would make PSI infinite.
+const driftEpsilon = 1e-4
+
+// FeatureDistribution summarises a feature's training distribution in quantile bins, so
+// live inputs can be compared with it.
+type FeatureDistribution struct {
+	Feature     string    `json:"feature"`
+	Edges       []float64 `json:"edges"`       // Inclusive upper bounds of every bin but the last, ascending
+	Proportions []float64 `json:"proportions"` // Share of training examples in each bin
+}
+
+// NewFeatureDistributions summarises each named feature's distribution over the examples
+// in up to bins quantile bins. Tied quantiles are merged, so a feature that is mostly zero
+// gets a bin of its own for zero.
+func NewFeatureDistributions(names []string, examples []Example, bins int) []FeatureDistribution {
+	if len(examples) == 0 || bins <= 0 {
+		return nil
+	}
+	distributions := make([]FeatureDistribution, 0, len(names))
+	values := make([]float64, len(examples))
+	for _, name := range names {
+		for i, e := range examples {
+			values[i] = e.Features[name]
+		}
+		sort.Float64s(values)
+
+		d := FeatureDistribution{Feature: name}
+		for q := 1; q < bins; q++ {
+			edge := values[q*len(values)/bins]
+			if n := len(d.Edges); n == 0 || edge > d.Edges[n-1] {
+				d.Edges = append(d.Edges, edge)
+			}
+		}
+		d.Proportions = d.shares(values)
+		distributions = append(distributions, d)
+	}
+	return distributions
+}
+
+// shares returns the share of values in each bin.
+func (d FeatureDistribution) shares(values []float64) []float64 {
+	shares := make([]float64, len(d.Edges)+1)
+	for _, v := range values {
+		shares[sort.SearchFloat64s(d.Edges, v)]++
+	}
+	for i := range shares {
+		shares[i] /= float64(len(values))
+	}
+	return shares
+}
+
+// PSI returns the population stability index of values against the distribution: below 0.1
+// is stable, above 0.25 a significant shift.
+func (d FeatureDistribution) PSI(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	psi := 0.0
+	for i, actual := range d.shares(values) {
+		expected := math.Max(d.Proportions[i], driftEpsilon)
+		actual = math.Max(actual, driftEpsilon)
+		psi += (actual - expected) * math.Log(actual/expected)
+	}
+	return psi
+}
+
+// KS returns the Kolmogorov-Smirnov distance between values and the distribution, the
+// largest difference of their cumulative distributions at the bin edges.
+func (d FeatureDistribution) KS(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	ks, expected, actual := 0.0, 0.0, 0.0
+	for i, share := range d.shares(values) {
+		expected += d.Proportions[i]
+		actual += share
+		ks = math.Max(ks, math.Abs(actual-expected))
+	}
+	return ks
+}
+
+// DriftConfig defines when live prediction inputs count as drifted from the training data.
+type DriftConfig struct {
+	Window       int     // Devices whose latest features form the live distribution; 500 when zero
+	MinSamples   int     // Devices needed before drift is assessed; 30 when zero
+	PSIThreshold float64 // PSI above which a feature has drifted; 0.25 when zero
+	KSThreshold  float64 // KS distance above which a feature has drifted; 0.3 when zero
+}
+
+// FeatureDrift is a feature whose live distribution drifted from its training distribution.
+type FeatureDrift struct {
+	Feature string
+	PSI     float64
+	KS      float64
+}
+
+// DriftReport compares the live inputs of a model with its training data.
+type DriftReport struct {
+	ModelVersion string
+	Samples      int            // Devices in the live distribution
+	Severity     float64        // Largest PSI or KS distance relative to its threshold; drifted above one
+	Features     []FeatureDrift // Drifted features, most drifted first
+}
+
+// Drifted reports whether any feature drifted.
+func (r DriftReport) Drifted() bool {
+	return len(r.Features) > 0
+}
+
+// Err returns an ERR_FEATURE_DRIFT error naming the drifted features, or nil.
+func (r DriftReport) Err() error {
+	if !r.Drifted() {
+		return nil
+	}
+	names := make([]string, len(r.Features))
+	for i, f := range r.Features {
+		names[i] = f.Feature
+	}
+	return errors.NewFeatureDrift("inputs of model "+r.ModelVersion+" drifted from its training data across "+
+		strconv.Itoa(r.Samples)+" devices: "+strings.Join(names, ", "), nil)
+}
+
+// driftMonitor keeps the latest features of every device scored by each model and compares
+// them with the model's training distributions.
+type driftMonitor struct {
+	config  DriftConfig
+	mu      sync.Mutex
+	windows map[string]*driftWindow // Keyed by device type and model version
+}
+
+// driftWindow is the live distribution of one model's inputs.
+type driftWindow struct {
+	latest  map[string]driftInput // Keyed by device
+	seq     uint64
+	drifted string // Drifted features at the last assessment, to report changes only
+}
+
+type driftInput struct {
+	features Features
+	seq      uint64
+}
+
+// newDriftMonitor creates a new driftMonitor, applying defaults.
+func newDriftMonitor(config DriftConfig) *driftMonitor {
+	if config.Window <= 0 {
+		config.Window = 500
+	}
+	if config.MinSamples <= 0 {
+		config.MinSamples = 30
+	}
+	if config.PSIThreshold <= 0 {
+		config.PSIThreshold = 0.25
+	}
+	if config.KSThreshold <= 0 {
+		config.KSThreshold = 0.3
+	}
+	return &driftMonitor{config: config, windows: make(map[string]*driftWindow)}
+}
+
+// observe adds a device's features to the model's live distribution and assesses drift.
+// changed reports whether the drifted features differ from the previous assessment.
+func (m *driftMonitor) observe(model Model, deviceID string, features Features) (report DriftReport, changed bool) {
+	info := model.Info()
+	report.ModelVersion = info.Version
+	if len(info.Distributions) == 0 {
+		return report, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := info.DeviceType.String() + "@" + info.Version
+	w, ok := m.windows[key]
+	if !ok {
+		w = &driftWindow{latest: make(map[string]driftInput)}
+		m.windows[key] = w
+	}
+	w.seq++
+	w.latest[deviceID] = driftInput{features: features, seq: w.seq}
+	if len(w.latest) > m.config.Window {
+		oldest := deviceID
+		for id, input := range w.latest {
+			if input.seq < w.latest[oldest].seq {
+				oldest = id
+			}
+		}
+		delete(w.latest, oldest)
+	}
+	report.Samples = len(w.latest)
+	if report.Samples < m.config.MinSamples {
+		return report, false
+	}
+
+	values := make([]float64, 0, len(w.latest))
+	for _, d := range info.Distributions {
+		values = values[:0]
+		for _, input := range w.latest {
+			values = append(values, input.features[d.Feature])
+		}
+		psi, ks := d.PSI(values), d.KS(values)
+		severity := math.Max(psi/m.config.PSIThreshold, ks/m.config.KSThreshold)
+		report.Severity = math.Max(report.Severity, severity)
+		if severity > 1 {
+			report.Features = append(report.Features, FeatureDrift{Feature: d.Feature, PSI: psi, KS: ks})
+		}
+	}
+	sort.SliceStable(report.Features, func(i, j int) bool {
+		if report.Features[i].PSI != report.Features[j].PSI {
+			return report.Features[i].PSI > report.Features[j].PSI
+		}
+		return report.Features[i].Feature < report.Features[j].Feature
+	})
+
+	names := make([]string, len(report.Features))
+	for i, f := range report.Features {
+		names[i] = f.Feature
+	}
+	drifted := strings.Join(names, ",")
+	changed = drifted != w.drifted
+	w.drifted = drifted
+	return report, changed
+}
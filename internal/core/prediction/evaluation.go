@@ -0,0 +1,129 @@
+package prediction
+
+import (
+	"sort"
+)
+
+// Evaluation summarises how well predicted probabilities matched observed outcomes.
+type Evaluation struct {
+	Samples   int     `json:"samples"`
+	Positives int     `json:"positives"`
+	Threshold float64 `json:"threshold"` // Probability at which a device counts as predicted to fail
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	AUC       float64 `json:"auc"`   // Zero when either class is missing
+	Brier     float64 `json:"brier"` // Mean squared error of the probabilities; lower is better
+}
+
+// CalibrationBin compares predicted probabilities in [Lower, Upper) with how often the
+// devices they were predicted for failed.
+type CalibrationBin struct {
+	Lower         float64 `json:"lower"`
+	Upper         float64 `json:"upper"`
+	Count         int     `json:"count"`
+	MeanPredicted float64 `json:"mean_predicted"`
+	ObservedRate  float64 `json:"observed_rate"`
+}
+
+// Evaluate scores probabilities against outcomes.
+func Evaluate(probabilities []float64, failed []bool, threshold float64) Evaluation {
+	e := Evaluation{Samples: len(probabilities), Threshold: threshold}
+	truePositives, predicted := 0, 0
+	for i, p := range probabilities {
+		outcome := 0.0
+		if failed[i] {
+			e.Positives++
+			outcome = 1
+		}
+		e.Brier += (p - outcome) * (p - outcome)
+		if p >= threshold {
+			predicted++
+			if failed[i] {
+				truePositives++
+			}
+		}
+	}
+	if predicted > 0 {
+		e.Precision = float64(truePositives) / float64(predicted)
+	}
+	if e.Positives > 0 {
+		e.Recall = float64(truePositives) / float64(e.Positives)
+	}
+	if e.Samples > 0 {
+		e.Brier /= float64(e.Samples)
+	}
+	e.AUC = auc(probabilities, failed)
+	return e
+}
+
+// Calibrate groups probabilities into equal-width bins; a well-calibrated model's bins
+// have observed failure rates close to their mean predicted probabilities. Empty bins
+// are omitted.
+func Calibrate(probabilities []float64, failed []bool, bins int) []CalibrationBin {
+	if bins <= 0 {
+		bins = 10
+	}
+	all := make([]CalibrationBin, bins)
+	for i := range all {
+		all[i].Lower = float64(i) / float64(bins)
+		all[i].Upper = float64(i+1) / float64(bins)
+	}
+	for i, p := range probabilities {
+		b := int(p * float64(bins))
+		if b >= bins {
+			b = bins - 1
+		}
+		if b < 0 {
+			b = 0
+		}
+		all[b].Count++
+		all[b].MeanPredicted += p
+		if failed[i] {
+			all[b].ObservedRate++
+		}
+	}
+	var result []CalibrationBin
+	for _, b := range all {
+		if b.Count == 0 {
+			continue
+		}
+		b.MeanPredicted /= float64(b.Count)
+		b.ObservedRate /= float64(b.Count)
+		result = append(result, b)
+	}
+	return result
+}
+
+// auc returns the area under the ROC curve from the Mann-Whitney rank sum, with tied
+// probabilities sharing their mean rank.
+func auc(probabilities []float64, failed []bool) float64 {
+	order := make([]int, len(probabilities))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return probabilities[order[a]] < probabilities[order[b]] })
+
+	positives, negatives := 0, 0
+	rankSum := 0.0
+	for i := 0; i < len(order); {
+		j := i
+		for j < len(order) && probabilities[order[j]] == probabilities[order[i]] {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for _, idx := range order[i:j] {
+			if failed[idx] {
+				positives++
+				rankSum += rank
+			} else {
+				negatives++
+			}
+		}
+		i = j
+	}
+	if positives == 0 || negatives == 0 {
+		return 0
+	}
+	p := float64(positives)
+	return (rankSum - p*(p+1)/2) / (p * float64(negatives))
+}
@@ -0,0 +1,179 @@
+package prediction
+
+import (
+	"fmt"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Attribution is one feature's contribution to a prediction.
+type Attribution struct {
+	Feature      string
+	Value        float64
+	Contribution float64 // Log-odds added relative to the average training device; positive raises risk
+}
+
+// Explainer is implemented by models that can attribute a prediction to its features.
+type Explainer interface {
+	Attribute(features Features) []Attribution
+}
+
+// Attribute returns each feature's coefficient × standardised value, largest effect first.
+func (m *LogisticModel) Attribute(features Features) []Attribution {
+	x := m.vector(features)
+	attributions := make([]Attribution, 0, len(x))
+	for i, name := range m.Meta.Features {
+		attributions = append(attributions, Attribution{
+			Feature:      name,
+			Value:        features[name],
+			Contribution: m.Weights[i] * x[i],
+		})
+	}
+	sortAttributions(attributions)
+	return attributions
+}
+
+// sortAttributions orders attributions by absolute contribution, then by name.
+func sortAttributions(attributions []Attribution) {
+	sort.SliceStable(attributions, func(i, j int) bool {
+		a, b := math.Abs(attributions[i].Contribution), math.Abs(attributions[j].Contribution)
+		if a != b {
+			return a > b
+		}
+		return attributions[i].Feature < attributions[j].Feature
+	})
+}
+
+// metricDescription names a metric for operators and says what to do when it drives risk.
+type metricDescription struct {
+	label          string
+	recommendation string
+}
+
+// metricDescriptions describe every metric features are extracted from.
+var metricDescriptions = map[string]metricDescription{
+	ebpf.MetricReallocatedSectors: {"reallocated sectors (SMART 5)",
+		"Back up the drive and schedule its replacement: reallocated sectors indicate failing media"},
+	ebpf.MetricReadErrorRate: {"read error rate (SMART 1)",
+		"Run an extended SMART self-test and check cabling: read errors are rising"},
+	ebpf.MetricTemperature: {"temperature in °C (SMART 194)",
+		"Check cooling and airflow around the drive"},
+	MetricPowerOnHours: {"power-on hours (SMART 9)",
+		"Include the drive in the next replacement cycle: it is near the end of its service life"},
+	MetricReportedUncorrectable: {"reported uncorrectable errors (SMART 187)",
+		"Back up the drive and replace it: it has reported errors it could not correct"},
+	MetricPendingSectors: {"pending sectors (SMART 197)",
+		"Back up the drive and run an extended SMART self-test: sectors are waiting to be reallocated"},
+	MetricOfflineUncorrectable: {"offline uncorrectable sectors (SMART 198)",
+		"Back up the drive and schedule its replacement: sectors failed offline scans"},
+	ebpf.MetricAvgLatency: {"average I/O latency in seconds",
+		"Move latency-sensitive workloads off the device and check its path for slow I/O"},
+	ebpf.MetricIOPSVariance: {"IOPS variance",
+		"Check the device for intermittent stalls: its throughput is erratic"},
+	ebpf.MetricQueueDepth: {"controller queue depth",
+		"Check controller load and firmware: requests are queuing up"},
+	ebpf.MetricErrorRetryRate: {"error retries per hour",
+		"Check RAID controller logs and firmware: retries indicate a degrading path"},
+	ebpf.MetricCacheEventRate: {"controller cache events per hour",
+		"Check the controller's cache battery or capacitor: its write cache is being disabled"},
+	MetricFirmwareChanges: {"controller firmware changes",
+		"Verify the controller firmware against the vendor's recommended release and roll back if problems started with the change"},
+	ebpf.MetricPacketLossRate: {"packet loss rate",
+		"Check the NIC, cabling and switch port, and fail over to a redundant path"},
+	ebpf.MetricLatencyP95: {"95th percentile network latency in seconds",
+		"Check the NIC and switch port for congestion or errors, and fail over to a redundant path"},
+	ebpf.MetricInterfaceErrorRate: {"interface errors per hour",
+		"Replace the cable or transceiver and check the switch port: the interface is counting errors"},
+	ebpf.MetricCarrierChangeRate: {"link flaps per hour",
+		"Check the cable, transceiver and switch port, and fail over to a redundant path: the link is flapping"},
+	ebpf.MetricRetransmitRate: {"TCP retransmits per second",
+		"Check the path for congestion or loss and fail over to a redundant path: TCP is retransmitting"},
+}
+
+// riskRecommendations are the overall recommendation for each risk level.
+var riskRecommendations = map[RiskLevel]string{
+	RiskCritical: "Replace the device as soon as possible",
+	RiskHigh:     "Schedule the device's replacement within the next month",
+	RiskMedium:   "Monitor the device closely and check it again within a week",
+	RiskLow:      "No action required",
+}
+
+// DescribeFeature renders a feature and its value for operators, e.g., "reallocated sectors
+// (SMART 5) rose by 14 over 7 days".
+func DescribeFeature(feature string, value float64) string {
+	switch feature {
+	case "history_days":
+		return fmt.Sprintf("history covers %.4g days", value)
+	case "samples":
+		return fmt.Sprintf("%.4g stored readings", value)
+	}
+
+	metric, aggregate := feature, ""
+	if i := strings.LastIndex(feature, "."); i >= 0 {
+		metric, aggregate = feature[:i], feature[i+1:]
+	}
+	label := metric
+	if d, ok := metricDescriptions[metric]; ok {
+		label = d.label
+	}
+	switch aggregate {
+	case "last":
+		return fmt.Sprintf("%s is %.4g", label, value)
+	case "mean_24h":
+		return fmt.Sprintf("%s averaged %.4g over 24 hours", label, value)
+	case "max_7d":
+		return fmt.Sprintf("%s peaked at %.4g over 7 days", label, value)
+	case "delta_24h":
+		return fmt.Sprintf("%s %s over 24 hours", label, change(value))
+	case "delta_7d":
+		return fmt.Sprintf("%s %s over 7 days", label, change(value))
+	case "rate_per_day":
+		return fmt.Sprintf("%s %s per day on average", label, change(value))
+	case "drift_7d":
+		return fmt.Sprintf("%s is %+.0f%% against its 7-day baseline", label, value*100)
+	default:
+		return fmt.Sprintf("%s = %.4g", feature, value)
+	}
+}
+
+func change(delta float64) string {
+	switch {
+	case delta > 0:
+		return fmt.Sprintf("rose by %.4g", delta)
+	case delta < 0:
+		return fmt.Sprintf("fell by %.4g", -delta)
+	default:
+		return "did not change"
+	}
+}
+
+// explain returns up to top human-readable factors that raised the prediction and the
+// recommendations for them, led by the recommendation for the risk level.
+func explain(attributions []Attribution, risk RiskLevel, top int) ([]string, []string) {
+	var factors []string
+	recommendations := []string{riskRecommendations[risk]}
+	seen := make(map[string]bool)
+	for _, a := range attributions {
+		if len(factors) >= top {
+			break
+		}
+		if a.Contribution <= 0 {
+			continue
+		}
+		factors = append(factors, fmt.Sprintf("%s (+%.2f log-odds)", DescribeFeature(a.Feature, a.Value), a.Contribution))
+		if risk == RiskLow {
+			continue
+		}
+		metric := a.Feature
+		if i := strings.LastIndex(metric, "."); i >= 0 {
+			metric = metric[:i]
+		}
+		if d, ok := metricDescriptions[metric]; ok && !seen[metric] {
+			seen[metric] = true
+			recommendations = append(recommendations, d.recommendation)
+		}
+	}
+	return factors, recommendations
+}
@@ -0,0 +1,348 @@
+package prediction
+
+import (
+	"encoding/json"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+	"sort"
+	"time"
+)
+
+// SMART metrics that only SMART records carry.
+const (
+	MetricPowerOnHours          = "power_on_hours"
+	MetricReportedUncorrectable = "reported_uncorrectable" // SMART 187
+	MetricPendingSectors        = "pending_sectors"        // SMART 197
+	MetricOfflineUncorrectable  = "offline_uncorrectable"  // SMART 198
+)
+
+// MetricFirmwareChanges counts the firmware changes a RAID controller's history records.
+const MetricFirmwareChanges = "firmware_changes"
+
+// attributeMetrics maps SMART attribute IDs to the metrics their raw values feed.
+var attributeMetrics = map[string]string{
+	"187": MetricReportedUncorrectable,
+	"197": MetricPendingSectors,
+	"198": MetricOfflineUncorrectable,
+}
+
+// Rolling windows features are aggregated over.
+const (
+	ShortWindow = 24 * time.Hour
+	LongWindow  = 7 * 24 * time.Hour
+)
+
+// Features maps feature names, such as "reallocated_sectors.delta_7d", to values.
+type Features map[string]float64
+
+// featureMetrics lists the metrics features are extracted from, per device type.
+var featureMetrics = map[enum.DeviceType][]string{
+	enum.RAID: {
+		ebpf.MetricQueueDepth,
+		ebpf.MetricAvgLatency,
+		ebpf.MetricErrorRetryRate,
+		ebpf.MetricCacheEventRate,
+		MetricFirmwareChanges,
+	},
+	enum.Disk: {
+		ebpf.MetricReallocatedSectors,
+		ebpf.MetricReadErrorRate,
+		ebpf.MetricTemperature,
+		MetricPowerOnHours,
+		MetricReportedUncorrectable,
+		MetricPendingSectors,
+		MetricOfflineUncorrectable,
+		ebpf.MetricAvgLatency,
+		ebpf.MetricIOPSVariance,
+	},
+	enum.Network: {
+		ebpf.MetricPacketLossRate,
+		ebpf.MetricLatencyP95,
+		ebpf.MetricInterfaceErrorRate,
+		ebpf.MetricCarrierChangeRate,
+		ebpf.MetricRetransmitRate,
+	},
+}
+
+// driftMetrics additionally get a "drift_7d" feature: the relative change of their 24-hour
+// mean against the six days before, which catches latency creeping up on a controller or
+// path long before it crosses an absolute threshold.
+var driftMetrics = map[enum.DeviceType][]string{
+	enum.RAID:    {ebpf.MetricAvgLatency},
+	enum.Network: {ebpf.MetricLatencyP95},
+}
+
+// smartMetrics come from SMART records, and from eBPF monitor records when the monitor read
+// the disk's SMART data; records without it carry zeros that are not observations.
+var smartMetrics = map[string]bool{
+	ebpf.MetricReallocatedSectors: true,
+	ebpf.MetricReadErrorRate:      true,
+	ebpf.MetricTemperature:        true,
+}
+
+// featureAggregates are the per-metric features, in the order FeatureNames reports them.
+var featureAggregates = []string{"last", "mean_24h", "max_7d", "delta_24h", "delta_7d", "rate_per_day"}
+
+// FeatureNames returns the names of the features ExtractFeatures produces for a device type.
+func FeatureNames(deviceType enum.DeviceType) []string {
+	names := []string{"history_days", "samples"}
+	for _, metric := range featureMetrics[deviceType] {
+		for _, aggregate := range featureAggregates {
+			names = append(names, metric+"."+aggregate)
+		}
+	}
+	for _, metric := range driftMetrics[deviceType] {
+		names = append(names, metric+".drift_7d")
+	}
+	return names
+}
+
+// ExtractFeatures derives a device's features as of now from its stored history: latest
+// values, rolling means and maxima, attribute deltas, daily rates of change and, for RAID
+// controllers and network paths, latency drift. Metrics missing from the history yield
+// zero features.
+func ExtractFeatures(deviceType enum.DeviceType, history []storage.Metric, now time.Time) (Features, error) {
+	metrics, ok := featureMetrics[deviceType]
+	if !ok {
+		return nil, errors.New("unsupported device type", nil)
+	}
+	series, err := decodeHistory(deviceType, history, now)
+	if err != nil {
+		return nil, err
+	}
+
+	features := make(Features, len(metrics)*len(featureAggregates)+2)
+	var first, last time.Time
+	samples := 0
+	for _, points := range series {
+		if len(points) == 0 {
+			continue
+		}
+		if first.IsZero() || points[0].Time.Before(first) {
+			first = points[0].Time
+		}
+		if points[len(points)-1].Time.After(last) {
+			last = points[len(points)-1].Time
+		}
+		if len(points) > samples {
+			samples = len(points)
+		}
+	}
+	features["samples"] = float64(samples)
+	if samples > 0 {
+		features["history_days"] = last.Sub(first).Hours() / 24
+	}
+
+	for _, metric := range metrics {
+		points := series[metric]
+		for _, aggregate := range featureAggregates {
+			features[metric+"."+aggregate] = 0
+		}
+		if len(points) == 0 {
+			continue
+		}
+		latest := points[len(points)-1]
+		features[metric+".last"] = latest.Value
+		features[metric+".mean_24h"] = windowMean(points, now.Add(-ShortWindow))
+		features[metric+".max_7d"] = windowMax(points, now.Add(-LongWindow))
+		features[metric+".delta_24h"] = windowDelta(points, now.Add(-ShortWindow))
+		features[metric+".delta_7d"] = windowDelta(points, now.Add(-LongWindow))
+		if days := latest.Time.Sub(points[0].Time).Hours() / 24; days > 0 {
+			features[metric+".rate_per_day"] = (latest.Value - points[0].Value) / days
+		}
+	}
+	for _, metric := range driftMetrics[deviceType] {
+		features[metric+".drift_7d"] = windowDrift(series[metric], now)
+	}
+	return features, nil
+}
+
+// smartRecord is the stored form of a pkg/disk SMART reading.
+type smartRecord struct {
+	ReallocatedSectors *float64 `json:"reallocated_sectors"`
+	ReadErrorRate      *float64 `json:"read_error_rate"`
+	Temperature        *float64 `json:"temperature"`
+	PowerOnHours       *float64 `json:"power_on_hours"`
+	Attributes         map[string]struct {
+		RawValue *float64 `json:"raw_value"`
+	} `json:"attributes"`
+}
+
+// values returns the record's metrics, or nil if the record is not a SMART reading.
+func (r smartRecord) values() map[string]float64 {
+	values := make(map[string]float64)
+	for metric, v := range map[string]*float64{
+		ebpf.MetricReallocatedSectors: r.ReallocatedSectors,
+		ebpf.MetricReadErrorRate:      r.ReadErrorRate,
+		ebpf.MetricTemperature:        r.Temperature,
+		MetricPowerOnHours:            r.PowerOnHours,
+	} {
+		if v != nil {
+			values[metric] = *v
+		}
+	}
+	for id, metric := range attributeMetrics {
+		if attr, ok := r.Attributes[id]; ok && attr.RawValue != nil {
+			values[metric] = *attr.RawValue
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// decodeHistory turns stored records up to now into per-metric series, oldest first.
+// Disk histories mix SMART readings with eBPF performance samples.
+func decodeHistory(deviceType enum.DeviceType, history []storage.Metric, now time.Time) (map[string][]ebpf.Point, error) {
+	sorted := append([]storage.Metric(nil), history...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	series := make(map[string][]ebpf.Point)
+	var firmware string
+	changes := 0
+	for _, m := range sorted {
+		if m.Timestamp.After(now) {
+			break
+		}
+		values, err := recordValues(deviceType, m.Value)
+		if err != nil {
+			return nil, err
+		}
+		if deviceType == enum.RAID {
+			if fw := recordFirmware(m.Value); fw != "" {
+				if firmware != "" && fw != firmware {
+					changes++
+				}
+				firmware = fw
+			}
+			values[MetricFirmwareChanges] = float64(changes)
+		}
+		for metric, v := range values {
+			series[metric] = append(series[metric], ebpf.Point{Time: m.Timestamp, Value: v})
+		}
+	}
+	return series, nil
+}
+
+// recordValues decodes one stored record into metric values.
+func recordValues(deviceType enum.DeviceType, value interface{}) (map[string]float64, error) {
+	if deviceType == enum.Disk {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, errors.New("failed to re-encode stored metric", err)
+		}
+		var smart smartRecord
+		if err := json.Unmarshal(data, &smart); err == nil {
+			if values := smart.values(); values != nil {
+				return values, nil
+			}
+		}
+	}
+
+	values, err := detection.MetricValues(deviceType, value)
+	if err != nil {
+		return nil, err
+	}
+	if deviceType == enum.Disk && !hasSMART(value) {
+		for metric := range smartMetrics {
+			delete(values, metric)
+		}
+	}
+	return values, nil
+}
+
+// hasSMART reports whether a stored eBPF disk record carries SMART data.
+func hasSMART(value interface{}) bool {
+	if m, ok := value.(*ebpf.DiskMetrics); ok {
+		return m.SMART != (ebpf.SMARTData{})
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	var record struct {
+		SMART ebpf.SMARTData
+	}
+	return json.Unmarshal(data, &record) == nil && record.SMART != (ebpf.SMARTData{})
+}
+
+// recordFirmware returns the firmware version a stored RAID record reports, if any.
+func recordFirmware(value interface{}) string {
+	if m, ok := value.(*ebpf.RAIDMetrics); ok {
+		return m.Firmware
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	var record struct{ Firmware string }
+	if err := json.Unmarshal(data, &record); err != nil {
+		return ""
+	}
+	return record.Firmware
+}
+
+// windowMean returns the mean of the points since cutoff, or of the latest point if none are.
+func windowMean(points []ebpf.Point, cutoff time.Time) float64 {
+	sum, n := 0.0, 0
+	for _, p := range points {
+		if !p.Time.Before(cutoff) {
+			sum += p.Value
+			n++
+		}
+	}
+	if n == 0 {
+		return points[len(points)-1].Value
+	}
+	return sum / float64(n)
+}
+
+// windowMax returns the largest value since cutoff, or the latest value if no point is.
+func windowMax(points []ebpf.Point, cutoff time.Time) float64 {
+	max := points[len(points)-1].Value
+	for _, p := range points {
+		if !p.Time.Before(cutoff) && p.Value > max {
+			max = p.Value
+		}
+	}
+	return max
+}
+
+// windowDelta returns how much the value changed since cutoff, measured from the last point
+// before the window so a change right at its start is not missed.
+func windowDelta(points []ebpf.Point, cutoff time.Time) float64 {
+	base := points[0].Value
+	for _, p := range points {
+		if p.Time.After(cutoff) {
+			break
+		}
+		base = p.Value
+	}
+	return points[len(points)-1].Value - base
+}
+
+// windowDrift returns the relative change of the mean over the last ShortWindow against the
+// mean over the rest of LongWindow, or zero without points in both.
+func windowDrift(points []ebpf.Point, now time.Time) float64 {
+	recent, baseline := 0.0, 0.0
+	nr, nb := 0, 0
+	for _, p := range points {
+		switch {
+		case p.Time.Before(now.Add(-LongWindow)):
+		case p.Time.Before(now.Add(-ShortWindow)):
+			baseline += p.Value
+			nb++
+		default:
+			recent += p.Value
+			nr++
+		}
+	}
+	if nr == 0 || nb == 0 || baseline == 0 {
+		return 0
+	}
+	return (recent/float64(nr))/(baseline/float64(nb)) - 1
+}
@@ -0,0 +1,327 @@
+package prediction
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Model kinds.
+const (
+	KindLogistic = "logistic_regression"
+)
+
+// ModelInfo describes a trained model.
+type ModelInfo struct {
+	Kind         string          `json:"kind"`
+	Version      string          `json:"version"`
+	DeviceType   enum.DeviceType `json:"device_type"`
+	TrainedAt    time.Time       `json:"trained_at"`
+	Horizon      time.Duration   `json:"horizon"`                 // Failures the model was trained to anticipate lie this far ahead
+	Features     []string        `json:"features"`                // Inputs in the order the model uses them
+	Evaluation   *Evaluation     `json:"evaluation,omitempty"`    // Holdout quality measured at training time
+	WeibullShape float64         `json:"weibull_shape,omitempty"` // Survival curve shape beyond the horizon; see Survival
+	DataFrom     time.Time       `json:"data_from"`               // Earliest training sample
+	DataTo       time.Time       `json:"data_to"`                 // Latest training sample
+	// Distributions summarise the training features, so drift in live inputs can be detected.
+	Distributions []FeatureDistribution `json:"distributions,omitempty"`
+}
+
+// Model scores a device's features with its probability of failing. Inference is
+// deterministic: the same features always yield the same probability.
+type Model interface {
+	Info() ModelInfo
+	Predict(features Features) float64
+}
+
+// LogisticModel is a logistic regression over standardised features.
+type LogisticModel struct {
+	Meta    ModelInfo `json:"info"`
+	Means   []float64 `json:"means"`
+	Scales  []float64 `json:"scales"`
+	Weights []float64 `json:"weights"`
+	Bias    float64   `json:"bias"`
+}
+
+// Info returns the model's metadata.
+func (m *LogisticModel) Info() ModelInfo {
+	return m.Meta
+}
+
+// Predict returns the failure probability for the features; missing features count as zero.
+func (m *LogisticModel) Predict(features Features) float64 {
+	return sigmoid(m.logit(m.vector(features)))
+}
+
+// vector standardises the model's features in order.
+func (m *LogisticModel) vector(features Features) []float64 {
+	x := make([]float64, len(m.Meta.Features))
+	for i, name := range m.Meta.Features {
+		x[i] = (features[name] - m.Means[i]) / m.Scales[i]
+	}
+	return x
+}
+
+func (m *LogisticModel) logit(x []float64) float64 {
+	z := m.Bias
+	for i, v := range x {
+		z += m.Weights[i] * v
+	}
+	return z
+}
+
+// validate checks that the model's parameters are consistent.
+func (m *LogisticModel) validate() error {
+	n := len(m.Meta.Features)
+	if len(m.Means) != n || len(m.Scales) != n || len(m.Weights) != n {
+		return errors.New(fmt.Sprintf("logistic model has %d features but %d means, %d scales and %d weights",
+			n, len(m.Means), len(m.Scales), len(m.Weights)), nil)
+	}
+	for i, s := range m.Scales {
+		if s <= 0 || math.IsNaN(s) || math.IsInf(s, 0) {
+			return errors.New("logistic model scale for "+m.Meta.Features[i]+" must be positive", nil)
+		}
+	}
+	return nil
+}
+
+// Example is one labelled training observation.
+type Example struct {
+	Features Features
+	Failed   bool    // Whether the device failed within the prediction horizon
+	Weight   float64 // Relative weight in the training loss; zero counts as one
+}
+
+// TrainConfig defines the training parameters.
+type TrainConfig struct {
+	Iterations   int            // Full-batch gradient descent steps
+	LearningRate float64        // Step size
+	L2           float64        // Weight decay
+	Initial      *LogisticModel // Model to continue training from, e.g., one bootstrapped on public data
+}
+
+// DefaultTrainConfig returns the default training parameters.
+func DefaultTrainConfig() TrainConfig {
+	return TrainConfig{
+		Iterations:   500,
+		LearningRate: 0.5,
+		L2:           0.01,
+	}
+}
+
+// TrainLogistic fits a logistic regression to the weighted examples with full-batch gradient
+// descent. Training is deterministic; the version is derived from the fitted parameters.
+func TrainLogistic(deviceType enum.DeviceType, examples []Example, config TrainConfig) (*LogisticModel, error) {
+	if len(examples) == 0 {
+		return nil, errors.New("no training examples", nil)
+	}
+	if config.Iterations <= 0 || config.LearningRate <= 0 || config.L2 < 0 {
+		return nil, errors.New("invalid training configuration", nil)
+	}
+
+	weights := make([]float64, len(examples))
+	n := 0.0
+	for j, e := range examples {
+		weights[j] = e.Weight
+		if weights[j] <= 0 {
+			weights[j] = 1
+		}
+		n += weights[j]
+	}
+	var model *LogisticModel
+	if config.Initial != nil {
+		model = config.Initial.warmStart(deviceType)
+	} else {
+		model = newLogisticModel(deviceType, examples, weights, n)
+	}
+
+	xs := make([][]float64, len(examples))
+	for j, e := range examples {
+		xs[j] = model.vector(e.Features)
+	}
+	grad := make([]float64, len(model.Weights))
+	for iter := 0; iter < config.Iterations; iter++ {
+		for i := range grad {
+			grad[i] = config.L2 * model.Weights[i]
+		}
+		gradBias := 0.0
+		for j, e := range examples {
+			residual := sigmoid(model.logit(xs[j]))
+			if e.Failed {
+				residual--
+			}
+			residual *= weights[j] / n
+			for i, v := range xs[j] {
+				grad[i] += residual * v
+			}
+			gradBias += residual
+		}
+		for i := range model.Weights {
+			model.Weights[i] -= config.LearningRate * grad[i]
+		}
+		model.Bias -= config.LearningRate * gradBias
+	}
+
+	model.Meta.Distributions = NewFeatureDistributions(model.Meta.Features, examples, distributionBins)
+	model.stampVersion()
+	return model, nil
+}
+
+// newLogisticModel creates an untrained model that standardises the examples' features.
+func newLogisticModel(deviceType enum.DeviceType, examples []Example, weights []float64, n float64) *LogisticModel {
+	seen := make(map[string]bool)
+	for _, e := range examples {
+		for name := range e.Features {
+			seen[name] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	model := &LogisticModel{
+		Meta: ModelInfo{
+			Kind:       KindLogistic,
+			DeviceType: deviceType,
+			TrainedAt:  time.Now().UTC(),
+			Features:   names,
+		},
+		Means:   make([]float64, len(names)),
+		Scales:  make([]float64, len(names)),
+		Weights: make([]float64, len(names)),
+	}
+	for i, name := range names {
+		for j, e := range examples {
+			model.Means[i] += weights[j] * e.Features[name]
+		}
+		model.Means[i] /= n
+		for j, e := range examples {
+			d := e.Features[name] - model.Means[i]
+			model.Scales[i] += weights[j] * d * d
+		}
+		model.Scales[i] = math.Sqrt(model.Scales[i] / n)
+		if model.Scales[i] == 0 {
+			model.Scales[i] = 1
+		}
+	}
+	return model
+}
+
+// warmStart copies the model to continue training it. The standardisation is kept so the
+// copied weights remain meaningful.
+func (m *LogisticModel) warmStart(deviceType enum.DeviceType) *LogisticModel {
+	meta := m.Meta
+	meta.DeviceType = deviceType
+	meta.TrainedAt = time.Now().UTC()
+	meta.Evaluation = nil
+	meta.Features = append([]string(nil), m.Meta.Features...)
+	return &LogisticModel{
+		Meta:    meta,
+		Means:   append([]float64(nil), m.Means...),
+		Scales:  append([]float64(nil), m.Scales...),
+		Weights: append([]float64(nil), m.Weights...),
+		Bias:    m.Bias,
+	}
+}
+
+// stampVersion derives the model version from its parameters.
+func (m *LogisticModel) stampVersion() {
+	m.Meta.Version = parameterVersion("lr", m.Means, m.Scales, m.Weights, []float64{m.Bias, m.Meta.WeibullShape})
+}
+
+// parameterVersion derives a stable version string from model parameters.
+func parameterVersion(prefix string, params ...[]float64) string {
+	h := fnv.New32a()
+	for _, p := range params {
+		for _, v := range p {
+			fmt.Fprintf(h, "%x;", math.Float64bits(v))
+		}
+	}
+	return fmt.Sprintf("%s-%08x", prefix, h.Sum32())
+}
+
+func sigmoid(z float64) float64 {
+	return 1 / (1 + math.Exp(-z))
+}
+
+// modelFile is the on-disk form of a model.
+type modelFile struct {
+	Kind  string          `json:"kind"`
+	Model json.RawMessage `json:"model"`
+}
+
+// modelDecoders decode each model kind from its serialised form.
+var modelDecoders = map[string]func(data []byte) (Model, error){
+	KindLogistic: func(data []byte) (Model, error) {
+		var m LogisticModel
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		if err := m.validate(); err != nil {
+			return nil, err
+		}
+		return &m, nil
+	},
+}
+
+// SaveModel writes a model to path, replacing any existing file atomically.
+func SaveModel(path string, model Model) error {
+	data, err := json.Marshal(model)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal model")
+	}
+	data, err = json.MarshalIndent(modelFile{Kind: model.Info().Kind, Model: data}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal model")
+	}
+	return writeFileAtomic(path, data, "model")
+}
+
+// writeFileAtomic writes data to path through a temporary file so readers never see a
+// partial write.
+func writeFileAtomic(path string, data []byte, what string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.NewStorageFailure("failed to create "+what+" directory", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.NewStorageFailure("failed to write "+what+" file", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.NewStorageFailure("failed to replace "+what+" file", err)
+	}
+	return nil
+}
+
+// LoadModel reads a model written by SaveModel.
+func LoadModel(path string) (Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.NewNotFound("model file "+path+" not found", err)
+		}
+		return nil, errors.NewStorageFailure("failed to read model file", err)
+	}
+	var file modelFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, errors.New("failed to parse model file "+path, err)
+	}
+	decode, ok := modelDecoders[file.Kind]
+	if !ok {
+		return nil, errors.New("unknown model kind "+file.Kind, nil)
+	}
+	model, err := decode(file.Model)
+	if err != nil {
+		return nil, errors.New("failed to decode model file "+path, err)
+	}
+	return model, nil
+}
@@ -1,10 +1,14 @@
 package prediction
 
 import (
-	"time"
 	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
 	"github.com/turtacn/ioshelfer/internal/common/types/enum"
 	"github.com/turtacn/ioshelfer/internal/infra/storage"
+	"go.uber.org/zap"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
 // Predictor defines the interface for failure prediction.
@@ -14,70 +18,282 @@ type Predictor interface {
 
 // Config defines the configuration for the prediction engine.
 type Config struct {
-	HistoryWindow     time.Duration // Time window for historical data
-	FeatureDimensions int           // Number of feature dimensions for the model
+	HistoryWindow time.Duration // Time window for historical data
+	ModelDir      string        // Directory holding one <device type>.json model file per device type
+	HorizonDays   []int         // Horizons failure probabilities are reported for; 7, 30 and 90 days when empty
+	TopFactors    int           // Contributing factors reported per prediction; 3 when zero
+	Drift         DriftConfig   // When live inputs count as drifted from a model's training data
 }
 
 // PredictionResult represents the result of a failure prediction.
 type PredictionResult struct {
-	DeviceType        enum.DeviceType
-	DeviceID          string
+	DeviceType           enum.DeviceType
+	DeviceID             string
+	FailureProbability   float64 // Probability of failing within the model horizon
+	Horizons             []HorizonProbability
+	RiskLevel            RiskLevel
+	RemainingLife        time.Duration // Median time to failure, capped at MaxRemainingLife
+	RemainingLifeLow     time.Duration // 10th percentile
+	RemainingLifeHigh    time.Duration // 90th percentile
+	PredictedFailureTime time.Time     // Zero when the median time to failure exceeds MaxRemainingLife
+	Survival             Survival
+	Attributions         []Attribution // Every feature's contribution, largest effect first; nil if the model cannot explain itself
+	ContributingFactors  []string      // Human-readable features that raised the prediction most
+	Recommendations      []string      // Actions for the risk level and the contributing factors
+	Confidence           float64       // Share of the history window covered by stored data, lowered when inputs drifted
+	Drift                DriftReport   // Drift of the model's live inputs from its training data
+	ModelVersion         string
+	Shadow               []ShadowScore // Scores of the device type's shadow models
+	Features             Features
+}
+
+// ShadowScore is a shadow model's score for a device.
+type ShadowScore struct {
+	Version            string
 	FailureProbability float64
-	PredictedFailureTime time.Time
-	Confidence         float64
 }
 
-// LSTMPredictor implements Predictor using an LSTM model.
-type LSTMPredictor struct {
-	config  *Config
-	storage *storage.Storage
-	model   *LSTMModel // Placeholder for LSTM model implementation
+// defaultHorizonDays are the horizons reported when none are configured.
+var defaultHorizonDays = []int{7, 30, 90}
+
+// ModelPredictor implements Predictor by scoring features extracted from stored history
+// with a trained model per device type. With a model directory, models come from the
+// registry in it: the active model serves predictions and shadow models are scored next to
+// it, their scores only logged. With a quality tracker, every prediction is recorded so it
+// can be reconciled against the device's fate.
+type ModelPredictor struct {
+	config   *Config
+	storage  storage.Storage
+	registry *Registry
+	tracker  *QualityTracker
+	drift    *driftMonitor
+	mu       sync.RWMutex
+	models   map[enum.DeviceType]Model // Set with SetModel; take precedence over the registry
 }
 
-// NewLSTMPredictor creates a new LSTMPredictor instance.
-func NewLSTMPredictor(config *Config, storage *storage.Storage) *LSTMPredictor {
-	return &LSTMPredictor{
+// NewModelPredictor creates a new ModelPredictor, opening the registry in the model
+// directory. Device types without an active model have none until one is promoted or
+// SetModel is called.
+func NewModelPredictor(config *Config, storage storage.Storage) (*ModelPredictor, error) {
+	p := &ModelPredictor{
 		config:  config,
 		storage: storage,
-		model:   newLSTMModel(config.FeatureDimensions), // Placeholder
+		models:  make(map[enum.DeviceType]Model),
+		drift:   newDriftMonitor(config.Drift),
+	}
+	if config.ModelDir == "" {
+		return p, nil
+	}
+	registry, err := NewRegistry(config.ModelDir)
+	if err != nil {
+		return nil, err
+	}
+	p.registry = registry
+	return p, nil
+}
+
+// ModelPath returns the file a device type's model is stored in.
+func ModelPath(dir string, deviceType enum.DeviceType) string {
+	return filepath.Join(dir, deviceType.String()+".json")
+}
+
+// SetModel replaces the model used for a device type.
+func (p *ModelPredictor) SetModel(deviceType enum.DeviceType, model Model) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.models[deviceType] = model
+	info := model.Info()
+	logger.Info("prediction model loaded",
+		zap.String("device_type", deviceType.String()),
+		zap.String("kind", info.Kind),
+		zap.String("version", info.Version),
+	)
+}
+
+// Model returns the model used for a device type.
+func (p *ModelPredictor) Model(deviceType enum.DeviceType) (Model, bool) {
+	p.mu.RLock()
+	model, ok := p.models[deviceType]
+	p.mu.RUnlock()
+	if ok || p.registry == nil {
+		return model, ok
 	}
+	return p.registry.Active(deviceType)
+}
+
+// Registry returns the model registry, or nil without a model directory.
+func (p *ModelPredictor) Registry() *Registry {
+	return p.registry
+}
+
+// SetTracker sets the tracker predictions are recorded with.
+func (p *ModelPredictor) SetTracker(tracker *QualityTracker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tracker = tracker
+}
+
+// Tracker returns the tracker predictions are recorded with, or nil if they are not.
+func (p *ModelPredictor) Tracker() *QualityTracker {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.tracker
+}
+
+// ListModels returns the registered models.
+func (p *ModelPredictor) ListModels() ([]ModelRecord, error) {
+	if p.registry == nil {
+		return nil, errors.NewNotFound("no model directory configured", nil)
+	}
+	return p.registry.List()
 }
 
 // PredictFailureProbability predicts the failure probability for a device.
-func (p *LSTMPredictor) PredictFailureProbability(deviceType enum.DeviceType, deviceID string) (float64, error) {
-	// Fetch historical data
-	data, err := p.storage.Query(deviceType, deviceID, p.config.HistoryWindow)
+func (p *ModelPredictor) PredictFailureProbability(deviceType enum.DeviceType, deviceID string) (float64, error) {
+	result, err := p.Predict(deviceType, deviceID)
 	if err != nil {
-		return 0, errors.NewStorageFailure("failed to query historical data", err)
+		return 0, err
 	}
+	return result.FailureProbability, nil
+}
 
-	// Extract features (placeholder for feature engineering)
-	features := p.extractFeatures(data)
+// Predict scores a device's stored history with the device type's model and derives its
+// survival curve: failure probabilities per horizon, remaining useful life and risk level.
+func (p *ModelPredictor) Predict(deviceType enum.DeviceType, deviceID string) (PredictionResult, error) {
+	model, ok := p.Model(deviceType)
+	if !ok {
+		return PredictionResult{}, errors.NewNotFound("no prediction model for device type "+deviceType.String(), nil)
+	}
+	result, err := p.predict(model, deviceType, deviceID)
+	if err != nil {
+		return result, err
+	}
 
-	// Run LSTM model prediction (placeholder)
-	probability := p.model.Predict(features)
-	if probability < 0 || probability > 1 {
-		return 0, errors.New("invalid prediction probability", nil)
+	var shadows []Model
+	if p.registry != nil {
+		shadows = p.registry.Shadows(deviceType)
+	}
+	for _, shadow := range shadows {
+		if shadow.Info().Version == result.ModelVersion {
+			continue
+		}
+		probability := shadow.Predict(result.Features)
+		result.Shadow = append(result.Shadow, ShadowScore{Version: shadow.Info().Version, FailureProbability: probability})
+		logger.Info("shadow prediction",
+			zap.String("device_type", deviceType.String()),
+			zap.String("device_id", deviceID),
+			zap.String("active_version", result.ModelVersion),
+			zap.Float64("active_probability", result.FailureProbability),
+			zap.String("shadow_version", shadow.Info().Version),
+			zap.Float64("shadow_probability", probability),
+		)
 	}
 
-	return probability, nil
+	if tracker := p.Tracker(); tracker != nil {
+		if err := tracker.Record(result, time.Now()); err != nil {
+			logger.Warn("failed to record prediction",
+				zap.String("device_type", deviceType.String()),
+				zap.String("device_id", deviceID),
+				zap.Error(err))
+		}
+	}
+	return result, nil
 }
 
-// Placeholder for LSTM model (to be implemented with a real ML library like Gorgonia or TensorFlow Go bindings)
-type LSTMModel struct {
-	dimensions int
+// PredictVersion predicts with a specific registered model version instead of the
+// active one.
+func (p *ModelPredictor) PredictVersion(deviceType enum.DeviceType, deviceID, version string) (PredictionResult, error) {
+	if p.registry == nil {
+		return PredictionResult{}, errors.NewNotFound("no model directory configured", nil)
+	}
+	model, err := p.registry.Load(deviceType, version)
+	if err != nil {
+		return PredictionResult{}, err
+	}
+	return p.predict(model, deviceType, deviceID)
 }
 
-func newLSTMModel(dimensions int) *LSTMModel {
-	return &LSTMModel{dimensions: dimensions}
-}
+// predict scores a device with a model.
+func (p *ModelPredictor) predict(model Model, deviceType enum.DeviceType, deviceID string) (PredictionResult, error) {
+	data, err := p.storage.Query(deviceType, deviceID, p.config.HistoryWindow)
+	if err != nil {
+		return PredictionResult{}, errors.NewStorageFailure("failed to query historical data", err)
+	}
+	if len(data) == 0 {
+		return PredictionResult{}, errors.NewNotFound("no history for device "+deviceID, nil)
+	}
 
-func (m *LSTMModel) Predict(features []float64) float64 {
-	// Placeholder: Simulate LSTM prediction
-	return 0.75 // Mock value for partial implementation
-}
+	now := time.Now()
+	features, err := ExtractFeatures(deviceType, data, now)
+	if err != nil {
+		return PredictionResult{}, err
+	}
+	probability := model.Predict(features)
+	if probability < 0 || probability > 1 {
+		return PredictionResult{}, errors.New("invalid prediction probability", nil)
+	}
+
+	survival := NewSurvival(model.Info(), probability)
+	result := PredictionResult{
+		DeviceType:         deviceType,
+		DeviceID:           deviceID,
+		FailureProbability: probability,
+		RiskLevel:          RiskFor(survival.FailureWithin(RiskHorizon)),
+		Survival:           survival,
+		ModelVersion:       model.Info().Version,
+		Features:           features,
+	}
+	days := p.config.HorizonDays
+	if len(days) == 0 {
+		days = defaultHorizonDays
+	}
+	for _, d := range days {
+		result.Horizons = append(result.Horizons, HorizonProbability{
+			Days:        d,
+			Probability: survival.FailureWithin(time.Duration(d) * 24 * time.Hour),
+		})
+	}
+	var bounded bool
+	if result.RemainingLife, bounded = survival.Quantile(0.5); bounded {
+		result.PredictedFailureTime = now.Add(result.RemainingLife)
+	}
+	result.RemainingLifeLow, _ = survival.Quantile(0.1)
+	result.RemainingLifeHigh, _ = survival.Quantile(0.9)
+
+	if explainer, ok := model.(Explainer); ok {
+		result.Attributions = explainer.Attribute(features)
+	}
+	top := p.config.TopFactors
+	if top <= 0 {
+		top = 3
+	}
+	result.ContributingFactors, result.Recommendations = explain(result.Attributions, result.RiskLevel, top)
+
+	result.Confidence = 1.0
+	if p.config.HistoryWindow > 0 {
+		result.Confidence = features["history_days"] * 24 * float64(time.Hour) / float64(p.config.HistoryWindow)
+		if result.Confidence > 1 {
+			result.Confidence = 1
+		}
+	}
 
-func (p *LSTMPredictor) extractFeatures(data []storage.Metric) []float64 {
-	// Placeholder: Implement feature extraction logic
-	return []float64{0.1, 0.2, 0.3} // Mock features
-}
\ No newline at end of file
+	var changed bool
+	if result.Drift, changed = p.drift.observe(model, deviceID, features); result.Drift.Drifted() {
+		// At the threshold, confidence halves; it falls further as the drift grows.
+		result.Confidence /= 1 + result.Drift.Severity
+	}
+	if changed {
+		fields := []zap.Field{
+			zap.String("device_type", deviceType.String()),
+			zap.String("version", result.ModelVersion),
+			zap.Int("devices", result.Drift.Samples),
+		}
+		if result.Drift.Drifted() {
+			logger.Warn("prediction feature drift detected", append(fields,
+				zap.Float64("severity", result.Drift.Severity), zap.Error(result.Drift.Err()))...)
+		} else {
+			logger.Info("prediction feature drift cleared", fields...)
+		}
+	}
+	return result, nil
+}
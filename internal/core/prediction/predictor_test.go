@@ -1 +1,866 @@
 package prediction
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+	"github.com/turtacn/ioshelfer/pkg/disk"
+)
+
+// diskHistory returns daily SMART readings whose reallocated sector count grows by growth
+// per day, interleaved with eBPF performance samples.
+func diskHistory(id string, now time.Time, days int, growth int) []storage.Metric {
+	var history []storage.Metric
+	for d := days; d >= 0; d-- {
+		at := now.Add(-time.Duration(d) * 24 * time.Hour)
+		history = append(history, storage.Metric{
+			Timestamp:  at,
+			DeviceType: enum.Disk,
+			DeviceID:   id,
+			Value: &disk.SMARTData{
+				DeviceID:           id,
+				ReallocatedSectors: (days - d) * growth,
+				Temperature:        40,
+				PowerOnHours:       int64(10000 + 24*(days-d)),
+			},
+		}, storage.Metric{
+			Timestamp:  at.Add(time.Minute),
+			DeviceType: enum.Disk,
+			DeviceID:   id,
+			Value:      &ebpf.DiskMetrics{DeviceID: id, AvgLatency: 5 * time.Millisecond, IOPS: 100},
+		})
+	}
+	return history
+}
+
+// trainingSet returns examples where disks with growing reallocated sector counts fail.
+func trainingSet(now time.Time) []Example {
+	var examples []Example
+	for i := 0; i < 20; i++ {
+		growth := i % 5
+		features, _ := ExtractFeatures(enum.Disk, diskHistory("sda", now, 10, growth), now)
+		examples = append(examples, Example{Features: features, Failed: growth >= 3})
+	}
+	return examples
+}
+
+func TestExtractFeatures(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	features, err := ExtractFeatures(enum.Disk, diskHistory("sda", now, 10, 2), now.Add(2*time.Minute))
+	require.NoError(t, err)
+
+	assert.Equal(t, 20.0, features["reallocated_sectors.last"])
+	assert.Equal(t, 2.0, features["reallocated_sectors.delta_24h"])
+	assert.Equal(t, 14.0, features["reallocated_sectors.delta_7d"])
+	assert.InDelta(t, 2.0, features["reallocated_sectors.rate_per_day"], 1e-9)
+	assert.Equal(t, 20.0, features["reallocated_sectors.max_7d"])
+	assert.Equal(t, 40.0, features["temperature_celsius.last"])
+	assert.Equal(t, 10240.0, features["power_on_hours.last"])
+	assert.Equal(t, 0.005, features["avg_latency_seconds.last"])
+	assert.Equal(t, 11.0, features["samples"])
+	assert.InDelta(t, 10, features["history_days"], 0.01)
+	assert.Len(t, features, len(FeatureNames(enum.Disk)))
+
+	// Readings after the extraction time are ignored.
+	early, err := ExtractFeatures(enum.Disk, diskHistory("sda", now, 10, 2), now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 18.0, early["reallocated_sectors.last"])
+
+	// eBPF records carrying SMART data count as readings; those without it do not.
+	history := append(diskHistory("sda", now, 10, 2), storage.Metric{
+		Timestamp:  now.Add(3 * time.Minute),
+		DeviceType: enum.Disk,
+		DeviceID:   "sda",
+		Value:      &ebpf.DiskMetrics{DeviceID: "sda", SMART: ebpf.SMARTData{ReallocatedSectors: 25, Temperature: 45}},
+	})
+	live, err := ExtractFeatures(enum.Disk, history, now.Add(4*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 25.0, live["reallocated_sectors.last"])
+	assert.Equal(t, 45.0, live["temperature_celsius.last"])
+
+	_, err = ExtractFeatures(enum.DeviceType(9), nil, now)
+	assert.Error(t, err)
+}
+
+// raidHistory returns hourly controller samples over days days, with latency doubling over
+// the last day and the firmware changing halfway through.
+func raidHistory(id string, now time.Time, days int, retries int) []storage.Metric {
+	var history []storage.Metric
+	for h := days * 24; h >= 0; h-- {
+		latency := 2 * time.Millisecond
+		if h < 24 {
+			latency = 4 * time.Millisecond
+		}
+		firmware := "25.5.8"
+		if h < days*12 {
+			firmware = "25.5.9"
+		}
+		history = append(history, storage.Metric{
+			Timestamp:  now.Add(-time.Duration(h) * time.Hour),
+			DeviceType: enum.RAID,
+			DeviceID:   id,
+			Value:      &ebpf.RAIDMetrics{DeviceID: id, QueueDepth: 8, AvgLatency: latency, ErrorRetryRate: retries, Firmware: firmware},
+		})
+	}
+	return history
+}
+
+func TestExtractFeaturesRAIDAndNetwork(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	features, err := ExtractFeatures(enum.RAID, raidHistory("host0", now, 7, 3), now)
+	require.NoError(t, err)
+	assert.Len(t, features, len(FeatureNames(enum.RAID)))
+	assert.InDelta(t, 0.96, features["avg_latency_seconds.drift_7d"], 1e-9)
+	assert.Equal(t, 1.0, features["firmware_changes.last"])
+	assert.Equal(t, 1.0, features["firmware_changes.delta_7d"])
+	assert.Equal(t, 3.0, features["error_retry_rate.mean_24h"])
+
+	var history []storage.Metric
+	for h := 48; h >= 0; h-- {
+		m := &ebpf.NetworkMetrics{DeviceID: "eth0", LatencyP95: time.Millisecond}
+		if h < 6 {
+			m.CarrierChangeRate, m.ErrorRate = 2, 30
+		}
+		history = append(history, storage.Metric{Timestamp: now.Add(-time.Duration(h) * time.Hour), DeviceType: enum.Network, DeviceID: "eth0", Value: m})
+	}
+	features, err = ExtractFeatures(enum.Network, history, now)
+	require.NoError(t, err)
+	assert.Len(t, features, len(FeatureNames(enum.Network)))
+	assert.Equal(t, 2.0, features["carrier_change_rate.max_7d"])
+	assert.Equal(t, 30.0, features["interface_error_rate.delta_24h"])
+	assert.InDelta(t, 0, features["latency_p95_seconds.drift_7d"], 1e-9)
+	assert.Contains(t, DescribeFeature("avg_latency_seconds.drift_7d", 1), "+100% against its 7-day baseline")
+}
+
+func TestModelPredictorRAID(t *testing.T) {
+	now := time.Now()
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, store.StoreBatch(raidHistory("host0", now.Add(-time.Minute), 7, 40)))
+
+	var examples []Example
+	for i := 0; i < 10; i++ {
+		retries := i * 5
+		features, err := ExtractFeatures(enum.RAID, raidHistory("host", now, 7, retries), now)
+		require.NoError(t, err)
+		examples = append(examples, Example{Features: features, Failed: retries >= 25})
+	}
+	model, err := TrainLogistic(enum.RAID, examples, DefaultTrainConfig())
+	require.NoError(t, err)
+
+	p, err := NewModelPredictor(&Config{HistoryWindow: 7 * 24 * time.Hour}, store)
+	require.NoError(t, err)
+	p.SetModel(enum.RAID, model)
+	result, err := p.Predict(enum.RAID, "host0")
+	require.NoError(t, err)
+	assert.Equal(t, enum.RAID, result.DeviceType)
+	assert.Greater(t, result.FailureProbability, 0.5)
+	assert.Len(t, result.Horizons, 3)
+	require.NotEmpty(t, result.ContributingFactors)
+	assert.Contains(t, result.ContributingFactors[0], "error retries per hour")
+	assert.Contains(t, result.Recommendations, metricDescriptions[ebpf.MetricErrorRetryRate].recommendation)
+}
+
+func TestTrainLogistic(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	examples := trainingSet(now)
+
+	model, err := TrainLogistic(enum.Disk, examples, DefaultTrainConfig())
+	require.NoError(t, err)
+	assert.Equal(t, KindLogistic, model.Info().Kind)
+	assert.Equal(t, enum.Disk, model.Info().DeviceType)
+
+	healthy, _ := ExtractFeatures(enum.Disk, diskHistory("sdb", now, 10, 0), now)
+	failing, _ := ExtractFeatures(enum.Disk, diskHistory("sdc", now, 10, 4), now)
+	assert.Less(t, model.Predict(healthy), 0.2)
+	assert.Greater(t, model.Predict(failing), 0.8)
+
+	again, err := TrainLogistic(enum.Disk, examples, DefaultTrainConfig())
+	require.NoError(t, err)
+	assert.Equal(t, model.Info().Version, again.Info().Version)
+	assert.Equal(t, model.Predict(failing), again.Predict(failing))
+
+	_, err = TrainLogistic(enum.Disk, nil, DefaultTrainConfig())
+	assert.Error(t, err)
+}
+
+func TestSaveLoadModel(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	model, err := TrainLogistic(enum.Disk, trainingSet(now), DefaultTrainConfig())
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "models", "disk.json")
+	require.NoError(t, SaveModel(path, model))
+	loaded, err := LoadModel(path)
+	require.NoError(t, err)
+	assert.Equal(t, model.Info().Version, loaded.Info().Version)
+	assert.Equal(t, model.Info().Features, loaded.Info().Features)
+
+	features, _ := ExtractFeatures(enum.Disk, diskHistory("sda", now, 10, 3), now)
+	assert.Equal(t, model.Predict(features), loaded.Predict(features))
+
+	_, err = LoadModel(filepath.Join(t.TempDir(), "missing.json"))
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+}
+
+func TestModelPredictor(t *testing.T) {
+	now := time.Now()
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	for _, m := range diskHistory("sda", now.Add(-time.Hour), 10, 4) {
+		require.NoError(t, store.Store(m))
+	}
+
+	dir := t.TempDir()
+	model, err := TrainLogistic(enum.Disk, trainingSet(now), DefaultTrainConfig())
+	require.NoError(t, err)
+	require.NoError(t, SaveModel(ModelPath(dir, enum.Disk), model))
+
+	p, err := NewModelPredictor(&Config{HistoryWindow: 30 * 24 * time.Hour, ModelDir: dir}, store)
+	require.NoError(t, err)
+
+	result, err := p.Predict(enum.Disk, "sda")
+	require.NoError(t, err)
+	assert.Greater(t, result.FailureProbability, 0.8)
+	assert.Equal(t, model.Info().Version, result.ModelVersion)
+	assert.InDelta(t, 1.0/3, result.Confidence, 0.01)
+
+	require.Len(t, result.Horizons, 3)
+	assert.Equal(t, 7, result.Horizons[0].Days)
+	assert.Equal(t, 90, result.Horizons[2].Days)
+	assert.Less(t, result.Horizons[0].Probability, result.Horizons[1].Probability)
+	assert.Less(t, result.Horizons[1].Probability, result.Horizons[2].Probability)
+	assert.Equal(t, RiskCritical, result.RiskLevel)
+	assert.Less(t, result.RemainingLifeLow, result.RemainingLife)
+	assert.Less(t, result.RemainingLife, result.RemainingLifeHigh)
+	assert.WithinDuration(t, time.Now().Add(result.RemainingLife), result.PredictedFailureTime, time.Minute)
+
+	require.NotEmpty(t, result.Attributions)
+	assert.Equal(t, "reallocated_sectors", strings.SplitN(result.Attributions[0].Feature, ".", 2)[0])
+	require.NotEmpty(t, result.ContributingFactors)
+	assert.Contains(t, result.ContributingFactors[0], "reallocated sectors (SMART 5)")
+	require.GreaterOrEqual(t, len(result.Recommendations), 2)
+	assert.Equal(t, riskRecommendations[RiskCritical], result.Recommendations[0])
+	assert.Contains(t, result.Recommendations[1], "replacement")
+
+	probability, err := p.PredictFailureProbability(enum.Disk, "sda")
+	require.NoError(t, err)
+	assert.Equal(t, result.FailureProbability, probability)
+
+	_, err = p.Predict(enum.Disk, "sdz")
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+	_, err = p.Predict(enum.RAID, "host0")
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+}
+
+func TestFeatureDistributions(t *testing.T) {
+	var examples []Example
+	for i := 0; i < 100; i++ {
+		z := 0.0
+		if i >= 90 {
+			z = 1
+		}
+		examples = append(examples, Example{Features: Features{"a": float64(i), "z": z}})
+	}
+	distributions := NewFeatureDistributions([]string{"a", "z"}, examples, 10)
+	require.Len(t, distributions, 2)
+	a, z := distributions[0], distributions[1]
+	assert.Equal(t, []float64{10, 20, 30, 40, 50, 60, 70, 80, 90}, a.Edges)
+	assert.InDelta(t, 0.11, a.Proportions[0], 1e-9)
+	// Tied quantiles merge, leaving zero a bin of its own.
+	assert.Equal(t, []float64{0, 1}, z.Edges)
+	assert.Equal(t, []float64{0.9, 0.1, 0}, z.Proportions)
+
+	same := make([]float64, 100)
+	shifted := make([]float64, 100)
+	for i := range same {
+		same[i] = float64(i)
+		shifted[i] = float64(i + 50)
+	}
+	assert.InDelta(t, 0, a.PSI(same), 1e-9)
+	assert.InDelta(t, 0, a.KS(same), 1e-9)
+	assert.Greater(t, a.PSI(shifted), 0.25)
+	assert.InDelta(t, 0.5, a.KS(shifted), 0.02)
+	assert.Greater(t, z.PSI([]float64{2, 2, 2}), 0.25)
+}
+
+func TestModelPredictorDrift(t *testing.T) {
+	now := time.Now()
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	// Sector counts grow far faster than in any training example.
+	for _, id := range []string{"sda", "sdb", "sdc"} {
+		for _, m := range diskHistory(id, now.Add(-time.Hour), 10, 50) {
+			require.NoError(t, store.Store(m))
+		}
+	}
+	model, err := TrainLogistic(enum.Disk, trainingSet(now), DefaultTrainConfig())
+	require.NoError(t, err)
+	require.Len(t, model.Info().Distributions, len(model.Info().Features))
+
+	p, err := NewModelPredictor(&Config{HistoryWindow: 30 * 24 * time.Hour, Drift: DriftConfig{MinSamples: 3}}, store)
+	require.NoError(t, err)
+	p.SetModel(enum.Disk, model)
+
+	for _, id := range []string{"sda", "sdb"} {
+		result, err := p.Predict(enum.Disk, id)
+		require.NoError(t, err)
+		assert.False(t, result.Drift.Drifted())
+		assert.NoError(t, result.Drift.Err())
+		assert.InDelta(t, 1.0/3, result.Confidence, 0.01)
+	}
+	result, err := p.Predict(enum.Disk, "sdc")
+	require.NoError(t, err)
+	require.True(t, result.Drift.Drifted())
+	assert.Equal(t, 3, result.Drift.Samples)
+	assert.Greater(t, result.Drift.Severity, 1.0)
+	assert.Less(t, result.Confidence, 1.0/6)
+	assert.True(t, errors.Is(result.Drift.Err(), errors.NewFeatureDrift("", nil)))
+	var reallocated bool
+	for _, f := range result.Drift.Features {
+		reallocated = reallocated || strings.HasPrefix(f.Feature, "reallocated_sectors.")
+	}
+	assert.True(t, reallocated)
+}
+
+func TestAttribute(t *testing.T) {
+	model := &LogisticModel{
+		Meta:    ModelInfo{Features: []string{"a.last", "b.last", "c.last"}},
+		Means:   []float64{1, 0, 5},
+		Scales:  []float64{1, 2, 1},
+		Weights: []float64{0.5, -1, 2},
+	}
+	attributions := model.Attribute(Features{"a.last": 3, "b.last": 2, "c.last": 5})
+	assert.Equal(t, []Attribution{
+		{Feature: "a.last", Value: 3, Contribution: 1},
+		{Feature: "b.last", Value: 2, Contribution: -1},
+		{Feature: "c.last", Value: 5, Contribution: 0},
+	}, attributions)
+
+	total := model.Bias
+	for _, a := range attributions {
+		total += a.Contribution
+	}
+	assert.InDelta(t, model.logit(model.vector(Features{"a.last": 3, "b.last": 2, "c.last": 5})), total, 1e-9)
+}
+
+func TestExplain(t *testing.T) {
+	assert.Equal(t, "reallocated sectors (SMART 5) rose by 14 over 7 days", DescribeFeature("reallocated_sectors.delta_7d", 14))
+	assert.Equal(t, "temperature in °C (SMART 194) peaked at 55 over 7 days", DescribeFeature("temperature_celsius.max_7d", 55))
+	assert.Equal(t, "history covers 12 days", DescribeFeature("history_days", 12))
+
+	attributions := []Attribution{
+		{Feature: "reallocated_sectors.delta_7d", Value: 14, Contribution: 2},
+		{Feature: "history_days", Value: 3, Contribution: -1.5},
+		{Feature: "reallocated_sectors.last", Value: 20, Contribution: 1},
+		{Feature: "temperature_celsius.last", Value: 50, Contribution: 0.5},
+		{Feature: "packet_loss_rate.last", Value: 0.1, Contribution: 0.1},
+	}
+	factors, recommendations := explain(attributions, RiskHigh, 3)
+	assert.Equal(t, []string{
+		"reallocated sectors (SMART 5) rose by 14 over 7 days (+2.00 log-odds)",
+		"reallocated sectors (SMART 5) is 20 (+1.00 log-odds)",
+		"temperature in °C (SMART 194) is 50 (+0.50 log-odds)",
+	}, factors)
+	assert.Equal(t, []string{
+		riskRecommendations[RiskHigh],
+		metricDescriptions["reallocated_sectors"].recommendation,
+		metricDescriptions["temperature_celsius"].recommendation,
+	}, recommendations)
+
+	factors, recommendations = explain(attributions, RiskLow, 1)
+	assert.Len(t, factors, 1)
+	assert.Equal(t, []string{riskRecommendations[RiskLow]}, recommendations)
+}
+
+// storeSMART stores daily SMART readings from start for days days, with reallocated sectors
+// growing by three per day from day growthFrom on.
+func storeSMART(t *testing.T, store storage.Storage, id string, start time.Time, days, growthFrom int) {
+	t.Helper()
+	sectors := 0
+	for d := 0; d < days; d++ {
+		if d >= growthFrom {
+			sectors += 3
+		}
+		require.NoError(t, store.Store(storage.Metric{
+			Timestamp:  start.Add(time.Duration(d) * 24 * time.Hour),
+			DeviceType: enum.Disk,
+			DeviceID:   id,
+			Value:      &disk.SMARTData{DeviceID: id, ReallocatedSectors: sectors, Temperature: 40},
+		}))
+	}
+}
+
+func TestReadLabels(t *testing.T) {
+	labels, err := ReadLabels(strings.NewReader(`device_type,device_id,event,timestamp
+# replaced after alerts
+disk,sda,Replacement,2024-05-01T00:00:00Z
+raid, host0, failure, 2024-05-02T12:00:00Z
+`))
+	require.NoError(t, err)
+	require.Len(t, labels, 2)
+	assert.Equal(t, Label{DeviceType: enum.Disk, DeviceID: "sda", Event: EventReplacement, Time: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}, labels[0])
+	assert.Equal(t, enum.RAID, labels[1].DeviceType)
+	assert.Equal(t, "host0", labels[1].DeviceID)
+
+	_, err = ReadLabels(strings.NewReader("disk,sda,exploded,2024-05-01T00:00:00Z\n"))
+	assert.Error(t, err)
+	_, err = ReadLabels(strings.NewReader("disk,sda,failure,yesterday\n"))
+	assert.Error(t, err)
+}
+
+func TestBuildSamplesSplitsAtReplacement(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	start := now.Add(-80 * 24 * time.Hour)
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	storeSMART(t, store, "sda", start, 20, 10)
+	storeSMART(t, store, "sda", start.Add(20*24*time.Hour), 60, 60)
+
+	config := DefaultTrainingConfig(enum.Disk)
+	config.Horizon = 5 * 24 * time.Hour
+	labels := []Label{{DeviceType: enum.Disk, DeviceID: "sda", Event: EventReplacement, Time: start.Add(19*24*time.Hour + time.Hour)}}
+	samples, err := BuildSamples(store, labels, config, now)
+	require.NoError(t, err)
+
+	// The first drive is sampled on days 1-19 and the replacement from day 21 until its
+	// outcome is unknown, five days before now.
+	var first, second []Sample
+	for _, s := range samples {
+		if s.At.Before(labels[0].Time) {
+			first = append(first, s)
+		} else {
+			second = append(second, s)
+		}
+	}
+	require.Len(t, first, 19)
+	assert.False(t, first[13].Failed)
+	assert.True(t, first[14].Failed)
+	assert.True(t, first[18].Failed)
+	require.Len(t, second, 55)
+	for _, s := range second {
+		assert.False(t, s.Failed)
+		assert.Zero(t, s.Features["reallocated_sectors.last"])
+	}
+}
+
+func TestBuildSamplesFromImportedHistory(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	storeSMART(t, store, "ZA1", start, 40, 20)
+	labels := []Label{{DeviceType: enum.Disk, DeviceID: "ZA1", Event: EventFailure, Time: start.Add(40 * 24 * time.Hour)}}
+
+	// Imported history is older than the default history window.
+	config := DefaultTrainingConfig(enum.Disk)
+	samples, err := BuildSamples(store, labels, config, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+
+	config.From = start
+	config.To = start.Add(30 * 24 * time.Hour)
+	samples, err = BuildSamples(store, labels, config, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 30)
+	assert.Equal(t, start.Add(24*time.Hour), samples[0].At.UTC())
+	assert.False(t, samples[8].Failed)
+	assert.True(t, samples[9].Failed, "day 10 is within the horizon of the failure")
+}
+
+func TestTrainPipeline(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	start := now.Add(-90 * 24 * time.Hour)
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	var labels []Label
+	for i := 0; i < 6; i++ {
+		storeSMART(t, store, "healthy"+string(rune('a'+i)), start, 90, 90)
+	}
+	for i := 0; i < 4; i++ {
+		id := "failing" + string(rune('a'+i))
+		failAt := 40 + 10*i
+		storeSMART(t, store, id, start, failAt, failAt-25)
+		labels = append(labels, Label{DeviceType: enum.Disk, DeviceID: id, Event: EventFailure, Time: start.Add(time.Duration(failAt) * 24 * time.Hour)})
+	}
+
+	dir := t.TempDir()
+	report, err := Train(store, labels, DefaultTrainingConfig(enum.Disk), dir, now)
+	require.NoError(t, err)
+	assert.Equal(t, report.Samples, report.TrainSamples+report.HoldoutSamples)
+	assert.Greater(t, report.Positives, 0)
+	assert.Greater(t, report.HoldoutSamples, 0)
+	assert.Greater(t, report.Holdout.Positives, 0)
+	assert.Greater(t, report.Holdout.AUC, 0.8)
+	assert.Greater(t, report.Holdout.Recall, 0.5)
+	assert.Equal(t, 30*24*time.Hour, report.Model.Info().Horizon)
+	assert.Equal(t, report.WeibullShape, report.Model.Info().WeibullShape)
+	assert.GreaterOrEqual(t, report.WeibullShape, 0.5)
+
+	for _, path := range []string{report.Path, ModelPath(dir, enum.Disk)} {
+		_, err := os.Stat(path)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, VersionedModelPath(dir, enum.Disk, report.Model.Info().Version), report.Path)
+
+	p, err := NewModelPredictor(&Config{HistoryWindow: 30 * 24 * time.Hour, ModelDir: dir}, store)
+	require.NoError(t, err)
+	model, ok := p.Model(enum.Disk)
+	require.True(t, ok)
+	assert.Equal(t, report.Model.Info().Version, model.Info().Version)
+	require.NotNil(t, model.Info().Evaluation)
+	assert.Equal(t, report.Holdout, *model.Info().Evaluation)
+
+	_, err = Train(store, nil, DefaultTrainingConfig(enum.Disk), "", now)
+	assert.Error(t, err)
+}
+
+func TestModelRegistry(t *testing.T) {
+	now := time.Now()
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	for _, m := range diskHistory("sda", now.Add(-time.Hour), 10, 4) {
+		require.NoError(t, store.Store(m))
+	}
+
+	first, err := TrainLogistic(enum.Disk, trainingSet(now), DefaultTrainConfig())
+	require.NoError(t, err)
+	second := first.warmStart(enum.Disk)
+	second.Bias -= 5
+	second.stampVersion()
+	require.NotEqual(t, first.Meta.Version, second.Meta.Version)
+
+	dir := t.TempDir()
+	registry, err := NewRegistry(dir)
+	require.NoError(t, err)
+	record, err := registry.Register(first, ModelActive)
+	require.NoError(t, err)
+	assert.Equal(t, ModelActive, record.Status)
+	assert.Equal(t, SchemaHash(first.Meta.Features), record.Schema)
+	_, err = registry.Register(second, ModelShadow)
+	require.NoError(t, err)
+
+	p, err := NewModelPredictor(&Config{HistoryWindow: 30 * 24 * time.Hour, ModelDir: dir}, store)
+	require.NoError(t, err)
+	result, err := p.Predict(enum.Disk, "sda")
+	require.NoError(t, err)
+	assert.Equal(t, first.Meta.Version, result.ModelVersion)
+	require.Len(t, result.Shadow, 1)
+	assert.Equal(t, second.Meta.Version, result.Shadow[0].Version)
+	assert.Less(t, result.Shadow[0].FailureProbability, result.FailureProbability)
+
+	versioned, err := p.PredictVersion(enum.Disk, "sda", second.Meta.Version)
+	require.NoError(t, err)
+	assert.Equal(t, result.Shadow[0].FailureProbability, versioned.FailureProbability)
+
+	// Changes made through another registry instance reach the running predictor.
+	_, err = registry.SetShadow(enum.Disk, first.Meta.Version, false)
+	assert.Error(t, err)
+	_, err = registry.Promote(enum.Disk, second.Meta.Version)
+	require.NoError(t, err)
+	result, err = p.Predict(enum.Disk, "sda")
+	require.NoError(t, err)
+	assert.Equal(t, second.Meta.Version, result.ModelVersion)
+	assert.Empty(t, result.Shadow)
+
+	record, err = registry.Rollback(enum.Disk)
+	require.NoError(t, err)
+	assert.Equal(t, first.Meta.Version, record.Info.Version)
+	model, ok := p.Model(enum.Disk)
+	require.True(t, ok)
+	assert.Equal(t, first.Meta.Version, model.Info().Version)
+	_, err = registry.Rollback(enum.Disk)
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+
+	records, err := p.ListModels()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	statuses := map[string]ModelStatus{}
+	for _, r := range records {
+		statuses[r.Info.Version] = r.Status
+	}
+	assert.Equal(t, map[string]ModelStatus{first.Meta.Version: ModelActive, second.Meta.Version: ModelRolledBack}, statuses)
+
+	// The active model is also where earlier releases load it from.
+	loaded, err := LoadModel(ModelPath(dir, enum.Disk))
+	require.NoError(t, err)
+	assert.Equal(t, first.Meta.Version, loaded.Info().Version)
+}
+
+func TestEvaluate(t *testing.T) {
+	e := Evaluate([]float64{0.9, 0.8, 0.6, 0.4, 0.2, 0.2}, []bool{true, false, true, false, true, false}, 0.5)
+	assert.Equal(t, 6, e.Samples)
+	assert.Equal(t, 3, e.Positives)
+	assert.InDelta(t, 2.0/3, e.Precision, 1e-9)
+	assert.InDelta(t, 2.0/3, e.Recall, 1e-9)
+	// Of the nine positive/negative pairs, five are ordered correctly and one is tied.
+	assert.InDelta(t, 5.5/9, e.AUC, 1e-9)
+	assert.InDelta(t, 1.65/6, e.Brier, 1e-9)
+
+	assert.Zero(t, Evaluate([]float64{0.1, 0.2}, []bool{false, false}, 0.5).AUC)
+}
+
+func TestCalibrate(t *testing.T) {
+	bins := Calibrate([]float64{0.9, 0.8, 0.6, 0.4, 0.2, 0.2, 1}, []bool{true, false, true, false, true, false, true}, 4)
+	require.Len(t, bins, 4)
+	assert.Equal(t, 0.0, bins[0].Lower)
+	assert.Equal(t, 2, bins[0].Count)
+	assert.InDelta(t, 0.2, bins[0].MeanPredicted, 1e-9)
+	assert.InDelta(t, 0.5, bins[0].ObservedRate, 1e-9)
+	assert.Equal(t, 0.25, bins[1].Lower)
+	assert.Equal(t, 1, bins[1].Count)
+	assert.Zero(t, bins[1].ObservedRate)
+	// A probability of one falls in the last bin.
+	assert.Equal(t, 1.0, bins[3].Upper)
+	assert.Equal(t, 3, bins[3].Count)
+	assert.InDelta(t, 0.9, bins[3].MeanPredicted, 1e-9)
+	assert.InDelta(t, 2.0/3, bins[3].ObservedRate, 1e-9)
+}
+
+func TestQualityTracker(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	path := filepath.Join(t.TempDir(), "predictions.jsonl")
+	tracker, err := NewQualityTracker(QualityConfig{Path: path})
+	require.NoError(t, err)
+
+	horizon := 30 * 24 * time.Hour
+	result := func(id string, probability float64) PredictionResult {
+		return PredictionResult{
+			DeviceType:         enum.Disk,
+			DeviceID:           id,
+			FailureProbability: probability,
+			ModelVersion:       "v1",
+			Survival:           Survival{Horizon: horizon},
+			Shadow:             []ShadowScore{{Version: "v2", FailureProbability: 0.5}},
+		}
+	}
+	require.NoError(t, tracker.Record(result("sda", 0.9), now.Add(-40*24*time.Hour)))
+	require.NoError(t, tracker.Record(result("sdb", 0.8), now.Add(-40*24*time.Hour)))
+	require.NoError(t, tracker.Record(result("sdc", 0.1), now.Add(-40*24*time.Hour)))
+	require.NoError(t, tracker.Record(result("sdd", 0.2), now.Add(-24*time.Hour)))
+	// Predicted again within the interval, so not recorded.
+	require.NoError(t, tracker.Record(result("sdd", 0.3), now.Add(-time.Hour)))
+	assert.Len(t, tracker.Records(), 8)
+
+	require.NoError(t, tracker.ObserveFailure(enum.Disk, "sda", now.Add(-20*24*time.Hour)))
+	labels := []Label{
+		{DeviceType: enum.Disk, DeviceID: "sdb", Event: EventReplacement, Time: now.Add(-35 * 24 * time.Hour)},
+		{DeviceType: enum.Disk, DeviceID: "sdc", Event: EventFailure, Time: now.Add(-5 * 24 * time.Hour)},
+	}
+	require.NoError(t, tracker.Reconcile(labels, now))
+
+	// Reload to check outcomes survive a restart.
+	tracker, err = NewQualityTracker(QualityConfig{Path: path})
+	require.NoError(t, err)
+	outcomes := make(map[string]Outcome)
+	for _, r := range tracker.Records() {
+		if !r.Shadow {
+			outcomes[r.DeviceID] = r.Outcome
+		}
+	}
+	// sdc failed after its prediction's horizon had passed, so the prediction still holds.
+	assert.Equal(t, map[string]Outcome{"sda": OutcomeFailed, "sdb": OutcomeFailed, "sdc": OutcomeSurvived, "sdd": OutcomePending}, outcomes)
+
+	reports := tracker.Reports(now)
+	require.Len(t, reports, 2)
+	active := reports[0]
+	assert.Equal(t, "v1", active.ModelVersion)
+	assert.Equal(t, 3, active.Samples)
+	assert.Equal(t, 2, active.Positives)
+	assert.Equal(t, 1, active.Pending)
+	assert.InDelta(t, 1.0, active.Precision, 1e-9)
+	assert.InDelta(t, 1.0, active.Recall, 1e-9)
+	assert.InDelta(t, (0.01+0.04+0.01)/3, active.Brier, 1e-9)
+	require.Len(t, active.Calibration, 3)
+	shadow := reports[1]
+	assert.Equal(t, "v2", shadow.ModelVersion)
+	assert.InDelta(t, 0.25, shadow.Brier, 1e-9)
+
+	// Resolved predictions age out of the window.
+	require.NoError(t, tracker.Reconcile(nil, now.Add(100*24*time.Hour)))
+	assert.Empty(t, tracker.Records())
+}
+
+func TestBacktest(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	start := now.Add(-90 * 24 * time.Hour)
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	var labels []Label
+	for i := 0; i < 4; i++ {
+		storeSMART(t, store, "healthy"+string(rune('a'+i)), start, 90, 90)
+	}
+	for i := 0; i < 2; i++ {
+		id := "failing" + string(rune('a'+i))
+		failAt := 50 + 10*i
+		storeSMART(t, store, id, start, failAt, failAt-25)
+		labels = append(labels, Label{DeviceType: enum.Disk, DeviceID: id, Event: EventFailure, Time: start.Add(time.Duration(failAt) * 24 * time.Hour)})
+	}
+	trained, err := Train(store, labels, DefaultTrainingConfig(enum.Disk), "", now)
+	require.NoError(t, err)
+
+	from, to := start.Add(20*24*time.Hour), start.Add(50*24*time.Hour)
+	report, records, err := Backtest(store, trained.Model, labels, BacktestConfig{
+		Training: DefaultTrainingConfig(enum.Disk),
+		From:     from,
+		To:       to,
+	}, now)
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	assert.Equal(t, len(records), report.Samples)
+	assert.Zero(t, report.Pending)
+	assert.Greater(t, report.Positives, 0)
+	assert.Greater(t, report.AUC, 0.8)
+	for _, r := range records {
+		assert.False(t, r.At.Before(from) || r.At.After(to))
+		assert.NotEqual(t, OutcomePending, r.Outcome)
+	}
+}
+
+func TestWriteLabelsRoundTrip(t *testing.T) {
+	labels := []Label{
+		{DeviceType: enum.Disk, DeviceID: "ZA1", Event: EventFailure, Time: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{DeviceType: enum.RAID, DeviceID: "host0", Event: EventReplacement, Time: time.Date(2024, 2, 1, 8, 0, 0, 0, time.UTC)},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, WriteLabels(&buf, labels))
+	read, err := ReadLabels(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, labels, read)
+}
+
+func TestBackblazeImporter(t *testing.T) {
+	var csv strings.Builder
+	csv.WriteString("date,serial_number,model,capacity_bytes,failure,smart_5_raw,smart_9_raw,smart_197_raw\n")
+	start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -5)
+	for d := 0; d < 5; d++ {
+		date := start.AddDate(0, 0, d).Format("2006-01-02")
+		fmt.Fprintf(&csv, "%s,ZA1,ST4000DM000,4000787030016,0,0,%d,0\n", date, 1000+24*d)
+		fmt.Fprintf(&csv, "%s,ZA2,ST4000DM000,4000787030016,%d,%d,%d,%d\n", date, map[bool]int{true: 1}[d == 4], 10*d, 2000+24*d, 2*d)
+		fmt.Fprintf(&csv, "%s,WD1,WDC WD60EFRX,6001175126016,0,0,%d,0\n", date, 3000+24*d)
+	}
+
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	importer := NewBackblazeImporter(store, ImportOptions{Models: []string{"ST4000DM000"}, BatchSize: 3})
+	require.NoError(t, importer.Import(strings.NewReader(csv.String())))
+
+	report := importer.Report()
+	assert.Equal(t, 15, report.Rows)
+	assert.Equal(t, 10, report.Records)
+	assert.Equal(t, 2, report.Devices)
+	assert.Equal(t, 1, report.Failures)
+	require.Len(t, report.Labels, 1)
+	assert.Equal(t, Label{DeviceType: enum.Disk, DeviceID: "ZA2", Event: EventFailure, Time: start.AddDate(0, 0, 4)}, report.Labels[0])
+
+	ids, err := store.ListDevices(enum.Disk)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ZA1", "ZA2"}, ids)
+
+	history, err := store.Query(enum.Disk, "ZA2", 30*24*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, history, 5)
+	features, err := ExtractFeatures(enum.Disk, history, start.AddDate(0, 0, 4))
+	require.NoError(t, err)
+	assert.Equal(t, 40.0, features["reallocated_sectors.last"])
+	assert.Equal(t, 8.0, features["pending_sectors.last"])
+	assert.Equal(t, 2.0, features["pending_sectors.delta_24h"])
+	assert.Equal(t, 2096.0, features["power_on_hours.last"])
+}
+
+func TestTrainLogisticWarmStart(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	base, err := TrainLogistic(enum.Disk, trainingSet(now), DefaultTrainConfig())
+	require.NoError(t, err)
+
+	config := DefaultTrainConfig()
+	config.Initial = base
+	config.Iterations = 50
+	config.L2 = 0
+	// Locally, disks fail at a slower growth than in the base data.
+	slow, _ := ExtractFeatures(enum.Disk, diskHistory("sdc", now, 10, 2), now)
+	tuned, err := TrainLogistic(enum.Disk, []Example{{Features: slow, Failed: true}}, config)
+	require.NoError(t, err)
+
+	assert.Equal(t, base.Info().Features, tuned.Info().Features)
+	assert.Equal(t, base.Means, tuned.Means)
+	assert.NotEqual(t, base.Info().Version, tuned.Info().Version)
+	assert.Greater(t, tuned.Predict(slow), base.Predict(slow))
+}
+
+// constantModel predicts the same probability for every device.
+type constantModel float64
+
+func (m constantModel) Info() ModelInfo                   { return ModelInfo{Horizon: 30 * 24 * time.Hour} }
+func (m constantModel) Predict(features Features) float64 { return float64(m) }
+
+func TestSurvival(t *testing.T) {
+	month := 30 * 24 * time.Hour
+	s := NewSurvival(ModelInfo{Horizon: month}, 0.5)
+	assert.Equal(t, 1.0, s.Shape)
+	assert.InDelta(t, 0.5, s.FailureWithin(month), 1e-9)
+	assert.InDelta(t, 0.75, s.FailureWithin(2*month), 1e-9)
+	assert.Zero(t, s.FailureWithin(0))
+	median, ok := s.Quantile(0.5)
+	assert.True(t, ok)
+	assert.InDelta(t, float64(month), float64(median), float64(time.Second))
+
+	// An increasing hazard concentrates failures around the horizon.
+	aging := NewSurvival(ModelInfo{Horizon: month, WeibullShape: 2}, 0.5)
+	assert.InDelta(t, 1-1.0/16, aging.FailureWithin(2*month), 1e-9)
+	assert.Less(t, aging.FailureWithin(month/2), s.FailureWithin(month/2))
+
+	never := NewSurvival(ModelInfo{}, 0)
+	assert.Equal(t, defaultHorizon, never.Horizon)
+	life, ok := never.Quantile(0.5)
+	assert.False(t, ok)
+	assert.Equal(t, MaxRemainingLife, life)
+
+	assert.Equal(t, RiskLow, RiskFor(0.01))
+	assert.Equal(t, RiskMedium, RiskFor(0.05))
+	assert.Equal(t, RiskHigh, RiskFor(0.3))
+	assert.Equal(t, RiskCritical, RiskFor(0.5))
+}
+
+func TestForecastFailures(t *testing.T) {
+	month := 30 * 24 * time.Hour
+	results := []PredictionResult{
+		{Survival: NewSurvival(ModelInfo{Horizon: month}, 0.5)},
+		{Survival: NewSurvival(ModelInfo{Horizon: month}, 0.5)},
+		{Survival: NewSurvival(ModelInfo{Horizon: month}, 0)},
+	}
+	f := ForecastFailures(results, month)
+	assert.Equal(t, 3, f.Devices)
+	assert.InDelta(t, 1, f.Expected, 1e-9)
+	assert.Zero(t, f.Low)
+	assert.InDelta(t, 1+1.645*math.Sqrt(0.5), f.High, 1e-9)
+}
+
+func TestFitWeibullShape(t *testing.T) {
+	month := 30 * 24 * time.Hour
+	// Failure times at the quantiles of a Weibull with shape 2 and median one month.
+	var samples []Sample
+	for i := 0; i < 200; i++ {
+		u := (float64(i) + 0.5) / 200
+		ttf := float64(month) * math.Sqrt(-math.Log(1-u)/math.Ln2)
+		samples = append(samples, Sample{TimeToEvent: time.Duration(ttf), Observed: true})
+	}
+	assert.InDelta(t, 2, FitWeibullShape(constantModel(0.5), samples, month), 0.15)
+
+	for i := range samples {
+		samples[i].Observed = false
+	}
+	assert.Equal(t, 1.0, FitWeibullShape(constantModel(0.5), samples, month))
+}
@@ -0,0 +1,392 @@
+package prediction
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Outcome is what became of a device after a prediction was made for it.
+type Outcome string
+
+// Prediction outcomes.
+const (
+	OutcomePending  Outcome = "pending"  // The horizon has not passed and the device has not failed
+	OutcomeFailed   Outcome = "failed"   // The device failed or was replaced within the horizon
+	OutcomeSurvived Outcome = "survived" // The horizon passed without a failure
+)
+
+// PredictionRecord is one recorded prediction and, once known, its outcome.
+type PredictionRecord struct {
+	DeviceType   enum.DeviceType `json:"device_type"`
+	DeviceID     string          `json:"device_id"`
+	ModelVersion string          `json:"model_version"`
+	Shadow       bool            `json:"shadow,omitempty"`
+	At           time.Time       `json:"at"`
+	Horizon      time.Duration   `json:"horizon"`
+	Probability  float64         `json:"probability"` // Of failing within Horizon
+	Outcome      Outcome         `json:"outcome"`
+	ResolvedAt   time.Time       `json:"resolved_at"`
+}
+
+// QualityConfig defines how predictions are recorded and reported on.
+type QualityConfig struct {
+	Path      string        // JSON lines file predictions are recorded in; in memory only when empty
+	Window    time.Duration // Reports cover predictions made this far back; 90 days when zero
+	Interval  time.Duration // Minimum time between recorded predictions of one device by one model; 24 hours when zero
+	Threshold float64       // Probability at which a device counts as predicted to fail; 0.5 when zero
+	Bins      int           // Calibration bins; 10 when zero
+}
+
+// QualityReport summarises how well one model version's predictions came true.
+type QualityReport struct {
+	DeviceType   enum.DeviceType
+	ModelVersion string
+	Pending      int // Predictions whose outcome is not known yet
+	Evaluation       // Over predictions with known outcomes
+	Calibration  []CalibrationBin
+}
+
+// QualityTracker records predictions and reconciles them against observed failures, so
+// each model version's precision, recall, Brier score and calibration can be tracked as
+// its predictions come due.
+type QualityTracker struct {
+	config  QualityConfig
+	mu      sync.Mutex
+	records []PredictionRecord
+	last    map[string]time.Time // Last recorded prediction per device and model version
+}
+
+// NewQualityTracker creates a new QualityTracker, loading the predictions recorded in
+// config.Path.
+func NewQualityTracker(config QualityConfig) (*QualityTracker, error) {
+	if config.Window <= 0 {
+		config.Window = 90 * 24 * time.Hour
+	}
+	if config.Interval <= 0 {
+		config.Interval = 24 * time.Hour
+	}
+	if config.Threshold <= 0 {
+		config.Threshold = 0.5
+	}
+	if config.Bins <= 0 {
+		config.Bins = 10
+	}
+	t := &QualityTracker{config: config, last: make(map[string]time.Time)}
+	if config.Path == "" {
+		return t, nil
+	}
+
+	f, err := os.Open(config.Path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, errors.NewStorageFailure("failed to open prediction records", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r PredictionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, errors.New("failed to parse prediction records "+config.Path, err)
+		}
+		t.add(r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.NewStorageFailure("failed to read prediction records", err)
+	}
+	return t, nil
+}
+
+// add appends a record; outcomes recorded later in the file replace earlier ones.
+func (t *QualityTracker) add(r PredictionRecord) {
+	key := recordKey(r.DeviceType, r.DeviceID, r.ModelVersion)
+	if last, ok := t.last[key]; ok && r.At.Equal(last) {
+		for i := len(t.records) - 1; i >= 0; i-- {
+			if recordKey(t.records[i].DeviceType, t.records[i].DeviceID, t.records[i].ModelVersion) == key && t.records[i].At.Equal(r.At) {
+				t.records[i] = r
+				return
+			}
+		}
+	}
+	t.records = append(t.records, r)
+	if r.At.After(t.last[key]) {
+		t.last[key] = r.At
+	}
+}
+
+func recordKey(deviceType enum.DeviceType, deviceID, version string) string {
+	return deviceType.String() + "/" + deviceID + "@" + version
+}
+
+// Record records a prediction and its shadow scores, unless the same model predicted
+// for the device less than Interval ago.
+func (t *QualityTracker) Record(result PredictionResult, at time.Time) error {
+	records := []PredictionRecord{{
+		DeviceType:   result.DeviceType,
+		DeviceID:     result.DeviceID,
+		ModelVersion: result.ModelVersion,
+		At:           at,
+		Horizon:      result.Survival.Horizon,
+		Probability:  result.FailureProbability,
+		Outcome:      OutcomePending,
+	}}
+	for _, s := range result.Shadow {
+		records = append(records, PredictionRecord{
+			DeviceType:   result.DeviceType,
+			DeviceID:     result.DeviceID,
+			ModelVersion: s.Version,
+			Shadow:       true,
+			At:           at,
+			Horizon:      result.Survival.Horizon,
+			Probability:  s.FailureProbability,
+			Outcome:      OutcomePending,
+		})
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var added []PredictionRecord
+	for _, r := range records {
+		if last, ok := t.last[recordKey(r.DeviceType, r.DeviceID, r.ModelVersion)]; ok && at.Sub(last) < t.config.Interval {
+			continue
+		}
+		t.add(r)
+		added = append(added, r)
+	}
+	return t.append(added)
+}
+
+// ObserveFailure resolves the device's pending predictions whose horizon covers at as
+// failed, e.g., when detection marks the device Failed.
+func (t *QualityTracker) ObserveFailure(deviceType enum.DeviceType, deviceID string, at time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var resolved []PredictionRecord
+	for i := range t.records {
+		r := &t.records[i]
+		if r.DeviceType == deviceType && r.DeviceID == deviceID && r.Outcome == OutcomePending &&
+			!at.Before(r.At) && !at.After(r.At.Add(r.Horizon)) {
+			r.Outcome, r.ResolvedAt = OutcomeFailed, at
+			resolved = append(resolved, *r)
+		}
+	}
+	return t.append(resolved)
+}
+
+// Reconcile resolves pending predictions against labelled failures and replacements:
+// predictions followed by an event within their horizon failed, and those whose horizon
+// passed without one survived. Records older than the reporting window are dropped.
+func (t *QualityTracker) Reconcile(labels []Label, now time.Time) error {
+	events := make(map[string][]time.Time)
+	for _, l := range labels {
+		key := l.DeviceType.String() + "/" + l.DeviceID
+		events[key] = append(events[key], l.Time)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cutoff := now.Add(-t.config.Window)
+	kept := t.records[:0]
+	for _, r := range t.records {
+		if r.Outcome == OutcomePending {
+			resolveRecord(&r, events[r.DeviceType.String()+"/"+r.DeviceID], now)
+		}
+		if r.Outcome != OutcomePending && r.At.Before(cutoff) {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	t.records = kept
+	return t.rewrite()
+}
+
+// resolveRecord resolves a pending record against its device's events as of now.
+func resolveRecord(r *PredictionRecord, events []time.Time, now time.Time) {
+	due := r.At.Add(r.Horizon)
+	for _, e := range events {
+		if !e.Before(r.At) && !e.After(due) && !e.After(now) {
+			r.Outcome, r.ResolvedAt = OutcomeFailed, e
+			return
+		}
+	}
+	if now.After(due) {
+		r.Outcome, r.ResolvedAt = OutcomeSurvived, due
+	}
+}
+
+// Records returns the recorded predictions.
+func (t *QualityTracker) Records() []PredictionRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]PredictionRecord(nil), t.records...)
+}
+
+// Reports returns a quality report per device type and model version, over the
+// predictions made within the reporting window before now.
+func (t *QualityTracker) Reports(now time.Time) []QualityReport {
+	t.mu.Lock()
+	records := append([]PredictionRecord(nil), t.records...)
+	t.mu.Unlock()
+
+	var within []PredictionRecord
+	for _, r := range records {
+		if !r.At.Before(now.Add(-t.config.Window)) && !r.At.After(now) {
+			within = append(within, r)
+		}
+	}
+	return qualityReports(within, t.config.Threshold, t.config.Bins)
+}
+
+// qualityReports groups records by device type and model version and evaluates each group.
+func qualityReports(records []PredictionRecord, threshold float64, bins int) []QualityReport {
+	type group struct {
+		report        QualityReport
+		probabilities []float64
+		failed        []bool
+	}
+	groups := make(map[string]*group)
+	var keys []string
+	for _, r := range records {
+		key := r.DeviceType.String() + "@" + r.ModelVersion
+		g, ok := groups[key]
+		if !ok {
+			g = &group{report: QualityReport{DeviceType: r.DeviceType, ModelVersion: r.ModelVersion}}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		if r.Outcome == OutcomePending {
+			g.report.Pending++
+			continue
+		}
+		g.probabilities = append(g.probabilities, r.Probability)
+		g.failed = append(g.failed, r.Outcome == OutcomeFailed)
+	}
+	sort.Strings(keys)
+
+	reports := make([]QualityReport, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		g.report.Evaluation = Evaluate(g.probabilities, g.failed, threshold)
+		g.report.Calibration = Calibrate(g.probabilities, g.failed, bins)
+		reports = append(reports, g.report)
+	}
+	return reports
+}
+
+// append writes records to the end of the records file.
+func (t *QualityTracker) append(records []PredictionRecord) error {
+	if t.config.Path == "" || len(records) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(t.config.Path), 0755); err != nil {
+		return errors.NewStorageFailure("failed to create prediction records directory", err)
+	}
+	f, err := os.OpenFile(t.config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.NewStorageFailure("failed to open prediction records", err)
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return errors.NewStorageFailure("failed to write prediction record", err)
+		}
+	}
+	return nil
+}
+
+// rewrite replaces the records file with the current records.
+func (t *QualityTracker) rewrite() error {
+	if t.config.Path == "" {
+		return nil
+	}
+	var data []byte
+	for _, r := range t.records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal prediction record")
+		}
+		data = append(append(data, line...), '\n')
+	}
+	return writeFileAtomic(t.config.Path, data, "prediction records")
+}
+
+// BacktestConfig defines a backtest.
+type BacktestConfig struct {
+	Training  TrainingConfig // Sampling parameters; the horizon is taken from the model when it records one
+	From, To  time.Time      // Predictions are replayed at Training.Stride intervals between these times
+	Threshold float64        // Probability at which a device counts as predicted to fail; 0.5 when zero
+	Bins      int            // Calibration bins; 10 when zero
+}
+
+// Backtest replays stored history through a model as if it had run live: at every stride
+// between From and To, each device is scored on the history it had then, and the score is
+// resolved against the labelled failures and replacements that followed within the
+// model's horizon. Predictions whose horizon extends past now are left out, as their
+// outcome is unknown.
+func Backtest(store storage.Storage, model Model, labels []Label, config BacktestConfig, now time.Time) (QualityReport, []PredictionRecord, error) {
+	info := model.Info()
+	training := config.Training
+	training.DeviceType = info.DeviceType
+	if info.Horizon > 0 {
+		training.Horizon = info.Horizon
+	}
+	if from := config.From.Add(-training.Lookback); training.From.IsZero() || from.Before(training.From) {
+		training.From = from
+	}
+	samples, err := BuildSamples(store, labels, training, now)
+	if err != nil {
+		return QualityReport{}, nil, err
+	}
+
+	var records []PredictionRecord
+	for _, s := range samples {
+		if s.At.Before(config.From) || s.At.After(config.To) {
+			continue
+		}
+		r := PredictionRecord{
+			DeviceType:   info.DeviceType,
+			DeviceID:     s.DeviceID,
+			ModelVersion: info.Version,
+			At:           s.At,
+			Horizon:      training.Horizon,
+			Probability:  model.Predict(s.Features),
+			Outcome:      OutcomeSurvived,
+			ResolvedAt:   s.At.Add(training.Horizon),
+		}
+		if s.Failed {
+			r.Outcome, r.ResolvedAt = OutcomeFailed, s.At.Add(s.TimeToEvent)
+		}
+		records = append(records, r)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].At.Before(records[j].At) })
+
+	threshold := config.Threshold
+	if threshold <= 0 {
+		threshold = 0.5
+	}
+	report := QualityReport{DeviceType: info.DeviceType, ModelVersion: info.Version}
+	if reports := qualityReports(records, threshold, config.Bins); len(reports) > 0 {
+		report = reports[0]
+	}
+	logger.Info("prediction backtest completed",
+		zap.String("device_type", info.DeviceType.String()),
+		zap.String("version", info.Version),
+		zap.Int("predictions", len(records)),
+		zap.Float64("precision", report.Precision),
+		zap.Float64("recall", report.Recall),
+		zap.Float64("brier", report.Brier),
+	)
+	return report, records, nil
+}
@@ -0,0 +1,381 @@
+package prediction
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModelStatus is a registered model's role.
+type ModelStatus string
+
+// Model statuses.
+const (
+	ModelCandidate  ModelStatus = "candidate"   // Stored but not scored
+	ModelShadow     ModelStatus = "shadow"      // Scored alongside the active model; its scores are only logged
+	ModelActive     ModelStatus = "active"      // Serves predictions for its device type
+	ModelArchived   ModelStatus = "archived"    // Replaced by a promotion; a rollback may reactivate it
+	ModelRolledBack ModelStatus = "rolled_back" // Deactivated by a rollback; only a promotion reactivates it
+)
+
+// registryFile is the registry index, kept next to the model artifacts.
+const registryFile = "registry.json"
+
+// ModelRecord describes a registered model.
+type ModelRecord struct {
+	Info         ModelInfo   `json:"info"`
+	Path         string      `json:"path"`   // Artifact file, relative to the registry directory
+	Schema       string      `json:"schema"` // Hash of the model's feature names in order; see SchemaHash
+	Status       ModelStatus `json:"status"`
+	RegisteredAt time.Time   `json:"registered_at"`
+	ActivatedAt  time.Time   `json:"activated_at"` // Most recent promotion; zero if never active
+}
+
+// SchemaHash identifies a feature schema, so models can be checked for compatibility with
+// the features extracted for them.
+func SchemaHash(features []string) string {
+	h := fnv.New32a()
+	for _, f := range features {
+		fmt.Fprintf(h, "%s;", f)
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// Registry stores versioned model artifacts and tracks which model is active for each
+// device type and which run in shadow. Its index is re-read whenever another process
+// changes it, so promotions and rollbacks take effect without a restart.
+type Registry struct {
+	dir     string
+	mu      sync.Mutex
+	records []ModelRecord
+	index   os.FileInfo      // Of the index when it was last read or written
+	models  map[string]Model // Loaded artifacts by path
+}
+
+// NewRegistry opens the registry in dir. A <device type>.json model file that is not yet
+// registered, such as one copied into place by hand, is registered and activated.
+func NewRegistry(dir string) (*Registry, error) {
+	r := &Registry{dir: dir, models: make(map[string]Model)}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.refresh(); err != nil {
+		return nil, err
+	}
+
+	for _, deviceType := range []enum.DeviceType{enum.RAID, enum.Disk, enum.Network} {
+		model, err := LoadModel(ModelPath(dir, deviceType))
+		if errors.Is(err, errors.NewNotFound("", nil)) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if i := r.find(deviceType, model.Info().Version); i >= 0 && r.records[i].Status == ModelActive {
+			continue
+		}
+		if _, err := r.register(model, ModelActive); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register stores a model's artifact and registers it with a status. Registering a model
+// as active promotes it; re-registering a version only changes its status.
+func (r *Registry) Register(model Model, status ModelStatus) (ModelRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.refresh(); err != nil {
+		return ModelRecord{}, err
+	}
+	return r.register(model, status)
+}
+
+func (r *Registry) register(model Model, status ModelStatus) (ModelRecord, error) {
+	info := model.Info()
+	if info.Version == "" {
+		return ModelRecord{}, errors.New("cannot register a model without a version", nil)
+	}
+	switch status {
+	case ModelCandidate, ModelShadow, ModelActive:
+	default:
+		return ModelRecord{}, errors.New("models cannot be registered as "+string(status), nil)
+	}
+
+	i := r.find(info.DeviceType, info.Version)
+	if i < 0 {
+		path := VersionedModelPath(r.dir, info.DeviceType, info.Version)
+		if err := SaveModel(path, model); err != nil {
+			return ModelRecord{}, err
+		}
+		r.models[filepath.Base(path)] = model
+		r.records = append(r.records, ModelRecord{
+			Info:         info,
+			Path:         filepath.Base(path),
+			Schema:       SchemaHash(info.Features),
+			Status:       ModelCandidate,
+			RegisteredAt: time.Now().UTC(),
+		})
+		i = len(r.records) - 1
+	}
+	if err := r.setStatus(i, status); err != nil {
+		return ModelRecord{}, err
+	}
+	if err := r.save(); err != nil {
+		return ModelRecord{}, err
+	}
+	return r.records[i], nil
+}
+
+// Promote makes a registered version the active model for its device type, archiving the
+// model it replaces.
+func (r *Registry) Promote(deviceType enum.DeviceType, version string) (ModelRecord, error) {
+	return r.update(deviceType, version, ModelActive)
+}
+
+// SetShadow starts or stops scoring a registered version in shadow.
+func (r *Registry) SetShadow(deviceType enum.DeviceType, version string, shadow bool) (ModelRecord, error) {
+	status := ModelCandidate
+	if shadow {
+		status = ModelShadow
+	}
+	return r.update(deviceType, version, status)
+}
+
+// update sets the status of a registered version.
+func (r *Registry) update(deviceType enum.DeviceType, version string, status ModelStatus) (ModelRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.refresh(); err != nil {
+		return ModelRecord{}, err
+	}
+	i := r.find(deviceType, version)
+	if i < 0 {
+		return ModelRecord{}, errors.NewNotFound("no "+deviceType.String()+" model with version "+version, nil)
+	}
+	if r.records[i].Status == ModelActive && status != ModelActive {
+		return ModelRecord{}, errors.New("the active model cannot be demoted; promote another model or roll back", nil)
+	}
+	if err := r.setStatus(i, status); err != nil {
+		return ModelRecord{}, err
+	}
+	if err := r.save(); err != nil {
+		return ModelRecord{}, err
+	}
+	return r.records[i], nil
+}
+
+// Rollback deactivates a device type's active model and reactivates the most recently
+// active archived one.
+func (r *Registry) Rollback(deviceType enum.DeviceType) (ModelRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.refresh(); err != nil {
+		return ModelRecord{}, err
+	}
+
+	current, previous := -1, -1
+	for i, rec := range r.records {
+		if rec.Info.DeviceType != deviceType {
+			continue
+		}
+		switch rec.Status {
+		case ModelActive:
+			current = i
+		case ModelArchived:
+			if previous < 0 || rec.ActivatedAt.After(r.records[previous].ActivatedAt) {
+				previous = i
+			}
+		}
+	}
+	if previous < 0 {
+		return ModelRecord{}, errors.NewNotFound("no earlier "+deviceType.String()+" model to roll back to", nil)
+	}
+	if current >= 0 {
+		r.records[current].Status = ModelRolledBack
+	}
+	if err := r.setStatus(previous, ModelActive); err != nil {
+		return ModelRecord{}, err
+	}
+	if err := r.save(); err != nil {
+		return ModelRecord{}, err
+	}
+	fields := []zap.Field{zap.String("device_type", deviceType.String()), zap.String("version", r.records[previous].Info.Version)}
+	if current >= 0 {
+		fields = append(fields, zap.String("rolled_back", r.records[current].Info.Version))
+	}
+	logger.Warn("prediction model rolled back", fields...)
+	return r.records[previous], nil
+}
+
+// setStatus changes a record's status. Activating it archives the device type's active
+// model and writes the artifact to ModelPath, where earlier releases load models from.
+func (r *Registry) setStatus(i int, status ModelStatus) error {
+	rec := &r.records[i]
+	if status == ModelActive && rec.Status != ModelActive {
+		model, err := r.load(*rec)
+		if err != nil {
+			return err
+		}
+		if err := SaveModel(ModelPath(r.dir, rec.Info.DeviceType), model); err != nil {
+			return err
+		}
+		for j := range r.records {
+			if r.records[j].Info.DeviceType == rec.Info.DeviceType && r.records[j].Status == ModelActive {
+				r.records[j].Status = ModelArchived
+			}
+		}
+		rec.ActivatedAt = time.Now().UTC()
+		logger.Info("prediction model promoted",
+			zap.String("device_type", rec.Info.DeviceType.String()),
+			zap.String("version", rec.Info.Version),
+		)
+	}
+	rec.Status = status
+	return nil
+}
+
+// Active returns a device type's active model.
+func (r *Registry) Active(deviceType enum.DeviceType) (Model, bool) {
+	models := r.byStatus(deviceType, ModelActive)
+	if len(models) == 0 {
+		return nil, false
+	}
+	return models[0], true
+}
+
+// Shadows returns the models scored in shadow for a device type.
+func (r *Registry) Shadows(deviceType enum.DeviceType) []Model {
+	return r.byStatus(deviceType, ModelShadow)
+}
+
+func (r *Registry) byStatus(deviceType enum.DeviceType, status ModelStatus) []Model {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.refresh(); err != nil {
+		logger.Error("failed to reload model registry", zap.Error(err))
+	}
+	var models []Model
+	for _, rec := range r.records {
+		if rec.Info.DeviceType != deviceType || rec.Status != status {
+			continue
+		}
+		model, err := r.load(rec)
+		if err != nil {
+			logger.Error("failed to load registered model", zap.String("path", rec.Path), zap.Error(err))
+			continue
+		}
+		models = append(models, model)
+	}
+	return models
+}
+
+// Load returns a registered model by version.
+func (r *Registry) Load(deviceType enum.DeviceType, version string) (Model, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.refresh(); err != nil {
+		return nil, err
+	}
+	i := r.find(deviceType, version)
+	if i < 0 {
+		return nil, errors.NewNotFound("no "+deviceType.String()+" model with version "+version, nil)
+	}
+	return r.load(r.records[i])
+}
+
+// List returns every registered model, by device type and then newest first.
+func (r *Registry) List() ([]ModelRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.refresh(); err != nil {
+		return nil, err
+	}
+	records := append([]ModelRecord(nil), r.records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Info.DeviceType != records[j].Info.DeviceType {
+			return records[i].Info.DeviceType < records[j].Info.DeviceType
+		}
+		return records[i].RegisteredAt.After(records[j].RegisteredAt)
+	})
+	return records, nil
+}
+
+func (r *Registry) find(deviceType enum.DeviceType, version string) int {
+	for i, rec := range r.records {
+		if rec.Info.DeviceType == deviceType && strings.EqualFold(rec.Info.Version, version) {
+			return i
+		}
+	}
+	return -1
+}
+
+// load returns a record's model, reading its artifact on first use.
+func (r *Registry) load(rec ModelRecord) (Model, error) {
+	if model, ok := r.models[rec.Path]; ok {
+		return model, nil
+	}
+	model, err := LoadModel(filepath.Join(r.dir, rec.Path))
+	if err != nil {
+		return nil, err
+	}
+	r.models[rec.Path] = model
+	return model, nil
+}
+
+// refresh re-reads the index if it changed since it was last read.
+func (r *Registry) refresh() error {
+	path := filepath.Join(r.dir, registryFile)
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.NewStorageFailure("failed to stat model registry", err)
+	}
+	// The index is replaced by renaming, so a rewrite always yields a different file.
+	if r.index != nil && os.SameFile(stat, r.index) && stat.ModTime().Equal(r.index.ModTime()) {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.NewStorageFailure("failed to read model registry", err)
+	}
+	var index struct {
+		Models []ModelRecord `json:"models"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return errors.New("failed to parse model registry "+path, err)
+	}
+	r.records = index.Models
+	r.index = stat
+	return nil
+}
+
+// save writes the index.
+func (r *Registry) save() error {
+	data, err := json.MarshalIndent(struct {
+		Models []ModelRecord `json:"models"`
+	}{r.records}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal model registry")
+	}
+	path := filepath.Join(r.dir, registryFile)
+	if err := writeFileAtomic(path, data, "model registry"); err != nil {
+		return err
+	}
+	if stat, err := os.Stat(path); err == nil {
+		r.index = stat
+	}
+	return nil
+}
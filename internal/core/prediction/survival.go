@@ -0,0 +1,165 @@
+package prediction
+
+import (
+	"math"
+	"time"
+)
+
+// MaxRemainingLife caps remaining useful life estimates.
+const MaxRemainingLife = 10 * 365 * 24 * time.Hour
+
+// defaultHorizon is assumed for models that do not record the horizon they were trained on.
+const defaultHorizon = 30 * 24 * time.Hour
+
+// RiskLevel classifies how likely a device is to fail soon.
+type RiskLevel string
+
+// Risk levels, by the probability of failing within RiskHorizon.
+const (
+	RiskLow      RiskLevel = "low"
+	RiskMedium   RiskLevel = "medium"
+	RiskHigh     RiskLevel = "high"
+	RiskCritical RiskLevel = "critical"
+)
+
+// RiskHorizon is the horizon risk levels are assessed over.
+const RiskHorizon = 30 * 24 * time.Hour
+
+// RiskFor returns the risk level for a probability of failing within RiskHorizon.
+func RiskFor(probability float64) RiskLevel {
+	switch {
+	case probability >= 0.5:
+		return RiskCritical
+	case probability >= 0.2:
+		return RiskHigh
+	case probability >= 0.05:
+		return RiskMedium
+	default:
+		return RiskLow
+	}
+}
+
+// Survival is a Weibull survival curve for one device. Its scale comes from the model's
+// probability of failure within the model horizon; its shape is fitted at training time,
+// with shapes above one meaning the hazard grows as the device ages.
+type Survival struct {
+	Horizon     time.Duration
+	Probability float64 // Probability of failing within Horizon
+	Shape       float64 // Weibull shape; zero counts as one, a constant hazard
+}
+
+// NewSurvival creates the survival curve implied by a model's prediction.
+func NewSurvival(info ModelInfo, probability float64) Survival {
+	s := Survival{Horizon: info.Horizon, Probability: probability, Shape: info.WeibullShape}
+	if s.Horizon <= 0 {
+		s.Horizon = defaultHorizon
+	}
+	if s.Shape <= 0 {
+		s.Shape = 1
+	}
+	return s
+}
+
+// cumulativeHazard returns the cumulative hazard at the horizon.
+func (s Survival) cumulativeHazard() float64 {
+	return -math.Log1p(-math.Min(s.Probability, 1-1e-12))
+}
+
+// FailureWithin returns the probability of failing within d.
+func (s Survival) FailureWithin(d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return -math.Expm1(-s.cumulativeHazard() * math.Pow(float64(d)/float64(s.Horizon), s.Shape))
+}
+
+// Quantile returns the time by which the device fails with probability q, capped at
+// MaxRemainingLife; the second result is false when the cap was applied.
+func (s Survival) Quantile(q float64) (time.Duration, bool) {
+	hazard := s.cumulativeHazard()
+	if hazard <= 0 {
+		return MaxRemainingLife, false
+	}
+	t := float64(s.Horizon) * math.Pow(-math.Log1p(-q)/hazard, 1/s.Shape)
+	if t >= float64(MaxRemainingLife) {
+		return MaxRemainingLife, false
+	}
+	return time.Duration(t), true
+}
+
+// HorizonProbability is the probability of failing within a number of days.
+type HorizonProbability struct {
+	Days        int
+	Probability float64
+}
+
+// FleetForecast is the expected number of failures across devices within a horizon.
+type FleetForecast struct {
+	Devices  int
+	Within   time.Duration
+	Expected float64
+	Low      float64 // 5th percentile, normal approximation
+	High     float64 // 95th percentile, normal approximation
+}
+
+// ForecastFailures sums the devices' failure probabilities within a horizon, answering
+// how many devices are expected to fail in, for example, the next month.
+func ForecastFailures(results []PredictionResult, within time.Duration) FleetForecast {
+	f := FleetForecast{Devices: len(results), Within: within}
+	variance := 0.0
+	for _, r := range results {
+		p := r.Survival.FailureWithin(within)
+		f.Expected += p
+		variance += p * (1 - p)
+	}
+	spread := 1.645 * math.Sqrt(variance)
+	f.Low = math.Max(0, f.Expected-spread)
+	f.High = math.Min(float64(len(results)), f.Expected+spread)
+	return f
+}
+
+// FitWeibullShape fits the Weibull shape that best explains when the samples' devices
+// failed or were last seen, given each sample's predicted probability of failing within
+// the horizon. It searches shapes between 0.5 and 3 and returns 1 without failures.
+func FitWeibullShape(model Model, samples []Sample, horizon time.Duration) float64 {
+	type observation struct {
+		hazard float64 // Cumulative hazard at the horizon
+		t      float64 // Time to failure or censoring, in horizons
+		failed bool
+	}
+	var observations []observation
+	failures := 0
+	for _, s := range samples {
+		if s.TimeToEvent <= 0 {
+			continue
+		}
+		survival := Survival{Horizon: horizon, Probability: model.Predict(s.Features), Shape: 1}
+		o := observation{hazard: survival.cumulativeHazard(), t: float64(s.TimeToEvent) / float64(horizon), failed: s.Observed}
+		if o.hazard <= 0 {
+			continue
+		}
+		if o.failed {
+			failures++
+		}
+		observations = append(observations, o)
+	}
+	if failures == 0 {
+		return 1
+	}
+
+	best, bestLL := 1.0, math.Inf(-1)
+	for k := 0.5; k <= 3.0001; k += 0.05 {
+		ll := 0.0
+		for _, o := range observations {
+			cumulative := o.hazard * math.Pow(o.t, k)
+			ll -= cumulative
+			if o.failed {
+				ll += math.Log(o.hazard*k) + (k-1)*math.Log(o.t)
+			}
+		}
+		if ll > bestLL {
+			best, bestLL = k, ll
+		}
+	}
+	return math.Round(best*100) / 100
+}
@@ -0,0 +1,373 @@
+package prediction
+
+import (
+	"encoding/csv"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+	"go.uber.org/zap"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Label events.
+const (
+	EventFailure     = "failure"
+	EventReplacement = "replacement"
+)
+
+// Label records that a device failed or was replaced. Both end the device's life: history
+// recorded under the same ID afterwards belongs to its replacement.
+type Label struct {
+	DeviceType enum.DeviceType
+	DeviceID   string
+	Event      string
+	Time       time.Time
+}
+
+// LoadLabels reads labels from a CSV file with device_type, device_id, event and RFC 3339
+// timestamp columns. A header row is optional.
+func LoadLabels(path string) ([]Label, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open labels file")
+	}
+	defer f.Close()
+	return ReadLabels(f)
+}
+
+// ReadLabels reads labels in the format LoadLabels expects.
+func ReadLabels(r io.Reader) ([]Label, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var labels []Label
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return labels, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse labels")
+		}
+		if line == 1 && record[0] == "device_type" {
+			continue
+		}
+		deviceType, err := enum.ParseDeviceType(record[0])
+		if err != nil {
+			return nil, errors.New("labels line "+strconv.Itoa(line)+": invalid device type", err)
+		}
+		event := strings.ToLower(record[2])
+		if event != EventFailure && event != EventReplacement {
+			return nil, errors.New("labels line "+strconv.Itoa(line)+": unknown event "+record[2], nil)
+		}
+		at, err := time.Parse(time.RFC3339, record[3])
+		if err != nil {
+			return nil, errors.New("labels line "+strconv.Itoa(line)+": invalid timestamp", err)
+		}
+		labels = append(labels, Label{DeviceType: deviceType, DeviceID: record[1], Event: event, Time: at})
+	}
+}
+
+// WriteLabels writes labels in the format LoadLabels reads.
+func WriteLabels(w io.Writer, labels []Label) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"device_type", "device_id", "event", "timestamp"}); err != nil {
+		return errors.Wrap(err, "failed to write labels")
+	}
+	for _, l := range labels {
+		if err := writer.Write([]string{l.DeviceType.String(), l.DeviceID, l.Event, l.Time.UTC().Format(time.RFC3339)}); err != nil {
+			return errors.Wrap(err, "failed to write labels")
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return errors.Wrap(err, "failed to write labels")
+	}
+	return nil
+}
+
+// deviceLister is implemented by storages that can enumerate the devices they hold.
+type deviceLister interface {
+	ListDevices(deviceType enum.DeviceType) ([]string, error)
+}
+
+// TrainingConfig defines how training samples are built and evaluated.
+type TrainingConfig struct {
+	DeviceType      enum.DeviceType
+	Devices         []string      // Devices to sample besides labelled ones and those the storage lists
+	History         time.Duration // How far back from now stored history is read, unless From is set
+	From            time.Time     // Start of the stored history read, e.g., of an imported dataset; now minus History when zero
+	To              time.Time     // End of the stored history read; unbounded when zero
+	Lookback        time.Duration // History features are extracted from at each sample
+	Horizon         time.Duration // A sample is positive if its device fails within this horizon
+	Stride          time.Duration // Interval between samples of one device
+	MinHistory      time.Duration // History a device needs before its first sample
+	HoldoutFraction float64       // Most recent share of samples held out for evaluation
+	Threshold       float64       // Probability at which a holdout sample counts as predicted to fail
+	Balance         bool          // Weight both classes equally, then correct the intercept for the true failure rate
+	Stage           ModelStatus   // Status the model is registered with: active (when empty), shadow or candidate
+	Train           TrainConfig
+}
+
+// DefaultTrainingConfig returns the default training configuration for a device type.
+func DefaultTrainingConfig(deviceType enum.DeviceType) TrainingConfig {
+	return TrainingConfig{
+		DeviceType:      deviceType,
+		History:         365 * 24 * time.Hour,
+		Lookback:        30 * 24 * time.Hour,
+		Horizon:         30 * 24 * time.Hour,
+		Stride:          24 * time.Hour,
+		MinHistory:      24 * time.Hour,
+		HoldoutFraction: 0.2,
+		Threshold:       0.5,
+		Balance:         true,
+		Stage:           ModelActive,
+		Train:           DefaultTrainConfig(),
+	}
+}
+
+// Sample is a training example taken from one device at one point in time.
+type Sample struct {
+	Example
+	DeviceID    string
+	At          time.Time
+	TimeToEvent time.Duration // Until the device failed, or until it was last seen if Observed is false
+	Observed    bool          // Whether the device's failure was observed
+}
+
+// BuildSamples samples every device's history at Stride intervals, labelling a sample
+// positive when the device failed or was replaced within Horizon of it. Samples whose
+// horizon extends past now without an event are skipped, as their outcome is unknown.
+func BuildSamples(store storage.Storage, labels []Label, config TrainingConfig, now time.Time) ([]Sample, error) {
+	if config.Stride <= 0 || config.Horizon <= 0 || config.Lookback <= 0 {
+		return nil, errors.New("stride, horizon and lookback must be positive", nil)
+	}
+
+	events := make(map[string][]time.Time)
+	for _, l := range labels {
+		if l.DeviceType == config.DeviceType {
+			events[l.DeviceID] = append(events[l.DeviceID], l.Time)
+		}
+	}
+	devices := append([]string(nil), config.Devices...)
+	for id := range events {
+		devices = append(devices, id)
+	}
+	if lister, ok := store.(deviceLister); ok {
+		listed, err := lister.ListDevices(config.DeviceType)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, listed...)
+	}
+	sort.Strings(devices)
+
+	from := config.From
+	if from.IsZero() {
+		from = now.Add(-config.History)
+	}
+	var samples []Sample
+	for i, id := range devices {
+		if i > 0 && devices[i-1] == id {
+			continue
+		}
+		history, err := store.QueryRange(config.DeviceType, id, from, config.To)
+		if err != nil {
+			return nil, errors.NewStorageFailure("failed to query historical data", err)
+		}
+		sort.SliceStable(history, func(a, b int) bool { return history[a].Timestamp.Before(history[b].Timestamp) })
+		deviceEvents := events[id]
+		sort.Slice(deviceEvents, func(a, b int) bool { return deviceEvents[a].Before(deviceEvents[b]) })
+
+		// Each event ends one device's life; history after it starts a new segment.
+		start := 0
+		for e := 0; e <= len(deviceEvents); e++ {
+			end := len(history)
+			var event *time.Time
+			if e < len(deviceEvents) {
+				event = &deviceEvents[e]
+				end = sort.Search(len(history), func(k int) bool { return history[k].Timestamp.After(*event) })
+			}
+			segment, err := sampleSegment(id, history[start:end], event, config, now)
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, segment...)
+			start = end
+		}
+	}
+	return samples, nil
+}
+
+// sampleSegment samples one device lifetime, which ended with event if it is not nil.
+func sampleSegment(id string, history []storage.Metric, event *time.Time, config TrainingConfig, now time.Time) ([]Sample, error) {
+	if len(history) == 0 {
+		return nil, nil
+	}
+	last := history[len(history)-1].Timestamp
+	if event != nil && event.Before(last) {
+		last = *event
+	}
+
+	var samples []Sample
+	from := 0
+	for at := history[0].Timestamp.Add(config.MinHistory); !at.After(last); at = at.Add(config.Stride) {
+		var failed bool
+		if event != nil {
+			if !at.Before(*event) {
+				break
+			}
+			failed = event.Sub(at) <= config.Horizon
+		} else if at.Add(config.Horizon).After(now) {
+			break
+		}
+
+		for from < len(history) && history[from].Timestamp.Before(at.Add(-config.Lookback)) {
+			from++
+		}
+		to := sort.Search(len(history), func(k int) bool { return history[k].Timestamp.After(at) })
+		features, err := ExtractFeatures(config.DeviceType, history[from:to], at)
+		if err != nil {
+			return nil, err
+		}
+		sample := Sample{
+			Example:     Example{Features: features, Failed: failed},
+			DeviceID:    id,
+			At:          at,
+			TimeToEvent: last.Sub(at),
+		}
+		if event != nil {
+			sample.TimeToEvent = event.Sub(at)
+			sample.Observed = true
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// TrainingReport describes a training run.
+type TrainingReport struct {
+	Model          *LogisticModel
+	Samples        int
+	Positives      int
+	TrainSamples   int
+	HoldoutSamples int
+	Holdout        Evaluation
+	WeibullShape   float64
+	Path           string // Versioned model artifact, empty if the model was not written
+}
+
+// Train builds samples from stored history and labels, fits a model on all but the most
+// recent samples and evaluates it on those. When outputDir is set, the model is added to
+// the registry there with config.Stage.
+func Train(store storage.Storage, labels []Label, config TrainingConfig, outputDir string, now time.Time) (*TrainingReport, error) {
+	samples, err := BuildSamples(store, labels, config, now)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].At.Before(samples[j].At) })
+
+	split := len(samples) - int(math.Ceil(float64(len(samples))*config.HoldoutFraction))
+	for split > 0 && split < len(samples) && samples[split-1].At.Equal(samples[split].At) {
+		split--
+	}
+	train, holdout := samples[:split], samples[split:]
+
+	report := &TrainingReport{Samples: len(samples), TrainSamples: len(train), HoldoutSamples: len(holdout)}
+	positives := 0
+	for _, s := range train {
+		if s.Failed {
+			positives++
+		}
+	}
+	for _, s := range samples {
+		if s.Failed {
+			report.Positives++
+		}
+	}
+	negatives := len(train) - positives
+	if positives == 0 || negatives == 0 {
+		return nil, errors.New("training samples need both failed and healthy devices, got "+
+			strconv.Itoa(positives)+" failed and "+strconv.Itoa(negatives)+" healthy", nil)
+	}
+
+	examples := make([]Example, len(train))
+	for i, s := range train {
+		examples[i] = s.Example
+		if config.Balance {
+			if s.Failed {
+				examples[i].Weight = float64(len(train)) / float64(2*positives)
+			} else {
+				examples[i].Weight = float64(len(train)) / float64(2*negatives)
+			}
+		}
+	}
+	model, err := TrainLogistic(config.DeviceType, examples, config.Train)
+	if err != nil {
+		return nil, err
+	}
+	if config.Balance {
+		// Balanced weights act as an even prior; shift the intercept back to the observed one.
+		model.Bias += math.Log(float64(positives) / float64(negatives))
+	}
+	model.Meta.Horizon = config.Horizon
+	model.Meta.DataFrom, model.Meta.DataTo = train[0].At, train[len(train)-1].At
+	model.Meta.WeibullShape = FitWeibullShape(model, train, config.Horizon)
+	model.stampVersion()
+
+	if len(holdout) > 0 {
+		probabilities := make([]float64, len(holdout))
+		failed := make([]bool, len(holdout))
+		for i, s := range holdout {
+			probabilities[i] = model.Predict(s.Features)
+			failed[i] = s.Failed
+		}
+		report.Holdout = Evaluate(probabilities, failed, config.Threshold)
+		model.Meta.Evaluation = &report.Holdout
+	}
+	report.Model = model
+	report.WeibullShape = model.Meta.WeibullShape
+
+	if outputDir != "" {
+		registry, err := NewRegistry(outputDir)
+		if err != nil {
+			return nil, err
+		}
+		stage := config.Stage
+		if stage == "" {
+			stage = ModelActive
+		}
+		record, err := registry.Register(model, stage)
+		if err != nil {
+			return nil, err
+		}
+		report.Path = filepath.Join(outputDir, record.Path)
+	}
+
+	logger.Info("prediction model trained",
+		zap.String("device_type", config.DeviceType.String()),
+		zap.String("version", model.Meta.Version),
+		zap.Int("samples", report.Samples),
+		zap.Int("positives", report.Positives),
+		zap.Float64("holdout_precision", report.Holdout.Precision),
+		zap.Float64("holdout_recall", report.Holdout.Recall),
+		zap.Float64("holdout_auc", report.Holdout.AUC),
+		zap.Float64("weibull_shape", report.WeibullShape),
+	)
+	return report, nil
+}
+
+// VersionedModelPath returns the file a specific model version is archived in.
+func VersionedModelPath(dir string, deviceType enum.DeviceType, version string) string {
+	return filepath.Join(dir, deviceType.String()+"-"+version+".json")
+}
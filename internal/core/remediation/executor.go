@@ -0,0 +1,185 @@
+package remediation
+
+import (
+	"context"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"go.uber.org/zap"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Step is one change to the system a remediation action is made of: a sysfs attribute
+// write or a command.
+type Step struct {
+	Path    string   // sysfs attribute to write, relative to the sysfs root
+	Value   string   // Value written to Path
+	Command []string // Command to run when Path is empty
+	Undo    *Step    // Reverts the step if a later step of the action fails; nil if it is not reverted
+}
+
+// String describes the step for logs and dry-run reports.
+func (s Step) String() string {
+	if s.Path != "" {
+		return "write " + s.Value + " to " + s.Path
+	}
+	return "run " + strings.Join(s.Command, " ")
+}
+
+// System is the host interface executors read device state from and act through.
+type System interface {
+	ReadFile(path string) (string, error) // Trimmed contents of a sysfs attribute
+	ReadDir(path string) ([]string, error)
+	ReadLink(path string) (string, error)
+	WriteFile(path, value string) error
+	Run(ctx context.Context, name string, args ...string) error
+	Output(ctx context.Context, name string, args ...string) (string, error) // Runs a command that only reads state
+}
+
+// HostSystem implements System on the local host, with sysfs mounted at root.
+type HostSystem struct {
+	root string
+}
+
+// NewHostSystem creates a new HostSystem; root defaults to "/sys".
+func NewHostSystem(root string) *HostSystem {
+	if root == "" {
+		root = "/sys"
+	}
+	return &HostSystem{root: root}
+}
+
+// ReadFile returns the trimmed contents of a sysfs attribute.
+func (s *HostSystem) ReadFile(path string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(s.root, path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ReadDir returns the names of a sysfs directory's entries.
+func (s *HostSystem) ReadDir(path string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, path))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+	return names, nil
+}
+
+// ReadLink returns the target of a sysfs symlink.
+func (s *HostSystem) ReadLink(path string) (string, error) {
+	return os.Readlink(filepath.Join(s.root, path))
+}
+
+// WriteFile writes a sysfs attribute.
+func (s *HostSystem) WriteFile(path, value string) error {
+	return os.WriteFile(filepath.Join(s.root, path), []byte(value), 0644)
+}
+
+// Run runs a command, including its output in the error when it fails.
+func (s *HostSystem) Run(ctx context.Context, name string, args ...string) error {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return errors.New(name+" failed: "+strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// Output runs a command and returns its standard output.
+func (s *HostSystem) Output(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return "", errors.New(name+" failed", err)
+	}
+	return string(out), nil
+}
+
+// Executor carries out isolation on one device type. Executors inspect the device to plan
+// the steps and, unless dryRun is set, apply them; either way the planned steps are returned.
+type Executor interface {
+	Isolate(ctx context.Context, deviceID string, strategy enum.IsolationStrategy, dryRun bool) ([]Step, error)
+	Restore(ctx context.Context, deviceID string, dryRun bool) ([]Step, error)
+}
+
+// Throttler is implemented by executors that keep devices in service and only limit their
+// I/O, for some or all isolation strategies, and by the remediators using them. Their
+// actions are reported and recorded as throttling, are not held back by redundancy
+// guardrails, and do not count against isolation rate limits.
+type Throttler interface {
+	Throttles(strategy enum.IsolationStrategy) bool
+}
+
+// PartialError reports an action that failed after some of its steps were applied, and
+// whose applied steps could not all be undone: the device is left partially changed.
+type PartialError struct {
+	Applied  []Step // Steps applied before the failure
+	Err      error  // Failure of the action
+	Rollback error  // Failure undoing the applied steps
+}
+
+// Error implements the error interface.
+func (e *PartialError) Error() string {
+	return e.Err.Error() + "; undoing applied steps failed: " + e.Rollback.Error()
+}
+
+// Unwrap returns the failure of the action.
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// apply applies steps in order, stopping at the first failure; with dryRun it only logs them.
+// On failure the steps applied so far are returned and undone in reverse order; a
+// *PartialError is returned if undoing them fails.
+func apply(ctx context.Context, system System, deviceID string, steps []Step, dryRun bool) ([]Step, error) {
+	for i, step := range steps {
+		if dryRun {
+			logger.Info("dry run: remediation step skipped", zap.String("device_id", deviceID), zap.String("step", step.String()))
+			continue
+		}
+		if err := run(ctx, system, step); err != nil {
+			err = errors.New("remediation step failed: "+step.String(), err)
+			if rollbackErr := undo(ctx, system, deviceID, steps[:i]); rollbackErr != nil {
+				return steps[:i], &PartialError{Applied: steps[:i], Err: err, Rollback: rollbackErr}
+			}
+			return steps[:i], err
+		}
+		logger.Info("remediation step applied", zap.String("device_id", deviceID), zap.String("step", step.String()))
+	}
+	return steps, nil
+}
+
+// undo reverts applied steps in reverse order, returning the first failure.
+func undo(ctx context.Context, system System, deviceID string, applied []Step) error {
+	var first error
+	for i := len(applied) - 1; i >= 0; i-- {
+		if applied[i].Undo == nil {
+			continue
+		}
+		step := *applied[i].Undo
+		if err := run(ctx, system, step); err != nil {
+			logger.Error("failed to undo remediation step", zap.String("device_id", deviceID), zap.String("step", step.String()), zap.Error(err))
+			if first == nil {
+				first = errors.New("undo step failed: "+step.String(), err)
+			}
+			continue
+		}
+		logger.Info("remediation step undone", zap.String("device_id", deviceID), zap.String("step", step.String()))
+	}
+	return first
+}
+
+// run applies a single step.
+func run(ctx context.Context, system System, step Step) error {
+	if step.Path != "" {
+		return system.WriteFile(step.Path, step.Value)
+	}
+	return system.Run(ctx, step.Command[0], step.Command[1:]...)
+}
@@ -0,0 +1,628 @@
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// validDeviceName guards sysfs paths and commands built from device IDs.
+var validDeviceName = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+func checkDeviceName(name string) error {
+	if !validDeviceName.MatchString(name) || name == "." || name == ".." {
+		return errors.New("invalid device name "+name, nil)
+	}
+	return nil
+}
+
+// mdMember is a block device that belongs to a Linux software RAID array.
+type mdMember struct {
+	array, device string
+}
+
+// DiskExecutor isolates disks: members of md arrays are failed out of their arrays, other
+// disks are set offline through sysfs.
+type DiskExecutor struct {
+	system System
+}
+
+// NewDiskExecutor creates a new DiskExecutor.
+func NewDiskExecutor(system System) *DiskExecutor {
+	return &DiskExecutor{system: system}
+}
+
+// Isolate fails the disk, or its partitions, out of their md arrays, also removing them
+// for permanent isolation. Disks outside md arrays are set offline.
+func (e *DiskExecutor) Isolate(ctx context.Context, deviceID string, strategy enum.IsolationStrategy, dryRun bool) ([]Step, error) {
+	members, err := e.mdMembers(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return apply(ctx, e.system, deviceID, []Step{{
+			Path: "block/" + deviceID + "/device/state", Value: "offline",
+			Undo: &Step{Path: "block/" + deviceID + "/device/state", Value: "running"},
+		}}, dryRun)
+	}
+	var steps []Step
+	for _, m := range members {
+		steps = append(steps, Step{
+			Command: []string{"mdadm", "/dev/" + m.array, "--fail", "/dev/" + m.device},
+			Undo:    &Step{Command: []string{"mdadm", "/dev/" + m.array, "--re-add", "/dev/" + m.device}},
+		})
+		if strategy == enum.Permanent {
+			steps = append(steps, Step{Command: []string{"mdadm", "/dev/" + m.array, "--remove", "/dev/" + m.device}})
+		}
+	}
+	return apply(ctx, e.system, deviceID, steps, dryRun)
+}
+
+// Restore re-adds the disk to its md arrays or sets it running again. Disks removed from
+// their arrays by permanent isolation are no longer members and must be re-added manually.
+func (e *DiskExecutor) Restore(ctx context.Context, deviceID string, dryRun bool) ([]Step, error) {
+	members, err := e.mdMembers(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	var steps []Step
+	for _, m := range members {
+		steps = append(steps, Step{Command: []string{"mdadm", "/dev/" + m.array, "--re-add", "/dev/" + m.device}})
+	}
+	if len(members) == 0 {
+		state, err := e.system.ReadFile("block/" + deviceID + "/device/state")
+		if err != nil {
+			return nil, errors.New("failed to read state of disk "+deviceID, err)
+		}
+		if state != "running" {
+			steps = append(steps, Step{Path: "block/" + deviceID + "/device/state", Value: "running"})
+		}
+	}
+	return apply(ctx, e.system, deviceID, steps, dryRun)
+}
+
+// Probe reads the start of the disk with direct I/O. A disk set offline is set running
+// for the read and offline again afterwards; md members stay failed out of their arrays.
+// Offline disks with holders are not probed, since a running disk is put back into use
+// by multipath or whatever else is stacked on it.
+func (e *DiskExecutor) Probe(ctx context.Context, deviceID string) (err error) {
+	if err := checkDeviceName(deviceID); err != nil {
+		return err
+	}
+	statePath := "block/" + deviceID + "/device/state"
+	if state, _ := e.system.ReadFile(statePath); state == "offline" {
+		if holders, _ := e.system.ReadDir("block/" + deviceID + "/holders"); len(holders) > 0 {
+			return errors.New("refusing to probe offline disk "+deviceID+" held by "+strings.Join(holders, ", "), nil)
+		}
+		if err := e.system.WriteFile(statePath, "running"); err != nil {
+			return errors.New("failed to set disk "+deviceID+" running for probing", err)
+		}
+		defer func() {
+			if offlineErr := e.system.WriteFile(statePath, "offline"); offlineErr != nil {
+				err = errors.New("failed to set disk "+deviceID+" offline after probing", offlineErr)
+			}
+		}()
+	}
+	if err := e.system.Run(ctx, "dd", "if=/dev/"+deviceID, "of=/dev/null", "bs=1M", "count=64", "iflag=direct"); err != nil {
+		return errors.New("read test of disk "+deviceID+" failed", err)
+	}
+	return nil
+}
+
+// Isolated reports whether the disk, or one of its partitions, is failed out of an md
+// array, or the disk is set offline. Members removed from their arrays are not detected.
+func (e *DiskExecutor) Isolated(ctx context.Context, deviceID string) (bool, error) {
+	members, err := e.mdMembers(deviceID)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range members {
+		state, err := e.system.ReadFile("block/" + m.array + "/md/dev-" + m.device + "/state")
+		if err != nil {
+			return false, errors.New("failed to read state of "+m.device+" in md array "+m.array, err)
+		}
+		if strings.Contains(state, "faulty") {
+			return true, nil
+		}
+	}
+	if len(members) > 0 {
+		return false, nil
+	}
+	state, err := e.system.ReadFile("block/" + deviceID + "/device/state")
+	if err != nil {
+		return false, errors.New("failed to read state of disk "+deviceID, err)
+	}
+	return state == "offline", nil
+}
+
+// mdMembers returns the md arrays holding the disk or its partitions.
+func (e *DiskExecutor) mdMembers(deviceID string) ([]mdMember, error) {
+	if err := checkDeviceName(deviceID); err != nil {
+		return nil, err
+	}
+	entries, err := e.system.ReadDir("block/" + deviceID)
+	if err != nil {
+		return nil, errors.NewNotFound("disk "+deviceID+" not found", err)
+	}
+	devices := []string{deviceID}
+	for _, name := range entries {
+		if strings.HasPrefix(name, deviceID) && name != deviceID {
+			devices = append(devices, name)
+		}
+	}
+
+	var members []mdMember
+	for _, device := range devices {
+		dir := "block/" + deviceID + "/holders"
+		if device != deviceID {
+			dir = "block/" + deviceID + "/" + device + "/holders"
+		}
+		holders, _ := e.system.ReadDir(dir)
+		sort.Strings(holders)
+		for _, h := range holders {
+			if strings.HasPrefix(h, "md") {
+				members = append(members, mdMember{array: h, device: device})
+			}
+		}
+	}
+	return members, nil
+}
+
+// Groups returns the md arrays and multipath devices the disk is a member or path of.
+func (e *DiskExecutor) Groups(ctx context.Context, deviceID string) ([]RedundancyGroup, error) {
+	members, err := e.mdMembers(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	var groups []RedundancyGroup
+	seen := make(map[string]bool)
+	for _, m := range members {
+		if seen[m.array] {
+			continue
+		}
+		seen[m.array] = true
+		g, err := e.mdGroup(m.array)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+
+	holders, _ := e.system.ReadDir("block/" + deviceID + "/holders")
+	sort.Strings(holders)
+	for _, h := range holders {
+		if !strings.HasPrefix(h, "dm-") {
+			continue
+		}
+		if uuid, _ := e.system.ReadFile("block/" + h + "/dm/uuid"); !strings.HasPrefix(uuid, "mpath-") {
+			continue
+		}
+		g := RedundancyGroup{Kind: GroupMultipath, Name: h}
+		if name, err := e.system.ReadFile("block/" + h + "/dm/name"); err == nil {
+			g.Name = name
+		}
+		paths, err := e.system.ReadDir("block/" + h + "/slaves")
+		if err != nil {
+			return nil, errors.New("failed to list paths of multipath device "+g.Name, err)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			state, _ := e.system.ReadFile("block/" + path + "/device/state")
+			g.Members = append(g.Members, GroupMember{ID: path, Device: path, Healthy: state == "running"})
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// mdGroup reads an md array's level, members and their states.
+func (e *DiskExecutor) mdGroup(array string) (RedundancyGroup, error) {
+	g := RedundancyGroup{Kind: GroupMD, Name: array}
+	g.Level, _ = e.system.ReadFile("block/" + array + "/md/level")
+	action, _ := e.system.ReadFile("block/" + array + "/md/sync_action")
+	g.Recovering = action == "recover"
+	entries, err := e.system.ReadDir("block/" + array + "/md")
+	if err != nil {
+		return g, errors.New("failed to list members of md array "+array, err)
+	}
+	sort.Strings(entries)
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry, "dev-") {
+			continue
+		}
+		member := strings.TrimPrefix(entry, "dev-")
+		state, _ := e.system.ReadFile("block/" + array + "/md/" + entry + "/state")
+		g.Members = append(g.Members, GroupMember{
+			ID:      member,
+			Device:  e.parentDisk(member),
+			Healthy: strings.Contains(state, "in_sync") && !strings.Contains(state, "faulty"),
+		})
+	}
+	return g, nil
+}
+
+// parentDisk returns the disk a partition is on, or the device itself if it is a disk.
+func (e *DiskExecutor) parentDisk(device string) string {
+	if _, err := e.system.ReadDir("block/" + device); err == nil {
+		return device
+	}
+	link, err := e.system.ReadLink("class/block/" + device)
+	if err != nil {
+		return device
+	}
+	return filepath.Base(filepath.Dir(link))
+}
+
+// NetworkExecutor isolates bonded network interfaces by taking them down, so the bond
+// fails over to its other slaves.
+type NetworkExecutor struct {
+	system System
+}
+
+// NewNetworkExecutor creates a new NetworkExecutor.
+func NewNetworkExecutor(system System) *NetworkExecutor {
+	return &NetworkExecutor{system: system}
+}
+
+// Isolate takes a bond slave down. Interfaces outside a bond are refused, as taking them
+// down would cut their connectivity.
+func (e *NetworkExecutor) Isolate(ctx context.Context, deviceID string, strategy enum.IsolationStrategy, dryRun bool) ([]Step, error) {
+	if _, err := e.bond(deviceID); err != nil {
+		return nil, err
+	}
+	return apply(ctx, e.system, deviceID, []Step{{Command: []string{"ip", "link", "set", "dev", deviceID, "down"}}}, dryRun)
+}
+
+// Restore brings a bond slave back up.
+func (e *NetworkExecutor) Restore(ctx context.Context, deviceID string, dryRun bool) ([]Step, error) {
+	if _, err := e.bond(deviceID); err != nil {
+		return nil, err
+	}
+	return apply(ctx, e.system, deviceID, []Step{{Command: []string{"ip", "link", "set", "dev", deviceID, "up"}}}, dryRun)
+}
+
+// Probe runs the interface's online self-test, which checks the NIC and its link without
+// bringing the interface up.
+func (e *NetworkExecutor) Probe(ctx context.Context, deviceID string) error {
+	if _, err := e.bond(deviceID); err != nil {
+		return err
+	}
+	out, err := e.system.Output(ctx, "ethtool", "-t", deviceID, "online")
+	if err != nil {
+		return errors.New("link test of "+deviceID+" failed", err)
+	}
+	if !strings.Contains(out, "result is PASS") {
+		return errors.New("link test of "+deviceID+" did not pass", nil)
+	}
+	return nil
+}
+
+// Isolated reports whether the interface is administratively down.
+func (e *NetworkExecutor) Isolated(ctx context.Context, deviceID string) (bool, error) {
+	if err := checkDeviceName(deviceID); err != nil {
+		return false, err
+	}
+	flags, err := e.system.ReadFile("class/net/" + deviceID + "/flags")
+	if err != nil {
+		return false, errors.NewNotFound("network interface "+deviceID+" not found", err)
+	}
+	value, err := strconv.ParseUint(strings.TrimPrefix(flags, "0x"), 16, 32)
+	if err != nil {
+		return false, errors.New("invalid flags "+flags+" of network interface "+deviceID, err)
+	}
+	return value&0x1 == 0, nil // IFF_UP
+}
+
+// Groups returns the bond the interface is a slave of.
+func (e *NetworkExecutor) Groups(ctx context.Context, deviceID string) ([]RedundancyGroup, error) {
+	bond, err := e.bond(deviceID)
+	if err != nil {
+		return nil, nil
+	}
+	slaves, err := e.system.ReadFile("class/net/" + bond + "/bonding/slaves")
+	if err != nil {
+		return nil, errors.New("failed to list slaves of bond "+bond, err)
+	}
+	g := RedundancyGroup{Kind: GroupBond, Name: bond}
+	for _, slave := range strings.Fields(slaves) {
+		state, _ := e.system.ReadFile("class/net/" + slave + "/operstate")
+		g.Members = append(g.Members, GroupMember{ID: slave, Device: slave, Healthy: state == "up"})
+	}
+	return []RedundancyGroup{g}, nil
+}
+
+// bond returns the bond an interface is enslaved to.
+func (e *NetworkExecutor) bond(deviceID string) (string, error) {
+	if err := checkDeviceName(deviceID); err != nil {
+		return "", err
+	}
+	if _, err := e.system.ReadDir("class/net/" + deviceID); err != nil {
+		return "", errors.NewNotFound("network interface "+deviceID+" not found", err)
+	}
+	master, err := e.system.ReadLink("class/net/" + deviceID + "/master")
+	if err == nil {
+		master = filepath.Base(master)
+		if _, err = e.system.ReadDir("class/net/" + master + "/bonding"); err == nil {
+			return master, nil
+		}
+	}
+	return "", errors.New("network interface "+deviceID+" is not a bond slave; taking it down would cut its connectivity", nil)
+}
+
+// RAIDBackend builds the controller management commands that take physical drives offline
+// and bring them back online.
+type RAIDBackend interface {
+	OfflineCommand(controller, drive string) ([]string, error)
+	OnlineCommand(controller, drive string) ([]string, error)
+	// DriveGroups returns the redundancy groups of the virtual drives a drive backs.
+	DriveGroups(ctx context.Context, system System, controller, drive string) ([]RedundancyGroup, error)
+	// ProbeDrive checks that an offline drive is still fit to be brought back online.
+	ProbeDrive(ctx context.Context, system System, controller, drive string) error
+	// DriveOffline reports whether the drive is set offline.
+	DriveOffline(ctx context.Context, system System, controller, drive string) (bool, error)
+}
+
+// storcliDrive matches StorCLI drive addresses, e.g., "e252/s3" or "s3" without an enclosure.
+var storcliDrive = regexp.MustCompile(`^(e[0-9]+/)?s[0-9]+$`)
+
+// StorCLIBackend implements RAIDBackend with Broadcom's StorCLI.
+type StorCLIBackend struct {
+	binary      string
+	controllers map[string]string // StorCLI controller index by controller ID, e.g., "host0" → "0"
+}
+
+// NewStorCLIBackend creates a new StorCLIBackend; binary defaults to "storcli64".
+// Controllers map controller IDs to StorCLI controller indexes, as SCSI host numbers and
+// StorCLI indexes need not agree.
+func NewStorCLIBackend(binary string, controllers map[string]string) *StorCLIBackend {
+	if binary == "" {
+		binary = "storcli64"
+	}
+	return &StorCLIBackend{binary: binary, controllers: controllers}
+}
+
+// OfflineCommand returns the command that sets a drive offline.
+func (b *StorCLIBackend) OfflineCommand(controller, drive string) ([]string, error) {
+	return b.command(controller, drive, "offline")
+}
+
+// OnlineCommand returns the command that sets a drive online.
+func (b *StorCLIBackend) OnlineCommand(controller, drive string) ([]string, error) {
+	return b.command(controller, drive, "online")
+}
+
+func (b *StorCLIBackend) command(controller, drive, state string) ([]string, error) {
+	object, err := b.object(controller, drive)
+	if err != nil {
+		return nil, err
+	}
+	return []string{b.binary, object, "set", state}, nil
+}
+
+// object returns the StorCLI object of a drive, e.g., "/c0/e252/s3".
+func (b *StorCLIBackend) object(controller, drive string) (string, error) {
+	index, ok := b.controllers[controller]
+	if !ok {
+		return "", errors.NewNotFound("no StorCLI controller index configured for "+controller, nil)
+	}
+	if !storcliDrive.MatchString(drive) {
+		return "", errors.New("invalid StorCLI drive address "+drive+", want e<enclosure>/s<slot>", nil)
+	}
+	return "/c" + index + "/" + drive, nil
+}
+
+// storcliResponse is the part of StorCLI's JSON output the backend reads.
+type storcliResponse struct {
+	Controllers []struct {
+		CommandStatus struct {
+			Status      string `json:"Status"`
+			Description string `json:"Description"`
+		} `json:"Command Status"`
+		ResponseData struct {
+			Drives []struct {
+				Slot  string          `json:"EID:Slt"`
+				State string          `json:"State"`
+				Group json.RawMessage `json:"DG"` // A number, or "-" for unconfigured drives
+			} `json:"Drive Information"`
+			VirtualDrives []struct {
+				ID    string `json:"DG/VD"`
+				Type  string `json:"TYPE"`
+				State string `json:"State"`
+			} `json:"Virtual Drives"`
+		} `json:"Response Data"`
+	} `json:"Controllers"`
+}
+
+// DriveGroups returns the drive group the drive belongs to, with the RAID level of its
+// virtual drives. Unconfigured drives belong to none.
+func (b *StorCLIBackend) DriveGroups(ctx context.Context, system System, controller, drive string) ([]RedundancyGroup, error) {
+	index, ok := b.controllers[controller]
+	if !ok {
+		return nil, errors.NewNotFound("no StorCLI controller index configured for "+controller, nil)
+	}
+	var drives, vds storcliResponse
+	if err := b.show(ctx, system, "/c"+index+"/eall/sall", &drives); err != nil {
+		return nil, err
+	}
+	if err := b.show(ctx, system, "/c"+index+"/vall", &vds); err != nil {
+		return nil, err
+	}
+
+	address := func(slot string) string {
+		parts := strings.SplitN(slot, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return "s" + strings.TrimSpace(parts[len(parts)-1])
+		}
+		return "e" + strings.TrimSpace(parts[0]) + "/s" + strings.TrimSpace(parts[1])
+	}
+	group := ""
+	members := make(map[string][]GroupMember)
+	rebuilding := make(map[string]bool)
+	for _, c := range drives.Controllers {
+		for _, d := range c.ResponseData.Drives {
+			dg := strings.Trim(string(d.Group), `"`)
+			if dg == "" || dg == "-" {
+				continue
+			}
+			a := address(d.Slot)
+			if a == drive {
+				group = dg
+			}
+			members[dg] = append(members[dg], GroupMember{ID: a, Device: controller + "/" + a, Healthy: d.State == "Onln"})
+			rebuilding[dg] = rebuilding[dg] || d.State == "Rbld"
+		}
+	}
+	if group == "" {
+		return nil, nil
+	}
+
+	g := RedundancyGroup{Kind: GroupRAIDVD, Name: controller + "/dg" + group, Members: members[group], Recovering: rebuilding[group]}
+	for _, c := range vds.Controllers {
+		for _, vd := range c.ResponseData.VirtualDrives {
+			if strings.SplitN(vd.ID, "/", 2)[0] == group {
+				g.Level = strings.ToLower(vd.Type)
+				g.Recovering = g.Recovering || vd.State == "Rec"
+			}
+		}
+	}
+	return []RedundancyGroup{g}, nil
+}
+
+// ProbeDrive queries the drive's state; drives the controller marked failed or bad fail
+// the probe.
+func (b *StorCLIBackend) ProbeDrive(ctx context.Context, system System, controller, drive string) error {
+	state, err := b.driveState(ctx, system, controller, drive)
+	if err != nil {
+		return err
+	}
+	if state == "Failed" || state == "UBad" {
+		return errors.New("drive "+controller+"/"+drive+" is in state "+state, nil)
+	}
+	return nil
+}
+
+// DriveOffline reports whether the controller reports the drive offline.
+func (b *StorCLIBackend) DriveOffline(ctx context.Context, system System, controller, drive string) (bool, error) {
+	state, err := b.driveState(ctx, system, controller, drive)
+	if err != nil {
+		return false, err
+	}
+	return state == "Offln", nil
+}
+
+// driveState returns the drive's state, e.g., "Onln" or "Offln".
+func (b *StorCLIBackend) driveState(ctx context.Context, system System, controller, drive string) (string, error) {
+	object, err := b.object(controller, drive)
+	if err != nil {
+		return "", err
+	}
+	var response storcliResponse
+	if err := b.show(ctx, system, object, &response); err != nil {
+		return "", err
+	}
+	for _, c := range response.Controllers {
+		for _, d := range c.ResponseData.Drives {
+			return d.State, nil
+		}
+	}
+	return "", errors.NewNotFound("drive "+controller+"/"+drive+" not reported by "+b.binary, nil)
+}
+
+// show runs a StorCLI show command with JSON output.
+func (b *StorCLIBackend) show(ctx context.Context, system System, object string, response *storcliResponse) error {
+	out, err := system.Output(ctx, b.binary, object, "show", "J")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(out), response); err != nil {
+		return errors.New("failed to parse "+b.binary+" output", err)
+	}
+	for _, c := range response.Controllers {
+		if c.CommandStatus.Status != "" && c.CommandStatus.Status != "Success" {
+			return errors.New(fmt.Sprintf("%s %s show failed: %s", b.binary, object, c.CommandStatus.Description), nil)
+		}
+	}
+	return nil
+}
+
+// RAIDExecutor isolates physical drives behind hardware RAID controllers through the
+// controller's management backend. Drives are addressed as <controller>/<drive>, e.g.,
+// "host0/e252/s3"; controllers themselves cannot be isolated.
+type RAIDExecutor struct {
+	system  System
+	backend RAIDBackend
+}
+
+// NewRAIDExecutor creates a new RAIDExecutor.
+func NewRAIDExecutor(system System, backend RAIDBackend) *RAIDExecutor {
+	return &RAIDExecutor{system: system, backend: backend}
+}
+
+// Isolate sets the drive offline.
+func (e *RAIDExecutor) Isolate(ctx context.Context, deviceID string, strategy enum.IsolationStrategy, dryRun bool) ([]Step, error) {
+	controller, drive, err := SplitRAIDDrive(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	command, err := e.backend.OfflineCommand(controller, drive)
+	if err != nil {
+		return nil, err
+	}
+	return apply(ctx, e.system, deviceID, []Step{{Command: command}}, dryRun)
+}
+
+// Restore sets the drive online.
+func (e *RAIDExecutor) Restore(ctx context.Context, deviceID string, dryRun bool) ([]Step, error) {
+	controller, drive, err := SplitRAIDDrive(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	command, err := e.backend.OnlineCommand(controller, drive)
+	if err != nil {
+		return nil, err
+	}
+	return apply(ctx, e.system, deviceID, []Step{{Command: command}}, dryRun)
+}
+
+// Groups returns the drive group the drive belongs to.
+func (e *RAIDExecutor) Groups(ctx context.Context, deviceID string) ([]RedundancyGroup, error) {
+	controller, drive, err := SplitRAIDDrive(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return e.backend.DriveGroups(ctx, e.system, controller, drive)
+}
+
+// Probe checks the drive through the controller's management backend.
+func (e *RAIDExecutor) Probe(ctx context.Context, deviceID string) error {
+	controller, drive, err := SplitRAIDDrive(deviceID)
+	if err != nil {
+		return err
+	}
+	return e.backend.ProbeDrive(ctx, e.system, controller, drive)
+}
+
+// Isolated reports whether the drive is offline.
+func (e *RAIDExecutor) Isolated(ctx context.Context, deviceID string) (bool, error) {
+	controller, drive, err := SplitRAIDDrive(deviceID)
+	if err != nil {
+		return false, err
+	}
+	return e.backend.DriveOffline(ctx, e.system, controller, drive)
+}
+
+// SplitRAIDDrive splits a physical drive ID into its controller and its drive address.
+func SplitRAIDDrive(deviceID string) (controller, drive string, err error) {
+	i := strings.Index(deviceID, "/")
+	if i <= 0 || i == len(deviceID)-1 {
+		return "", "", errors.New("RAID isolation needs a physical drive as <controller>/<drive>, got "+deviceID, nil)
+	}
+	return deviceID[:i], deviceID[i+1:], nil
+}
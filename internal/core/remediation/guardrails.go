@@ -0,0 +1,170 @@
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// GroupKind is the kind of redundancy a group of devices provides.
+type GroupKind string
+
+// Redundancy group kinds.
+const (
+	GroupBond      GroupKind = "bond"      // Network interfaces bonded together
+	GroupMD        GroupKind = "md"        // Members of a Linux software RAID array
+	GroupMultipath GroupKind = "multipath" // Paths to the same multipath device
+	GroupRAIDVD    GroupKind = "raid_vd"   // Physical drives backing hardware RAID virtual drives
+)
+
+// GroupMember is one member of a redundancy group.
+type GroupMember struct {
+	ID      string // Member as the group knows it, e.g., "sdb1" in an md array
+	Device  string // Device isolating which takes the member out, e.g., "sdb"
+	Healthy bool   // In service
+}
+
+// RedundancyGroup is a set of devices that back each other up.
+type RedundancyGroup struct {
+	Kind       GroupKind
+	Name       string
+	Level      string // RAID level, e.g., "raid1", for md arrays and RAID virtual drives
+	Members    []GroupMember
+	Recovering bool // A member is being rebuilt, so redundancy will rise again
+}
+
+// tolerance returns how many more members the group can lose with healthy members left in
+// service before it fails; negative once it has failed.
+func (g RedundancyGroup) tolerance(healthy int) int {
+	total := len(g.Members)
+	switch g.Kind {
+	case GroupBond, GroupMultipath:
+		return healthy - 1
+	}
+	switch strings.ToLower(g.Level) {
+	case "raid1":
+		return healthy - 1
+	case "raid4", "raid5", "raid10", "raid50":
+		return healthy - (total - 1)
+	case "raid6", "raid60":
+		return healthy - (total - 2)
+	default: // raid0, linear and unknown levels tolerate no loss
+		return healthy - total
+	}
+}
+
+// GroupResolver is implemented by executors that know the redundancy groups a device
+// belongs to.
+type GroupResolver interface {
+	Groups(ctx context.Context, deviceID string) ([]RedundancyGroup, error)
+}
+
+// Guardrail rules.
+const (
+	RuleMinHealthyPaths    = "min_healthy_paths"
+	RulePreservePathsRatio = "preserve_paths_ratio"
+	RuleRedundancyFloor    = "redundancy_floor"
+)
+
+// Violation is a limit an isolation would breach in one redundancy group.
+type Violation struct {
+	Group   RedundancyGroup
+	Rule    string
+	Limit   float64 // Lowest allowed value
+	Actual  float64 // Value after the isolation
+	Message string
+}
+
+// Decision is the guardrails' verdict on a blocked isolation.
+type Decision string
+
+// Guardrail decisions.
+const (
+	DecisionRefused  Decision = "refused"  // The isolation breaches a limit
+	DecisionDeferred Decision = "deferred" // The isolation breaches a limit only until a rebuild completes
+)
+
+// Refusal explains why an isolation was blocked.
+type Refusal struct {
+	DeviceID   string
+	Decision   Decision
+	Violations []Violation
+}
+
+// Explain renders the refusal for operators.
+func (r Refusal) Explain() string {
+	messages := make([]string, len(r.Violations))
+	for i, v := range r.Violations {
+		messages[i] = v.Message
+	}
+	action := "isolation of " + r.DeviceID + " refused"
+	if r.Decision == DecisionDeferred {
+		action = "isolation of " + r.DeviceID + " deferred until the rebuild completes"
+	}
+	return action + ": " + strings.Join(messages, "; ")
+}
+
+// checkGuardrails returns the refusal for isolating a device from its redundancy groups,
+// or nil if the isolation is safe. Every group must keep MinHealthyPaths healthy members,
+// PreservePathsRatio of its members healthy, and the ability to lose MinRedundancy more
+// members; the last healthy member of a group is never isolated. A device in no group has
+// nothing backing it up, so it tolerates no loss and is never isolated either.
+func checkGuardrails(config *Config, deviceID string, groups []RedundancyGroup) *Refusal {
+	refusal := &Refusal{DeviceID: deviceID, Decision: DecisionDeferred}
+	member := false
+	for _, g := range groups {
+		healthy, affected := 0, false
+		for _, m := range g.Members {
+			if m.Device == deviceID || m.ID == deviceID {
+				affected = true
+			} else if m.Healthy {
+				healthy++
+			}
+		}
+		if !affected || len(g.Members) == 0 {
+			continue
+		}
+		member = true
+
+		var violations []Violation
+		name := string(g.Kind) + " " + g.Name
+		if config.MinHealthyPaths > 0 && healthy < config.MinHealthyPaths {
+			violations = append(violations, Violation{
+				Group: g, Rule: RuleMinHealthyPaths, Limit: float64(config.MinHealthyPaths), Actual: float64(healthy),
+				Message: fmt.Sprintf("%s would keep %d healthy members, fewer than the minimum of %d", name, healthy, config.MinHealthyPaths),
+			})
+		}
+		ratio := float64(healthy) / float64(len(g.Members))
+		if config.PreservePathsRatio > 0 && ratio < config.PreservePathsRatio {
+			violations = append(violations, Violation{
+				Group: g, Rule: RulePreservePathsRatio, Limit: config.PreservePathsRatio, Actual: ratio,
+				Message: fmt.Sprintf("%s would keep %.0f%% of its members healthy, less than the required %.0f%%", name, ratio*100, config.PreservePathsRatio*100),
+			})
+		}
+		if tolerance := g.tolerance(healthy); tolerance < config.MinRedundancy || healthy == 0 {
+			message := fmt.Sprintf("%s could lose %d more members, fewer than the required %d", name, tolerance, config.MinRedundancy)
+			if tolerance < 0 || healthy == 0 {
+				message = name + " would fail without this member"
+			}
+			violations = append(violations, Violation{
+				Group: g, Rule: RuleRedundancyFloor, Limit: float64(config.MinRedundancy), Actual: float64(tolerance),
+				Message: message,
+			})
+		}
+		if len(violations) > 0 && !g.Recovering {
+			refusal.Decision = DecisionRefused
+		}
+		refusal.Violations = append(refusal.Violations, violations...)
+	}
+	if !member {
+		refusal.Decision = DecisionRefused
+		refusal.Violations = []Violation{{
+			Rule: RuleRedundancyFloor, Limit: float64(config.MinRedundancy), Actual: -1,
+			Message: deviceID + " is in no redundancy group and would go out of service",
+		}}
+	}
+	if len(refusal.Violations) == 0 {
+		return nil
+	}
+	return refusal
+}
@@ -0,0 +1,735 @@
+package remediation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Playbook step actions.
+const (
+	StepIsolate = "isolate" // Isolate the device through its remediator
+	StepRecover = "recover" // Recover the device through its remediator
+	StepProbe   = "probe"   // Probe the device, e.g., to verify a rebuild
+	StepWrite   = "write"   // Write a value to a sysfs attribute
+	StepWait    = "wait"    // Wait for a duration, e.g., for in-flight I/O to drain
+	StepShell   = "shell"   // Run a shell command
+)
+
+// Playbook is a multi-step runbook as loaded from YAML, run for findings with one of its
+// codes.
+type Playbook struct {
+	Name       string         `yaml:"name"`
+	Codes      []string       `yaml:"codes"`                 // Finding codes (ERR_*) the playbook handles
+	DeviceType string         `yaml:"device_type,omitempty"` // raid, disk or network; empty matches every type
+	Steps      []PlaybookStep `yaml:"steps"`
+}
+
+// PlaybookStep is one step of a playbook. Path, Value and Command are text/templates over
+// DeviceType, DeviceID, Controller and Drive (for RAID drives), Code, Metric, Observed and
+// Threshold. A write step with Restore set rolls back to the attribute's previous value
+// and takes no Rollback.
+type PlaybookStep struct {
+	Name            string        `yaml:"name"`
+	Action          string        `yaml:"action"`             // isolate, recover, probe, write, wait or shell
+	Strategy        string        `yaml:"strategy,omitempty"` // isolate: temporary (default) or permanent
+	Path            string        `yaml:"path,omitempty"`     // write: sysfs attribute, relative to the sysfs root
+	Value           string        `yaml:"value,omitempty"`    // write
+	Restore         bool          `yaml:"restore,omitempty"`  // write: the rollback writes back the value read before the write
+	Command         string        `yaml:"command,omitempty"`  // shell: script run with sh -c
+	Duration        time.Duration `yaml:"duration,omitempty"` // wait
+	When            *Condition    `yaml:"when,omitempty"`     // Runs the step only if the condition holds
+	Timeout         time.Duration `yaml:"timeout,omitempty"`  // Per attempt; isolate and recover use the remediator's ActionTimeout
+	Retries         int           `yaml:"retries,omitempty"`
+	RetryDelay      time.Duration `yaml:"retry_delay,omitempty"`
+	ContinueOnError bool          `yaml:"continue_on_error,omitempty"` // A failure does not stop the playbook
+	Rollback        *PlaybookStep `yaml:"rollback,omitempty"`          // Compensates the step if a later step fails
+}
+
+// Condition gates a playbook step; every set field must hold.
+type Condition struct {
+	Severity  string   `yaml:"severity,omitempty"`  // subhealthy or failed: the finding's severity
+	Devices   []string `yaml:"devices,omitempty"`   // Device ID globs
+	Observed  string   `yaml:"observed,omitempty"`  // Comparison of the finding's observed value, e.g., "> 512"
+	Succeeded []string `yaml:"succeeded,omitempty"` // Earlier steps that must have succeeded
+	Failed    []string `yaml:"failed,omitempty"`    // Earlier steps that must have failed
+}
+
+// PlaybookSet is the top-level structure of a playbook file.
+type PlaybookSet struct {
+	Playbooks []Playbook `yaml:"playbooks"`
+}
+
+// StepStatus is the outcome of a playbook step.
+type StepStatus string
+
+// Playbook step outcomes.
+const (
+	StepSucceeded StepStatus = "succeeded"
+	StepFailed    StepStatus = "failed"
+	StepSkipped   StepStatus = "skipped"
+	StepDeferred  StepStatus = "deferred" // The isolation was queued for approval
+)
+
+// StepResult is the outcome of one playbook step or rollback.
+type StepResult struct {
+	Name      string
+	Action    string
+	Status    StepStatus
+	Attempts  int
+	Steps     []string // System changes made
+	Error     string
+	Duration  time.Duration
+	PendingID string // Pending action a deferred isolation awaits
+}
+
+// PlaybookRun is the outcome of a playbook run.
+type PlaybookRun struct {
+	Playbook   string
+	DeviceType enum.DeviceType
+	DeviceID   string
+	Code       string
+	Started    time.Time
+	Finished   time.Time
+	Success    bool
+	Deferred   string // Pending action the run stopped at, awaiting approval
+	Steps      []StepResult
+	RolledBack []StepResult // Rollbacks run after a failure or deferral, in the order they ran
+	Error      error
+}
+
+// comparisons maps the operators of observed-value conditions to their comparisons.
+var comparisons = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// compiledPlaybook is a validated Playbook ready to run.
+type compiledPlaybook struct {
+	Playbook
+	deviceType *enum.DeviceType
+	steps      []compiledStep
+}
+
+// compiledStep is a validated PlaybookStep.
+type compiledStep struct {
+	PlaybookStep
+	strategy         enum.IsolationStrategy
+	path, value, cmd *template.Template
+	severity         *enum.HealthStatus
+	compare          func(v float64) bool
+	rollback         *compiledStep
+	undo             bool   // The step is a rollback
+	restores         string // Write step whose saved value the rollback writes back
+}
+
+// playbookData is the data passed to step templates.
+type playbookData struct {
+	DeviceType string
+	DeviceID   string
+	Controller string
+	Drive      string
+	Code       string
+	Metric     string
+	Observed   float64
+	Threshold  float64
+
+	saved map[string]string // Attribute values read by restore steps of the run, by step name
+}
+
+// deferral is returned by an isolate step queued for approval.
+type deferral struct {
+	pendingID string
+}
+
+func (d *deferral) Error() string {
+	return "isolation pending approval as " + d.pendingID
+}
+
+// PlaybookEngine runs the playbook matching a finding's code against the finding's device.
+// Isolation and recovery go through the device type's remediator, and probes too if it is
+// a Prober, such as a DeviceRemediator; sysfs writes and shell commands go through the
+// system.
+type PlaybookEngine struct {
+	system      System
+	remediators map[enum.DeviceType]Remediator
+
+	mu        sync.RWMutex
+	playbooks []compiledPlaybook
+}
+
+// NewPlaybookEngine creates a new PlaybookEngine with the given playbooks.
+func NewPlaybookEngine(playbooks []Playbook, system System, remediators map[enum.DeviceType]Remediator) (*PlaybookEngine, error) {
+	e := &PlaybookEngine{system: system, remediators: remediators}
+	if err := e.Replace(playbooks); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ParsePlaybooks decodes and validates a YAML playbook file.
+func ParsePlaybooks(data []byte) ([]Playbook, error) {
+	var set PlaybookSet
+	if err := yaml.Unmarshal(data, &set); err != nil {
+		return nil, errors.New("failed to parse playbooks", err)
+	}
+	if _, err := compilePlaybooks(set.Playbooks); err != nil {
+		return nil, err
+	}
+	return set.Playbooks, nil
+}
+
+// ReadPlaybookFile reads and validates a YAML playbook file.
+func ReadPlaybookFile(path string) ([]Playbook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("failed to read playbook file "+path, err)
+	}
+	return ParsePlaybooks(data)
+}
+
+// Replace atomically swaps the playbooks. The current playbooks stay in place if any new
+// one is invalid.
+func (e *PlaybookEngine) Replace(playbooks []Playbook) error {
+	compiled, err := compilePlaybooks(playbooks)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.playbooks = compiled
+	e.mu.Unlock()
+	return nil
+}
+
+// Select returns the first playbook handling a code on devices of the given type.
+func (e *PlaybookEngine) Select(deviceType enum.DeviceType, code string) (Playbook, bool) {
+	p, ok := e.match(deviceType, code)
+	if !ok {
+		return Playbook{}, false
+	}
+	return p.Playbook, true
+}
+
+// RunFor runs the playbook of the most severe of the status's findings that has one. It
+// reports whether a playbook matched.
+func (e *PlaybookEngine) RunFor(ctx context.Context, status detection.HealthStatus) (PlaybookRun, bool, error) {
+	for _, f := range status.Findings {
+		if _, ok := e.match(status.DeviceType, f.Code); ok {
+			run, err := e.Run(ctx, status.DeviceType, status.DeviceID, f)
+			return run, true, err
+		}
+	}
+	return PlaybookRun{}, false, nil
+}
+
+// Run runs the playbook handling the finding's code against a device. Steps run in order,
+// each retried as configured; when a step fails, the rollbacks of the steps that succeeded
+// run in reverse order and the run fails. An isolation queued for approval defers the run:
+// it stops and rolls back like a failure, without an error, and the isolation runs alone
+// once approved.
+func (e *PlaybookEngine) Run(ctx context.Context, deviceType enum.DeviceType, deviceID string, finding detection.Finding) (PlaybookRun, error) {
+	p, ok := e.match(deviceType, finding.Code)
+	if !ok {
+		return PlaybookRun{}, errors.NewNotFound("no playbook for "+finding.Code+" on "+deviceType.String()+" devices", nil)
+	}
+	for _, part := range strings.Split(deviceID, "/") {
+		if err := checkDeviceName(part); err != nil {
+			return PlaybookRun{}, err
+		}
+	}
+
+	data := playbookData{
+		DeviceType: deviceType.String(),
+		DeviceID:   deviceID,
+		Code:       finding.Code,
+		Metric:     finding.Metric,
+		Observed:   finding.Observed,
+		Threshold:  finding.Threshold,
+		saved:      make(map[string]string),
+	}
+	if deviceType == enum.RAID {
+		if !strings.Contains(deviceID, "/") {
+			// Controller findings are keyed by the controller alone.
+			data.Controller = deviceID
+		} else {
+			controller, drive, err := SplitRAIDDrive(deviceID)
+			if err != nil {
+				return PlaybookRun{}, err
+			}
+			data.Controller, data.Drive = controller, drive
+		}
+	}
+	run := PlaybookRun{Playbook: p.Name, DeviceType: deviceType, DeviceID: deviceID, Code: finding.Code, Started: time.Now()}
+	logger.Info("running remediation playbook",
+		zap.String("playbook", p.Name),
+		zap.String("device_type", deviceType.String()),
+		zap.String("device_id", deviceID),
+		zap.String("code", finding.Code),
+	)
+
+	outcomes := make(map[string]StepStatus, len(p.steps))
+	var done []*compiledStep
+	for i := range p.steps {
+		step := &p.steps[i]
+		if !step.applies(finding, deviceID, outcomes) {
+			outcomes[step.Name] = StepSkipped
+			run.Steps = append(run.Steps, StepResult{Name: step.Name, Action: step.Action, Status: StepSkipped})
+			continue
+		}
+		result := e.runStep(ctx, step, deviceType, deviceID, p.Name, finding, data)
+		outcomes[step.Name] = result.Status
+		run.Steps = append(run.Steps, result)
+		if result.Status == StepSucceeded {
+			done = append(done, step)
+			continue
+		}
+		if result.Status == StepDeferred {
+			run.Deferred = result.PendingID
+			logger.Info("playbook deferred until its isolation is approved", zap.String("playbook", p.Name), zap.String("step", step.Name), zap.String("pending_id", result.PendingID))
+		} else if step.ContinueOnError {
+			logger.Warn("playbook step failed, continuing", zap.String("playbook", p.Name), zap.String("step", step.Name), zap.String("error", result.Error))
+			continue
+		} else {
+			run.Error = errors.New("playbook "+p.Name+" failed at step "+step.Name+": "+result.Error, nil)
+		}
+
+		for j := len(done) - 1; j >= 0; j-- {
+			if done[j].rollback == nil {
+				continue
+			}
+			rollback := e.runStep(ctx, done[j].rollback, deviceType, deviceID, p.Name, finding, data)
+			run.RolledBack = append(run.RolledBack, rollback)
+			if rollback.Status != StepSucceeded {
+				logger.Error("playbook rollback failed", zap.String("playbook", p.Name), zap.String("step", rollback.Name), zap.String("error", rollback.Error))
+			}
+		}
+		break
+	}
+
+	run.Finished = time.Now()
+	run.Success = run.Error == nil && run.Deferred == ""
+	logger.Info("remediation playbook finished",
+		zap.String("playbook", p.Name),
+		zap.String("device_id", deviceID),
+		zap.Bool("success", run.Success),
+		zap.Bool("deferred", run.Deferred != ""),
+		zap.Int("rolled_back", len(run.RolledBack)),
+	)
+	return run, run.Error
+}
+
+// match returns the first playbook handling a code on devices of the given type.
+func (e *PlaybookEngine) match(deviceType enum.DeviceType, code string) (compiledPlaybook, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, p := range e.playbooks {
+		if p.deviceType != nil && *p.deviceType != deviceType {
+			continue
+		}
+		for _, c := range p.Codes {
+			if c == code {
+				return p, true
+			}
+		}
+	}
+	return compiledPlaybook{}, false
+}
+
+// runStep runs a step, retrying failed attempts. A deferred isolation is not retried.
+func (e *PlaybookEngine) runStep(ctx context.Context, step *compiledStep, deviceType enum.DeviceType, deviceID, playbook string, finding detection.Finding, data playbookData) StepResult {
+	start := time.Now()
+	result := StepResult{Name: step.Name, Action: step.Action}
+	var err error
+	for result.Attempts <= step.Retries {
+		if result.Attempts > 0 && step.RetryDelay > 0 {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(step.RetryDelay):
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		result.Attempts++
+		var steps []string
+		steps, err = e.attempt(ctx, step, deviceType, deviceID, playbook, finding, data)
+		result.Steps = append(result.Steps, steps...)
+		if d, ok := err.(*deferral); ok {
+			result.Duration = time.Since(start)
+			result.Status, result.PendingID = StepDeferred, d.pendingID
+			return result
+		}
+		if err == nil {
+			break
+		}
+		logger.Warn("playbook step attempt failed",
+			zap.String("playbook", playbook),
+			zap.String("step", step.Name),
+			zap.Int("attempt", result.Attempts),
+			zap.Error(err),
+		)
+	}
+
+	result.Duration = time.Since(start)
+	result.Status = StepSucceeded
+	if err != nil {
+		result.Status, result.Error = StepFailed, err.Error()
+	}
+	return result
+}
+
+// attempt runs a step once, returning the system changes it made.
+func (e *PlaybookEngine) attempt(ctx context.Context, step *compiledStep, deviceType enum.DeviceType, deviceID, playbook string, finding detection.Finding, data playbookData) ([]string, error) {
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.Timeout)
+		defer cancel()
+	}
+
+	switch step.Action {
+	case StepIsolate, StepRecover, StepProbe:
+		r, ok := e.remediators[deviceType]
+		if !ok {
+			return nil, errors.NewNotFound("no remediator for "+deviceType.String(), nil)
+		}
+		cause := Cause{Trigger: "playbook " + playbook + " step " + step.Name + " for " + finding.Code, Undo: step.undo}
+		var (
+			result RemediationResult
+			err    error
+		)
+		switch step.Action {
+		case StepIsolate:
+			result, err = isolate(r, deviceType, deviceID, step.strategy, cause)
+		case StepRecover:
+			result, err = recoverDevice(r, deviceType, deviceID, cause)
+		default:
+			return nil, probe(ctx, r, deviceType, deviceID)
+		}
+		if err != nil {
+			return result.Steps, err
+		}
+		if result.PendingID != "" {
+			return result.Steps, &deferral{pendingID: result.PendingID}
+		}
+		if !result.Success {
+			if result.Error != nil {
+				return result.Steps, errors.New(result.Action, result.Error)
+			}
+			return result.Steps, errors.New(result.Action, nil)
+		}
+		return result.Steps, nil
+	case StepWrite:
+		p, err := render(step.path, data)
+		if err != nil {
+			return nil, err
+		}
+		var value string
+		if step.restores != "" {
+			saved, ok := data.saved[step.restores]
+			if !ok {
+				return nil, errors.New("no saved value of "+p+" to restore", nil)
+			}
+			value = saved
+		} else if value, err = render(step.value, data); err != nil {
+			return nil, err
+		}
+		if step.Restore {
+			old, err := e.system.ReadFile(p)
+			if err != nil {
+				return nil, errors.New("failed to read "+p+" to restore it on rollback", err)
+			}
+			data.saved[step.Name] = old
+		}
+		s := Step{Path: p, Value: value}
+		if err := e.system.WriteFile(p, value); err != nil {
+			return nil, errors.New("failed to write "+p, err)
+		}
+		return []string{s.String()}, nil
+	case StepWait:
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(step.Duration):
+			return nil, nil
+		}
+	default:
+		script, err := render(step.cmd, data)
+		if err != nil {
+			return nil, err
+		}
+		s := Step{Command: []string{"sh", "-c", script}}
+		if err := e.system.Run(ctx, "sh", "-c", script); err != nil {
+			return nil, errors.New("command failed: "+script, err)
+		}
+		return []string{s.String()}, nil
+	}
+}
+
+// applies reports whether a step's condition holds for the finding, the device and the
+// outcomes of the earlier steps.
+func (s *compiledStep) applies(finding detection.Finding, deviceID string, outcomes map[string]StepStatus) bool {
+	if s.When == nil {
+		return true
+	}
+	if s.severity != nil && finding.Severity != *s.severity {
+		return false
+	}
+	if s.compare != nil && !s.compare(finding.Observed) {
+		return false
+	}
+	if len(s.When.Devices) > 0 {
+		matched := false
+		for _, pattern := range s.When.Devices {
+			if ok, _ := path.Match(pattern, deviceID); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, name := range s.When.Succeeded {
+		if outcomes[name] != StepSucceeded {
+			return false
+		}
+	}
+	for _, name := range s.When.Failed {
+		if outcomes[name] != StepFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// render executes a step template.
+func render(tmpl *template.Template, data playbookData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", errors.New("failed to render "+tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// probe probes a device through r, which must be a DeviceProber or a Prober.
+func probe(ctx context.Context, r Remediator, deviceType enum.DeviceType, deviceID string) error {
+	if prober, ok := r.(DeviceProber); ok {
+		return prober.ProbeDevice(ctx, deviceType, deviceID)
+	}
+	if prober, ok := r.(Prober); ok {
+		return prober.Probe(ctx, deviceID)
+	}
+	return errors.New(deviceType.String()+" remediator cannot probe devices", nil)
+}
+
+// recoverDevice recovers a device through r, passing the cause on if r records causes.
+func recoverDevice(r Remediator, deviceType enum.DeviceType, deviceID string, cause Cause) (RemediationResult, error) {
+	if caused, ok := r.(CausedRemediator); ok {
+		return caused.RecoverWith(deviceType, deviceID, cause)
+	}
+	return r.Recover(deviceType, deviceID)
+}
+
+func compilePlaybooks(playbooks []Playbook) ([]compiledPlaybook, error) {
+	names := make(map[string]bool, len(playbooks))
+	compiled := make([]compiledPlaybook, 0, len(playbooks))
+	for _, p := range playbooks {
+		if names[p.Name] {
+			return nil, errors.New("duplicate playbook name "+p.Name, nil)
+		}
+		names[p.Name] = true
+		c, err := compilePlaybook(p)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func compilePlaybook(p Playbook) (compiledPlaybook, error) {
+	if p.Name == "" {
+		return compiledPlaybook{}, errors.New("playbook name is required", nil)
+	}
+	if len(p.Codes) == 0 {
+		return compiledPlaybook{}, errors.New("playbook "+p.Name+": at least one finding code is required", nil)
+	}
+	if len(p.Steps) == 0 {
+		return compiledPlaybook{}, errors.New("playbook "+p.Name+": at least one step is required", nil)
+	}
+	c := compiledPlaybook{Playbook: p}
+	if p.DeviceType != "" {
+		deviceType, err := enum.ParseDeviceType(p.DeviceType)
+		if err != nil {
+			return compiledPlaybook{}, errors.New("playbook "+p.Name, err)
+		}
+		c.deviceType = &deviceType
+	}
+
+	earlier := make(map[string]bool, len(p.Steps))
+	for _, step := range p.Steps {
+		if earlier[step.Name] {
+			return compiledPlaybook{}, errors.New(fmt.Sprintf("playbook %s: duplicate step name %q", p.Name, step.Name), nil)
+		}
+		s, err := compileStep(p.Name, step, earlier, false)
+		if err != nil {
+			return compiledPlaybook{}, err
+		}
+		earlier[step.Name] = true
+		c.steps = append(c.steps, s)
+	}
+	return c, nil
+}
+
+// compileStep validates a step; earlier holds the names of the steps before it.
+func compileStep(playbook string, step PlaybookStep, earlier map[string]bool, rollback bool) (compiledStep, error) {
+	where := "playbook " + playbook + " step " + step.Name
+	if step.Name == "" {
+		return compiledStep{}, errors.New("playbook "+playbook+": step name is required", nil)
+	}
+	if step.Retries < 0 || step.RetryDelay < 0 || step.Timeout < 0 {
+		return compiledStep{}, errors.New(where+": retries, retry_delay and timeout must not be negative", nil)
+	}
+	c := compiledStep{PlaybookStep: step, undo: rollback}
+
+	var err error
+	switch step.Action {
+	case StepIsolate:
+		c.strategy = enum.Temporary
+		switch step.Strategy {
+		case "", "temporary":
+		case "permanent":
+			c.strategy = enum.Permanent
+		default:
+			return compiledStep{}, errors.New(fmt.Sprintf("%s: strategy must be temporary or permanent, got %q", where, step.Strategy), nil)
+		}
+	case StepRecover, StepProbe:
+	case StepWrite:
+		if step.Path == "" {
+			return compiledStep{}, errors.New(where+": path is required", nil)
+		}
+		if step.Restore && step.Rollback != nil {
+			return compiledStep{}, errors.New(where+": restore replaces the rollback; set one or the other", nil)
+		}
+		if c.path, err = parseStepTemplate(where+" path", step.Path); err != nil {
+			return compiledStep{}, err
+		}
+		if c.value, err = parseStepTemplate(where+" value", step.Value); err != nil {
+			return compiledStep{}, err
+		}
+	case StepWait:
+		if step.Duration <= 0 {
+			return compiledStep{}, errors.New(where+": a positive duration is required", nil)
+		}
+	case StepShell:
+		if strings.TrimSpace(step.Command) == "" {
+			return compiledStep{}, errors.New(where+": command is required", nil)
+		}
+		if c.cmd, err = parseStepTemplate(where+" command", step.Command); err != nil {
+			return compiledStep{}, err
+		}
+	default:
+		return compiledStep{}, errors.New(fmt.Sprintf("%s: unknown action %q", where, step.Action), nil)
+	}
+	if step.Restore && step.Action != StepWrite {
+		return compiledStep{}, errors.New(where+": restore applies to write steps only", nil)
+	}
+	if step.Restore && rollback {
+		return compiledStep{}, errors.New(where+": rollbacks cannot restore", nil)
+	}
+
+	if w := step.When; w != nil {
+		if rollback {
+			return compiledStep{}, errors.New(where+": rollbacks cannot have conditions", nil)
+		}
+		if w.Severity != "" {
+			severity, err := enum.ParseHealthStatus(w.Severity)
+			if err != nil || severity == enum.Healthy {
+				return compiledStep{}, errors.New(fmt.Sprintf("%s: severity must be subhealthy or failed, got %q", where, w.Severity), nil)
+			}
+			c.severity = &severity
+		}
+		if w.Observed != "" {
+			if c.compare, err = parseComparison(w.Observed); err != nil {
+				return compiledStep{}, errors.New(where, err)
+			}
+		}
+		for _, pattern := range w.Devices {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return compiledStep{}, errors.New(fmt.Sprintf("%s: invalid device pattern %q", where, pattern), err)
+			}
+		}
+		for _, name := range append(append([]string(nil), w.Succeeded...), w.Failed...) {
+			if !earlier[name] {
+				return compiledStep{}, errors.New(fmt.Sprintf("%s: condition refers to %q, which is not an earlier step", where, name), nil)
+			}
+		}
+	}
+
+	if step.Rollback != nil {
+		if rollback {
+			return compiledStep{}, errors.New(where+": rollbacks cannot have rollbacks", nil)
+		}
+		undo := *step.Rollback
+		if undo.Name == "" {
+			undo.Name = step.Name + " rollback"
+		}
+		r, err := compileStep(playbook, undo, earlier, true)
+		if err != nil {
+			return compiledStep{}, err
+		}
+		c.rollback = &r
+	}
+	if step.Restore {
+		c.rollback = &compiledStep{
+			PlaybookStep: PlaybookStep{Name: step.Name + " rollback", Action: StepWrite, Timeout: step.Timeout, Retries: step.Retries, RetryDelay: step.RetryDelay},
+			path:         c.path,
+			undo:         true,
+			restores:     step.Name,
+		}
+	}
+	return c, nil
+}
+
+func parseStepTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.New("invalid template in "+name, err)
+	}
+	return tmpl, nil
+}
+
+// parseComparison parses an observed-value condition such as "> 512".
+func parseComparison(expr string) (func(v float64) bool, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 2 {
+		return nil, errors.New("observed condition "+strconv.Quote(expr)+" must be an operator and a value", nil)
+	}
+	cmp, ok := comparisons[fields[0]]
+	if !ok {
+		return nil, errors.New("unsupported operator "+strconv.Quote(fields[0]), nil)
+	}
+	threshold, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return nil, errors.New("invalid value "+strconv.Quote(fields[1]), err)
+	}
+	return func(v float64) bool { return cmp(v, threshold) }, nil
+}
@@ -0,0 +1,239 @@
+package remediation
+
+import (
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/slo"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode is how a remediation action is authorised.
+type Mode string
+
+// Remediation modes.
+const (
+	ModeAuto      Mode = "auto"      // Execute the action automatically
+	ModeApproval  Mode = "approval"  // Queue the action for human approval
+	ModeRecommend Mode = "recommend" // Only log the action as a recommendation
+)
+
+// maxWindowDuration bounds change windows, which are matched minute by minute.
+const maxWindowDuration = 7 * 24 * time.Hour
+
+// Policy decides how isolations of one device type serving one service tier are authorised.
+type Policy struct {
+	DeviceType     enum.DeviceType
+	Tier           slo.ServiceTier // Empty matches every tier
+	Mode           Mode
+	Windows        []ChangeWindow // When Mode applies; always when empty
+	OutsideWindows Mode           // Mode outside the windows; ModeApproval when empty
+}
+
+// matches reports whether the policy covers a device of the given type and tier.
+func (p Policy) matches(deviceType enum.DeviceType, tier slo.ServiceTier) bool {
+	return p.DeviceType == deviceType && (p.Tier == "" || p.Tier == tier)
+}
+
+// mode returns the policy's mode at t and, outside the change windows, why it applies.
+func (p Policy) mode(t time.Time) (Mode, string) {
+	if len(p.Windows) == 0 {
+		return p.Mode, ""
+	}
+	for _, w := range p.Windows {
+		if w.Contains(t) {
+			return p.Mode, ""
+		}
+	}
+	specs := make([]string, len(p.Windows))
+	for i, w := range p.Windows {
+		specs[i] = w.String()
+	}
+	outside := p.OutsideWindows
+	if outside == "" {
+		outside = ModeApproval
+	}
+	return outside, "outside change windows " + strings.Join(specs, ", ")
+}
+
+// ChangeWindow is a recurring maintenance window: a cron schedule of the window's starts
+// followed by its duration, e.g., "0 22 * * 6 4h" for Saturdays from 22:00 to 02:00.
+type ChangeWindow struct {
+	spec     string
+	fields   [5]map[int]bool // Minute, hour, day of month, month, day of week; nil for "*"
+	duration time.Duration
+}
+
+// cronBounds are the ranges of the cron fields.
+var cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+// ParseChangeWindow parses a change window from five cron fields and a duration. Fields
+// take "*", values, ranges ("1-5"), lists ("1,3") and steps ("*/15"); day of week 7 is
+// Sunday, like 0. As in cron, a day matches if either a restricted day of month or a
+// restricted day of week matches.
+func ParseChangeWindow(spec string) (ChangeWindow, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != 6 {
+		return ChangeWindow{}, errors.New("change window "+strconv.Quote(spec)+" needs five cron fields and a duration", nil)
+	}
+	w := ChangeWindow{spec: strings.Join(parts, " ")}
+	for i := range w.fields {
+		if parts[i] == "*" {
+			continue
+		}
+		values, err := parseCronField(parts[i], cronBounds[i][0], cronBounds[i][1])
+		if err != nil {
+			return ChangeWindow{}, errors.New("invalid change window "+strconv.Quote(spec), err)
+		}
+		w.fields[i] = values
+	}
+	if w.fields[4][7] {
+		w.fields[4][0] = true
+	}
+	duration, err := time.ParseDuration(parts[5])
+	if err != nil || duration <= 0 || duration > maxWindowDuration {
+		return ChangeWindow{}, errors.New("change window "+strconv.Quote(spec)+" needs a positive duration of at most 168h", err)
+	}
+	w.duration = duration
+	return w, nil
+}
+
+// parseCronField parses one cron field into the set of values it matches.
+func parseCronField(field string, lo, hi int) (map[int]bool, error) {
+	if lo == 0 && hi == 6 {
+		hi = 7 // Sunday is 0 or 7
+	}
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step, stepped := 1, false
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, errors.New("invalid step in "+part, nil)
+			}
+			step, stepped, part = n, true, part[:i]
+		}
+		from, to := lo, hi
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, errors.New("invalid value "+bounds[0], nil)
+			}
+			to = from
+			if stepped {
+				to = hi // "5/10" steps from 5 to the end of the range
+			}
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, errors.New("invalid value "+bounds[1], nil)
+				}
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return nil, errors.New(part+" is out of range "+strconv.Itoa(lo)+"-"+strconv.Itoa(hi), nil)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// String returns the window's spec.
+func (w ChangeWindow) String() string {
+	return w.spec
+}
+
+// Contains reports whether t falls within the window, i.e., the window started in the
+// duration before t. Times are matched in t's location.
+func (w ChangeWindow) Contains(t time.Time) bool {
+	earliest := t.Add(-w.duration)
+	for start := t.Truncate(time.Minute); start.After(earliest); start = start.Add(-time.Minute) {
+		if w.starts(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// starts reports whether the window starts at the minute t.
+func (w ChangeWindow) starts(t time.Time) bool {
+	in := func(field, v int) bool {
+		return w.fields[field] == nil || w.fields[field][v]
+	}
+	if !in(0, t.Minute()) || !in(1, t.Hour()) || !in(3, int(t.Month())) {
+		return false
+	}
+	dom, dow := in(2, t.Day()), in(4, int(t.Weekday()))
+	if w.fields[2] != nil && w.fields[4] != nil {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// RateScope is what a rate limit counts isolations across.
+type RateScope string
+
+// Rate limit scopes.
+const (
+	ScopeHost    RateScope = "host"
+	ScopeCluster RateScope = "cluster"
+)
+
+// RateLimit caps the isolations executed across a host or cluster in a sliding window.
+type RateLimit struct {
+	Scope RateScope
+	Max   int
+	Per   time.Duration // Sliding window; an hour when zero
+}
+
+// ActionCounter counts executed isolations by scope key. Cluster-wide limits need a counter
+// shared by the cluster's hosts.
+type ActionCounter interface {
+	Count(key string, since time.Time) (int, error)
+	Add(key string, at time.Time) error
+}
+
+// MemoryCounter implements ActionCounter in memory, for one host. It keeps a week of
+// isolations.
+type MemoryCounter struct {
+	mu    sync.Mutex
+	times map[string][]time.Time
+}
+
+// NewMemoryCounter creates a new MemoryCounter.
+func NewMemoryCounter() *MemoryCounter {
+	return &MemoryCounter{times: make(map[string][]time.Time)}
+}
+
+// Count returns the isolations counted under key since the given time.
+func (c *MemoryCounter) Count(key string, since time.Time) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, t := range c.times[key] {
+		if !t.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Add counts an isolation under key, dropping isolations older than a week.
+func (c *MemoryCounter) Add(key string, at time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	times := c.times[key][:0]
+	for _, t := range c.times[key] {
+		if at.Sub(t) < maxWindowDuration {
+			times = append(times, t)
+		}
+	}
+	c.times[key] = append(times, at)
+	return nil
+}
@@ -0,0 +1,278 @@
+package remediation
+
+import (
+	"context"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"go.uber.org/zap"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Prober is implemented by executors that can test an isolated device with synthetic
+// probes, e.g., read or link tests, without bringing it back into service.
+type Prober interface {
+	Probe(ctx context.Context, deviceID string) error
+}
+
+// DeviceProber is implemented by remediators of several device types, such as the Workflow,
+// that probe devices through the remediator of their type.
+type DeviceProber interface {
+	ProbeDevice(ctx context.Context, deviceType enum.DeviceType, deviceID string) error
+}
+
+// Isolation is a temporary isolation on probation: the device is probed with exponential
+// back-off until it passes enough consecutive probes to be re-admitted, or its TTL expires
+// and it is escalated to permanent isolation.
+type Isolation struct {
+	DeviceType enum.DeviceType
+	DeviceID   string
+	Since      time.Time
+	Expires    time.Time // End of the TTL; zero when isolations never expire
+	NextProbe  time.Time
+	Interval   time.Duration // Delay after the next failed probe, doubled by every failure
+	Passed     int           // Consecutive healthy probes
+	Failed     int           // Failed probes in total
+	LastError  string        // Error of the last failed probe
+}
+
+// Escalation reports a temporary isolation escalated to permanent because the device kept
+// failing its probes until the TTL expired.
+type Escalation struct {
+	Isolation
+	At     time.Time
+	Reason string
+	Result RemediationResult
+}
+
+// Isolations returns the temporary isolations on probation, ordered by device.
+func (r *DeviceRemediator) Isolations() []Isolation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	isolations := make([]Isolation, 0, len(r.probation))
+	for _, iso := range r.probation {
+		isolations = append(isolations, *iso)
+	}
+	sort.Slice(isolations, func(i, j int) bool { return isolations[i].DeviceID < isolations[j].DeviceID })
+	return isolations
+}
+
+// OnEscalate sets the function called when a temporary isolation is escalated.
+func (r *DeviceRemediator) OnEscalate(fn func(Escalation)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.escalate = fn
+}
+
+// ProbeDue probes every isolation whose next probe is due at now, re-admitting devices
+// that passed RecoveryProbes consecutive probes and escalating those whose TTL expired.
+// It returns the results of the re-admissions and escalations.
+func (r *DeviceRemediator) ProbeDue(now time.Time) []RemediationResult {
+	prober, ok := r.executor.(Prober)
+	if !ok {
+		return nil
+	}
+
+	var results []RemediationResult
+	for _, iso := range r.Isolations() {
+		if !iso.Expires.IsZero() && !now.Before(iso.Expires) {
+			results = append(results, r.escalateIsolation(iso, now))
+			continue
+		}
+		if now.Before(iso.NextProbe) {
+			continue
+		}
+
+		ctx, cancel := r.context(r.config.ActionTimeout)
+		err := prober.Probe(ctx, iso.DeviceID)
+		cancel()
+		if passed := r.recordProbe(iso.DeviceID, err, now); passed {
+			if result, ok := r.readmit(iso.DeviceID, now); ok {
+				results = append(results, result)
+			}
+		}
+	}
+	return results
+}
+
+// Probe probes a device with the executor, which must be a Prober.
+func (r *DeviceRemediator) Probe(ctx context.Context, deviceID string) error {
+	prober, ok := r.executor.(Prober)
+	if !ok {
+		return errors.New(r.deviceType.String()+" executor cannot probe devices", nil)
+	}
+	if r.config.ActionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.config.ActionTimeout)
+		defer cancel()
+	}
+	return prober.Probe(ctx, deviceID)
+}
+
+// Watch probes due isolations every interval until ctx is done.
+func (r *DeviceRemediator) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.ProbeDue(now)
+		}
+	}
+}
+
+// startProbation puts a temporarily isolated device on probation, if the executor can
+// probe it; permanent isolation ends any probation.
+func (r *DeviceRemediator) startProbation(deviceID string, strategy enum.IsolationStrategy, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.executor.(Prober); !ok || strategy == enum.Permanent {
+		delete(r.probation, deviceID)
+		return
+	}
+	iso := &Isolation{
+		DeviceType: r.deviceType,
+		DeviceID:   deviceID,
+		Since:      now,
+		NextProbe:  now.Add(r.config.ProbeInterval),
+		Interval:   r.config.ProbeInterval,
+	}
+	if r.config.RecoveryTimeout > 0 {
+		iso.Expires = now.Add(r.config.RecoveryTimeout)
+	}
+	r.probation[deviceID] = iso
+}
+
+// recordProbe records a probe's outcome and schedules the next one, reporting whether the
+// device has passed enough consecutive probes to be re-admitted.
+func (r *DeviceRemediator) recordProbe(deviceID string, err error, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	iso, ok := r.probation[deviceID]
+	if !ok {
+		return false
+	}
+	if err == nil {
+		iso.Passed++
+		iso.NextProbe = now.Add(r.config.ProbeInterval)
+		logger.Info("isolated device passed probe",
+			zap.String("device_id", deviceID),
+			zap.Int("passed", iso.Passed),
+			zap.Int("required", r.config.RecoveryProbes),
+		)
+		return iso.Passed >= r.config.RecoveryProbes
+	}
+
+	iso.Passed = 0
+	iso.Failed++
+	iso.LastError = err.Error()
+	iso.NextProbe = now.Add(iso.Interval)
+	iso.Interval *= 2
+	if iso.Interval > r.config.MaxProbeInterval {
+		iso.Interval = r.config.MaxProbeInterval
+	}
+	logger.Warn("isolated device failed probe",
+		zap.String("device_id", deviceID),
+		zap.Int("failed", iso.Failed),
+		zap.Time("next_probe", iso.NextProbe),
+		zap.Error(err),
+	)
+	return false
+}
+
+// readmit restores a device that passed its probes, ending its probation. A failed restore
+// keeps the device on probation to be probed again.
+func (r *DeviceRemediator) readmit(deviceID string, now time.Time) (RemediationResult, bool) {
+	r.mu.Lock()
+	iso, ok := r.probation[deviceID]
+	if ok {
+		delete(r.probation, deviceID)
+	}
+	r.mu.Unlock()
+	if !ok {
+		return RemediationResult{}, false
+	}
+
+	ctx, cancel := r.context(r.config.ActionTimeout)
+	defer cancel()
+	steps, err := r.executor.Restore(ctx, deviceID, r.config.DryRun)
+	result := r.result(deviceID, "recovered", "recovery planned", steps)
+	if err != nil {
+		result.Action, result.Success, result.Error = "recovery failed", false, err
+		logger.Error("failed to re-admit probed device", zap.String("device_id", deviceID), zap.Error(err))
+
+		iso.Passed = 0
+		iso.LastError = err.Error()
+		iso.NextProbe = now.Add(iso.Interval)
+		r.mu.Lock()
+		r.probation[deviceID] = iso
+		r.mu.Unlock()
+		return result, true
+	}
+	if !r.config.DryRun {
+		r.trackDevice(deviceID, Transition{
+			To:      StatusRecovered,
+			Trigger: "passed " + strconv.Itoa(iso.Passed) + " consecutive probes",
+			Message: "device re-admitted after healthy probes",
+			Steps:   result.Steps,
+		})
+	}
+	logger.Info("re-admitted isolated device after healthy probes",
+		zap.String("device_type", r.deviceType.String()),
+		zap.String("device_id", deviceID),
+		zap.Int("probes", iso.Passed),
+		zap.Duration("isolated_for", now.Sub(iso.Since)),
+	)
+	return result, true
+}
+
+// escalateIsolation isolates a device whose TTL expired permanently and reports the
+// escalation.
+func (r *DeviceRemediator) escalateIsolation(iso Isolation, now time.Time) RemediationResult {
+	r.mu.Lock()
+	delete(r.probation, iso.DeviceID)
+	escalate := r.escalate
+	r.mu.Unlock()
+
+	ctx, cancel := r.context(r.config.ActionTimeout)
+	defer cancel()
+	steps, err := r.executor.Isolate(ctx, iso.DeviceID, enum.Permanent, r.config.DryRun)
+	result := r.result(iso.DeviceID, "escalated", "escalation planned", steps)
+	if err != nil {
+		result.Action, result.Success, result.Error = "escalation failed", false, err
+	}
+
+	reason := "device did not pass " + strconv.Itoa(r.config.RecoveryProbes) + " consecutive probes within " + r.config.RecoveryTimeout.String()
+	if iso.LastError != "" {
+		reason += "; last probe: " + iso.LastError
+	}
+	if !r.config.DryRun {
+		r.trackDevice(iso.DeviceID, Transition{
+			To:        StatusIsolated,
+			Trigger:   "isolation TTL expired",
+			Message:   reason,
+			Steps:     result.Steps,
+			Error:     err,
+			Permanent: err == nil,
+		})
+	}
+	logger.Warn("escalated temporary isolation to permanent",
+		zap.String("device_type", r.deviceType.String()),
+		zap.String("device_id", iso.DeviceID),
+		zap.Int("failed_probes", iso.Failed),
+		zap.String("reason", reason),
+		zap.Bool("success", result.Success),
+	)
+	if escalate != nil {
+		escalate(Escalation{Isolation: iso, At: now, Reason: reason, Result: result})
+	}
+	return result
+}
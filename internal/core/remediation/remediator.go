@@ -1,11 +1,14 @@
 package remediation
 
 import (
+	"context"
 	"github.com/turtacn/ioshelfer/internal/common/errors"
 	"github.com/turtacn/ioshelfer/internal/common/logger"
 	"github.com/turtacn/ioshelfer/internal/common/types/enum"
 	"github.com/turtacn/ioshelfer/internal/core/detection"
 	"go.uber.org/zap"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,16 +17,26 @@ type Config struct {
 	AutoIsolation      bool          // Enable automatic isolation
 	PreservePathsRatio float64       // Minimum ratio of healthy paths to preserve
 	MinHealthyPaths    int           // Minimum number of healthy paths
-	RecoveryTimeout    time.Duration // Timeout for recovery attempts
+	RecoveryTimeout    time.Duration // TTL of a temporary isolation to pass its probes before it is escalated to permanent; none when zero
+	MinRedundancy      int           // Member losses every redundancy group must still survive after an isolation
+	ActionTimeout      time.Duration // Timeout for applying an action's steps or running a probe; none when zero
+	ProbeInterval      time.Duration // Delay before an isolated device is first probed; 1m when zero
+	MaxProbeInterval   time.Duration // Cap of the probe back-off after failed probes; 30m when zero
+	RecoveryProbes     int           // Consecutive healthy probes before an isolated device is re-admitted; 3 when zero
+	DryRun             bool          // Plan and report isolation and recovery steps without applying them
 }
 
 // RemediationResult represents the result of a remediation action.
 type RemediationResult struct {
 	DeviceType enum.DeviceType
 	DeviceID   string
-	Action     string // e.g., "isolated", "recovered"
+	Action     string // e.g., "isolated", "throttled", "recovered", "escalated"
 	Success    bool
 	Error      error
+	DryRun     bool     // The steps were planned but not applied
+	Steps      []string // System changes made, or planned in a dry run
+	Refusal    *Refusal // Why guardrails blocked the isolation, if they did
+	PendingID  string   // Pending action awaiting approval, if the isolation was queued
 }
 
 // Remediator defines the interface for remediation actions.
@@ -32,28 +45,65 @@ type Remediator interface {
 	Recover(deviceType enum.DeviceType, deviceID string) (RemediationResult, error)
 }
 
-// RAIDRemediator implements Remediator for RAID controllers.
-type RAIDRemediator struct {
-	config   *Config
-	detector detection.Detector
+// DeviceRemediator implements Remediator for one device type, taking devices out of service
+// and back with the device type's executor. Temporarily isolated devices are kept on
+// probation when the executor is a Prober; see ProbeDue.
+type DeviceRemediator struct {
+	config     *Config
+	deviceType enum.DeviceType
+	detector   detection.Detector
+	executor   Executor
+
+	mu        sync.Mutex
+	probation map[string]*Isolation // Keyed by device
+	escalate  func(Escalation)
+	state     *StateStore // Persisted actions; none when nil
 }
 
-// NewRAIDRemediator creates a new RAIDRemediator instance.
-func NewRAIDRemediator(config *Config, detector detection.Detector) *RAIDRemediator {
-	return &RAIDRemediator{
-		config:   config,
-		detector: detector,
+// NewRemediator creates a new DeviceRemediator instance, applying defaults. The detector
+// confirms devices need isolation and are fit for recovery; without one, actions are taken
+// unchecked.
+func NewRemediator(config *Config, deviceType enum.DeviceType, detector detection.Detector, executor Executor) *DeviceRemediator {
+	if config.ProbeInterval <= 0 {
+		config.ProbeInterval = time.Minute
+	}
+	if config.MaxProbeInterval <= 0 {
+		config.MaxProbeInterval = 30 * time.Minute
+	}
+	if config.RecoveryProbes <= 0 {
+		config.RecoveryProbes = 3
 	}
+	return &DeviceRemediator{
+		config:     config,
+		deviceType: deviceType,
+		detector:   detector,
+		executor:   executor,
+		probation:  make(map[string]*Isolation),
+	}
+}
+
+// SetState makes the remediator persist its actions, and the triggers and evidence they
+// were taken on, in state.
+func (r *DeviceRemediator) SetState(state *StateStore) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = state
 }
 
-// Isolate isolates a RAID controller based on the specified strategy.
-func (r *RAIDRemediator) Isolate(deviceType enum.DeviceType, deviceID string, strategy enum.IsolationStrategy) (RemediationResult, error) {
-	if deviceType != enum.RAID {
-		return RemediationResult{}, errors.New("invalid device type for RAID remediation", nil)
+// Isolate takes a device out of service based on the specified strategy.
+func (r *DeviceRemediator) Isolate(deviceType enum.DeviceType, deviceID string, strategy enum.IsolationStrategy) (RemediationResult, error) {
+	return r.IsolateWith(deviceType, deviceID, strategy, Cause{})
+}
+
+// IsolateWith isolates a device like Isolate, recording the cause with the action.
+func (r *DeviceRemediator) IsolateWith(deviceType enum.DeviceType, deviceID string, strategy enum.IsolationStrategy, cause Cause) (RemediationResult, error) {
+	if deviceType != r.deviceType {
+		return RemediationResult{}, errors.New("invalid device type for "+r.deviceType.String()+" remediation", nil)
 	}
 
 	if !r.config.AutoIsolation {
 		logger.Warn("auto-isolation disabled", zap.String("device_id", deviceID))
+		r.settle(cause.ActionID, StatusCancelled, "auto-isolation disabled")
 		return RemediationResult{
 			DeviceType: deviceType,
 			DeviceID:   deviceID,
@@ -63,68 +113,200 @@ func (r *RAIDRemediator) Isolate(deviceType enum.DeviceType, deviceID string, st
 	}
 
 	// Check current health to ensure isolation is necessary
-	status, err := r.detector.CheckSubHealth()
-	if err != nil {
-		return RemediationResult{}, errors.Wrap(err, "failed to check RAID health")
+	if r.detector != nil {
+		status, err := r.detector.CheckSubHealth(r.healthID(deviceID))
+		if err != nil {
+			return RemediationResult{}, errors.Wrap(err, "failed to check "+deviceType.String()+" health")
+		}
+		if status.Status == enum.Healthy {
+			logger.Info("no isolation needed", zap.String("device_id", deviceID), zap.String("status", status.Status.String()))
+			r.settle(cause.ActionID, StatusCancelled, "no isolation needed: device is healthy")
+			return RemediationResult{
+				DeviceType: deviceType,
+				DeviceID:   deviceID,
+				Action:     "no action",
+				Success:    true,
+			}, nil
+		}
 	}
 
-	if status.Status == enum.Healthy {
-		logger.Info("no isolation needed", zap.String("device_id", deviceID), zap.String("status", status.Status.String()))
-		return RemediationResult{
-			DeviceType: deviceType,
-			DeviceID:   deviceID,
-			Action:     "no action",
-			Success:    true,
-		}, nil
+	ctx, cancel := r.context(r.config.ActionTimeout)
+	defer cancel()
+	// Throttled devices stay in service, so they take no redundancy away.
+	if resolver, ok := r.executor.(GroupResolver); ok && !r.Throttles(strategy) {
+		groups, err := resolver.Groups(ctx, deviceID)
+		if err != nil {
+			return RemediationResult{}, errors.Wrap(err, "failed to resolve redundancy groups of "+deviceID)
+		}
+		if refusal := checkGuardrails(r.config, deviceID, groups); refusal != nil {
+			logger.Warn("isolation blocked by guardrails",
+				zap.String("device_type", deviceType.String()),
+				zap.String("device_id", deviceID),
+				zap.String("decision", string(refusal.Decision)),
+				zap.String("reason", refusal.Explain()),
+			)
+			r.settle(cause.ActionID, StatusCancelled, "isolation "+string(refusal.Decision)+": "+refusal.Explain())
+			return RemediationResult{
+				DeviceType: deviceType,
+				DeviceID:   deviceID,
+				Action:     "isolation " + string(refusal.Decision),
+				Success:    false,
+				Error:      errors.New(refusal.Explain(), nil),
+				Refusal:    refusal,
+			}, nil
+		}
 	}
 
-	// Placeholder: Implement actual isolation logic (e.g., disable controller via system call)
-	logger.Info("isolating RAID controller",
+	logger.Info("isolating device",
+		zap.String("device_type", deviceType.String()),
 		zap.String("device_id", deviceID),
 		zap.String("strategy", strategy.String()),
+		zap.Bool("dry_run", r.config.DryRun),
 	)
+	actionID, err := r.begin(deviceID, strategy, cause)
+	if err != nil {
+		return RemediationResult{}, err
+	}
+	action, applied, status := "isolation", "isolated", StatusIsolated
+	if r.Throttles(strategy) {
+		action, applied, status = "throttling", "throttled", StatusThrottled
+	}
+	steps, err := r.executor.Isolate(ctx, deviceID, strategy, r.config.DryRun)
+	result := r.result(deviceID, applied, action+" planned", steps)
+	if _, ok := err.(*PartialError); ok {
+		// The device is partly changed; the record stays in effect for recovery.
+		result.Action, result.Success, result.Error = action+" partially applied", false, err
+		r.track(actionID, Transition{To: StatusPartial, Message: action + " failed and its applied steps could not be undone", Steps: result.Steps, Error: err})
+		return result, errors.Wrap(err, "failed to isolate "+deviceID)
+	}
+	if err != nil {
+		result.Action, result.Success, result.Error = action+" failed", false, err
+		r.track(actionID, Transition{To: StatusFailed, Message: action + " failed", Steps: result.Steps, Error: err})
+		return result, errors.Wrap(err, "failed to isolate "+deviceID)
+	}
+	if !r.config.DryRun {
+		r.track(actionID, Transition{To: status, Message: strategy.String() + " " + action + " applied", Steps: result.Steps})
+		r.startProbation(deviceID, strategy, time.Now())
+	}
+	return result, nil
+}
 
-	return RemediationResult{
-		DeviceType: deviceType,
-		DeviceID:   deviceID,
-		Action:     "isolated",
-		Success:    true,
-	}, nil
+// Throttles reports whether the remediator's executor throttles devices rather than
+// isolating them with the strategy.
+func (r *DeviceRemediator) Throttles(strategy enum.IsolationStrategy) bool {
+	t, ok := r.executor.(Throttler)
+	return ok && t.Throttles(strategy)
 }
 
-// Recover attempts to recover a previously isolated RAID controller.
-func (r *RAIDRemediator) Recover(deviceType enum.DeviceType, deviceID string) (RemediationResult, error) {
-	if deviceType != enum.RAID {
-		return RemediationResult{}, errors.New("invalid device type for RAID remediation", nil)
-	}
+// Recover attempts to bring a previously isolated device back into service. Devices on
+// probation are only re-admitted once they pass their probes.
+func (r *DeviceRemediator) Recover(deviceType enum.DeviceType, deviceID string) (RemediationResult, error) {
+	return r.RecoverWith(deviceType, deviceID, Cause{})
+}
 
-	// Check current health to confirm recovery is feasible
-	status, err := r.detector.CheckSubHealth()
-	if err != nil {
-		return RemediationResult{}, errors.Wrap(err, "failed to check RAID health")
+// RecoverWith recovers a device like Recover, recording the cause with the device's action.
+func (r *DeviceRemediator) RecoverWith(deviceType enum.DeviceType, deviceID string, cause Cause) (RemediationResult, error) {
+	if deviceType != r.deviceType {
+		return RemediationResult{}, errors.New("invalid device type for "+r.deviceType.String()+" remediation", nil)
 	}
 
-	if status.Status != enum.Healthy {
-		logger.Warn("cannot recover unhealthy device",
+	r.mu.Lock()
+	iso, onProbation := r.probation[deviceID]
+	var passed int
+	if onProbation {
+		passed = iso.Passed
+	}
+	if cause.Undo {
+		delete(r.probation, deviceID)
+		onProbation = false
+	}
+	r.mu.Unlock()
+	if onProbation {
+		logger.Info("recovery deferred to probes",
 			zap.String("device_id", deviceID),
-			zap.String("status", status.Status.String()),
+			zap.Int("passed", passed),
+			zap.Int("required", r.config.RecoveryProbes),
 		)
 		return RemediationResult{
 			DeviceType: deviceType,
 			DeviceID:   deviceID,
-			Action:     "recovery failed",
+			Action:     "recovery pending",
 			Success:    false,
-			Error:      errors.New("device not healthy for recovery", nil),
 		}, nil
 	}
 
-	// Placeholder: Implement actual recovery logic (e.g., re-enable controller)
-	logger.Info("recovering RAID controller", zap.String("device_id", deviceID))
+	// Check current health to confirm recovery is feasible
+	if r.detector != nil && !cause.Undo {
+		status, err := r.detector.CheckSubHealth(r.healthID(deviceID))
+		if err != nil {
+			return RemediationResult{}, errors.Wrap(err, "failed to check "+deviceType.String()+" health")
+		}
+		if status.Status != enum.Healthy {
+			logger.Warn("cannot recover unhealthy device",
+				zap.String("device_id", deviceID),
+				zap.String("status", status.Status.String()),
+			)
+			return RemediationResult{
+				DeviceType: deviceType,
+				DeviceID:   deviceID,
+				Action:     "recovery failed",
+				Success:    false,
+				Error:      errors.New("device not healthy for recovery", nil),
+			}, nil
+		}
+	}
 
-	return RemediationResult{
-		DeviceType: deviceType,
+	logger.Info("recovering device",
+		zap.String("device_type", deviceType.String()),
+		zap.String("device_id", deviceID),
+		zap.Bool("dry_run", r.config.DryRun),
+	)
+	ctx, cancel := r.context(r.config.ActionTimeout)
+	defer cancel()
+	steps, err := r.executor.Restore(ctx, deviceID, r.config.DryRun)
+	result := r.result(deviceID, "recovered", "recovery planned", steps)
+	if err != nil {
+		result.Action, result.Success, result.Error = "recovery failed", false, err
+		return result, errors.Wrap(err, "failed to recover "+deviceID)
+	}
+	if !r.config.DryRun {
+		r.trackDevice(deviceID, Transition{To: StatusRecovered, Trigger: trigger(cause), Message: "device recovered", Steps: result.Steps})
+	}
+	return result, nil
+}
+
+// healthID returns the device the detector reports on: RAID drives are checked through
+// their controller.
+func (r *DeviceRemediator) healthID(deviceID string) string {
+	if r.deviceType == enum.RAID {
+		if i := strings.Index(deviceID, "/"); i > 0 {
+			return deviceID[:i]
+		}
+	}
+	return deviceID
+}
+
+func (r *DeviceRemediator) context(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// result builds a successful result, naming the action after whether it was a dry run.
+func (r *DeviceRemediator) result(deviceID, action, planned string, steps []Step) RemediationResult {
+	result := RemediationResult{
+		DeviceType: r.deviceType,
 		DeviceID:   deviceID,
-		Action:     "recovered",
+		Action:     action,
 		Success:    true,
-	}, nil
+		DryRun:     r.config.DryRun,
+	}
+	if r.config.DryRun {
+		result.Action = planned
+	}
+	for _, step := range steps {
+		result.Steps = append(result.Steps, step.String())
+	}
+	return result
 }
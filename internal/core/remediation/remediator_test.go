@@ -1 +1,1379 @@
 package remediation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"github.com/turtacn/ioshelfer/internal/core/slo"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+)
+
+// fakeSystem is an in-memory sysfs tree that records writes and commands instead of
+// applying them.
+type fakeSystem struct {
+	files    map[string]string
+	dirs     map[string][]string
+	links    map[string]string
+	outputs  map[string]string // Command output by command line
+	writes   []string
+	commands []string
+	fail     string // Commands starting with this fail
+}
+
+func newFakeSystem() *fakeSystem {
+	return &fakeSystem{files: map[string]string{}, dirs: map[string][]string{}, links: map[string]string{}, outputs: map[string]string{}}
+}
+
+func (s *fakeSystem) ReadFile(path string) (string, error) {
+	v, ok := s.files[path]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return v, nil
+}
+
+func (s *fakeSystem) ReadDir(path string) ([]string, error) {
+	v, ok := s.dirs[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return v, nil
+}
+
+func (s *fakeSystem) ReadLink(path string) (string, error) {
+	v, ok := s.links[path]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return v, nil
+}
+
+func (s *fakeSystem) WriteFile(path, value string) error {
+	s.writes = append(s.writes, path+"="+value)
+	s.files[path] = value
+	return nil
+}
+
+func (s *fakeSystem) Run(ctx context.Context, name string, args ...string) error {
+	command := strings.Join(append([]string{name}, args...), " ")
+	if s.fail != "" && strings.HasPrefix(command, s.fail) {
+		return errors.New("exit status 1", nil)
+	}
+	s.commands = append(s.commands, command)
+	return nil
+}
+
+func (s *fakeSystem) Output(ctx context.Context, name string, args ...string) (string, error) {
+	command := strings.Join(append([]string{name}, args...), " ")
+	out, ok := s.outputs[command]
+	if !ok {
+		return "", errors.New(command+" failed", nil)
+	}
+	return out, nil
+}
+
+func TestDiskExecutorSetsOffline(t *testing.T) {
+	system := newFakeSystem()
+	system.dirs["block/sda"] = []string{"device", "holders", "queue", "stat"}
+	system.files["block/sda/device/state"] = "running"
+	e := NewDiskExecutor(system)
+
+	steps, err := e.Isolate(context.Background(), "sda", enum.Temporary, true)
+	require.NoError(t, err)
+	require.Len(t, steps, 1)
+	assert.Equal(t, "write offline to block/sda/device/state", steps[0].String())
+	assert.Equal(t, "write running to block/sda/device/state", steps[0].Undo.String())
+	assert.Empty(t, system.writes)
+
+	_, err = e.Isolate(context.Background(), "sda", enum.Temporary, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"block/sda/device/state=offline"}, system.writes)
+
+	steps, err = e.Restore(context.Background(), "sda", false)
+	require.NoError(t, err)
+	assert.Equal(t, "write running to block/sda/device/state", steps[0].String())
+	// Restoring a running disk is a no-op.
+	steps, err = e.Restore(context.Background(), "sda", false)
+	require.NoError(t, err)
+	assert.Empty(t, steps)
+
+	_, err = e.Isolate(context.Background(), "sdz", enum.Temporary, false)
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+	_, err = e.Isolate(context.Background(), "../sda", enum.Temporary, false)
+	assert.Error(t, err)
+}
+
+func TestDiskExecutorFailsMDMembers(t *testing.T) {
+	system := newFakeSystem()
+	system.dirs["block/sdb"] = []string{"device", "holders", "sdb1", "sdb2"}
+	system.dirs["block/sdb/holders"] = nil
+	system.dirs["block/sdb/sdb1/holders"] = []string{"md0"}
+	system.dirs["block/sdb/sdb2/holders"] = []string{"md1"}
+	e := NewDiskExecutor(system)
+
+	_, err := e.Isolate(context.Background(), "sdb", enum.Temporary, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mdadm /dev/md0 --fail /dev/sdb1", "mdadm /dev/md1 --fail /dev/sdb2"}, system.commands)
+
+	system.commands = nil
+	_, err = e.Isolate(context.Background(), "sdb", enum.Permanent, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"mdadm /dev/md0 --fail /dev/sdb1", "mdadm /dev/md0 --remove /dev/sdb1",
+		"mdadm /dev/md1 --fail /dev/sdb2", "mdadm /dev/md1 --remove /dev/sdb2",
+	}, system.commands)
+
+	system.commands = nil
+	_, err = e.Restore(context.Background(), "sdb", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mdadm /dev/md0 --re-add /dev/sdb1", "mdadm /dev/md1 --re-add /dev/sdb2"}, system.commands)
+	assert.Empty(t, system.writes)
+
+	// A failing step stops the action.
+	system.commands, system.fail = nil, "mdadm /dev/md0"
+	steps, err := e.Isolate(context.Background(), "sdb", enum.Temporary, false)
+	assert.Error(t, err)
+	assert.Empty(t, steps)
+	assert.Empty(t, system.commands)
+
+	// Steps applied before the failure are undone.
+	system.commands, system.fail = nil, "mdadm /dev/md1"
+	steps, err = e.Isolate(context.Background(), "sdb", enum.Temporary, false)
+	assert.Error(t, err)
+	_, partial := err.(*PartialError)
+	assert.False(t, partial)
+	require.Len(t, steps, 1)
+	assert.Equal(t, "run mdadm /dev/md0 --fail /dev/sdb1", steps[0].String())
+	assert.Equal(t, []string{"mdadm /dev/md0 --fail /dev/sdb1", "mdadm /dev/md0 --re-add /dev/sdb1"}, system.commands)
+}
+
+func TestApplyUndo(t *testing.T) {
+	system := newFakeSystem()
+	steps := []Step{
+		{Command: []string{"x1"}, Undo: &Step{Command: []string{"y1"}}},
+		{Path: "a", Value: "1"},
+		{Command: []string{"y2"}},
+	}
+
+	// Undo steps that fail leave the device partially changed.
+	system.fail = "y"
+	applied, err := apply(context.Background(), system, "sda", steps, false)
+	require.Len(t, applied, 2)
+	partial, ok := err.(*PartialError)
+	require.True(t, ok, "%v", err)
+	assert.Equal(t, applied, partial.Applied)
+	assert.Contains(t, partial.Error(), "remediation step failed: run y2")
+	assert.Contains(t, partial.Error(), "undo step failed: run y1")
+	assert.Equal(t, []string{"x1"}, system.commands)
+	assert.Equal(t, []string{"a=1"}, system.writes)
+}
+
+func TestNetworkExecutorTakesBondSlavesDown(t *testing.T) {
+	system := newFakeSystem()
+	system.dirs["class/net/eth0"] = []string{"master", "statistics"}
+	system.links["class/net/eth0/master"] = "../bond0"
+	system.dirs["class/net/bond0/bonding"] = []string{"slaves"}
+	system.dirs["class/net/eth1"] = []string{"statistics"}
+	e := NewNetworkExecutor(system)
+
+	steps, err := e.Isolate(context.Background(), "eth0", enum.Temporary, true)
+	require.NoError(t, err)
+	assert.Equal(t, "run ip link set dev eth0 down", steps[0].String())
+	assert.Empty(t, system.commands)
+
+	_, err = e.Isolate(context.Background(), "eth0", enum.Temporary, false)
+	require.NoError(t, err)
+	_, err = e.Restore(context.Background(), "eth0", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ip link set dev eth0 down", "ip link set dev eth0 up"}, system.commands)
+
+	_, err = e.Isolate(context.Background(), "eth1", enum.Temporary, false)
+	assert.ErrorContains(t, err, "not a bond slave")
+	_, err = e.Isolate(context.Background(), "eth9", enum.Temporary, false)
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+}
+
+func TestRAIDExecutorUsesControllerBackend(t *testing.T) {
+	system := newFakeSystem()
+	e := NewRAIDExecutor(system, NewStorCLIBackend("", map[string]string{"host0": "0"}))
+
+	_, err := e.Isolate(context.Background(), "host0/e252/s3", enum.Temporary, false)
+	require.NoError(t, err)
+	_, err = e.Restore(context.Background(), "host0/e252/s3", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"storcli64 /c0/e252/s3 set offline", "storcli64 /c0/e252/s3 set online"}, system.commands)
+
+	steps, err := e.Isolate(context.Background(), "host0/s4", enum.Temporary, true)
+	require.NoError(t, err)
+	assert.Equal(t, "run storcli64 /c0/s4 set offline", steps[0].String())
+	assert.Len(t, system.commands, 2)
+
+	_, err = e.Isolate(context.Background(), "host0", enum.Temporary, false)
+	assert.ErrorContains(t, err, "physical drive")
+	_, err = e.Isolate(context.Background(), "host1/e252/s3", enum.Temporary, false)
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+	_, err = e.Isolate(context.Background(), "host0/e252/s3;reboot", enum.Temporary, false)
+	assert.Error(t, err)
+}
+
+// fakeExecutor records the actions it is asked to take.
+type fakeExecutor struct {
+	actions []string
+	err     error
+}
+
+func (e *fakeExecutor) Isolate(ctx context.Context, deviceID string, strategy enum.IsolationStrategy, dryRun bool) ([]Step, error) {
+	if !dryRun {
+		e.actions = append(e.actions, "isolate "+deviceID+" "+strategy.String())
+	}
+	return []Step{{Path: "block/" + deviceID + "/device/state", Value: "offline"}}, e.err
+}
+
+func (e *fakeExecutor) Restore(ctx context.Context, deviceID string, dryRun bool) ([]Step, error) {
+	if !dryRun {
+		e.actions = append(e.actions, "restore "+deviceID)
+	}
+	return []Step{{Path: "block/" + deviceID + "/device/state", Value: "running"}}, e.err
+}
+
+// stubDetector reports a fixed status for every device.
+type stubDetector struct {
+	status  enum.HealthStatus
+	checked []string
+}
+
+func (d *stubDetector) CheckSubHealth(deviceID string) (detection.HealthStatus, error) {
+	d.checked = append(d.checked, deviceID)
+	return detection.HealthStatus{DeviceID: deviceID, Status: d.status}, nil
+}
+
+func (d *stubDetector) CheckAll() ([]detection.HealthStatus, error) {
+	return nil, nil
+}
+
+func TestDeviceRemediator(t *testing.T) {
+	detector := &stubDetector{status: enum.SubHealthy}
+	executor := &fakeExecutor{}
+	config := &Config{AutoIsolation: true}
+	r := NewRemediator(config, enum.Disk, detector, executor)
+
+	result, err := r.Isolate(enum.Disk, "sda", enum.Permanent)
+	require.NoError(t, err)
+	assert.True(t, result.Success)
+	assert.Equal(t, "isolated", result.Action)
+	assert.Equal(t, []string{"write offline to block/sda/device/state"}, result.Steps)
+	assert.Equal(t, []string{"isolate sda permanent"}, executor.actions)
+
+	_, err = r.Isolate(enum.RAID, "sda", enum.Permanent)
+	assert.Error(t, err)
+
+	// Recovery waits for the device to be healthy again.
+	result, err = r.Recover(enum.Disk, "sda")
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	detector.status = enum.Healthy
+	result, err = r.Recover(enum.Disk, "sda")
+	require.NoError(t, err)
+	assert.Equal(t, "recovered", result.Action)
+	assert.Equal(t, []string{"isolate sda permanent", "restore sda"}, executor.actions)
+
+	result, err = r.Isolate(enum.Disk, "sda", enum.Temporary)
+	require.NoError(t, err)
+	assert.Equal(t, "no action", result.Action)
+
+	config.DryRun = true
+	detector.status = enum.SubHealthy
+	result, err = r.Isolate(enum.Disk, "sdb", enum.Temporary)
+	require.NoError(t, err)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, "isolation planned", result.Action)
+	assert.Len(t, result.Steps, 1)
+	assert.Len(t, executor.actions, 2)
+
+	config.DryRun = false
+	executor.err = errors.New("mdadm failed", nil)
+	result, err = r.Isolate(enum.Disk, "sdb", enum.Temporary)
+	assert.Error(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, "isolation failed", result.Action)
+
+	// RAID drives are checked through their controller.
+	raid := NewRemediator(config, enum.RAID, detector, &fakeExecutor{})
+	_, err = raid.Isolate(enum.RAID, "host0/e252/s3", enum.Temporary)
+	require.NoError(t, err)
+	assert.Equal(t, "host0", detector.checked[len(detector.checked)-1])
+}
+
+// raid1System returns a system where sdb1 and sdc1 form the RAID 1 array md0.
+func raid1System() *fakeSystem {
+	system := newFakeSystem()
+	for _, disk := range []string{"sdb", "sdc"} {
+		system.dirs["block/"+disk] = []string{"device", "holders", disk + "1"}
+		system.dirs["block/"+disk+"/"+disk+"1/holders"] = []string{"md0"}
+		system.links["class/block/"+disk+"1"] = "../../devices/pci0000:00/host0/block/" + disk + "/" + disk + "1"
+		system.files["block/md0/md/dev-"+disk+"1/state"] = "in_sync"
+	}
+	system.dirs["block/md0/md"] = []string{"array_state", "dev-sdb1", "dev-sdc1", "level", "rd0", "rd1"}
+	system.files["block/md0/md/level"] = "raid1"
+	system.files["block/md0/md/sync_action"] = "idle"
+	return system
+}
+
+func TestDiskExecutorGroups(t *testing.T) {
+	system := raid1System()
+	system.dirs["block/sdb/holders"] = []string{"dm-0"}
+	system.files["block/dm-0/dm/uuid"] = "mpath-3600508b1001c"
+	system.files["block/dm-0/dm/name"] = "mpatha"
+	system.dirs["block/dm-0/slaves"] = []string{"sdb", "sdd"}
+	system.files["block/sdb/device/state"] = "running"
+	system.files["block/sdd/device/state"] = "offline"
+
+	groups, err := NewDiskExecutor(system).Groups(context.Background(), "sdb")
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, RedundancyGroup{Kind: GroupMD, Name: "md0", Level: "raid1", Members: []GroupMember{
+		{ID: "sdb1", Device: "sdb", Healthy: true},
+		{ID: "sdc1", Device: "sdc", Healthy: true},
+	}}, groups[0])
+	assert.Equal(t, RedundancyGroup{Kind: GroupMultipath, Name: "mpatha", Members: []GroupMember{
+		{ID: "sdb", Device: "sdb", Healthy: true},
+		{ID: "sdd", Device: "sdd", Healthy: false},
+	}}, groups[1])
+}
+
+func TestNetworkExecutorGroups(t *testing.T) {
+	system := newFakeSystem()
+	for _, slave := range []string{"eth0", "eth1", "eth2"} {
+		system.dirs["class/net/"+slave] = []string{"master"}
+		system.links["class/net/"+slave+"/master"] = "../bond0"
+		system.files["class/net/"+slave+"/operstate"] = "up"
+	}
+	system.files["class/net/eth2/operstate"] = "down"
+	system.dirs["class/net/bond0/bonding"] = []string{"slaves"}
+	system.files["class/net/bond0/bonding/slaves"] = "eth0 eth1 eth2"
+
+	groups, err := NewNetworkExecutor(system).Groups(context.Background(), "eth0")
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, "bond0", groups[0].Name)
+	assert.Equal(t, []GroupMember{
+		{ID: "eth0", Device: "eth0", Healthy: true},
+		{ID: "eth1", Device: "eth1", Healthy: true},
+		{ID: "eth2", Device: "eth2", Healthy: false},
+	}, groups[0].Members)
+}
+
+func TestStorCLIDriveGroups(t *testing.T) {
+	system := newFakeSystem()
+	system.outputs["storcli64 /c0/eall/sall show J"] = `{"Controllers":[{"Command Status":{"Status":"Success"},"Response Data":{"Drive Information":[
+		{"EID:Slt":"252:0","State":"Onln","DG":0},
+		{"EID:Slt":"252:1","State":"Onln","DG":0},
+		{"EID:Slt":"252:2","State":"Rbld","DG":0},
+		{"EID:Slt":"252:3","State":"Onln","DG":1},
+		{"EID:Slt":"252:4","State":"UGood","DG":"-"}]}}]}`
+	system.outputs["storcli64 /c0/vall show J"] = `{"Controllers":[{"Command Status":{"Status":"Success"},"Response Data":{"Virtual Drives":[
+		{"DG/VD":"0/0","TYPE":"RAID5","State":"Dgrd"},
+		{"DG/VD":"1/1","TYPE":"RAID0","State":"Optl"}]}}]}`
+	e := NewRAIDExecutor(system, NewStorCLIBackend("", map[string]string{"host0": "0"}))
+
+	groups, err := e.Groups(context.Background(), "host0/e252/s1")
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	g := groups[0]
+	assert.Equal(t, "host0/dg0", g.Name)
+	assert.Equal(t, "raid5", g.Level)
+	assert.True(t, g.Recovering)
+	require.Len(t, g.Members, 3)
+	assert.Equal(t, GroupMember{ID: "e252/s2", Device: "host0/e252/s2", Healthy: false}, g.Members[2])
+
+	groups, err = e.Groups(context.Background(), "host0/e252/s4")
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestCheckGuardrails(t *testing.T) {
+	bond := RedundancyGroup{Kind: GroupBond, Name: "bond0", Members: []GroupMember{
+		{ID: "eth0", Device: "eth0", Healthy: true},
+		{ID: "eth1", Device: "eth1", Healthy: true},
+		{ID: "eth2", Device: "eth2", Healthy: true},
+		{ID: "eth3", Device: "eth3", Healthy: false},
+	}}
+
+	assert.Nil(t, checkGuardrails(&Config{}, "eth0", []RedundancyGroup{bond}))
+	assert.Nil(t, checkGuardrails(&Config{MinHealthyPaths: 2, PreservePathsRatio: 0.5}, "eth0", []RedundancyGroup{bond}))
+	// Devices outside every group have nothing backing them up.
+	for _, groups := range [][]RedundancyGroup{nil, {bond}} {
+		refusal := checkGuardrails(&Config{}, "eth9", groups)
+		require.NotNil(t, refusal)
+		assert.Equal(t, DecisionRefused, refusal.Decision)
+		assert.Equal(t, RuleRedundancyFloor, refusal.Violations[0].Rule)
+		assert.Equal(t, -1.0, refusal.Violations[0].Actual)
+	}
+
+	refusal := checkGuardrails(&Config{MinHealthyPaths: 3, PreservePathsRatio: 0.75}, "eth0", []RedundancyGroup{bond})
+	require.NotNil(t, refusal)
+	assert.Equal(t, DecisionRefused, refusal.Decision)
+	require.Len(t, refusal.Violations, 2)
+	assert.Equal(t, RuleMinHealthyPaths, refusal.Violations[0].Rule)
+	assert.Equal(t, 2.0, refusal.Violations[0].Actual)
+	assert.Equal(t, RulePreservePathsRatio, refusal.Violations[1].Rule)
+	assert.InDelta(t, 0.5, refusal.Violations[1].Actual, 1e-9)
+	assert.Contains(t, refusal.Explain(), "bond bond0 would keep 2 healthy members, fewer than the minimum of 3")
+
+	// The last healthy member is never isolated, whatever the configuration.
+	last := RedundancyGroup{Kind: GroupBond, Name: "bond1", Members: []GroupMember{
+		{ID: "eth4", Device: "eth4", Healthy: true},
+		{ID: "eth5", Device: "eth5", Healthy: false},
+	}}
+	refusal = checkGuardrails(&Config{}, "eth4", []RedundancyGroup{last})
+	require.NotNil(t, refusal)
+	assert.Equal(t, RuleRedundancyFloor, refusal.Violations[0].Rule)
+	assert.Contains(t, refusal.Explain(), "bond bond1 would fail without this member")
+
+	raid5 := RedundancyGroup{Kind: GroupRAIDVD, Name: "host0/dg0", Level: "raid5", Members: []GroupMember{
+		{ID: "e252/s0", Device: "host0/e252/s0", Healthy: true},
+		{ID: "e252/s1", Device: "host0/e252/s1", Healthy: true},
+		{ID: "e252/s2", Device: "host0/e252/s2", Healthy: true},
+	}}
+	assert.Nil(t, checkGuardrails(&Config{}, "host0/e252/s0", []RedundancyGroup{raid5}))
+	refusal = checkGuardrails(&Config{MinRedundancy: 1}, "host0/e252/s0", []RedundancyGroup{raid5})
+	require.NotNil(t, refusal)
+	assert.Equal(t, DecisionRefused, refusal.Decision)
+	assert.Contains(t, refusal.Explain(), "could lose 0 more members, fewer than the required 1")
+
+	// A degraded array that is rebuilding defers isolation instead of refusing it.
+	raid5.Members[2].Healthy = false
+	refusal = checkGuardrails(&Config{}, "host0/e252/s0", []RedundancyGroup{raid5})
+	require.NotNil(t, refusal)
+	assert.Equal(t, DecisionRefused, refusal.Decision)
+	raid5.Recovering = true
+	refusal = checkGuardrails(&Config{}, "host0/e252/s0", []RedundancyGroup{raid5})
+	require.NotNil(t, refusal)
+	assert.Equal(t, DecisionDeferred, refusal.Decision)
+	assert.Contains(t, refusal.Explain(), "deferred until the rebuild completes")
+}
+
+func TestDeviceRemediatorGuardrails(t *testing.T) {
+	system := raid1System()
+	system.files["block/md0/md/dev-sdc1/state"] = "faulty"
+	r := NewRemediator(&Config{AutoIsolation: true}, enum.Disk, nil, NewDiskExecutor(system))
+
+	result, err := r.Isolate(enum.Disk, "sdb", enum.Temporary)
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, "isolation refused", result.Action)
+	require.NotNil(t, result.Refusal)
+	assert.Equal(t, "md0", result.Refusal.Violations[0].Group.Name)
+	assert.EqualError(t, result.Error, result.Refusal.Explain())
+	assert.Empty(t, system.commands)
+
+	// With both mirrors in sync, one can go.
+	system.files["block/md0/md/dev-sdc1/state"] = "in_sync"
+	result, err = r.Isolate(enum.Disk, "sdb", enum.Temporary)
+	require.NoError(t, err)
+	assert.True(t, result.Success)
+	assert.Equal(t, []string{"mdadm /dev/md0 --fail /dev/sdb1"}, system.commands)
+
+	// A standalone disk is never taken offline.
+	system.dirs["block/sdd"] = []string{"device", "holders"}
+	system.files["block/sdd/device/state"] = "running"
+	result, err = r.Isolate(enum.Disk, "sdd", enum.Temporary)
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, "isolation refused", result.Action)
+	require.NotNil(t, result.Refusal)
+	assert.Contains(t, result.Refusal.Explain(), "sdd is in no redundancy group")
+	assert.Empty(t, system.writes)
+}
+
+func TestExecutorProbes(t *testing.T) {
+	system := newFakeSystem()
+	system.files["block/sda/device/state"] = "offline"
+	require.NoError(t, NewDiskExecutor(system).Probe(context.Background(), "sda"))
+	assert.Equal(t, []string{"dd if=/dev/sda of=/dev/null bs=1M count=64 iflag=direct"}, system.commands)
+	// The offline disk is only set running for the read.
+	assert.Equal(t, []string{"block/sda/device/state=running", "block/sda/device/state=offline"}, system.writes)
+	system.fail = "dd"
+	assert.ErrorContains(t, NewDiskExecutor(system).Probe(context.Background(), "sda"), "read test of disk sda failed")
+	assert.Equal(t, "offline", system.files["block/sda/device/state"])
+
+	// An offline multipath path is not set running, which would put it back into use.
+	system.fail = ""
+	system.writes = nil
+	system.commands = nil
+	system.dirs["block/sda/holders"] = []string{"dm-0"}
+	assert.ErrorContains(t, NewDiskExecutor(system).Probe(context.Background(), "sda"), "refusing to probe offline disk sda held by dm-0")
+	assert.Empty(t, system.writes)
+	assert.Empty(t, system.commands)
+
+	system.dirs["class/net/eth0"] = []string{"master"}
+	system.links["class/net/eth0/master"] = "../bond0"
+	system.dirs["class/net/bond0/bonding"] = []string{"slaves"}
+	network := NewNetworkExecutor(system)
+	system.outputs["ethtool -t eth0 online"] = "The test result is PASS\nThe test extra info:\nLink test (on/offline)\t 0\n"
+	assert.NoError(t, network.Probe(context.Background(), "eth0"))
+	system.outputs["ethtool -t eth0 online"] = "The test result is FAIL\nThe test extra info:\nLink test (on/offline)\t 1\n"
+	assert.ErrorContains(t, network.Probe(context.Background(), "eth0"), "did not pass")
+
+	raid := NewRAIDExecutor(system, NewStorCLIBackend("", map[string]string{"host0": "0"}))
+	system.outputs["storcli64 /c0/e252/s3 show J"] = `{"Controllers":[{"Command Status":{"Status":"Success"},"Response Data":{"Drive Information":[{"EID:Slt":"252:3","State":"Offln","DG":0}]}}]}`
+	assert.NoError(t, raid.Probe(context.Background(), "host0/e252/s3"))
+	system.outputs["storcli64 /c0/e252/s3 show J"] = `{"Controllers":[{"Command Status":{"Status":"Success"},"Response Data":{"Drive Information":[{"EID:Slt":"252:3","State":"UBad","DG":"-"}]}}]}`
+	assert.ErrorContains(t, raid.Probe(context.Background(), "host0/e252/s3"), "in state UBad")
+}
+
+// probingExecutor is a fakeExecutor whose probes return queued results, then succeed.
+type probingExecutor struct {
+	fakeExecutor
+	probes []error
+	probed int
+}
+
+func (e *probingExecutor) Probe(ctx context.Context, deviceID string) error {
+	e.probed++
+	if len(e.probes) == 0 {
+		return nil
+	}
+	err := e.probes[0]
+	e.probes = e.probes[1:]
+	return err
+}
+
+func TestDeviceRemediatorProbation(t *testing.T) {
+	probeErr := errors.New("read test failed", nil)
+	executor := &probingExecutor{probes: []error{probeErr, probeErr}}
+	config := &Config{AutoIsolation: true, ProbeInterval: time.Minute, RecoveryProbes: 2, RecoveryTimeout: time.Hour}
+	r := NewRemediator(config, enum.Disk, nil, executor)
+
+	start := time.Now()
+	_, err := r.Isolate(enum.Disk, "sda", enum.Temporary)
+	require.NoError(t, err)
+	isolations := r.Isolations()
+	require.Len(t, isolations, 1)
+	assert.Equal(t, "sda", isolations[0].DeviceID)
+	assert.False(t, isolations[0].Expires.IsZero())
+
+	// Recovery is left to the probes.
+	result, err := r.Recover(enum.Disk, "sda")
+	require.NoError(t, err)
+	assert.Equal(t, "recovery pending", result.Action)
+	assert.Equal(t, []string{"isolate sda temporary"}, executor.actions)
+
+	// Probes back off exponentially after failures.
+	now := start.Add(30 * time.Second)
+	assert.Empty(t, r.ProbeDue(now))
+	assert.Equal(t, 0, executor.probed)
+	now = start.Add(2 * time.Minute)
+	r.ProbeDue(now)
+	assert.Equal(t, now.Add(time.Minute), r.Isolations()[0].NextProbe)
+	now = now.Add(time.Minute)
+	r.ProbeDue(now)
+	iso := r.Isolations()[0]
+	assert.Equal(t, now.Add(2*time.Minute), iso.NextProbe)
+	assert.Equal(t, 2, iso.Failed)
+	assert.Equal(t, "read test failed", iso.LastError)
+
+	// Re-admission takes RecoveryProbes consecutive healthy probes.
+	now = iso.NextProbe
+	assert.Empty(t, r.ProbeDue(now))
+	assert.Equal(t, 1, r.Isolations()[0].Passed)
+	now = now.Add(time.Minute)
+	results := r.ProbeDue(now)
+	require.Len(t, results, 1)
+	assert.Equal(t, "recovered", results[0].Action)
+	assert.Empty(t, r.Isolations())
+	assert.Equal(t, []string{"isolate sda temporary", "restore sda"}, executor.actions)
+
+	// A device that keeps failing is escalated to permanent isolation once its TTL expires.
+	var escalations []Escalation
+	r.OnEscalate(func(e Escalation) { escalations = append(escalations, e) })
+	executor.probes = []error{probeErr, probeErr, probeErr, probeErr, probeErr, probeErr, probeErr}
+	start = time.Now()
+	_, err = r.Isolate(enum.Disk, "sdb", enum.Temporary)
+	require.NoError(t, err)
+	for now = start; now.Before(start.Add(59 * time.Minute)); now = now.Add(time.Minute) {
+		assert.Empty(t, r.ProbeDue(now))
+	}
+	results = r.ProbeDue(r.Isolations()[0].Expires)
+	require.Len(t, results, 1)
+	assert.Equal(t, "escalated", results[0].Action)
+	assert.Equal(t, "isolate sdb permanent", executor.actions[len(executor.actions)-1])
+	require.Len(t, escalations, 1)
+	assert.Equal(t, "sdb", escalations[0].DeviceID)
+	assert.Contains(t, escalations[0].Reason, "did not pass 2 consecutive probes within 1h0m0s")
+	assert.Empty(t, r.Isolations())
+
+	// Permanent isolation is never probed.
+	_, err = r.Isolate(enum.Disk, "sdc", enum.Permanent)
+	require.NoError(t, err)
+	assert.Empty(t, r.Isolations())
+}
+
+func TestParseChangeWindow(t *testing.T) {
+	w, err := ParseChangeWindow("0 22 * * 6 4h")
+	require.NoError(t, err)
+	saturday := time.Date(2026, 10, 17, 22, 0, 0, 0, time.UTC)
+	assert.True(t, w.Contains(saturday))
+	assert.True(t, w.Contains(saturday.Add(3*time.Hour+59*time.Minute)))
+	assert.False(t, w.Contains(saturday.Add(4*time.Hour)))
+	assert.False(t, w.Contains(saturday.Add(-time.Minute)))
+	assert.False(t, w.Contains(saturday.Add(24*time.Hour)))
+
+	w, err = ParseChangeWindow("*/30 1-3 1,15 * 7 10m")
+	require.NoError(t, err)
+	// Day of month and day of week match either way, as in cron.
+	assert.True(t, w.Contains(time.Date(2026, 10, 18, 2, 35, 0, 0, time.UTC))) // Sunday
+	assert.True(t, w.Contains(time.Date(2026, 10, 15, 1, 0, 0, 0, time.UTC)))  // The 15th
+	assert.False(t, w.Contains(time.Date(2026, 10, 16, 1, 0, 0, 0, time.UTC)))
+	assert.False(t, w.Contains(time.Date(2026, 10, 18, 2, 15, 0, 0, time.UTC)))
+
+	for _, spec := range []string{"0 22 * * 6", "60 * * * * 1h", "0 0 * * * 0s", "0 0 * * * 200h", "0 x * * * 1h", "0 5-2 * * * 1h"} {
+		_, err := ParseChangeWindow(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestWorkflowPolicies(t *testing.T) {
+	window, err := ParseChangeWindow("0 2 * * 0 4h")
+	require.NoError(t, err)
+	config := &WorkflowConfig{
+		Policies: []Policy{
+			{DeviceType: enum.Disk, Tier: slo.Critical, Mode: ModeAuto, Windows: []ChangeWindow{window}},
+			{DeviceType: enum.Network, Mode: ModeRecommend},
+		},
+		Tiers: map[detection.DeviceRef]slo.ServiceTier{
+			{DeviceType: enum.Disk, DeviceID: "sda"}:   slo.Critical,
+			{DeviceType: enum.RAID, DeviceID: "host0"}: slo.Critical,
+		},
+	}
+	w := newTestWorkflow(t, config, nil)
+
+	sunday := time.Date(2026, 10, 18, 3, 0, 0, 0, time.Local)
+	mode, _ := w.Decide(enum.Disk, slo.Critical, sunday)
+	assert.Equal(t, ModeAuto, mode)
+	mode, reason := w.Decide(enum.Disk, slo.Critical, sunday.Add(4*time.Hour))
+	assert.Equal(t, ModeApproval, mode)
+	assert.Equal(t, "outside change windows 0 2 * * 0 4h", reason)
+	mode, _ = w.Decide(enum.Disk, slo.NonCritical, sunday.Add(4*time.Hour))
+	assert.Equal(t, ModeAuto, mode)
+	mode, reason = w.Decide(enum.Network, slo.NonCritical, sunday)
+	assert.Equal(t, ModeRecommend, mode)
+	assert.Contains(t, reason, "requires recommend")
+
+	assert.Equal(t, slo.Critical, w.tier(enum.RAID, "host0/e252/s3"))
+	assert.Equal(t, slo.NonCritical, w.tier(enum.Disk, "sdb"))
+}
+
+func TestWorkflowApprovals(t *testing.T) {
+	disks := &fakeExecutor{}
+	nics := &fakeExecutor{}
+	remediators := map[enum.DeviceType]Remediator{
+		enum.Disk:    NewRemediator(&Config{AutoIsolation: true}, enum.Disk, nil, disks),
+		enum.Network: NewRemediator(&Config{AutoIsolation: true}, enum.Network, nil, nics),
+	}
+	config := &WorkflowConfig{
+		Policies:   []Policy{{DeviceType: enum.Disk, Tier: slo.Critical, Mode: ModeApproval}, {DeviceType: enum.Network, Mode: ModeRecommend}},
+		Tiers:      map[detection.DeviceRef]slo.ServiceTier{{DeviceType: enum.Disk, DeviceID: "sda"}: slo.Critical},
+		Host:       "node1",
+		RateLimits: []RateLimit{{Scope: ScopeHost, Max: 1}},
+	}
+	w := newTestWorkflow(t, config, remediators)
+
+	// Critical disks wait for approval.
+	result, err := w.Isolate(enum.Disk, "sda", enum.Temporary)
+	require.NoError(t, err)
+	assert.Equal(t, "isolation pending approval", result.Action)
+	require.NotEmpty(t, result.PendingID)
+	again, err := w.Isolate(enum.Disk, "sda", enum.Permanent)
+	require.NoError(t, err)
+	assert.Equal(t, result.PendingID, again.PendingID)
+	actions := w.Actions(false)
+	require.Len(t, actions, 1)
+	assert.Equal(t, enum.Permanent, actions[0].Strategy)
+	assert.Empty(t, disks.actions)
+
+	// Network isolation is only recommended.
+	result, err = w.Isolate(enum.Network, "eth0", enum.Temporary)
+	require.NoError(t, err)
+	assert.Equal(t, "isolation recommended", result.Action)
+	assert.Empty(t, nics.actions)
+
+	// Non-critical disks are isolated automatically, within the rate limit.
+	result, err = w.Isolate(enum.Disk, "sdb", enum.Temporary)
+	require.NoError(t, err)
+	assert.Equal(t, "isolated", result.Action)
+	result, err = w.Isolate(enum.Disk, "sdc", enum.Temporary)
+	require.NoError(t, err)
+	assert.Equal(t, "isolation pending approval", result.Action)
+	actions = w.Actions(false)
+	require.Len(t, actions, 2)
+	assert.Equal(t, "rate limit of 1 isolations per 1h0m0s on host/node1 reached", actions[1].Reason)
+
+	approved, err := w.Approve(actions[0].ID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, ActionApproved, approved.State)
+	assert.Equal(t, "alice", approved.DecidedBy)
+	require.NotNil(t, approved.Result)
+	assert.True(t, approved.Result.Success)
+	assert.Equal(t, []string{"isolate sdb temporary", "isolate sda permanent"}, disks.actions)
+	_, err = w.Approve(actions[0].ID, "bob")
+	assert.True(t, errors.Is(err, errors.NewInvalidState("", nil)))
+	_, err = w.Approve("missing", "bob")
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+
+	rejected, err := w.Reject(actions[1].ID, "bob", "not during the batch run")
+	require.NoError(t, err)
+	assert.Equal(t, ActionRejected, rejected.State)
+	assert.Equal(t, "not during the batch run", rejected.Comment)
+	assert.Empty(t, w.Actions(false))
+	assert.Len(t, w.Actions(true), 2)
+
+	// Recovery cancels pending isolations without approval.
+	result, err = w.Isolate(enum.Disk, "sdd", enum.Temporary)
+	require.NoError(t, err)
+	_, err = w.Recover(enum.Disk, "sdd")
+	require.NoError(t, err)
+	assert.Empty(t, w.Actions(false))
+	assert.Equal(t, "restore sdd", disks.actions[len(disks.actions)-1])
+
+	_, err = w.Isolate(enum.RAID, "host0/e252/s3", enum.Temporary)
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+}
+
+func TestWorkflowClusterRateLimit(t *testing.T) {
+	limits := []RateLimit{{Scope: ScopeCluster, Max: 1}}
+	_, err := NewWorkflow(&WorkflowConfig{Host: "node1", Cluster: "prod", RateLimits: limits}, nil)
+	assert.Error(t, err, "a per-host counter cannot enforce a cluster limit")
+
+	// Hosts sharing a counter share the cluster's limit.
+	counter := NewMemoryCounter()
+	var workflows []*Workflow
+	for _, host := range []string{"node1", "node2"} {
+		remediators := map[enum.DeviceType]Remediator{enum.Disk: NewRemediator(&Config{AutoIsolation: true}, enum.Disk, nil, &fakeExecutor{})}
+		workflows = append(workflows, newTestWorkflow(t, &WorkflowConfig{Host: host, Cluster: "prod", RateLimits: limits, Counter: counter}, remediators))
+	}
+	result, err := workflows[0].Isolate(enum.Disk, "sda", enum.Temporary)
+	require.NoError(t, err)
+	assert.Equal(t, "isolated", result.Action)
+	result, err = workflows[1].Isolate(enum.Disk, "sda", enum.Temporary)
+	require.NoError(t, err)
+	assert.Equal(t, "isolation pending approval", result.Action)
+}
+
+// slowExecutor counts isolations, each taking a while to apply.
+type slowExecutor struct {
+	mu       sync.Mutex
+	isolated int
+}
+
+func (e *slowExecutor) Isolate(ctx context.Context, deviceID string, strategy enum.IsolationStrategy, dryRun bool) ([]Step, error) {
+	time.Sleep(10 * time.Millisecond)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.isolated++
+	return nil, nil
+}
+
+func (e *slowExecutor) Restore(ctx context.Context, deviceID string, dryRun bool) ([]Step, error) {
+	return nil, nil
+}
+
+func TestWorkflowRateLimitIsAtomic(t *testing.T) {
+	executor := &slowExecutor{}
+	remediators := map[enum.DeviceType]Remediator{enum.Disk: NewRemediator(&Config{AutoIsolation: true}, enum.Disk, nil, executor)}
+	w := newTestWorkflow(t, &WorkflowConfig{Host: "node1", RateLimits: []RateLimit{{Scope: ScopeHost, Max: 2}}}, remediators)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := w.Isolate(enum.Disk, "sd"+string(rune('a'+i)), enum.Temporary)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2, executor.isolated)
+	assert.Len(t, w.Actions(false), 6)
+}
+
+func newTestWorkflow(t *testing.T, config *WorkflowConfig, remediators map[enum.DeviceType]Remediator) *Workflow {
+	w, err := NewWorkflow(config, remediators)
+	require.NoError(t, err)
+	return w
+}
+
+func newTestState(t *testing.T, dir string) *StateStore {
+	store, err := storage.NewFileStorage(dir)
+	require.NoError(t, err)
+	state, err := NewStateStore(store, 0)
+	require.NoError(t, err)
+	return state
+}
+
+func TestStateStore(t *testing.T) {
+	dir := t.TempDir()
+	state := newTestState(t, dir)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	a, err := state.Create(ActionRecord{DeviceType: enum.Disk, DeviceID: "sda", Status: StatusExecuting, Trigger: "direct request"}, start)
+	require.NoError(t, err)
+	assert.Equal(t, "disk-20240301T120000-1", a.ID)
+	_, err = state.Transition(a.ID, Transition{To: StatusIsolated, Message: "applied", Steps: []string{"write offline to block/sda/device/state"}}, start.Add(time.Second))
+	require.NoError(t, err)
+	_, err = state.Create(ActionRecord{ID: "pending-1", DeviceType: enum.Network, DeviceID: "eth0", Status: StatusPending, Trigger: "approval"}, start.Add(time.Minute))
+	require.NoError(t, err)
+	_, err = state.Create(ActionRecord{ID: "pending-1"}, start)
+	assert.True(t, errors.Is(err, errors.NewInvalidState("", nil)))
+	_, err = state.Transition("missing", Transition{To: StatusFailed}, start)
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+
+	// Actions and their audit log survive a restart.
+	state = newTestState(t, dir)
+	actions := state.Actions()
+	require.Len(t, actions, 2)
+	assert.Equal(t, a.ID, actions[0].ID)
+	assert.Equal(t, StatusIsolated, actions[0].Status)
+	assert.Equal(t, "direct request", actions[0].Trigger)
+	assert.Equal(t, []string{"write offline to block/sda/device/state"}, actions[0].Steps)
+
+	_, err = state.Transition(a.ID, Transition{To: StatusRecovered, Trigger: "probes passed"}, start.Add(time.Hour))
+	require.NoError(t, err)
+	entries, err := state.Audit(AuditQuery{ActionID: a.ID})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, ActionStatus(""), entries[0].From)
+	assert.Equal(t, StatusExecuting, entries[0].To)
+	assert.Equal(t, StatusIsolated, entries[2].From)
+	assert.Equal(t, "probes passed", entries[2].Trigger)
+	record, ok := state.Get(a.ID)
+	require.True(t, ok)
+	assert.Equal(t, "direct request", record.Trigger)
+
+	entries, err = state.Audit(AuditQuery{DeviceTypes: []enum.DeviceType{enum.Network}})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "eth0", entries[0].DeviceID)
+	entries, err = state.Audit(AuditQuery{Since: start.Add(time.Second), Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, StatusPending, entries[0].To)
+
+	// Finished actions are pruned after the retention, also when loaded; their audit
+	// entries are kept.
+	_, err = state.Transition("pending-1", Transition{To: StatusCancelled}, start.Add(31*24*time.Hour))
+	require.NoError(t, err)
+	_, ok = state.Get(a.ID)
+	assert.False(t, ok)
+	_, ok = state.Get("pending-1")
+	assert.True(t, ok)
+	assert.Empty(t, newTestState(t, dir).Actions())
+	entries, err = state.Audit(AuditQuery{})
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+}
+
+func TestDeviceRemediatorState(t *testing.T) {
+	state := newTestState(t, t.TempDir())
+	executor := &probingExecutor{}
+	r := NewRemediator(&Config{AutoIsolation: true}, enum.Disk, nil, executor)
+	r.SetState(state)
+
+	evidence := NewEvidence(detection.HealthStatus{Status: enum.SubHealthy, Confidence: 0.9}, time.Now())
+	_, err := r.IsolateWith(enum.Disk, "sda", enum.Temporary, Cause{Trigger: "state change from healthy to subhealthy", Evidence: evidence})
+	require.NoError(t, err)
+	actions := state.Actions()
+	require.Len(t, actions, 1)
+	assert.Equal(t, StatusIsolated, actions[0].Status)
+	assert.Equal(t, "state change from healthy to subhealthy", actions[0].Trigger)
+	assert.Equal(t, "subhealthy", actions[0].Evidence.Status)
+	assert.Equal(t, []string{"write offline to block/sda/device/state"}, actions[0].Steps)
+
+	// Failure escalates the isolation in effect to permanent.
+	_, err = r.IsolateWith(enum.Disk, "sda", enum.Permanent, Cause{Trigger: "state change from subhealthy to failed"})
+	require.NoError(t, err)
+	actions = state.Actions()
+	require.Len(t, actions, 1)
+	assert.Equal(t, enum.Permanent, actions[0].Strategy)
+	_, err = r.Recover(enum.Disk, "sda")
+	require.NoError(t, err)
+	record, _ := state.Get(actions[0].ID)
+	assert.Equal(t, StatusRecovered, record.Status)
+
+	executor.err = errors.New("device busy", nil)
+	_, err = r.Isolate(enum.Disk, "sdb", enum.Temporary)
+	assert.Error(t, err)
+	actions = state.Actions()
+	require.Len(t, actions, 2)
+	assert.Equal(t, StatusFailed, actions[1].Status)
+	assert.Equal(t, "device busy", actions[1].Error)
+	assert.Equal(t, "direct request", actions[1].Trigger)
+
+	// A partly applied isolation that could not be undone stays in effect until recovery.
+	executor.err = &PartialError{Err: errors.New("md1 busy", nil), Rollback: errors.New("md0 busy", nil)}
+	result, err := r.Isolate(enum.Disk, "sdc", enum.Temporary)
+	assert.Error(t, err)
+	assert.Equal(t, "isolation partially applied", result.Action)
+	actions = state.Actions()
+	require.Len(t, actions, 3)
+	assert.Equal(t, StatusPartial, actions[2].Status)
+	executor.err = nil
+	_, err = r.Recover(enum.Disk, "sdc")
+	require.NoError(t, err)
+	record, _ = state.Get(actions[2].ID)
+	assert.Equal(t, StatusRecovered, record.Status)
+
+	entries, err := state.Audit(AuditQuery{DeviceID: "sda"})
+	require.NoError(t, err)
+	var transitions []string
+	for _, e := range entries {
+		transitions = append(transitions, string(e.From)+">"+string(e.To))
+	}
+	assert.Equal(t, []string{">executing", "executing>isolated", "isolated>executing", "executing>isolated", "isolated>recovered"}, transitions)
+}
+
+// inspectingExecutor is a probingExecutor that reports which devices are isolated.
+type inspectingExecutor struct {
+	probingExecutor
+	isolated map[string]bool
+}
+
+func (e *inspectingExecutor) Isolated(ctx context.Context, deviceID string) (bool, error) {
+	return e.isolated[deviceID], nil
+}
+
+func TestDeviceRemediatorReconcile(t *testing.T) {
+	dir := t.TempDir()
+	state := newTestState(t, dir)
+	before := time.Now().Add(-time.Hour)
+	for _, a := range []ActionRecord{
+		{ID: "a1", DeviceID: "sda", Strategy: enum.Temporary, Status: StatusExecuting},
+		{ID: "a2", DeviceID: "sdb", Strategy: enum.Temporary, Status: StatusExecuting},
+		{ID: "a3", DeviceID: "sdc", Strategy: enum.Temporary, Status: StatusIsolated},
+		{ID: "a4", DeviceID: "sdd", Strategy: enum.Temporary, Status: StatusIsolated},
+		{ID: "a5", DeviceID: "sde", Strategy: enum.Permanent, Status: StatusIsolated},
+		{ID: "a6", DeviceID: "sdf", Strategy: enum.Temporary, Status: StatusPending},
+	} {
+		a.DeviceType = enum.Disk
+		_, err := state.Create(a, before)
+		require.NoError(t, err)
+	}
+
+	// After a restart, the actions are checked against the devices.
+	executor := &inspectingExecutor{isolated: map[string]bool{"sda": true, "sdd": true}}
+	r := NewRemediator(&Config{AutoIsolation: true}, enum.Disk, nil, executor)
+	r.SetState(newTestState(t, dir))
+	require.NoError(t, r.Reconcile(time.Now()))
+
+	status := make(map[string]ActionStatus)
+	for _, a := range r.stateStore().Actions() {
+		status[a.DeviceID] = a.Status
+	}
+	assert.Equal(t, map[string]ActionStatus{
+		"sda": StatusIsolated,
+		"sdb": StatusFailed,
+		"sdc": StatusRecovered,
+		"sdd": StatusIsolated,
+		"sde": StatusIsolated, // Permanent isolations are not inspected
+		"sdf": StatusPending,
+	}, status)
+
+	isolations := r.Isolations()
+	require.Len(t, isolations, 2)
+	assert.Equal(t, "sda", isolations[0].DeviceID)
+	assert.True(t, before.Equal(isolations[1].Since))
+
+	// Probation resumes where it left off.
+	results := r.ProbeDue(time.Now())
+	assert.Empty(t, results)
+	assert.Equal(t, 1, executor.probed)
+}
+
+func TestExecutorInspection(t *testing.T) {
+	system := newFakeSystem()
+	system.dirs["block/sda"] = []string{"device"}
+	system.files["block/sda/device/state"] = "offline"
+	system.dirs["block/sdb"] = []string{"sdb1"}
+	system.dirs["block/sdb/sdb1/holders"] = []string{"md0"}
+	system.files["block/md0/md/dev-sdb1/state"] = "in_sync"
+	disks := NewDiskExecutor(system)
+	isolated, err := disks.Isolated(context.Background(), "sda")
+	require.NoError(t, err)
+	assert.True(t, isolated)
+	isolated, err = disks.Isolated(context.Background(), "sdb")
+	require.NoError(t, err)
+	assert.False(t, isolated)
+	system.files["block/md0/md/dev-sdb1/state"] = "faulty"
+	isolated, err = disks.Isolated(context.Background(), "sdb")
+	require.NoError(t, err)
+	assert.True(t, isolated)
+
+	system.files["class/net/eth0/flags"] = "0x1802"
+	isolated, err = NewNetworkExecutor(system).Isolated(context.Background(), "eth0")
+	require.NoError(t, err)
+	assert.True(t, isolated)
+	system.files["class/net/eth0/flags"] = "0x1803"
+	isolated, err = NewNetworkExecutor(system).Isolated(context.Background(), "eth0")
+	require.NoError(t, err)
+	assert.False(t, isolated)
+
+	raid := NewRAIDExecutor(system, NewStorCLIBackend("", map[string]string{"host0": "0"}))
+	system.outputs["storcli64 /c0/e252/s3 show J"] = `{"Controllers":[{"Command Status":{"Status":"Success"},"Response Data":{"Drive Information":[{"EID:Slt":"252:3","State":"Offln","DG":0}]}}]}`
+	isolated, err = raid.Isolated(context.Background(), "host0/e252/s3")
+	require.NoError(t, err)
+	assert.True(t, isolated)
+}
+
+func TestWorkflowState(t *testing.T) {
+	dir := t.TempDir()
+	disks := &fakeExecutor{}
+	r := NewRemediator(&Config{AutoIsolation: true}, enum.Disk, nil, disks)
+	state := newTestState(t, dir)
+	r.SetState(state)
+	config := &WorkflowConfig{Default: ModeApproval}
+	w := newTestWorkflow(t, config, map[enum.DeviceType]Remediator{enum.Disk: r})
+	w.SetState(state)
+
+	result, err := w.IsolateWith(enum.Disk, "sda", enum.Temporary, Cause{Trigger: "state change from healthy to subhealthy"})
+	require.NoError(t, err)
+	_, err = w.Isolate(enum.Disk, "sdb", enum.Temporary)
+	require.NoError(t, err)
+	record, ok := state.Get(result.PendingID)
+	require.True(t, ok)
+	assert.Equal(t, StatusPending, record.Status)
+	assert.Equal(t, "default policy requires approval", record.Message)
+
+	// Pending actions survive a restart.
+	state = newTestState(t, dir)
+	r.SetState(state)
+	w = newTestWorkflow(t, config, map[enum.DeviceType]Remediator{enum.Disk: r})
+	w.SetState(state)
+	actions := w.Actions(false)
+	require.Len(t, actions, 2)
+	assert.Equal(t, result.PendingID, actions[0].ID)
+	assert.Equal(t, "default policy requires approval", actions[0].Reason)
+
+	_, err = w.Approve(actions[0].ID, "alice")
+	require.NoError(t, err)
+	_, err = w.Reject(actions[1].ID, "bob", "spare disk")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"isolate sda temporary"}, disks.actions)
+
+	record, _ = state.Get(actions[0].ID)
+	assert.Equal(t, StatusIsolated, record.Status)
+	assert.Equal(t, "state change from healthy to subhealthy", record.Trigger)
+	record, _ = state.Get(actions[1].ID)
+	assert.Equal(t, StatusCancelled, record.Status)
+	entries, err := state.Audit(AuditQuery{ActionID: actions[0].ID})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "approved by alice", entries[1].Trigger)
+	entries, err = state.Audit(AuditQuery{ActionID: actions[1].ID})
+	require.NoError(t, err)
+	assert.Equal(t, "rejected by bob", entries[len(entries)-1].Trigger)
+}
+
+const testPlaybooks = `
+playbooks:
+  - name: queue-overflow
+    codes: [ERR_QUEUE_OVERFLOW]
+    device_type: disk
+    steps:
+      - name: drain
+        action: write
+        path: block/{{.DeviceID}}/queue/nr_requests
+        value: "32"
+        restore: true
+      - name: settle
+        action: wait
+        duration: 1ms
+      - name: isolate
+        action: isolate
+        when:
+          severity: failed
+          observed: "> 512"
+        rollback:
+          action: recover
+      - name: rebuild
+        action: shell
+        command: mdadm /dev/md0 --add /dev/sdz
+        timeout: 1m
+        retries: 2
+      - name: verify
+        action: probe
+        when:
+          succeeded: [isolate]
+      - name: ticket
+        action: shell
+        command: open-ticket {{.Code}} {{.DeviceID}}
+        continue_on_error: true
+  - name: firmware
+    codes: [ERR_FIRMWARE_MISMATCH]
+    steps:
+      - name: flash
+        action: shell
+        command: storcli64 /c{{.Controller}} download
+`
+
+func TestParsePlaybooks(t *testing.T) {
+	playbooks, err := ParsePlaybooks([]byte(testPlaybooks))
+	require.NoError(t, err)
+	require.Len(t, playbooks, 2)
+	steps := playbooks[0].Steps
+	require.Len(t, steps, 6)
+	assert.Equal(t, time.Millisecond, steps[1].Duration)
+	assert.Equal(t, time.Minute, steps[3].Timeout)
+	assert.Equal(t, 2, steps[3].Retries)
+	assert.Equal(t, "recover", steps[2].Rollback.Action)
+
+	for _, invalid := range []string{
+		"playbooks: [{name: p, steps: [{name: s, action: probe}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], device_type: tape, steps: [{name: s, action: probe}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], steps: [{name: s, action: reboot}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], steps: [{name: s, action: wait}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], steps: [{name: s, action: isolate, strategy: forever}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], steps: [{name: s, action: shell, command: 'x {{.Nope'}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], steps: [{name: s, action: probe}, {name: s, action: probe}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], steps: [{name: s, action: probe, when: {succeeded: [t]}}, {name: t, action: probe}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], steps: [{name: s, action: probe, when: {observed: '~ 3'}}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], steps: [{name: s, action: probe, retries: -1}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], steps: [{name: s, action: probe, rollback: {action: probe, rollback: {action: probe}}}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], steps: [{name: s, action: probe, restore: true}]}]",
+		"playbooks: [{name: p, codes: [ERR_HIGH_LATENCY], steps: [{name: s, action: write, path: a, restore: true, rollback: {action: probe}}]}]",
+	} {
+		_, err := ParsePlaybooks([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPlaybookEngine(t *testing.T) {
+	playbooks, err := ParsePlaybooks([]byte(testPlaybooks))
+	require.NoError(t, err)
+	system := newFakeSystem()
+	system.files["block/sda/queue/nr_requests"] = "256"
+	executor := &probingExecutor{}
+	r := NewRemediator(&Config{AutoIsolation: true}, enum.Disk, nil, executor)
+	e, err := NewPlaybookEngine(playbooks, system, map[enum.DeviceType]Remediator{enum.Disk: r})
+	require.NoError(t, err)
+
+	p, ok := e.Select(enum.Disk, errors.ErrCodeQueueOverflow)
+	require.True(t, ok)
+	assert.Equal(t, "queue-overflow", p.Name)
+	_, ok = e.Select(enum.Network, errors.ErrCodeQueueOverflow)
+	assert.False(t, ok)
+	p, ok = e.Select(enum.RAID, errors.ErrCodeFirmwareMismatch)
+	require.True(t, ok)
+	assert.Equal(t, "firmware", p.Name)
+	_, err = e.Run(context.Background(), enum.Disk, "sda", detection.Finding{Code: errors.ErrCodeHighLatency})
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+
+	// Conditions skip the isolation and the verification of a subhealthy disk; a failing
+	// ticket step does not fail the playbook.
+	system.fail = "sh -c open-ticket"
+	finding := detection.Finding{Code: errors.ErrCodeQueueOverflow, Severity: enum.SubHealthy, Observed: 600}
+	run, err := e.Run(context.Background(), enum.Disk, "sda", finding)
+	require.NoError(t, err)
+	assert.True(t, run.Success)
+	status := make([]StepStatus, len(run.Steps))
+	for i, s := range run.Steps {
+		status[i] = s.Status
+	}
+	assert.Equal(t, []StepStatus{StepSucceeded, StepSucceeded, StepSkipped, StepSucceeded, StepSkipped, StepFailed}, status)
+	assert.Equal(t, 1, run.Steps[5].Attempts)
+	assert.Equal(t, []string{"block/sda/queue/nr_requests=32"}, system.writes)
+	assert.Equal(t, []string{"sh -c mdadm /dev/md0 --add /dev/sdz"}, system.commands)
+	assert.Empty(t, executor.actions)
+
+	// A failed disk is isolated and verified; a rebuild that keeps failing after its
+	// retries rolls back the isolation and the drain, in reverse order, restoring the
+	// queue depth the drain found.
+	system.files["block/sda/queue/nr_requests"] = "256"
+	system.writes, system.commands, system.fail = nil, nil, "sh -c mdadm"
+	finding.Severity = enum.Failed
+	run, err = e.Run(context.Background(), enum.Disk, "sda", finding)
+	assert.ErrorContains(t, err, "failed at step rebuild")
+	assert.False(t, run.Success)
+	require.Len(t, run.Steps, 4)
+	assert.Equal(t, 3, run.Steps[3].Attempts)
+	require.Len(t, run.RolledBack, 2)
+	assert.Equal(t, "isolate rollback", run.RolledBack[0].Name)
+	assert.Equal(t, "drain rollback", run.RolledBack[1].Name)
+	assert.Equal(t, []string{"isolate sda temporary", "restore sda"}, executor.actions)
+	assert.Equal(t, []string{"block/sda/queue/nr_requests=32", "block/sda/queue/nr_requests=256"}, system.writes)
+
+	system.fail = ""
+	executor.actions = nil
+	run, err = e.Run(context.Background(), enum.Disk, "sda", finding)
+	require.NoError(t, err)
+	assert.Equal(t, StepSucceeded, run.Steps[4].Status)
+	assert.Equal(t, 1, executor.probed)
+	assert.Equal(t, []string{"isolate sda temporary"}, executor.actions)
+
+	// Findings without a playbook are passed over; RAID templates see the drive's controller.
+	system.commands = nil
+	run, ok, err = e.RunFor(context.Background(), detection.HealthStatus{
+		DeviceType: enum.RAID,
+		DeviceID:   "host0/e252/s3",
+		Findings:   []detection.Finding{{Code: errors.ErrCodeHighLatency}, {Code: errors.ErrCodeFirmwareMismatch}},
+	})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "firmware", run.Playbook)
+	assert.Equal(t, []string{"sh -c storcli64 /chost0 download"}, system.commands)
+	// Controller findings are keyed by the controller alone.
+	system.commands = nil
+	run, err = e.Run(context.Background(), enum.RAID, "host1", detection.Finding{Code: errors.ErrCodeFirmwareMismatch})
+	require.NoError(t, err)
+	assert.True(t, run.Success)
+	assert.Equal(t, []string{"sh -c storcli64 /chost1 download"}, system.commands)
+	_, err = e.Run(context.Background(), enum.Disk, "sda;reboot", finding)
+	assert.Error(t, err)
+}
+
+func TestPlaybookEngineThroughWorkflow(t *testing.T) {
+	playbooks, err := ParsePlaybooks([]byte(testPlaybooks))
+	require.NoError(t, err)
+	system := newFakeSystem()
+	system.files["block/sda/queue/nr_requests"] = "256"
+	executor := &probingExecutor{}
+	r := NewRemediator(&Config{AutoIsolation: true}, enum.Disk, nil, executor)
+	config := &WorkflowConfig{Default: ModeApproval}
+	w := newTestWorkflow(t, config, map[enum.DeviceType]Remediator{enum.Disk: r})
+	e, err := NewPlaybookEngine(playbooks, system, map[enum.DeviceType]Remediator{enum.Disk: w})
+	require.NoError(t, err)
+	finding := detection.Finding{Code: errors.ErrCodeQueueOverflow, Severity: enum.Failed, Observed: 600}
+
+	// An isolation queued for approval defers the playbook, rolling back the drain.
+	run, err := e.Run(context.Background(), enum.Disk, "sda", finding)
+	require.NoError(t, err)
+	assert.False(t, run.Success)
+	require.Len(t, run.Steps, 3)
+	assert.Equal(t, StepDeferred, run.Steps[2].Status)
+	assert.NotEmpty(t, run.Deferred)
+	assert.Equal(t, run.Deferred, run.Steps[2].PendingID)
+	require.Len(t, run.RolledBack, 1)
+	assert.Equal(t, "256", system.files["block/sda/queue/nr_requests"])
+	assert.Empty(t, executor.actions)
+	require.Len(t, w.Actions(false), 1)
+
+	// Automatic isolations run through, and the workflow forwards the verification probe.
+	config.Default = ModeAuto
+	run, err = e.Run(context.Background(), enum.Disk, "sda", finding)
+	require.NoError(t, err)
+	assert.True(t, run.Success)
+	assert.Empty(t, run.Deferred)
+	assert.Equal(t, StepSucceeded, run.Steps[4].Status)
+	assert.Equal(t, 1, executor.probed)
+	assert.Equal(t, []string{"isolate sda temporary"}, executor.actions)
+}
+
+func TestThrottlingIsNotIsolation(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "batch.slice"), 0755))
+	system := newFakeSystem()
+	system.files["block/sdb/dev"] = "8:16"
+	system.files["block/sdc/dev"] = "8:32"
+	e, err := NewThrottleExecutor(ThrottleConfig{CgroupRoot: root, Limits: []CgroupIOLimit{{Cgroup: "batch.slice", Weight: 10}}}, system)
+	require.NoError(t, err)
+	r := NewRemediator(&Config{AutoIsolation: true}, enum.Disk, nil, e)
+	r.SetState(newTestState(t, t.TempDir()))
+	assert.True(t, r.Throttles(enum.Temporary))
+
+	// Throttling is named and recorded as such, and does not use up the isolation rate limit.
+	w := newTestWorkflow(t, &WorkflowConfig{Host: "node1", RateLimits: []RateLimit{{Scope: ScopeHost, Max: 1}}}, map[enum.DeviceType]Remediator{enum.Disk: r})
+	for _, id := range []string{"sdb", "sdc"} {
+		result, err := w.Isolate(enum.Disk, id, enum.Temporary)
+		require.NoError(t, err)
+		assert.Equal(t, "throttled", result.Action)
+		assert.True(t, result.Success)
+		record, ok := r.inEffect(id)
+		require.True(t, ok)
+		assert.Equal(t, StatusThrottled, record.Status)
+		assert.Equal(t, "temporary throttling applied", record.Message)
+	}
+	assert.Empty(t, w.Actions(false))
+	n, err := w.config.Counter.Count("host/node1", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	result, err := w.Recover(enum.Disk, "sdb")
+	require.NoError(t, err)
+	assert.Equal(t, "recovered", result.Action)
+	_, ok := r.inEffect("sdb")
+	assert.False(t, ok)
+}
+
+func TestDefaultPlaybookFileParses(t *testing.T) {
+	_, err := ReadPlaybookFile("../../../configs/playbooks.yaml")
+	assert.NoError(t, err)
+}
+
+func TestThrottleExecutor(t *testing.T) {
+	root := t.TempDir()
+	for _, cgroup := range []string{"batch.slice", "critical.slice/db.service"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, cgroup), 0755))
+	}
+	system := newFakeSystem()
+	system.files["block/sdb/dev"] = "8:16"
+	config := ThrottleConfig{CgroupRoot: root, Limits: []CgroupIOLimit{
+		{Cgroup: "batch.slice", ReadBPS: 10 << 20, WriteIOPS: 200, Weight: 10},
+		{Cgroup: "critical.slice/db.service", LatencyTarget: 5 * time.Millisecond},
+	}}
+	e, err := NewThrottleExecutor(config, system)
+	require.NoError(t, err)
+	read := func(file string) string {
+		data, err := os.ReadFile(filepath.Join(root, file))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	steps, err := e.Isolate(context.Background(), "sdb", enum.Temporary, true)
+	require.NoError(t, err)
+	assert.Equal(t, "write 8:16 rbps=10485760 wbps=max riops=max wiops=200 to batch.slice/io.max", steps[0].String())
+	assert.NoFileExists(t, filepath.Join(root, "batch.slice/io.max"))
+
+	_, err = e.Isolate(context.Background(), "sdb", enum.Temporary, false)
+	require.NoError(t, err)
+	assert.Equal(t, "8:16 rbps=10485760 wbps=max riops=max wiops=200", read("batch.slice/io.max"))
+	assert.Equal(t, "8:16 10", read("batch.slice/io.weight"))
+	assert.Equal(t, "8:16 target=5000", read("critical.slice/db.service/io.latency"))
+	assert.NoFileExists(t, filepath.Join(root, "batch.slice/io.latency"))
+	throttledNow, err := e.Isolated(context.Background(), "sdb")
+	require.NoError(t, err)
+	assert.True(t, throttledNow)
+
+	// Recovery resets the controls.
+	_, err = e.Restore(context.Background(), "sdb", false)
+	require.NoError(t, err)
+	assert.Equal(t, "8:16 rbps=max wbps=max riops=max wiops=max", read("batch.slice/io.max"))
+	assert.Equal(t, "8:16 default", read("batch.slice/io.weight"))
+	assert.Equal(t, "8:16 target=max", read("critical.slice/db.service/io.latency"))
+	throttledNow, err = e.Isolated(context.Background(), "sdb")
+	require.NoError(t, err)
+	assert.False(t, throttledNow)
+
+	_, err = e.Isolate(context.Background(), "sdz", enum.Temporary, false)
+	assert.True(t, errors.Is(err, errors.NewNotFound("", nil)))
+	for _, limits := range [][]CgroupIOLimit{
+		nil,
+		{{Cgroup: ""}},
+		{{Cgroup: "../escape"}},
+		{{Cgroup: "/abs"}},
+		{{Cgroup: "batch.slice", Weight: 20000}},
+	} {
+		_, err := NewThrottleExecutor(ThrottleConfig{CgroupRoot: root, Limits: limits}, system)
+		assert.Error(t, err)
+	}
+}
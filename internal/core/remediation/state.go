@@ -0,0 +1,485 @@
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Storage names of the remediation state.
+const (
+	actionCollection = "remediation_actions"
+	auditLog         = "remediation_audit"
+)
+
+// ActionStatus is the state of a remediation action.
+type ActionStatus string
+
+// Remediation action statuses.
+const (
+	StatusPending   ActionStatus = "pending"   // Waiting for approval
+	StatusExecuting ActionStatus = "executing" // Being applied
+	StatusIsolated  ActionStatus = "isolated"  // The device is out of service
+	StatusThrottled ActionStatus = "throttled" // The device is in service with its I/O limited
+	StatusRecovered ActionStatus = "recovered" // The device is back in service
+	StatusFailed    ActionStatus = "failed"    // Applying the action failed; applied steps were undone
+	StatusPartial   ActionStatus = "partial"   // Applying the action failed and undoing its applied steps failed too
+	StatusCancelled ActionStatus = "cancelled" // Never executed: rejected, expired or obsolete
+)
+
+// active reports whether an action in the status still holds or may change the device.
+func (s ActionStatus) active() bool {
+	return s == StatusPending || s == StatusExecuting || s == StatusIsolated || s == StatusThrottled || s == StatusPartial
+}
+
+// Evidence is a snapshot of the health status an action was taken on.
+type Evidence struct {
+	Status         string    `json:"status"`
+	Confidence     float64   `json:"confidence"`
+	Recommendation string    `json:"recommendation,omitempty"`
+	Findings       []string  `json:"findings,omitempty"` // Explanations, most severe first
+	At             time.Time `json:"at"`
+}
+
+// NewEvidence snapshots a health status observed at the given time.
+func NewEvidence(status detection.HealthStatus, at time.Time) *Evidence {
+	e := &Evidence{
+		Status:         status.Status.String(),
+		Confidence:     status.Confidence,
+		Recommendation: status.Recommendation,
+		At:             at,
+	}
+	for _, f := range status.Findings {
+		e.Findings = append(e.Findings, f.Explain())
+	}
+	return e
+}
+
+// Cause is who or what asked for an action, and on what evidence.
+type Cause struct {
+	Trigger  string // e.g., "state change from healthy to subhealthy" or "approved by alice"
+	Evidence *Evidence
+	ActionID string // Action the request continues, e.g., an approved pending action
+	Undo     bool   // Recovery compensates an isolation just made: probation and health checks are skipped
+}
+
+// CausedRemediator is implemented by remediators that record why actions were taken.
+type CausedRemediator interface {
+	IsolateWith(deviceType enum.DeviceType, deviceID string, strategy enum.IsolationStrategy, cause Cause) (RemediationResult, error)
+	RecoverWith(deviceType enum.DeviceType, deviceID string, cause Cause) (RemediationResult, error)
+}
+
+// Inspector is implemented by executors that can tell whether a device is isolated, so
+// persisted actions can be reconciled with the devices after a restart.
+type Inspector interface {
+	Isolated(ctx context.Context, deviceID string) (bool, error)
+}
+
+// ActionRecord is the persisted state of one isolation of a device, from its request to
+// the device's recovery.
+type ActionRecord struct {
+	ID         string                 `json:"id"`
+	DeviceType enum.DeviceType        `json:"device_type"`
+	DeviceID   string                 `json:"device_id"`
+	Strategy   enum.IsolationStrategy `json:"strategy"`
+	Status     ActionStatus           `json:"status"`
+	Trigger    string                 `json:"trigger"` // Who or what requested the isolation
+	Evidence   *Evidence              `json:"evidence,omitempty"`
+	Message    string                 `json:"message,omitempty"` // Reason of the latest transition
+	Steps      []string               `json:"steps,omitempty"`   // System changes of the latest execution
+	Error      string                 `json:"error,omitempty"`
+	Created    time.Time              `json:"created"`
+	Updated    time.Time              `json:"updated"`
+}
+
+// AuditEntry is one state transition of a remediation action.
+type AuditEntry struct {
+	At         time.Time       `json:"at"`
+	ActionID   string          `json:"action_id"`
+	DeviceType enum.DeviceType `json:"device_type"`
+	DeviceID   string          `json:"device_id"`
+	From       ActionStatus    `json:"from,omitempty"` // Empty when the action was created
+	To         ActionStatus    `json:"to"`
+	Trigger    string          `json:"trigger"`
+	Message    string          `json:"message,omitempty"`
+}
+
+// AuditQuery filters the audit log; zero fields match every entry.
+type AuditQuery struct {
+	DeviceTypes []enum.DeviceType
+	DeviceID    string
+	ActionID    string
+	Since       time.Time
+	Limit       int // Most recent entries kept
+}
+
+// Transition is a change of an action's status.
+type Transition struct {
+	To        ActionStatus
+	Trigger   string // Who or what caused the transition; the action keeps its original trigger
+	Message   string
+	Steps     []string
+	Error     error
+	Evidence  *Evidence // Replaces the action's evidence when set
+	Permanent bool      // Make the isolation permanent
+}
+
+// StateStore persists remediation actions and an append-only audit log of their
+// transitions, so isolations survive restarts.
+type StateStore struct {
+	store     storage.RecordStore
+	retention time.Duration
+
+	mu      sync.Mutex
+	records map[string]*ActionRecord
+	seq     int
+}
+
+// NewStateStore creates a new StateStore, loading the actions persisted in store. Finished
+// actions are kept for retention, 30 days when zero; the audit log keeps every transition.
+func NewStateStore(store storage.RecordStore, retention time.Duration) (*StateStore, error) {
+	if retention <= 0 {
+		retention = 30 * 24 * time.Hour
+	}
+	raw, err := store.Records(actionCollection)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load remediation state")
+	}
+	s := &StateStore{store: store, retention: retention, records: make(map[string]*ActionRecord, len(raw))}
+	now := time.Now()
+	for id, data := range raw {
+		var record ActionRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			logger.Warn("skipping unreadable remediation action", zap.String("id", id), zap.Error(err))
+			continue
+		}
+		if !record.Status.active() && now.Sub(record.Updated) > retention {
+			if err := store.DeleteRecord(actionCollection, id); err != nil {
+				return nil, errors.Wrap(err, "failed to prune remediation action "+id)
+			}
+			continue
+		}
+		s.records[id] = &record
+	}
+	return s, nil
+}
+
+// Create persists a new action in its initial status. An empty ID is generated.
+func (s *StateStore) Create(record ActionRecord, now time.Time) (ActionRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record.ID == "" {
+		s.seq++
+		record.ID = fmt.Sprintf("%s-%s-%d", record.DeviceType, now.UTC().Format("20060102T150405"), s.seq)
+	}
+	if _, ok := s.records[record.ID]; ok {
+		return ActionRecord{}, errors.NewInvalidState("remediation action "+record.ID+" already exists", nil)
+	}
+	record.Created, record.Updated = now, now
+	if err := s.persist(&record, "", record.Trigger, now); err != nil {
+		return ActionRecord{}, err
+	}
+	s.records[record.ID] = &record
+	return record, nil
+}
+
+// Transition moves an action to a new status, recording the transition in the audit log.
+func (s *StateStore) Transition(id string, t Transition, now time.Time) (ActionRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.records[id]
+	if !ok {
+		return ActionRecord{}, errors.NewNotFound("remediation action "+id+" not found", nil)
+	}
+	record := *current
+	from := record.Status
+	record.Status, record.Message, record.Updated = t.To, t.Message, now
+	if t.Steps != nil {
+		record.Steps = t.Steps
+	}
+	record.Error = ""
+	if t.Error != nil {
+		record.Error = t.Error.Error()
+	}
+	if t.Evidence != nil {
+		record.Evidence = t.Evidence
+	}
+	if t.Permanent {
+		record.Strategy = enum.Permanent
+	}
+	if err := s.persist(&record, from, t.Trigger, now); err != nil {
+		return ActionRecord{}, err
+	}
+	*current = record
+	s.pruneLocked(now)
+	return record, nil
+}
+
+// Get returns an action.
+func (s *StateStore) Get(id string) (ActionRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[id]
+	if !ok {
+		return ActionRecord{}, false
+	}
+	return *record, true
+}
+
+// Actions returns the retained actions, oldest first.
+func (s *StateStore) Actions() []ActionRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]ActionRecord, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, *r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Created.Equal(records[j].Created) {
+			return records[i].Created.Before(records[j].Created)
+		}
+		return records[i].ID < records[j].ID
+	})
+	return records
+}
+
+// Audit returns the audit log entries matching the query, oldest first.
+func (s *StateStore) Audit(q AuditQuery) ([]AuditEntry, error) {
+	raw, err := s.store.ReadLog(auditLog)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read remediation audit log")
+	}
+	types := make(map[enum.DeviceType]bool, len(q.DeviceTypes))
+	for _, t := range q.DeviceTypes {
+		types[t] = true
+	}
+
+	var entries []AuditEntry
+	for _, data := range raw {
+		var e AuditEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			continue
+		}
+		if (len(types) > 0 && !types[e.DeviceType]) || (q.DeviceID != "" && e.DeviceID != q.DeviceID) ||
+			(q.ActionID != "" && e.ActionID != q.ActionID) || e.At.Before(q.Since) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, nil
+}
+
+// persist writes the action and appends its transition to the audit log; the caller holds
+// the lock. The audit entry is appended first, so no state change goes unaudited.
+func (s *StateStore) persist(record *ActionRecord, from ActionStatus, trigger string, now time.Time) error {
+	entry := AuditEntry{
+		At:         now,
+		ActionID:   record.ID,
+		DeviceType: record.DeviceType,
+		DeviceID:   record.DeviceID,
+		From:       from,
+		To:         record.Status,
+		Trigger:    trigger,
+		Message:    record.Message,
+	}
+	if err := s.store.AppendLog(auditLog, entry); err != nil {
+		return errors.Wrap(err, "failed to append to remediation audit log")
+	}
+	if err := s.store.PutRecord(actionCollection, record.ID, record); err != nil {
+		return errors.Wrap(err, "failed to persist remediation action "+record.ID)
+	}
+	return nil
+}
+
+// pruneLocked forgets finished actions older than the retention; the caller holds the lock.
+func (s *StateStore) pruneLocked(now time.Time) {
+	for id, r := range s.records {
+		if r.Status.active() || now.Sub(r.Updated) <= s.retention {
+			continue
+		}
+		if err := s.store.DeleteRecord(actionCollection, id); err != nil {
+			logger.Warn("failed to prune remediation action", zap.String("id", id), zap.Error(err))
+			continue
+		}
+		delete(s.records, id)
+	}
+}
+
+// Reconcile checks the device type's persisted actions against the devices after a
+// restart: actions interrupted while executing are settled by whether their device is
+// isolated, temporary isolations whose device is back in service are marked recovered, and
+// those still in effect are put back on probation. Permanent isolations are kept until
+// recovered, as, e.g., disks removed from their md arrays look in service. Without an
+// Inspector, devices of interrupted actions are assumed isolated.
+func (r *DeviceRemediator) Reconcile(now time.Time) error {
+	state := r.stateStore()
+	if state == nil {
+		return nil
+	}
+	inspector, _ := r.executor.(Inspector)
+
+	const trigger = "reconciled after restart"
+	for _, record := range state.Actions() {
+		if record.DeviceType != r.deviceType || (record.Status != StatusExecuting && record.Status != StatusIsolated && record.Status != StatusThrottled) {
+			continue
+		}
+		isolated := true
+		if inspector != nil && (record.Status == StatusExecuting || record.Strategy != enum.Permanent) {
+			ctx, cancel := r.context(r.config.ActionTimeout)
+			ok, err := inspector.Isolated(ctx, record.DeviceID)
+			cancel()
+			if err != nil {
+				logger.Warn("failed to inspect device, assuming it is isolated",
+					zap.String("action_id", record.ID),
+					zap.String("device_id", record.DeviceID),
+					zap.Error(err),
+				)
+			} else {
+				isolated = ok
+			}
+		}
+
+		var err error
+		switch {
+		case record.Status == StatusExecuting && isolated && r.Throttles(record.Strategy):
+			record, err = state.Transition(record.ID, Transition{To: StatusThrottled, Trigger: trigger, Message: "interrupted throttling found applied"}, now)
+		case record.Status == StatusExecuting && isolated:
+			record, err = state.Transition(record.ID, Transition{To: StatusIsolated, Trigger: trigger, Message: "interrupted isolation found applied"}, now)
+		case record.Status == StatusExecuting:
+			record, err = state.Transition(record.ID, Transition{To: StatusFailed, Trigger: trigger, Message: "isolation interrupted before it was applied"}, now)
+		case !isolated:
+			record, err = state.Transition(record.ID, Transition{To: StatusRecovered, Trigger: trigger, Message: "device found back in service"}, now)
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to reconcile remediation action "+record.ID)
+		}
+		if record.Status == StatusIsolated {
+			r.startProbation(record.DeviceID, record.Strategy, record.Updated)
+		}
+		logger.Info("reconciled remediation action",
+			zap.String("action_id", record.ID),
+			zap.String("device_id", record.DeviceID),
+			zap.String("status", string(record.Status)),
+		)
+	}
+	return nil
+}
+
+// stateStore returns the remediator's state, nil when actions are not persisted.
+func (r *DeviceRemediator) stateStore() *StateStore {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// begin records an isolation as executing and returns its action. It continues the action
+// named by the cause, or the device's isolation in effect, e.g., a temporary isolation
+// made permanent; dry runs are not recorded.
+func (r *DeviceRemediator) begin(deviceID string, strategy enum.IsolationStrategy, cause Cause) (string, error) {
+	state := r.stateStore()
+	if state == nil {
+		return "", nil
+	}
+	if r.config.DryRun {
+		r.settle(cause.ActionID, StatusCancelled, "isolation planned in a dry run")
+		return "", nil
+	}
+
+	now := time.Now()
+	id := cause.ActionID
+	if id == "" {
+		if record, ok := r.inEffect(deviceID); ok {
+			id = record.ID
+		}
+	}
+	if id != "" {
+		t := Transition{
+			To:        StatusExecuting,
+			Trigger:   trigger(cause),
+			Message:   "isolating device",
+			Evidence:  cause.Evidence,
+			Permanent: strategy == enum.Permanent,
+		}
+		if _, err := state.Transition(id, t, now); err != nil {
+			return "", errors.Wrap(err, "failed to record isolation of "+deviceID)
+		}
+		return id, nil
+	}
+	record, err := state.Create(ActionRecord{
+		DeviceType: r.deviceType,
+		DeviceID:   deviceID,
+		Strategy:   strategy,
+		Status:     StatusExecuting,
+		Trigger:    trigger(cause),
+		Evidence:   cause.Evidence,
+		Message:    "isolating device",
+	}, now)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to record isolation of "+deviceID)
+	}
+	return record.ID, nil
+}
+
+// track records a transition of an action. The device has already changed, so failing to
+// record it is only logged.
+func (r *DeviceRemediator) track(actionID string, t Transition) {
+	state := r.stateStore()
+	if state == nil || actionID == "" {
+		return
+	}
+	if _, err := state.Transition(actionID, t, time.Now()); err != nil {
+		logger.Error("failed to record remediation action", zap.String("action_id", actionID), zap.String("status", string(t.To)), zap.Error(err))
+	}
+}
+
+// settle ends an action that was not executed.
+func (r *DeviceRemediator) settle(actionID string, status ActionStatus, message string) {
+	r.track(actionID, Transition{To: status, Message: message})
+}
+
+// trackDevice records a transition of the device's isolation in effect, if any.
+func (r *DeviceRemediator) trackDevice(deviceID string, t Transition) {
+	if record, ok := r.inEffect(deviceID); ok {
+		r.track(record.ID, t)
+	}
+}
+
+// inEffect returns the device's latest action that is executing or has, at least partly,
+// isolated or throttled it.
+func (r *DeviceRemediator) inEffect(deviceID string) (ActionRecord, bool) {
+	state := r.stateStore()
+	if state == nil {
+		return ActionRecord{}, false
+	}
+	var found ActionRecord
+	for _, record := range state.Actions() {
+		if record.DeviceType == r.deviceType && record.DeviceID == deviceID &&
+			(record.Status == StatusExecuting || record.Status == StatusIsolated || record.Status == StatusThrottled || record.Status == StatusPartial) {
+			found = record
+		}
+	}
+	return found, found.ID != ""
+}
+
+// trigger returns the trigger recorded for a cause.
+func trigger(cause Cause) string {
+	if cause.Trigger == "" {
+		return "direct request"
+	}
+	return cause.Trigger
+}
@@ -0,0 +1,246 @@
+package remediation
+
+import (
+	"context"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ThrottleConfig defines the cgroup v2 I/O controls applied to a sub-healthy disk.
+type ThrottleConfig struct {
+	CgroupRoot string          // cgroup v2 mount point; /sys/fs/cgroup when empty
+	Limits     []CgroupIOLimit // Controls of each cgroup on the throttled disk
+}
+
+// CgroupIOLimit is the I/O control of one cgroup on a throttled disk: noisy workloads are
+// capped or de-prioritised, critical-tier workloads protected with a latency target.
+type CgroupIOLimit struct {
+	Cgroup        string        // Path below the cgroup root, e.g., "batch.slice"
+	ReadBPS       uint64        // io.max rbps; unlimited when zero
+	WriteBPS      uint64        // io.max wbps; unlimited when zero
+	ReadIOPS      uint64        // io.max riops; unlimited when zero
+	WriteIOPS     uint64        // io.max wiops; unlimited when zero
+	LatencyTarget time.Duration // io.latency target; none when zero
+	Weight        int           // io.weight on the disk, 1-10000; unchanged when zero
+}
+
+// ThrottleExecutor remediates disks by throttling I/O through cgroup v2 instead of taking
+// them out of service: io.max, io.latency and io.weight are set for the disk in each
+// configured cgroup and reset on recovery. The disk stays in service whatever the strategy.
+type ThrottleExecutor struct {
+	system  System // sysfs, to resolve disk device numbers
+	cgroups System // cgroupfs
+	limits  []CgroupIOLimit
+}
+
+// NewThrottleExecutor creates a new ThrottleExecutor, validating the limits.
+func NewThrottleExecutor(config ThrottleConfig, system System) (*ThrottleExecutor, error) {
+	root := config.CgroupRoot
+	if root == "" {
+		root = "/sys/fs/cgroup"
+	}
+	if len(config.Limits) == 0 {
+		return nil, errors.New("throttling needs at least one cgroup limit", nil)
+	}
+	limits := make([]CgroupIOLimit, len(config.Limits))
+	for i, l := range config.Limits {
+		cgroup := path.Clean(l.Cgroup)
+		if l.Cgroup == "" || cgroup == "." || path.IsAbs(cgroup) || cgroup == ".." || strings.HasPrefix(cgroup, "../") {
+			return nil, errors.New("invalid cgroup "+strconv.Quote(l.Cgroup)+": want a path below the cgroup root", nil)
+		}
+		if l.Weight < 0 || l.Weight > 10000 {
+			return nil, errors.New("io.weight of cgroup "+cgroup+" must be in 1-10000", nil)
+		}
+		if l.LatencyTarget < 0 {
+			return nil, errors.New("io.latency target of cgroup "+cgroup+" must not be negative", nil)
+		}
+		l.Cgroup = cgroup
+		limits[i] = l
+	}
+	return &ThrottleExecutor{system: system, cgroups: NewHostSystem(root), limits: limits}, nil
+}
+
+// Isolate sets the cgroups' I/O controls for the disk.
+func (e *ThrottleExecutor) Isolate(ctx context.Context, deviceID string, strategy enum.IsolationStrategy, dryRun bool) ([]Step, error) {
+	dev, err := e.deviceNumber(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	var steps []Step
+	for _, l := range e.limits {
+		if l.ReadBPS > 0 || l.WriteBPS > 0 || l.ReadIOPS > 0 || l.WriteIOPS > 0 {
+			value := dev + " rbps=" + ioMax(l.ReadBPS) + " wbps=" + ioMax(l.WriteBPS) + " riops=" + ioMax(l.ReadIOPS) + " wiops=" + ioMax(l.WriteIOPS)
+			steps = append(steps, Step{Path: l.Cgroup + "/io.max", Value: value, Undo: &Step{Path: l.Cgroup + "/io.max", Value: dev + " rbps=max wbps=max riops=max wiops=max"}})
+		}
+		if l.LatencyTarget > 0 {
+			value := dev + " target=" + strconv.FormatInt(l.LatencyTarget.Microseconds(), 10)
+			steps = append(steps, Step{Path: l.Cgroup + "/io.latency", Value: value, Undo: &Step{Path: l.Cgroup + "/io.latency", Value: dev + " target=max"}})
+		}
+		if l.Weight > 0 {
+			steps = append(steps, Step{Path: l.Cgroup + "/io.weight", Value: dev + " " + strconv.Itoa(l.Weight), Undo: &Step{Path: l.Cgroup + "/io.weight", Value: dev + " default"}})
+		}
+	}
+	return apply(ctx, e.cgroups, deviceID, steps, dryRun)
+}
+
+// Restore removes the disk's I/O controls from the cgroups.
+func (e *ThrottleExecutor) Restore(ctx context.Context, deviceID string, dryRun bool) ([]Step, error) {
+	dev, err := e.deviceNumber(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	var steps []Step
+	for _, l := range e.limits {
+		if l.ReadBPS > 0 || l.WriteBPS > 0 || l.ReadIOPS > 0 || l.WriteIOPS > 0 {
+			steps = append(steps, Step{Path: l.Cgroup + "/io.max", Value: dev + " rbps=max wbps=max riops=max wiops=max"})
+		}
+		if l.LatencyTarget > 0 {
+			steps = append(steps, Step{Path: l.Cgroup + "/io.latency", Value: dev + " target=max"})
+		}
+		if l.Weight > 0 {
+			steps = append(steps, Step{Path: l.Cgroup + "/io.weight", Value: dev + " default"})
+		}
+	}
+	return apply(ctx, e.cgroups, deviceID, steps, dryRun)
+}
+
+// Throttles reports that the executor throttles disks rather than isolating them, whatever
+// the strategy.
+func (e *ThrottleExecutor) Throttles(strategy enum.IsolationStrategy) bool {
+	return true
+}
+
+// Isolated reports whether any of the cgroups has I/O controls for the disk.
+func (e *ThrottleExecutor) Isolated(ctx context.Context, deviceID string) (bool, error) {
+	dev, err := e.deviceNumber(deviceID)
+	if err != nil {
+		return false, err
+	}
+	for _, l := range e.limits {
+		for _, file := range []string{"io.max", "io.latency", "io.weight"} {
+			content, err := e.cgroups.ReadFile(l.Cgroup + "/" + file)
+			if err != nil {
+				continue
+			}
+			if throttled(content, dev) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// TieredDiskExecutor throttles sub-healthy disks, which are isolated temporarily, with a
+// ThrottleExecutor, and takes failed disks, which are isolated permanently, out of service
+// with a DiskExecutor. A throttled disk that fails is isolated with its throttling left in
+// place; recovery lifts both.
+type TieredDiskExecutor struct {
+	throttle *ThrottleExecutor
+	disks    *DiskExecutor
+}
+
+// NewTieredDiskExecutor creates a new TieredDiskExecutor.
+func NewTieredDiskExecutor(throttle *ThrottleExecutor, disks *DiskExecutor) *TieredDiskExecutor {
+	return &TieredDiskExecutor{throttle: throttle, disks: disks}
+}
+
+// Isolate throttles the disk for temporary isolation and isolates it for permanent
+// isolation.
+func (e *TieredDiskExecutor) Isolate(ctx context.Context, deviceID string, strategy enum.IsolationStrategy, dryRun bool) ([]Step, error) {
+	if strategy == enum.Permanent {
+		return e.disks.Isolate(ctx, deviceID, strategy, dryRun)
+	}
+	return e.throttle.Isolate(ctx, deviceID, strategy, dryRun)
+}
+
+// Restore brings the disk back into service if it is isolated, then lifts its throttling.
+func (e *TieredDiskExecutor) Restore(ctx context.Context, deviceID string, dryRun bool) ([]Step, error) {
+	isolated, err := e.disks.Isolated(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	var steps []Step
+	if isolated {
+		if steps, err = e.disks.Restore(ctx, deviceID, dryRun); err != nil {
+			return steps, err
+		}
+	}
+	throttled, err := e.throttle.Restore(ctx, deviceID, dryRun)
+	return append(steps, throttled...), err
+}
+
+// Throttles reports that temporary isolations throttle the disk.
+func (e *TieredDiskExecutor) Throttles(strategy enum.IsolationStrategy) bool {
+	return strategy != enum.Permanent
+}
+
+// Isolated reports whether the disk is throttled or isolated.
+func (e *TieredDiskExecutor) Isolated(ctx context.Context, deviceID string) (bool, error) {
+	if throttled, err := e.throttle.Isolated(ctx, deviceID); err != nil || throttled {
+		return throttled, err
+	}
+	return e.disks.Isolated(ctx, deviceID)
+}
+
+// Probe probes the disk like a DiskExecutor.
+func (e *TieredDiskExecutor) Probe(ctx context.Context, deviceID string) error {
+	return e.disks.Probe(ctx, deviceID)
+}
+
+// Groups returns the disk's redundancy groups like a DiskExecutor.
+func (e *TieredDiskExecutor) Groups(ctx context.Context, deviceID string) ([]RedundancyGroup, error) {
+	return e.disks.Groups(ctx, deviceID)
+}
+
+// deviceNumber returns the disk's major:minor device number.
+func (e *ThrottleExecutor) deviceNumber(deviceID string) (string, error) {
+	if err := checkDeviceName(deviceID); err != nil {
+		return "", err
+	}
+	dev, err := e.system.ReadFile("block/" + deviceID + "/dev")
+	if err != nil {
+		return "", errors.NewNotFound("disk "+deviceID+" not found", err)
+	}
+	parts := strings.Split(dev, ":")
+	if len(parts) != 2 {
+		return "", errors.New("invalid device number "+dev+" of disk "+deviceID, nil)
+	}
+	for _, p := range parts {
+		if _, err := strconv.ParseUint(p, 10, 32); err != nil {
+			return "", errors.New("invalid device number "+dev+" of disk "+deviceID, err)
+		}
+	}
+	return dev, nil
+}
+
+// ioMax formats an io.max limit, zero meaning unlimited.
+func ioMax(v uint64) string {
+	if v == 0 {
+		return "max"
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// throttled reports whether a cgroup I/O control file limits the device: a line for the
+// device with a value other than max or default.
+func throttled(content, dev string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != dev {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if i := strings.Index(f, "="); i >= 0 {
+				f = f[i+1:]
+			}
+			if f != "max" && f != "default" {
+				return true
+			}
+		}
+	}
+	return false
+}
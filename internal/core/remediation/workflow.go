@@ -0,0 +1,498 @@
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"github.com/turtacn/ioshelfer/internal/core/slo"
+	"go.uber.org/zap"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WorkflowConfig defines how remediation actions are authorised before they are executed.
+type WorkflowConfig struct {
+	Policies    []Policy                                // First matching policy applies
+	Default     Mode                                    // Mode when no policy matches; ModeAuto when empty
+	Tiers       map[detection.DeviceRef]slo.ServiceTier // Tier served by each device; NonCritical when unset
+	Host        string                                  // Host rate limits count under
+	Cluster     string                                  // Cluster rate limits count under; cluster limits are skipped when empty
+	RateLimits  []RateLimit                             // Automatic isolations beyond a limit are queued for approval
+	Counter     ActionCounter                           // Counts executed isolations; in memory, for host limits only, when nil
+	ApprovalTTL time.Duration                           // Time pending actions wait for a decision, and decided ones are kept; 24h when zero
+}
+
+// ActionState is the state of an action queued for approval.
+type ActionState string
+
+// Pending action states.
+const (
+	ActionPending   ActionState = "pending"
+	ActionApproved  ActionState = "approved"
+	ActionRejected  ActionState = "rejected"
+	ActionExpired   ActionState = "expired"
+	ActionCancelled ActionState = "cancelled" // The device recovered before a decision
+)
+
+// PendingAction is an isolation queued for human approval.
+type PendingAction struct {
+	ID         string
+	DeviceType enum.DeviceType
+	DeviceID   string
+	Tier       slo.ServiceTier
+	Strategy   enum.IsolationStrategy
+	Reason     string // Why the action needs approval
+	Created    time.Time
+	Expires    time.Time
+	State      ActionState
+	DecidedBy  string
+	DecidedAt  time.Time
+	Comment    string
+	Result     *RemediationResult // Outcome of the approved action
+}
+
+// Workflow authorises isolations by policy before handing them to the remediator of their
+// device type: each is executed automatically, queued for approval, or only recommended.
+// It implements Remediator for every device type it has a remediator for.
+type Workflow struct {
+	config      *WorkflowConfig
+	remediators map[enum.DeviceType]Remediator
+	rate        sync.Mutex // Held from an isolation's rate limit check until it is counted
+
+	mu      sync.Mutex
+	actions map[string]*PendingAction
+	seq     int
+	state   *StateStore // Persisted actions; none when nil
+}
+
+// NewWorkflow creates a new Workflow, applying defaults. Cluster rate limits need a Counter
+// shared by the cluster's hosts: the default in-memory counter only sees this host.
+func NewWorkflow(config *WorkflowConfig, remediators map[enum.DeviceType]Remediator) (*Workflow, error) {
+	if config.Default == "" {
+		config.Default = ModeAuto
+	}
+	if config.Counter == nil {
+		for _, l := range config.RateLimits {
+			if l.Scope == ScopeCluster && config.Cluster != "" {
+				return nil, errors.New("cluster rate limits need a counter shared by the cluster's hosts", nil)
+			}
+		}
+		config.Counter = NewMemoryCounter()
+	}
+	if config.ApprovalTTL <= 0 {
+		config.ApprovalTTL = 24 * time.Hour
+	}
+	return &Workflow{
+		config:      config,
+		remediators: remediators,
+		actions:     make(map[string]*PendingAction),
+	}, nil
+}
+
+// SetState makes the workflow persist its pending actions in state, restoring those pending
+// before a restart. Give the remediators the same state so approved actions are tracked to
+// their end.
+func (w *Workflow) SetState(state *StateStore) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.state = state
+
+	for _, record := range state.Actions() {
+		if record.Status != StatusPending {
+			continue
+		}
+		if _, ok := w.actions[record.ID]; ok {
+			continue
+		}
+		w.actions[record.ID] = &PendingAction{
+			ID:         record.ID,
+			DeviceType: record.DeviceType,
+			DeviceID:   record.DeviceID,
+			Tier:       w.tier(record.DeviceType, record.DeviceID),
+			Strategy:   record.Strategy,
+			Reason:     record.Message,
+			Created:    record.Created,
+			Expires:    record.Created.Add(w.config.ApprovalTTL),
+			State:      ActionPending,
+		}
+	}
+}
+
+// Isolate executes, queues or recommends an isolation as its policy decides.
+func (w *Workflow) Isolate(deviceType enum.DeviceType, deviceID string, strategy enum.IsolationStrategy) (RemediationResult, error) {
+	return w.IsolateWith(deviceType, deviceID, strategy, Cause{})
+}
+
+// IsolateWith isolates a device like Isolate, recording the cause with the action.
+func (w *Workflow) IsolateWith(deviceType enum.DeviceType, deviceID string, strategy enum.IsolationStrategy, cause Cause) (RemediationResult, error) {
+	r, ok := w.remediators[deviceType]
+	if !ok {
+		return RemediationResult{}, errors.NewNotFound("no remediator for "+deviceType.String(), nil)
+	}
+
+	now := time.Now()
+	tier := w.tier(deviceType, deviceID)
+	mode, reason := w.Decide(deviceType, tier, now)
+	if mode == ModeAuto && !throttles(r, strategy) {
+		// Concurrent isolations must not all pass the check before any is counted.
+		w.rate.Lock()
+		defer w.rate.Unlock()
+		if limit, err := w.limited(now); err != nil {
+			return RemediationResult{}, err
+		} else if limit != "" {
+			mode, reason = ModeApproval, limit
+		}
+	}
+
+	switch mode {
+	case ModeRecommend:
+		logger.Warn("isolation recommended",
+			zap.String("device_type", deviceType.String()),
+			zap.String("device_id", deviceID),
+			zap.String("service_tier", string(tier)),
+			zap.String("strategy", strategy.String()),
+			zap.String("reason", reason),
+		)
+		return RemediationResult{
+			DeviceType: deviceType,
+			DeviceID:   deviceID,
+			Action:     "isolation recommended",
+			Success:    false,
+		}, nil
+	case ModeApproval:
+		action, err := w.queue(deviceType, deviceID, tier, strategy, reason, cause, now)
+		if err != nil {
+			return RemediationResult{}, err
+		}
+		return RemediationResult{
+			DeviceType: deviceType,
+			DeviceID:   deviceID,
+			Action:     "isolation pending approval",
+			Success:    false,
+			PendingID:  action.ID,
+		}, nil
+	}
+
+	result, err := isolate(r, deviceType, deviceID, strategy, cause)
+	w.count(result, now)
+	return result, err
+}
+
+// Recover cancels the device's pending isolations and recovers it; recovery restores
+// capacity, so it needs no approval.
+func (w *Workflow) Recover(deviceType enum.DeviceType, deviceID string) (RemediationResult, error) {
+	return w.RecoverWith(deviceType, deviceID, Cause{})
+}
+
+// RecoverWith recovers a device like Recover, recording the cause with the device's action.
+func (w *Workflow) RecoverWith(deviceType enum.DeviceType, deviceID string, cause Cause) (RemediationResult, error) {
+	r, ok := w.remediators[deviceType]
+	if !ok {
+		return RemediationResult{}, errors.NewNotFound("no remediator for "+deviceType.String(), nil)
+	}
+
+	now := time.Now()
+	w.mu.Lock()
+	for _, a := range w.actions {
+		if a.DeviceType == deviceType && a.DeviceID == deviceID && a.State == ActionPending {
+			a.State, a.DecidedAt, a.Comment = ActionCancelled, now, "device recovered"
+			w.trackLocked(a.ID, Transition{To: StatusCancelled, Trigger: trigger(cause), Message: "device recovered"}, now)
+			logger.Info("cancelled pending isolation of recovered device", zap.String("id", a.ID), zap.String("device_id", deviceID))
+		}
+	}
+	w.mu.Unlock()
+	if caused, ok := r.(CausedRemediator); ok {
+		return caused.RecoverWith(deviceType, deviceID, cause)
+	}
+	return r.Recover(deviceType, deviceID)
+}
+
+// ProbeDevice probes a device through the remediator of its type, which must be a Prober;
+// probes change nothing, so they need no approval.
+func (w *Workflow) ProbeDevice(ctx context.Context, deviceType enum.DeviceType, deviceID string) error {
+	r, ok := w.remediators[deviceType]
+	if !ok {
+		return errors.NewNotFound("no remediator for "+deviceType.String(), nil)
+	}
+	return probe(ctx, r, deviceType, deviceID)
+}
+
+// Decide returns the mode of an isolation of a device of the given type and tier at now,
+// and why, unless the isolation can run automatically. Rate limits are not considered.
+func (w *Workflow) Decide(deviceType enum.DeviceType, tier slo.ServiceTier, now time.Time) (Mode, string) {
+	for _, p := range w.config.Policies {
+		if !p.matches(deviceType, tier) {
+			continue
+		}
+		mode, reason := p.mode(now)
+		if reason == "" && mode != ModeAuto {
+			reason = "policy for " + deviceType.String() + " devices serving the " + string(tier) + " tier requires " + string(mode)
+		}
+		return mode, reason
+	}
+	if w.config.Default != ModeAuto {
+		return w.config.Default, "default policy requires " + string(w.config.Default)
+	}
+	return ModeAuto, ""
+}
+
+// Actions returns the pending actions, and with all also the recently decided ones, oldest
+// first.
+func (w *Workflow) Actions(all bool) []PendingAction {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expireLocked(time.Now())
+
+	actions := make([]PendingAction, 0, len(w.actions))
+	for _, a := range w.actions {
+		if all || a.State == ActionPending {
+			actions = append(actions, *a)
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		if !actions[i].Created.Equal(actions[j].Created) {
+			return actions[i].Created.Before(actions[j].Created)
+		}
+		return actions[i].ID < actions[j].ID
+	})
+	return actions
+}
+
+// Approve executes a pending action on behalf of an approver. Approved isolations are
+// counted against rate limits but not held back by them.
+func (w *Workflow) Approve(id, by string) (PendingAction, error) {
+	action, err := w.decide(id, by, "", ActionApproved)
+	if err != nil {
+		return PendingAction{}, err
+	}
+	logger.Info("pending isolation approved", zap.String("id", id), zap.String("device_id", action.DeviceID), zap.String("by", by))
+
+	r := w.remediators[action.DeviceType]
+	cause := Cause{Trigger: "approved by " + by}
+	w.mu.Lock()
+	state := w.state
+	w.mu.Unlock()
+	if state != nil {
+		cause.ActionID = id
+	}
+	w.rate.Lock()
+	result, err := isolate(r, action.DeviceType, action.DeviceID, action.Strategy, cause)
+	if err != nil && result.Error == nil {
+		result.Error = err
+	}
+	w.count(result, time.Now())
+	w.rate.Unlock()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := r.(CausedRemediator); !ok {
+		status := StatusFailed
+		if result.Success && throttles(r, action.Strategy) {
+			status = StatusThrottled
+		} else if result.Success {
+			status = StatusIsolated
+		}
+		w.trackLocked(id, Transition{To: status, Trigger: cause.Trigger, Message: result.Action, Steps: result.Steps, Error: result.Error}, time.Now())
+	}
+	if a, ok := w.actions[id]; ok {
+		a.Result = &result
+		action = *a
+	}
+	return action, err
+}
+
+// Reject declines a pending action on behalf of an approver.
+func (w *Workflow) Reject(id, by, comment string) (PendingAction, error) {
+	action, err := w.decide(id, by, comment, ActionRejected)
+	if err != nil {
+		return PendingAction{}, err
+	}
+	logger.Info("pending isolation rejected", zap.String("id", id), zap.String("device_id", action.DeviceID), zap.String("by", by))
+	return action, nil
+}
+
+// decide moves a pending action to a decided state.
+func (w *Workflow) decide(id, by, comment string, state ActionState) (PendingAction, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	w.expireLocked(now)
+	a, ok := w.actions[id]
+	if !ok {
+		return PendingAction{}, errors.NewNotFound("pending action "+id+" not found", nil)
+	}
+	if a.State != ActionPending {
+		return PendingAction{}, errors.NewInvalidState("action "+id+" is already "+string(a.State), nil)
+	}
+	a.State, a.DecidedBy, a.DecidedAt, a.Comment = state, by, now, comment
+	if state == ActionRejected {
+		w.trackLocked(id, Transition{To: StatusCancelled, Trigger: "rejected by " + by, Message: comment}, now)
+	}
+	return *a, nil
+}
+
+// queue adds an isolation to the approval queue. A device has at most one pending action:
+// queuing it again returns the existing one, escalated to permanent isolation if asked.
+func (w *Workflow) queue(deviceType enum.DeviceType, deviceID string, tier slo.ServiceTier, strategy enum.IsolationStrategy, reason string, cause Cause, now time.Time) (PendingAction, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expireLocked(now)
+
+	for _, a := range w.actions {
+		if a.DeviceType == deviceType && a.DeviceID == deviceID && a.State == ActionPending {
+			if strategy == enum.Permanent && a.Strategy != strategy {
+				a.Strategy = strategy
+				w.trackLocked(a.ID, Transition{To: StatusPending, Trigger: trigger(cause), Message: reason, Evidence: cause.Evidence, Permanent: true}, now)
+			}
+			return *a, nil
+		}
+	}
+	w.seq++
+	a := &PendingAction{
+		ID:         fmt.Sprintf("%s-%d", now.UTC().Format("20060102T150405"), w.seq),
+		DeviceType: deviceType,
+		DeviceID:   deviceID,
+		Tier:       tier,
+		Strategy:   strategy,
+		Reason:     reason,
+		Created:    now,
+		Expires:    now.Add(w.config.ApprovalTTL),
+		State:      ActionPending,
+	}
+	if w.state != nil {
+		_, err := w.state.Create(ActionRecord{
+			ID:         a.ID,
+			DeviceType: deviceType,
+			DeviceID:   deviceID,
+			Strategy:   strategy,
+			Status:     StatusPending,
+			Trigger:    trigger(cause),
+			Evidence:   cause.Evidence,
+			Message:    reason,
+		}, now)
+		if err != nil {
+			return PendingAction{}, errors.Wrap(err, "failed to record pending isolation of "+deviceID)
+		}
+	}
+	w.actions[a.ID] = a
+	logger.Warn("isolation queued for approval",
+		zap.String("id", a.ID),
+		zap.String("device_type", deviceType.String()),
+		zap.String("device_id", deviceID),
+		zap.String("service_tier", string(tier)),
+		zap.String("strategy", strategy.String()),
+		zap.String("reason", reason),
+	)
+	return *a, nil
+}
+
+// expireLocked expires pending actions past their deadline and forgets decided actions
+// older than ApprovalTTL.
+func (w *Workflow) expireLocked(now time.Time) {
+	for id, a := range w.actions {
+		switch {
+		case a.State == ActionPending && !now.Before(a.Expires):
+			a.State, a.DecidedAt = ActionExpired, a.Expires
+			w.trackLocked(id, Transition{To: StatusCancelled, Trigger: "approval TTL expired", Message: "expired without a decision"}, now)
+			logger.Warn("pending isolation expired without a decision", zap.String("id", id), zap.String("device_id", a.DeviceID))
+		case a.State != ActionPending && now.Sub(a.DecidedAt) > w.config.ApprovalTTL:
+			delete(w.actions, id)
+		}
+	}
+}
+
+// trackLocked records a transition of a pending action; the caller holds the lock.
+func (w *Workflow) trackLocked(id string, t Transition, now time.Time) {
+	if w.state == nil {
+		return
+	}
+	if _, err := w.state.Transition(id, t, now); err != nil {
+		logger.Error("failed to record remediation action", zap.String("action_id", id), zap.String("status", string(t.To)), zap.Error(err))
+	}
+}
+
+// isolate isolates a device through r, passing the cause on if r records causes.
+func isolate(r Remediator, deviceType enum.DeviceType, deviceID string, strategy enum.IsolationStrategy, cause Cause) (RemediationResult, error) {
+	if caused, ok := r.(CausedRemediator); ok {
+		return caused.IsolateWith(deviceType, deviceID, strategy, cause)
+	}
+	return r.Isolate(deviceType, deviceID, strategy)
+}
+
+// throttles reports whether r throttles devices rather than isolating them with the strategy.
+func throttles(r Remediator, strategy enum.IsolationStrategy) bool {
+	t, ok := r.(Throttler)
+	return ok && t.Throttles(strategy)
+}
+
+// limited returns the rate limit an automatic isolation at now would exceed, if any.
+func (w *Workflow) limited(now time.Time) (string, error) {
+	for _, l := range w.config.RateLimits {
+		key, ok := w.rateKey(l.Scope)
+		if !ok || l.Max <= 0 {
+			continue
+		}
+		per := l.Per
+		if per <= 0 {
+			per = time.Hour
+		}
+		n, err := w.config.Counter.Count(key, now.Add(-per))
+		if err != nil {
+			return "", errors.Wrap(err, "failed to count isolations of "+key)
+		}
+		if n >= l.Max {
+			return "rate limit of " + strconv.Itoa(l.Max) + " isolations per " + per.String() + " on " + key + " reached", nil
+		}
+	}
+	return "", nil
+}
+
+// count records an executed isolation against the rate limits; throttling keeps devices in
+// service and is not counted.
+func (w *Workflow) count(result RemediationResult, now time.Time) {
+	if !result.Success || result.DryRun || result.Action != "isolated" {
+		return
+	}
+	for _, scope := range []RateScope{ScopeHost, ScopeCluster} {
+		key, ok := w.rateKey(scope)
+		if !ok {
+			continue
+		}
+		if err := w.config.Counter.Add(key, now); err != nil {
+			logger.Error("failed to count isolation", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// rateKey returns the counter key of a rate limit scope.
+func (w *Workflow) rateKey(scope RateScope) (string, bool) {
+	switch scope {
+	case ScopeHost:
+		return "host/" + w.config.Host, true
+	case ScopeCluster:
+		return "cluster/" + w.config.Cluster, w.config.Cluster != ""
+	default:
+		return "", false
+	}
+}
+
+// tier returns the service tier a device serves. RAID drives serve their controller's tier
+// unless configured themselves.
+func (w *Workflow) tier(deviceType enum.DeviceType, deviceID string) slo.ServiceTier {
+	if tier, ok := w.config.Tiers[detection.DeviceRef{DeviceType: deviceType, DeviceID: deviceID}]; ok {
+		return tier
+	}
+	if i := strings.Index(deviceID, "/"); i > 0 && deviceType == enum.RAID {
+		if tier, ok := w.config.Tiers[detection.DeviceRef{DeviceType: deviceType, DeviceID: deviceID[:i]}]; ok {
+			return tier
+		}
+	}
+	return slo.NonCritical
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"github.com/turtacn/ioshelfer/internal/core/prediction"
+	"github.com/turtacn/ioshelfer/internal/core/remediation"
+	"github.com/turtacn/ioshelfer/internal/infra/metrics"
+	"github.com/turtacn/ioshelfer/internal/infra/storage"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StorageSink persists the metrics behind every check.
+type StorageSink struct {
+	storage storage.Storage
+}
+
+// NewStorageSink creates a new StorageSink; subscribe it to EventCheck.
+func NewStorageSink(s storage.Storage) *StorageSink {
+	return &StorageSink{storage: s}
+}
+
+// Handle stores the checked device's metrics.
+func (s *StorageSink) Handle(ctx context.Context, event Event) error {
+	if event.Type != EventCheck {
+		return nil
+	}
+	return detection.StoreStatus(s.storage, event.Status)
+}
+
+// MetricsSink exports every check to a metrics collector.
+type MetricsSink struct {
+	collector metrics.MetricsCollector
+}
+
+// NewMetricsSink creates a new MetricsSink; subscribe it to EventCheck.
+func NewMetricsSink(collector metrics.MetricsCollector) *MetricsSink {
+	return &MetricsSink{collector: collector}
+}
+
+// Handle updates the collector with the checked device's metrics.
+func (s *MetricsSink) Handle(ctx context.Context, event Event) error {
+	if event.Type != EventCheck {
+		return nil
+	}
+	s.collector.Collect(event.DeviceType, event.Status)
+	return nil
+}
+
+// RemediationSink isolates devices that degrade and recovers devices that return to health.
+type RemediationSink struct {
+	remediators map[enum.DeviceType]remediation.Remediator
+	playbooks   *remediation.PlaybookEngine // Nil when devices are always isolated directly
+}
+
+// NewRemediationSink creates a new RemediationSink; subscribe it to EventStateChange.
+// Device types without a remediator are ignored. playbooks may be nil.
+func NewRemediationSink(remediators map[enum.DeviceType]remediation.Remediator, playbooks *remediation.PlaybookEngine) *RemediationSink {
+	return &RemediationSink{remediators: remediators, playbooks: playbooks}
+}
+
+// Handle isolates a device temporarily when it becomes SubHealthy and permanently when it
+// fails, and recovers it once it is Healthy again. A degraded device is instead remediated
+// by the playbook of its findings when one handles their code. Remediators that record
+// causes are given the state change and the status it was observed with.
+func (s *RemediationSink) Handle(ctx context.Context, event Event) error {
+	if event.Type != EventStateChange {
+		return nil
+	}
+	r, ok := s.remediators[event.DeviceType]
+	if !ok {
+		return nil
+	}
+
+	var (
+		result remediation.RemediationResult
+		err    error
+	)
+	cause := remediation.Cause{
+		Trigger:  "state change from " + event.Previous.String() + " to " + event.Status.Status.String(),
+		Evidence: remediation.NewEvidence(event.Status, event.At),
+	}
+	caused, recordsCause := r.(remediation.CausedRemediator)
+	switch event.Status.Status {
+	case enum.SubHealthy, enum.Failed:
+		if s.playbooks != nil {
+			run, ok, err := s.playbooks.RunFor(ctx, event.Status)
+			if ok {
+				logger.Info("remediation playbook triggered by state change",
+					zap.String("device_type", event.DeviceType.String()),
+					zap.String("device_id", event.DeviceID),
+					zap.String("status", event.Status.Status.String()),
+					zap.String("playbook", run.Playbook),
+					zap.Bool("success", run.Success),
+				)
+				return err
+			}
+		}
+		strategy := enum.Temporary
+		if event.Status.Status == enum.Failed {
+			strategy = enum.Permanent
+		}
+		if recordsCause {
+			result, err = caused.IsolateWith(event.DeviceType, event.DeviceID, strategy, cause)
+		} else {
+			result, err = r.Isolate(event.DeviceType, event.DeviceID, strategy)
+		}
+	case enum.Healthy:
+		if recordsCause {
+			result, err = caused.RecoverWith(event.DeviceType, event.DeviceID, cause)
+		} else {
+			result, err = r.Recover(event.DeviceType, event.DeviceID)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("remediation triggered by state change",
+		zap.String("device_type", event.DeviceType.String()),
+		zap.String("device_id", event.DeviceID),
+		zap.String("status", event.Status.Status.String()),
+		zap.String("action", result.Action),
+		zap.Bool("success", result.Success),
+	)
+	return nil
+}
+
+// PredictionOutcomeSink resolves recorded failure predictions when their device fails.
+type PredictionOutcomeSink struct {
+	tracker *prediction.QualityTracker
+}
+
+// NewPredictionOutcomeSink creates a new PredictionOutcomeSink; subscribe it to
+// EventStateChange.
+func NewPredictionOutcomeSink(tracker *prediction.QualityTracker) *PredictionOutcomeSink {
+	return &PredictionOutcomeSink{tracker: tracker}
+}
+
+// Handle marks the device's pending predictions as failed when it becomes Failed.
+func (s *PredictionOutcomeSink) Handle(ctx context.Context, event Event) error {
+	if event.Type != EventStateChange || event.Status.Status != enum.Failed {
+		return nil
+	}
+	return s.tracker.ObserveFailure(event.DeviceType, event.DeviceID, event.At)
+}
+
+// FindingPayload is the JSON representation of a detection finding.
+type FindingPayload struct {
+	Code        string  `json:"code"`
+	Severity    string  `json:"severity"`
+	Source      string  `json:"source"`
+	Name        string  `json:"name"`
+	Metric      string  `json:"metric"`
+	Operator    string  `json:"operator"`
+	Threshold   float64 `json:"threshold"`
+	Observed    float64 `json:"observed"`
+	Confidence  float64 `json:"confidence"`
+	Start       string  `json:"start"`
+	End         string  `json:"end"`
+	Action      string  `json:"action"`
+	Explanation string  `json:"explanation"`
+}
+
+// NewFindingPayloads converts findings to their JSON representation.
+func NewFindingPayloads(findings []detection.Finding) []FindingPayload {
+	payloads := make([]FindingPayload, 0, len(findings))
+	for _, f := range findings {
+		payloads = append(payloads, FindingPayload{
+			Code:        f.Code,
+			Severity:    f.Severity.String(),
+			Source:      f.Source,
+			Name:        f.Name,
+			Metric:      f.Metric,
+			Operator:    f.Operator,
+			Threshold:   f.Threshold,
+			Observed:    f.Observed,
+			Confidence:  f.Confidence,
+			Start:       f.Start.UTC().Format(time.RFC3339),
+			End:         f.End.UTC().Format(time.RFC3339),
+			Action:      f.Action,
+			Explanation: f.Explain(),
+		})
+	}
+	return payloads
+}
+
+// WebhookPayload is the JSON body posted to the webhook on a state change or escalation.
+type WebhookPayload struct {
+	Event          string           `json:"event"`
+	Timestamp      string           `json:"timestamp"`
+	DeviceType     string           `json:"device_type"`
+	DeviceID       string           `json:"device_id"`
+	Previous       string           `json:"previous_status"`
+	Status         string           `json:"status"`
+	Confidence     float64          `json:"confidence"`
+	Recommendation string           `json:"recommendation"`
+	Findings       []FindingPayload `json:"findings"`
+}
+
+// WebhookSink posts state changes and escalations to an HTTP endpoint.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink creates a new WebhookSink; subscribe it to EventStateChange and
+// EventEscalation.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Handle posts the state change or escalation to the webhook.
+func (s *WebhookSink) Handle(ctx context.Context, event Event) error {
+	if event.Type != EventStateChange && event.Type != EventEscalation {
+		return nil
+	}
+
+	body, err := json.Marshal(WebhookPayload{
+		Event:          event.Type.String(),
+		Timestamp:      event.At.UTC().Format(time.RFC3339),
+		DeviceType:     event.DeviceType.String(),
+		DeviceID:       event.DeviceID,
+		Previous:       event.Previous.String(),
+		Status:         event.Status.Status.String(),
+		Confidence:     event.Status.Confidence,
+		Recommendation: event.Status.Recommendation,
+		Findings:       NewFindingPayloads(event.Status.Findings),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal webhook payload")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send webhook notification")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("webhook returned status "+strconv.Itoa(resp.StatusCode), nil)
+	}
+	return nil
+}
@@ -1,85 +1,568 @@
 package ebpf
 
 import (
+	"context"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
 	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
 	"go.uber.org/zap"
+	"sort"
+	"sync"
 	"time"
 )
 
 // RAIDMetrics represents metrics collected for a RAID controller.
 type RAIDMetrics struct {
+	DeviceID       string        // Controller identifier (e.g., "host0")
 	QueueDepth     int           // Current queue depth
 	AvgLatency     time.Duration // Average I/O latency
 	ErrorRetryRate int           // Number of error retries per hour
+	CacheEventRate float64       // Cache policy changes and battery or capacitor faults per hour
+	Firmware       string        // Firmware version reported by the driver, if any
 }
 
 // DiskMetrics represents metrics collected for a disk device.
 type DiskMetrics struct {
-	IOPSVariance float64   // Variance in IOPS
-	SMART        SMARTData // SMART attributes
+	DeviceID     string        // Block device name (e.g., "sda")
+	QueueDepth   int           // Requests currently in flight
+	AvgLatency   time.Duration // Average I/O latency
+	IOPS         float64       // Completed I/Os per second since the previous sample
+	IOPSVariance float64       // Variance in IOPS
+	SMART        SMARTData     // SMART attributes
 }
 
 // SMARTData represents SMART attributes for a disk.
 type SMARTData struct {
 	ReallocatedSectors int     // Number of reallocated sectors
-	ReadErrorRate      float64 // Raw read error rate
+	ReadErrorRate      float64 // ATA raw read error rate in millions; NVMe media errors or SCSI uncorrected reads
 	Temperature        int     // Disk temperature in Celsius
 }
 
 // NetworkMetrics represents metrics collected for network I/O.
 type NetworkMetrics struct {
-	PacketLossRate float64       // Packet loss rate
-	LatencyP95     time.Duration // 95th percentile latency
+	DeviceID          string        // Interface name (e.g., "eth0")
+	PacketLossRate    float64       // Packet loss rate
+	LatencyP95        time.Duration // 95th percentile latency
+	BytesPerSecond    uint64        // Combined rx/tx throughput since the previous sample
+	ErrorRate         float64       // rx/tx errors per hour since the previous sample
+	CarrierChangeRate float64       // Link flaps per hour since the previous sample
+	RetransmitRate    float64       // TCP segments retransmitted per second
+}
+
+// Values returns the controller metrics keyed by Metric* name.
+func (m *RAIDMetrics) Values() map[string]float64 {
+	return map[string]float64{
+		MetricQueueDepth:     float64(m.QueueDepth),
+		MetricAvgLatency:     m.AvgLatency.Seconds(),
+		MetricErrorRetryRate: float64(m.ErrorRetryRate),
+		MetricCacheEventRate: m.CacheEventRate,
+	}
+}
+
+// Values returns the disk metrics keyed by Metric* name.
+func (m *DiskMetrics) Values() map[string]float64 {
+	return map[string]float64{
+		MetricQueueDepth:         float64(m.QueueDepth),
+		MetricAvgLatency:         m.AvgLatency.Seconds(),
+		MetricIOPS:               m.IOPS,
+		MetricIOPSVariance:       m.IOPSVariance,
+		MetricReallocatedSectors: float64(m.SMART.ReallocatedSectors),
+		MetricReadErrorRate:      m.SMART.ReadErrorRate,
+		MetricTemperature:        float64(m.SMART.Temperature),
+	}
+}
+
+// Values returns the interface metrics keyed by Metric* name.
+func (m *NetworkMetrics) Values() map[string]float64 {
+	return map[string]float64{
+		MetricPacketLossRate:     m.PacketLossRate,
+		MetricLatencyP95:         m.LatencyP95.Seconds(),
+		MetricBytesPerSecond:     float64(m.BytesPerSecond),
+		MetricInterfaceErrorRate: m.ErrorRate,
+		MetricCarrierChangeRate:  m.CarrierChangeRate,
+		MetricRetransmitRate:     m.RetransmitRate,
+	}
 }
 
 // Monitor defines the interface for eBPF-based metric collection.
 type Monitor interface {
-	GetRAIDMetrics() (*RAIDMetrics, error)
-	GetDiskMetrics() (*DiskMetrics, error)
-	GetNetworkMetrics() (*NetworkMetrics, error)
-	StartMonitor() error
+	ListDevices(deviceType enum.DeviceType) ([]string, error)
+	GetRAIDMetrics() (map[string]*RAIDMetrics, error)
+	GetDiskMetrics() (map[string]*DiskMetrics, error)
+	GetNetworkMetrics() (map[string]*NetworkMetrics, error)
+	Samples(deviceType enum.DeviceType, deviceID, metric string, window time.Duration) []Point
+	Aggregate(deviceType enum.DeviceType, deviceID, metric string, window time.Duration) WindowStats
+	Subscribe(buffer int) (<-chan Sample, func())
+	StartMonitor(ctx context.Context) error
+	Stop() error
 }
 
 // EBPFMonitor implements the Monitor interface using eBPF.
 type EBPFMonitor struct {
+	*SampleBuffer
+
 	config *Config
+	sysfs  *sysfsReader
+
+	mu       sync.Mutex
+	lastRAID map[string]raidCounters
+	lastDisk map[string]diskCounters
+	lastNet  map[string]netCounters
+	iopsHist map[string][]float64
+
+	smartMu  sync.Mutex
+	smart    map[string]smartCache
+	smartctl smartRunner
+
+	// Latest polled snapshots, served by the getters while the sampling loop runs.
+	latestMu      sync.RWMutex
+	latestRAID    map[string]*RAIDMetrics
+	latestDisk    map[string]*DiskMetrics
+	latestNetwork map[string]*NetworkMetrics
+
+	runMu  sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 // Config defines the configuration for the eBPF monitor.
 type Config struct {
 	PollInterval time.Duration // Interval for polling metrics
+	SysfsRoot    string        // Root of the sysfs tree used for device enumeration (default "/sys")
+	BufferSize   int           // Number of samples retained per device and metric
+
+	// SMART attributes are read with smartctl, which needs root. Collection is off by
+	// default, leaving the SMART metrics and the rules on them at zero.
+	SMARTInterval time.Duration // How often each disk's SMART attributes are read; 0 disables SMART collection
+	SMARTCommand  string        // smartctl binary (default "smartctl")
 }
 
+const (
+	// iopsHistorySize bounds the number of IOPS samples kept per disk for variance.
+	iopsHistorySize = 60
+	// defaultBufferSize keeps one hour of history at a 10s poll interval.
+	defaultBufferSize = 360
+)
+
 // NewEBPFMonitor creates a new EBPFMonitor instance.
 func NewEBPFMonitor(config *Config) *EBPFMonitor {
+	root := config.SysfsRoot
+	if root == "" {
+		root = "/sys"
+	}
+	smartctl := config.SMARTCommand
+	if smartctl == "" {
+		smartctl = "smartctl"
+	}
 	return &EBPFMonitor{
-		config: config,
+		SampleBuffer: NewSampleBuffer(config.BufferSize),
+		config:       config,
+		sysfs:        &sysfsReader{root: root},
+		lastRAID:     make(map[string]raidCounters),
+		lastDisk:     make(map[string]diskCounters),
+		lastNet:      make(map[string]netCounters),
+		iopsHist:     make(map[string][]float64),
+		smart:        make(map[string]smartCache),
+		smartctl:     runSmartctl(smartctl),
 	}
 }
 
-// StartMonitor initializes the eBPF probes and starts metric collection.
-func (m *EBPFMonitor) StartMonitor() error {
+// StartMonitor initializes the eBPF probes and starts the background sampling loop.
+// The loop runs until ctx is cancelled or Stop is called.
+func (m *EBPFMonitor) StartMonitor(ctx context.Context) error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+
+	if m.cancel != nil {
+		return errors.New("eBPF monitor already started", nil)
+	}
+	if m.config.PollInterval <= 0 {
+		return errors.New("poll interval must be positive", nil)
+	}
+
 	// Placeholder: Initialize eBPF probes (e.g., attach to kernel functions)
 	logger.Info("starting eBPF monitor", zap.Duration("poll_interval", m.config.PollInterval))
 	// Actual implementation would load eBPF programs using cilium/ebpf
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.run(ctx, m.done)
 	return nil
 }
 
-// GetRAIDMetrics collects RAID controller metrics using eBPF.
-func (m *EBPFMonitor) GetRAIDMetrics() (*RAIDMetrics, error) {
-	// Placeholder: Simulate eBPF data collection
-	metrics := &RAIDMetrics{
-		QueueDepth:     150,                   // Mock value
-		AvgLatency:     25 * time.Millisecond, // Mock value
-		ErrorRetryRate: 50,                    // Mock value
+// Stop halts the sampling loop and waits for it to exit.
+func (m *EBPFMonitor) Stop() error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+
+	if m.cancel == nil {
+		return nil
+	}
+	m.cancel()
+	<-m.done
+	m.cancel = nil
+
+	m.latestMu.Lock()
+	m.latestRAID, m.latestDisk, m.latestNetwork = nil, nil, nil
+	m.latestMu.Unlock()
+
+	logger.Info("stopped eBPF monitor")
+	return nil
+}
+
+// run polls all devices every PollInterval until ctx is done.
+func (m *EBPFMonitor) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(m.config.PollInterval)
+	defer ticker.Stop()
+
+	m.poll()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.poll()
+		}
+	}
+}
+
+// poll takes one sample of every device and records it in the ring buffers.
+func (m *EBPFMonitor) poll() {
+	now := time.Now()
+
+	raid, err := m.collectRAIDMetrics()
+	if err != nil {
+		logger.Warn("failed to sample RAID metrics", zap.Error(err))
+	}
+	disk, err := m.collectDiskMetrics()
+	if err != nil {
+		logger.Warn("failed to sample disk metrics", zap.Error(err))
+	}
+	network, err := m.collectNetworkMetrics()
+	if err != nil {
+		logger.Warn("failed to sample network metrics", zap.Error(err))
+	}
+
+	m.latestMu.Lock()
+	m.latestRAID, m.latestDisk, m.latestNetwork = raid, disk, network
+	m.latestMu.Unlock()
+
+	for id, metrics := range raid {
+		m.Record(Sample{DeviceType: enum.RAID, DeviceID: id, Time: now, Values: metrics.Values()})
 	}
+	for id, metrics := range disk {
+		m.Record(Sample{DeviceType: enum.Disk, DeviceID: id, Time: now, Values: metrics.Values()})
+	}
+	for id, metrics := range network {
+		m.Record(Sample{DeviceType: enum.Network, DeviceID: id, Time: now, Values: metrics.Values()})
+	}
+}
 
-	logger.Info("collected RAID metrics",
-		zap.Int("queue_depth", metrics.QueueDepth),
-		zap.Duration("avg_latency", metrics.AvgLatency),
-		zap.Int("error_retry_rate", metrics.ErrorRetryRate),
+// ListDevices enumerates the devices of the given type present on the host.
+func (m *EBPFMonitor) ListDevices(deviceType enum.DeviceType) ([]string, error) {
+	var (
+		devices []string
+		err     error
 	)
+	switch deviceType {
+	case enum.RAID:
+		devices, err = m.sysfs.listRAIDControllers()
+	case enum.Disk:
+		devices, err = m.sysfs.listDisks()
+	case enum.Network:
+		devices, err = m.sysfs.listInterfaces()
+	default:
+		return nil, errors.New("unsupported device type", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(devices)
+	return devices, nil
+}
 
-	// Actual implementation would read from eBPF maps
-	return metrics, nil
+// PeerGroups returns groups of devices of the given type that should behave alike, keyed
+// by group name: disks in the same md array or of the same model, RAID controllers using the
+// same driver, and slaves of the same bond.
+func (m *EBPFMonitor) PeerGroups(deviceType enum.DeviceType) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	switch deviceType {
+	case enum.RAID:
+		hosts, err := m.sysfs.listRAIDControllers()
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			if driver, err := m.sysfs.readHostDriver(host); err == nil {
+				groups["driver:"+driver] = append(groups["driver:"+driver], host)
+			}
+		}
+	case enum.Disk:
+		arrays, err := m.sysfs.listMDArrays()
+		if err != nil {
+			return nil, err
+		}
+		for array, members := range arrays {
+			groups["md:"+array] = members
+		}
+		disks, err := m.sysfs.listDisks()
+		if err != nil {
+			return nil, err
+		}
+		for _, disk := range disks {
+			if model, err := m.sysfs.readDiskModel(disk); err == nil && model != "" {
+				groups["model:"+model] = append(groups["model:"+model], disk)
+			}
+		}
+	case enum.Network:
+		bonds, err := m.sysfs.listBonds()
+		if err != nil {
+			return nil, err
+		}
+		for bond, slaves := range bonds {
+			groups["bond:"+bond] = slaves
+		}
+	default:
+		return nil, errors.New("unsupported device type", nil)
+	}
+	for name := range groups {
+		sort.Strings(groups[name])
+	}
+	return groups, nil
+}
+
+// DiskControllers maps each disk attached to a RAID controller to that controller.
+func (m *EBPFMonitor) DiskControllers() (map[string]string, error) {
+	controllers, err := m.sysfs.listRAIDControllers()
+	if err != nil {
+		return nil, err
+	}
+	isRAID := make(map[string]bool, len(controllers))
+	for _, c := range controllers {
+		isRAID[c] = true
+	}
+	disks, err := m.sysfs.listDisks()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for _, disk := range disks {
+		if host, err := m.sysfs.readDiskHost(disk); err == nil && isRAID[host] {
+			result[disk] = host
+		}
+	}
+	return result, nil
+}
+
+// GetRAIDMetrics returns the latest sampled RAID controller metrics while the sampling loop
+// runs, and collects them on demand otherwise.
+func (m *EBPFMonitor) GetRAIDMetrics() (map[string]*RAIDMetrics, error) {
+	m.latestMu.RLock()
+	latest := m.latestRAID
+	m.latestMu.RUnlock()
+	if latest != nil {
+		return latest, nil
+	}
+	return m.collectRAIDMetrics()
+}
+
+// collectRAIDMetrics collects RAID controller metrics using eBPF, keyed by controller ID.
+func (m *EBPFMonitor) collectRAIDMetrics() (map[string]*RAIDMetrics, error) {
+	controllers, err := m.ListDevices(enum.RAID)
+	if err != nil {
+		return nil, errors.NewQueueOverflow("failed to enumerate RAID controllers", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	result := make(map[string]*RAIDMetrics, len(controllers))
+	for _, id := range controllers {
+		busy, err := m.sysfs.readHostBusy(id)
+		if err != nil {
+			logger.Warn("failed to read RAID controller counters", zap.String("controller_id", id), zap.Error(err))
+			continue
+		}
+		// Latency and cache events require the scsi_dispatch_cmd probes and vendor
+		// tooling; actual implementation would read them from eBPF maps.
+		metrics := &RAIDMetrics{
+			DeviceID:   id,
+			QueueDepth: busy,
+		}
+		metrics.Firmware, _ = m.sysfs.readHostFirmware(id)
+		if errs, err := m.sysfs.readHostIOErrors(id); err == nil {
+			cur := raidCounters{ioErrors: errs, at: now}
+			if prev, ok := m.lastRAID[id]; ok && cur.ioErrors >= prev.ioErrors {
+				if hours := cur.at.Sub(prev.at).Hours(); hours > 0 {
+					metrics.ErrorRetryRate = int(float64(cur.ioErrors-prev.ioErrors) / hours)
+				}
+			}
+			m.lastRAID[id] = cur
+		}
+		result[id] = metrics
+
+		logger.Info("collected RAID metrics",
+			zap.String("controller_id", id),
+			zap.Int("queue_depth", metrics.QueueDepth),
+			zap.Duration("avg_latency", metrics.AvgLatency),
+			zap.Int("error_retry_rate", metrics.ErrorRetryRate),
+			zap.String("firmware", metrics.Firmware),
+		)
+	}
+	return result, nil
+}
+
+// GetDiskMetrics returns the latest sampled disk metrics while the sampling loop
+// runs, and collects them on demand otherwise.
+func (m *EBPFMonitor) GetDiskMetrics() (map[string]*DiskMetrics, error) {
+	m.latestMu.RLock()
+	latest := m.latestDisk
+	m.latestMu.RUnlock()
+	if latest != nil {
+		return latest, nil
+	}
+	return m.collectDiskMetrics()
+}
+
+// collectDiskMetrics collects disk metrics using eBPF, keyed by block device name.
+func (m *EBPFMonitor) collectDiskMetrics() (map[string]*DiskMetrics, error) {
+	disks, err := m.ListDevices(enum.Disk)
+	if err != nil {
+		return nil, errors.NewStorageFailure("failed to enumerate disks", err)
+	}
+
+	now := time.Now()
+	smart := m.readSMART(disks, now)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[string]*DiskMetrics, len(disks))
+	for _, id := range disks {
+		cur, err := m.sysfs.readDiskCounters(id)
+		if err != nil {
+			logger.Warn("failed to read disk counters", zap.String("disk_id", id), zap.Error(err))
+			continue
+		}
+		cur.at = now
+
+		metrics := &DiskMetrics{
+			DeviceID:   id,
+			QueueDepth: int(cur.inFlight),
+			SMART:      smart[id],
+		}
+		if prev, ok := m.lastDisk[id]; ok && !cur.reset(prev) {
+			ios := float64(cur.ios() - prev.ios())
+			if elapsed := cur.at.Sub(prev.at).Seconds(); elapsed > 0 {
+				metrics.IOPS = ios / elapsed
+			}
+			if ios > 0 {
+				metrics.AvgLatency = time.Duration(float64(cur.ticks()-prev.ticks()) / ios * float64(time.Millisecond))
+			}
+			hist := append(m.iopsHist[id], metrics.IOPS)
+			if len(hist) > iopsHistorySize {
+				hist = hist[len(hist)-iopsHistorySize:]
+			}
+			m.iopsHist[id] = hist
+			metrics.IOPSVariance = variance(hist)
+		}
+		m.lastDisk[id] = cur
+		result[id] = metrics
+
+		logger.Info("collected disk metrics",
+			zap.String("disk_id", id),
+			zap.Int("queue_depth", metrics.QueueDepth),
+			zap.Duration("avg_latency", metrics.AvgLatency),
+			zap.Float64("iops", metrics.IOPS),
+		)
+	}
+	return result, nil
+}
+
+// GetNetworkMetrics returns the latest sampled network metrics while the sampling loop
+// runs, and collects them on demand otherwise.
+func (m *EBPFMonitor) GetNetworkMetrics() (map[string]*NetworkMetrics, error) {
+	m.latestMu.RLock()
+	latest := m.latestNetwork
+	m.latestMu.RUnlock()
+	if latest != nil {
+		return latest, nil
+	}
+	return m.collectNetworkMetrics()
+}
+
+// collectNetworkMetrics collects network metrics using eBPF, keyed by interface name.
+func (m *EBPFMonitor) collectNetworkMetrics() (map[string]*NetworkMetrics, error) {
+	interfaces, err := m.ListDevices(enum.Network)
+	if err != nil {
+		return nil, errors.NewNetworkPacketLoss("failed to enumerate network interfaces", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	result := make(map[string]*NetworkMetrics, len(interfaces))
+	for _, id := range interfaces {
+		cur, err := m.sysfs.readNetCounters(id)
+		if err != nil {
+			logger.Warn("failed to read interface counters", zap.String("interface", id), zap.Error(err))
+			continue
+		}
+		cur.at = now
+
+		// LatencyP95 and RetransmitRate require the tcp_rtt and tcp_retransmit_skb
+		// probes; actual implementation would read them from eBPF maps.
+		metrics := &NetworkMetrics{DeviceID: id}
+		if prev, ok := m.lastNet[id]; ok && !cur.reset(prev) {
+			packets := cur.packets() - prev.packets()
+			if packets > 0 {
+				metrics.PacketLossRate = float64(cur.lost()-prev.lost()) / float64(packets)
+			}
+			if elapsed := cur.at.Sub(prev.at).Seconds(); elapsed > 0 {
+				metrics.BytesPerSecond = uint64(float64(cur.bytes()-prev.bytes()) / elapsed)
+				if cur.errors() >= prev.errors() {
+					metrics.ErrorRate = float64(cur.errors()-prev.errors()) / elapsed * 3600
+				}
+				if cur.carrierChanges >= prev.carrierChanges {
+					metrics.CarrierChangeRate = float64(cur.carrierChanges-prev.carrierChanges) / elapsed * 3600
+				}
+			}
+		} else if packets := cur.packets(); packets > 0 {
+			metrics.PacketLossRate = float64(cur.lost()) / float64(packets)
+		}
+		m.lastNet[id] = cur
+		result[id] = metrics
+
+		logger.Info("collected network metrics",
+			zap.String("interface", id),
+			zap.Float64("packet_loss_rate", metrics.PacketLossRate),
+			zap.Uint64("bytes_per_second", metrics.BytesPerSecond),
+			zap.Float64("error_rate", metrics.ErrorRate),
+			zap.Float64("carrier_change_rate", metrics.CarrierChangeRate),
+		)
+	}
+	return result, nil
+}
+
+// variance returns the population variance of the given samples.
+func variance(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	sum := 0.0
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+	return sum / float64(len(values))
 }
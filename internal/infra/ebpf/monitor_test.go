@@ -1 +1,265 @@
 package ebpf
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+)
+
+// writeSysfs creates a file with the given content under a fake sysfs root.
+func writeSysfs(t *testing.T, root, rel, content string) {
+	t.Helper()
+	path := filepath.Join(root, rel)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func newFakeSysfs(t *testing.T) string {
+	root := t.TempDir()
+	writeSysfs(t, root, "block/sda/stat", "100 0 0 200 50 0 0 100 3 0 0")
+	writeSysfs(t, root, "block/sdb/stat", "10 0 0 10 0 0 0 0 0 0 0")
+	writeSysfs(t, root, "block/loop0/stat", "0 0 0 0 0 0 0 0 0 0 0")
+	writeSysfs(t, root, "class/scsi_host/host0/proc_name", "megaraid_sas")
+	writeSysfs(t, root, "class/scsi_host/host0/host_busy", "42")
+	writeSysfs(t, root, "class/scsi_host/host1/proc_name", "ahci")
+	for _, iface := range []string{"eth0", "lo"} {
+		for name, value := range map[string]string{
+			"rx_packets": "900", "tx_packets": "100",
+			"rx_dropped": "5", "tx_dropped": "5",
+			"rx_errors": "0", "tx_errors": "0",
+			"rx_bytes": "1000", "tx_bytes": "1000",
+		} {
+			writeSysfs(t, root, filepath.Join("class/net", iface, "statistics", name), value)
+		}
+	}
+	return root
+}
+
+func TestListDevices(t *testing.T) {
+	m := NewEBPFMonitor(&Config{SysfsRoot: newFakeSysfs(t)})
+
+	disks, err := m.ListDevices(enum.Disk)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sda", "sdb"}, disks)
+
+	controllers, err := m.ListDevices(enum.RAID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"host0"}, controllers)
+
+	interfaces, err := m.ListDevices(enum.Network)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eth0"}, interfaces)
+}
+
+func TestGetMetricsPerDevice(t *testing.T) {
+	root := newFakeSysfs(t)
+	writeSysfs(t, root, "class/scsi_host/host0/version_fw", "25.5.9.0001\n")
+	writeSysfs(t, root, "class/scsi_device/0:2:0:0/device/ioerr_cnt", "0x2\n")
+	writeSysfs(t, root, "class/scsi_device/1:0:0:0/device/ioerr_cnt", "0x40\n")
+	writeSysfs(t, root, "class/net/eth0/carrier_changes", "4\n")
+	m := NewEBPFMonitor(&Config{SysfsRoot: root})
+
+	raid, err := m.GetRAIDMetrics()
+	require.NoError(t, err)
+	require.Contains(t, raid, "host0")
+	assert.Equal(t, 42, raid["host0"].QueueDepth)
+	assert.Equal(t, "25.5.9.0001", raid["host0"].Firmware)
+	assert.Equal(t, uint64(2), m.lastRAID["host0"].ioErrors)
+
+	disks, err := m.GetDiskMetrics()
+	require.NoError(t, err)
+	require.Len(t, disks, 2)
+	assert.Equal(t, 3, disks["sda"].QueueDepth)
+	assert.Equal(t, 0, disks["sdb"].QueueDepth)
+
+	// A second sample yields per-device deltas.
+	writeSysfs(t, root, "block/sda/stat", "200 0 0 1200 50 0 0 100 1 0 0")
+	disks, err = m.GetDiskMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, "10ms", disks["sda"].AvgLatency.String())
+	assert.Zero(t, disks["sdb"].AvgLatency)
+
+	network, err := m.GetNetworkMetrics()
+	require.NoError(t, err)
+	require.Contains(t, network, "eth0")
+	assert.InDelta(t, 0.01, network["eth0"].PacketLossRate, 1e-9)
+
+	// Error counters and link flaps become hourly rates between samples.
+	m.lastRAID["host0"] = raidCounters{ioErrors: 2, at: time.Now().Add(-time.Hour)}
+	writeSysfs(t, root, "class/scsi_device/0:2:0:0/device/ioerr_cnt", "0xc\n")
+	raid, err = m.GetRAIDMetrics()
+	require.NoError(t, err)
+	assert.InDelta(t, 10, raid["host0"].ErrorRetryRate, 1)
+
+	prev := m.lastNet["eth0"]
+	prev.at = time.Now().Add(-30 * time.Minute)
+	m.lastNet["eth0"] = prev
+	writeSysfs(t, root, "class/net/eth0/carrier_changes", "6\n")
+	writeSysfs(t, root, "class/net/eth0/statistics/rx_errors", "3\n")
+	network, err = m.GetNetworkMetrics()
+	require.NoError(t, err)
+	assert.InDelta(t, 4, network["eth0"].CarrierChangeRate, 0.01)
+	assert.InDelta(t, 6, network["eth0"].ErrorRate, 0.01)
+
+	// Counters that went backwards are a reset, not a wrapped delta.
+	writeSysfs(t, root, "block/sda/stat", "5 0 0 10 0 0 0 0 0 0 0")
+	disks, err = m.GetDiskMetrics()
+	require.NoError(t, err)
+	assert.Zero(t, disks["sda"].IOPS)
+	assert.Zero(t, disks["sda"].AvgLatency)
+	writeSysfs(t, root, "block/sda/stat", "15 0 0 30 0 0 0 0 0 0 0")
+	disks, err = m.GetDiskMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, "2ms", disks["sda"].AvgLatency.String())
+	// Sub-millisecond latencies are not truncated.
+	writeSysfs(t, root, "block/sda/stat", "25 0 0 35 0 0 0 0 0 0 0")
+	disks, err = m.GetDiskMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, "500µs", disks["sda"].AvgLatency.String())
+
+	for _, name := range []string{"rx_packets", "rx_bytes", "rx_dropped"} {
+		writeSysfs(t, root, "class/net/eth0/statistics/"+name, "1\n")
+	}
+	network, err = m.GetNetworkMetrics()
+	require.NoError(t, err)
+	assert.Zero(t, network["eth0"].BytesPerSecond)
+	assert.Less(t, network["eth0"].PacketLossRate, 1.0)
+}
+
+func TestSampleBufferWindow(t *testing.T) {
+	b := NewSampleBuffer(3)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		b.Record(Sample{DeviceType: enum.Disk, DeviceID: "sda", Time: now.Add(time.Duration(i-4) * time.Second),
+			Values: map[string]float64{MetricIOPS: float64(i)}})
+	}
+
+	// Only the three newest points survive.
+	points := b.Samples(enum.Disk, "sda", MetricIOPS, time.Minute)
+	require.Len(t, points, 3)
+	assert.Equal(t, 2.0, points[0].Value)
+	assert.Equal(t, 4.0, points[2].Value)
+
+	stats := b.Aggregate(enum.Disk, "sda", MetricIOPS, 1500*time.Millisecond)
+	assert.Equal(t, 2, stats.Count)
+	assert.Equal(t, 3.0, stats.Min)
+	assert.Equal(t, 4.0, stats.Max)
+	assert.Equal(t, 3.5, stats.Mean)
+	assert.Empty(t, b.Samples(enum.Disk, "sdb", MetricIOPS, time.Minute))
+}
+
+func TestSamplingLoop(t *testing.T) {
+	m := NewEBPFMonitor(&Config{SysfsRoot: newFakeSysfs(t), PollInterval: 10 * time.Millisecond})
+	samples, unsubscribe := m.Subscribe(16)
+	defer unsubscribe()
+
+	require.NoError(t, m.StartMonitor(context.Background()))
+	assert.Error(t, m.StartMonitor(context.Background()))
+
+	seen := map[string]bool{}
+	timeout := time.After(2 * time.Second)
+	for len(seen) < 4 {
+		select {
+		case s := <-samples:
+			seen[s.DeviceType.String()+"/"+s.DeviceID] = true
+		case <-timeout:
+			t.Fatalf("timed out waiting for samples, got %v", seen)
+		}
+	}
+	require.NoError(t, m.Stop())
+	require.NoError(t, m.Stop())
+
+	assert.True(t, seen["raid/host0"])
+	assert.True(t, seen["disk/sda"])
+	assert.True(t, seen["network/eth0"])
+	assert.NotEmpty(t, m.Samples(enum.RAID, "host0", MetricQueueDepth, time.Minute))
+}
+
+func TestPeerGroups(t *testing.T) {
+	root := newFakeSysfs(t)
+	writeSysfs(t, root, "block/sdc/stat", "0 0 0 0 0 0 0 0 0 0 0")
+	writeSysfs(t, root, "block/md0/slaves/sda1", "")
+	writeSysfs(t, root, "block/md0/slaves/sdb", "")
+	writeSysfs(t, root, "block/md0/slaves/sdc", "")
+	writeSysfs(t, root, "block/sda/device/model", "ST4000NM0035\n")
+	writeSysfs(t, root, "block/sdb/device/model", "ST4000NM0035\n")
+	writeSysfs(t, root, "class/net/bond0/bonding/slaves", "eth0 eth1\n")
+	m := NewEBPFMonitor(&Config{SysfsRoot: root})
+
+	disks, err := m.PeerGroups(enum.Disk)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sda", "sdb", "sdc"}, disks["md:md0"])
+	assert.Equal(t, []string{"sda", "sdb"}, disks["model:ST4000NM0035"])
+
+	bonds, err := m.PeerGroups(enum.Network)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"bond:bond0": {"eth0", "eth1"}}, bonds)
+
+	raid, err := m.PeerGroups(enum.RAID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"driver:megaraid_sas": {"host0"}}, raid)
+}
+
+func TestDiskControllers(t *testing.T) {
+	root := newFakeSysfs(t)
+	writeSysfs(t, root, "devices/pci0000:00/0000:00:1f.2/host0/target0:0:0/0:0:0:0/model", "PERC H730\n")
+	writeSysfs(t, root, "devices/pci0000:00/0000:00:1f.3/host1/target1:0:0/1:0:0:0/model", "SATA SSD\n")
+	require.NoError(t, os.Symlink(filepath.Join(root, "devices/pci0000:00/0000:00:1f.2/host0/target0:0:0/0:0:0:0"), filepath.Join(root, "block/sda/device")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "devices/pci0000:00/0000:00:1f.3/host1/target1:0:0/1:0:0:0"), filepath.Join(root, "block/sdb/device")))
+	m := NewEBPFMonitor(&Config{SysfsRoot: root})
+
+	controllers, err := m.DiskControllers()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"sda": "host0"}, controllers)
+}
+
+func TestDiskSMART(t *testing.T) {
+	m := NewEBPFMonitor(&Config{SysfsRoot: newFakeSysfs(t), SMARTInterval: time.Hour})
+	reports := map[string]string{
+		"sda": `{"temperature": {"current": 41}, "ata_smart_attributes": {"table": [
+			{"id": 1, "raw": {"value": 12000000}}, {"id": 5, "raw": {"value": 120}}, {"id": 194, "raw": {"value": 176093659177}}]}}`,
+		"sdb": `{"smartctl": {"exit_status": 1}}`,
+	}
+	calls := 0
+	blocking := false
+	m.smartctl = func(ctx context.Context, disk string) ([]byte, error) {
+		calls++
+		// smartctl must not hold up the collection of the other metrics.
+		if m.mu.TryLock() {
+			m.mu.Unlock()
+		} else {
+			blocking = true
+		}
+		return []byte(reports[disk]), nil
+	}
+
+	disks, err := m.GetDiskMetrics()
+	require.NoError(t, err)
+	assert.False(t, blocking)
+	assert.Equal(t, SMARTData{ReallocatedSectors: 120, ReadErrorRate: 12, Temperature: 41}, disks["sda"].SMART)
+	assert.Equal(t, 120.0, disks["sda"].Values()[MetricReallocatedSectors])
+	assert.Zero(t, disks["sdb"].SMART)
+	assert.Equal(t, 2, calls)
+
+	// SMART is read once per interval, not on every poll.
+	disks, err = m.GetDiskMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 41, disks["sda"].SMART.Temperature)
+	assert.Equal(t, 2, calls)
+
+	nvme, err := parseSMART([]byte(`{"nvme_smart_health_information_log": {"media_errors": 3, "temperature": 38}}`))
+	require.NoError(t, err)
+	assert.Equal(t, SMARTData{ReadErrorRate: 3, Temperature: 38}, nvme)
+	scsi, err := parseSMART([]byte(`{"scsi_grown_defect_list": 7, "scsi_error_counter_log": {"read": {"total_uncorrected_errors": 2}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, SMARTData{ReallocatedSectors: 7, ReadErrorRate: 2}, scsi)
+	ata, err := parseSMART([]byte(`{"ata_smart_attributes": {"table": [{"id": 194, "raw": {"value": 176093659177}}]}}`))
+	require.NoError(t, err)
+	assert.Equal(t, 41, ata.Temperature)
+}
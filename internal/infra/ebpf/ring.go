@@ -0,0 +1,226 @@
+package ebpf
+
+import (
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metric names used for the per-device sample buffers.
+const (
+	MetricQueueDepth     = "queue_depth"
+	MetricAvgLatency     = "avg_latency_seconds"
+	MetricErrorRetryRate = "error_retry_rate"
+	MetricIOPS           = "iops"
+	MetricIOPSVariance   = "iops_variance"
+	MetricPacketLossRate = "packet_loss_rate"
+	MetricLatencyP95     = "latency_p95_seconds"
+	MetricBytesPerSecond = "bytes_per_second"
+
+	MetricCacheEventRate     = "cache_event_rate"
+	MetricInterfaceErrorRate = "interface_error_rate"
+	MetricCarrierChangeRate  = "carrier_change_rate"
+	MetricRetransmitRate     = "tcp_retransmit_rate"
+
+	MetricReallocatedSectors = "reallocated_sectors"
+	MetricReadErrorRate      = "read_error_rate"
+	MetricTemperature        = "temperature_celsius"
+)
+
+// Point is a single timestamped metric value.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Sample is one polling round's reading of a single device.
+type Sample struct {
+	DeviceType enum.DeviceType
+	DeviceID   string
+	Time       time.Time
+	Values     map[string]float64 // Keyed by Metric* name
+}
+
+// WindowStats summarises the points of one metric over a time window.
+type WindowStats struct {
+	Count int
+	Min   float64
+	Max   float64
+	Mean  float64
+	P50   float64
+	P90   float64
+	P95   float64
+	P99   float64
+}
+
+// ringBuffer keeps the most recent points of one metric in a fixed-size buffer.
+type ringBuffer struct {
+	points []Point
+	next   int
+	full   bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{points: make([]Point, size)}
+}
+
+// add appends a point, overwriting the oldest one once the buffer is full.
+func (r *ringBuffer) add(p Point) {
+	r.points[r.next] = p
+	r.next = (r.next + 1) % len(r.points)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// since returns the points newer than cutoff in chronological order.
+func (r *ringBuffer) since(cutoff time.Time) []Point {
+	var ordered []Point
+	if r.full {
+		ordered = append(ordered, r.points[r.next:]...)
+	}
+	ordered = append(ordered, r.points[:r.next]...)
+
+	result := make([]Point, 0, len(ordered))
+	for _, p := range ordered {
+		if p.Time.After(cutoff) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Summarize computes min/max/mean/percentiles over the given points.
+func Summarize(points []Point) WindowStats {
+	if len(points) == 0 {
+		return WindowStats{}
+	}
+	values := make([]float64, len(points))
+	stats := WindowStats{Count: len(points), Min: math.Inf(1), Max: math.Inf(-1)}
+	sum := 0.0
+	for i, p := range points {
+		values[i] = p.Value
+		sum += p.Value
+		stats.Min = math.Min(stats.Min, p.Value)
+		stats.Max = math.Max(stats.Max, p.Value)
+	}
+	stats.Mean = sum / float64(len(values))
+
+	sort.Float64s(values)
+	stats.P50 = Percentile(values, 0.50)
+	stats.P90 = Percentile(values, 0.90)
+	stats.P95 = Percentile(values, 0.95)
+	stats.P99 = Percentile(values, 0.99)
+	return stats
+}
+
+// Percentile returns the q-th quantile (0..1) of sorted values using linear interpolation.
+func Percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return sorted[0]
+	}
+	if q >= 1 {
+		return sorted[len(sorted)-1]
+	}
+	pos := q * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	frac := pos - float64(lower)
+	return sorted[lower] + (sorted[upper]-sorted[lower])*frac
+}
+
+// seriesKey identifies one metric of one device.
+type seriesKey struct {
+	deviceType enum.DeviceType
+	deviceID   string
+	metric     string
+}
+
+// SampleBuffer retains recent samples per device and metric and fans them out to subscribers.
+type SampleBuffer struct {
+	size int
+
+	mu          sync.RWMutex
+	series      map[seriesKey]*ringBuffer
+	subscribers map[int]chan Sample
+	nextSubID   int
+}
+
+// NewSampleBuffer creates a SampleBuffer keeping up to size points per series.
+func NewSampleBuffer(size int) *SampleBuffer {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	return &SampleBuffer{
+		size:        size,
+		series:      make(map[seriesKey]*ringBuffer),
+		subscribers: make(map[int]chan Sample),
+	}
+}
+
+// Record stores every metric of a sample and notifies subscribers.
+// Subscribers that are not keeping up miss the sample rather than stalling collection.
+func (b *SampleBuffer) Record(sample Sample) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for metric, value := range sample.Values {
+		key := seriesKey{sample.DeviceType, sample.DeviceID, metric}
+		ring, ok := b.series[key]
+		if !ok {
+			ring = newRingBuffer(b.size)
+			b.series[key] = ring
+		}
+		ring.add(Point{Time: sample.Time, Value: value})
+	}
+
+	for _, ch := range b.subscribers {
+		select {
+		case ch <- sample:
+		default:
+		}
+	}
+}
+
+// Samples returns the points of a device metric recorded within the last window.
+func (b *SampleBuffer) Samples(deviceType enum.DeviceType, deviceID, metric string, window time.Duration) []Point {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	ring, ok := b.series[seriesKey{deviceType, deviceID, metric}]
+	if !ok {
+		return nil
+	}
+	return ring.since(time.Now().Add(-window))
+}
+
+// Aggregate summarises a device metric over the last window.
+func (b *SampleBuffer) Aggregate(deviceType enum.DeviceType, deviceID, metric string, window time.Duration) WindowStats {
+	return Summarize(b.Samples(deviceType, deviceID, metric, window))
+}
+
+// Subscribe returns a channel receiving every recorded sample and a function to cancel the subscription.
+func (b *SampleBuffer) Subscribe(buffer int) (<-chan Sample, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextSubID
+	b.nextSubID++
+	ch := make(chan Sample, buffer)
+	b.subscribers[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, id)
+			close(ch)
+		})
+	}
+}
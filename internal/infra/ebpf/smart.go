@@ -0,0 +1,168 @@
+package ebpf
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"github.com/turtacn/ioshelfer/internal/common/logger"
+	"go.uber.org/zap"
+	"os/exec"
+	"time"
+)
+
+// smartTimeout bounds a single smartctl invocation.
+const smartTimeout = 30 * time.Second
+
+// smartRunner returns the smartctl JSON report of a disk.
+type smartRunner func(ctx context.Context, disk string) ([]byte, error)
+
+// smartCache is the last SMART reading of a disk.
+type smartCache struct {
+	data SMARTData
+	at   time.Time
+}
+
+// runSmartctl returns a smartRunner executing the given smartctl binary.
+func runSmartctl(command string) smartRunner {
+	return func(ctx context.Context, disk string) ([]byte, error) {
+		out, err := exec.CommandContext(ctx, command, "--json", "-i", "-A", "/dev/"+disk).Output()
+		// The exit status is a bit mask that also flags failing attributes and logged
+		// errors; only bits 0 and 1 mean no report was produced.
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode()&0x3 == 0 {
+			err = nil
+		}
+		if err != nil {
+			return nil, errors.New("smartctl failed for "+disk, err)
+		}
+		return out, nil
+	}
+}
+
+// readSMART returns the disks' SMART attributes, running smartctl at most once per
+// SMARTInterval for each disk. A failed reading keeps the previous attributes until the
+// next interval. smartctl runs without m.mu held, so a slow disk does not stall the
+// collection of the other metrics.
+func (m *EBPFMonitor) readSMART(ids []string, now time.Time) map[string]SMARTData {
+	if m.config.SMARTInterval <= 0 {
+		return nil
+	}
+
+	result := make(map[string]SMARTData, len(ids))
+	var due []string
+	m.smartMu.Lock()
+	for _, id := range ids {
+		cached, ok := m.smart[id]
+		result[id] = cached.data
+		if ok && now.Sub(cached.at) < m.config.SMARTInterval {
+			continue
+		}
+		// Claim the reading so concurrent collections do not run smartctl again.
+		m.smart[id] = smartCache{data: cached.data, at: now}
+		due = append(due, id)
+	}
+	m.smartMu.Unlock()
+
+	for _, id := range due {
+		ctx, cancel := context.WithTimeout(context.Background(), smartTimeout)
+		out, err := m.smartctl(ctx, id)
+		cancel()
+		var data SMARTData
+		if err == nil {
+			data, err = parseSMART(out)
+		}
+		if err != nil {
+			logger.Warn("failed to read SMART attributes", zap.String("disk_id", id), zap.Error(err))
+			continue
+		}
+		result[id] = data
+		m.smartMu.Lock()
+		m.smart[id] = smartCache{data: data, at: now}
+		m.smartMu.Unlock()
+	}
+	return result
+}
+
+// smartReport is the subset of smartctl's JSON report holding the attributes collected,
+// for ATA, NVMe and SCSI disks.
+type smartReport struct {
+	Temperature struct {
+		Current *int `json:"current"`
+	} `json:"temperature"`
+	ATA *struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMe *struct {
+		MediaErrors int64 `json:"media_errors"`
+		Temperature int   `json:"temperature"`
+	} `json:"nvme_smart_health_information_log"`
+	SCSIGrownDefects *int64 `json:"scsi_grown_defect_list"`
+	SCSIErrors       *struct {
+		Read struct {
+			Uncorrected int64 `json:"total_uncorrected_errors"`
+		} `json:"read"`
+	} `json:"scsi_error_counter_log"`
+}
+
+// ATA SMART attribute IDs.
+const (
+	ataRawReadErrorRate   = 1
+	ataReallocatedSectors = 5
+	ataTemperatureCelsius = 194
+)
+
+// parseSMART extracts the SMART attributes from a smartctl JSON report. NVMe and SCSI disks
+// have no reallocated sector count; media errors and uncorrected read errors stand in for
+// the ATA raw read error rate, and SCSI grown defects for reallocated sectors.
+func parseSMART(data []byte) (SMARTData, error) {
+	var report smartReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return SMARTData{}, errors.New("invalid smartctl report", err)
+	}
+
+	var smart SMARTData
+	found := report.Temperature.Current != nil
+	if report.Temperature.Current != nil {
+		smart.Temperature = *report.Temperature.Current
+	}
+	if report.ATA != nil {
+		found = true
+		for _, attr := range report.ATA.Table {
+			switch attr.ID {
+			case ataRawReadErrorRate:
+				// In millions, the scale of pkg/disk and so of imported SMART history.
+				smart.ReadErrorRate = float64(attr.Raw.Value) / 1000000.0
+			case ataReallocatedSectors:
+				smart.ReallocatedSectors = int(attr.Raw.Value)
+			case ataTemperatureCelsius:
+				if report.Temperature.Current == nil {
+					// The low byte is the current temperature; higher bytes hold min and max.
+					smart.Temperature = int(attr.Raw.Value & 0xff)
+				}
+			}
+		}
+	}
+	if report.NVMe != nil {
+		found = true
+		smart.ReadErrorRate = float64(report.NVMe.MediaErrors)
+		if report.Temperature.Current == nil {
+			smart.Temperature = report.NVMe.Temperature
+		}
+	}
+	if report.SCSIGrownDefects != nil {
+		found = true
+		smart.ReallocatedSectors = int(*report.SCSIGrownDefects)
+	}
+	if report.SCSIErrors != nil {
+		found = true
+		smart.ReadErrorRate = float64(report.SCSIErrors.Read.Uncorrected)
+	}
+	if !found {
+		return SMARTData{}, errors.New("smartctl report has no SMART attributes", nil)
+	}
+	return smart, nil
+}
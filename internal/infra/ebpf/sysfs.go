@@ -0,0 +1,354 @@
+package ebpf
+
+import (
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// raidDrivers lists the SCSI host drivers treated as hardware RAID controllers.
+var raidDrivers = map[string]bool{
+	"megaraid_sas": true,
+	"mpt3sas":      true,
+	"mpt2sas":      true,
+	"smartpqi":     true,
+	"hpsa":         true,
+	"aacraid":      true,
+}
+
+// ignoredDiskPrefixes lists block device name prefixes that are not physical disks.
+var ignoredDiskPrefixes = []string{"loop", "ram", "zram", "sr", "fd"}
+
+// firmwareAttributes lists the SCSI host attributes RAID drivers report firmware versions in.
+var firmwareAttributes = []string{"version_fw", "fw_version", "firmware_version", "firmware_revision"}
+
+// raidCounters holds the cumulative I/O error count of a controller's SCSI devices.
+type raidCounters struct {
+	ioErrors uint64
+	at       time.Time
+}
+
+// diskCounters holds the cumulative counters from /sys/block/<dev>/stat.
+type diskCounters struct {
+	reads, writes   uint64
+	readMs, writeMs uint64
+	inFlight        uint64
+	at              time.Time
+}
+
+func (c diskCounters) ios() uint64   { return c.reads + c.writes }
+func (c diskCounters) ticks() uint64 { return c.readMs + c.writeMs }
+
+// reset reports whether the counters went backwards since prev, e.g., after the device
+// was re-attached, so no delta can be taken.
+func (c diskCounters) reset(prev diskCounters) bool {
+	return c.ios() < prev.ios() || c.ticks() < prev.ticks()
+}
+
+// netCounters holds the cumulative counters from /sys/class/net/<if>/statistics.
+type netCounters struct {
+	rxPackets, txPackets uint64
+	rxDropped, txDropped uint64
+	rxErrors, txErrors   uint64
+	rxBytes, txBytes     uint64
+	carrierChanges       uint64
+	at                   time.Time
+}
+
+func (c netCounters) packets() uint64 { return c.rxPackets + c.txPackets }
+func (c netCounters) lost() uint64 {
+	return c.rxDropped + c.txDropped + c.rxErrors + c.txErrors
+}
+func (c netCounters) bytes() uint64  { return c.rxBytes + c.txBytes }
+func (c netCounters) errors() uint64 { return c.rxErrors + c.txErrors }
+
+// reset reports whether the counters went backwards since prev, e.g., after a driver
+// reload, so no delta can be taken.
+func (c netCounters) reset(prev netCounters) bool {
+	return c.packets() < prev.packets() || c.lost() < prev.lost() || c.bytes() < prev.bytes()
+}
+
+// sysfsReader enumerates devices and reads kernel counters from a sysfs tree.
+type sysfsReader struct {
+	root string
+}
+
+// listDisks returns the block devices under /sys/block, skipping virtual devices.
+func (r *sysfsReader) listDisks() ([]string, error) {
+	entries, err := r.readDir("block")
+	if err != nil {
+		return nil, err
+	}
+	var disks []string
+	for _, name := range entries {
+		ignored := false
+		for _, prefix := range ignoredDiskPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				ignored = true
+				break
+			}
+		}
+		if !ignored {
+			disks = append(disks, name)
+		}
+	}
+	return disks, nil
+}
+
+// listInterfaces returns the network interfaces under /sys/class/net, skipping loopback.
+func (r *sysfsReader) listInterfaces() ([]string, error) {
+	entries, err := r.readDir(filepath.Join("class", "net"))
+	if err != nil {
+		return nil, err
+	}
+	var interfaces []string
+	for _, name := range entries {
+		if name != "lo" {
+			interfaces = append(interfaces, name)
+		}
+	}
+	return interfaces, nil
+}
+
+// listRAIDControllers returns the SCSI hosts driven by a known RAID driver.
+func (r *sysfsReader) listRAIDControllers() ([]string, error) {
+	entries, err := r.readDir(filepath.Join("class", "scsi_host"))
+	if err != nil {
+		return nil, err
+	}
+	var controllers []string
+	for _, name := range entries {
+		driver, err := r.readString(filepath.Join("class", "scsi_host", name, "proc_name"))
+		if err != nil {
+			continue
+		}
+		if raidDrivers[driver] {
+			controllers = append(controllers, name)
+		}
+	}
+	return controllers, nil
+}
+
+// readHostBusy returns the number of commands outstanding on a SCSI host.
+func (r *sysfsReader) readHostBusy(host string) (int, error) {
+	value, err := r.readUint(filepath.Join("class", "scsi_host", host, "host_busy"))
+	if err != nil {
+		return 0, err
+	}
+	return int(value), nil
+}
+
+// readDiskCounters parses /sys/block/<dev>/stat.
+func (r *sysfsReader) readDiskCounters(disk string) (diskCounters, error) {
+	line, err := r.readString(filepath.Join("block", disk, "stat"))
+	if err != nil {
+		return diskCounters{}, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 9 {
+		return diskCounters{}, errors.New("malformed block device stat", nil)
+	}
+	values := make([]uint64, 9)
+	for i := range values {
+		v, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return diskCounters{}, errors.Wrap(err, "failed to parse block device stat")
+		}
+		values[i] = v
+	}
+	return diskCounters{
+		reads:    values[0],
+		readMs:   values[3],
+		writes:   values[4],
+		writeMs:  values[7],
+		inFlight: values[8],
+	}, nil
+}
+
+// readNetCounters reads the interface statistics counters.
+func (r *sysfsReader) readNetCounters(iface string) (netCounters, error) {
+	dir := filepath.Join("class", "net", iface, "statistics")
+	var c netCounters
+	fields := []struct {
+		name string
+		dst  *uint64
+	}{
+		{"rx_packets", &c.rxPackets},
+		{"tx_packets", &c.txPackets},
+		{"rx_dropped", &c.rxDropped},
+		{"tx_dropped", &c.txDropped},
+		{"rx_errors", &c.rxErrors},
+		{"tx_errors", &c.txErrors},
+		{"rx_bytes", &c.rxBytes},
+		{"tx_bytes", &c.txBytes},
+	}
+	for _, f := range fields {
+		v, err := r.readUint(filepath.Join(dir, f.name))
+		if err != nil {
+			return netCounters{}, err
+		}
+		*f.dst = v
+	}
+	// carrier_changes appeared in Linux 4.16; older kernels report no flaps.
+	c.carrierChanges, _ = r.readUint(filepath.Join("class", "net", iface, "carrier_changes"))
+	return c, nil
+}
+
+// readHostIOErrors sums the ioerr_cnt counters of the SCSI devices behind a host.
+func (r *sysfsReader) readHostIOErrors(host string) (uint64, error) {
+	entries, err := r.readDir(filepath.Join("class", "scsi_device"))
+	if err != nil {
+		return 0, err
+	}
+	prefix := strings.TrimPrefix(host, "host") + ":"
+	var total uint64
+	found := false
+	for _, hctl := range entries {
+		if !strings.HasPrefix(hctl, prefix) {
+			continue
+		}
+		s, err := r.readString(filepath.Join("class", "scsi_device", hctl, "device", "ioerr_cnt"))
+		if err != nil {
+			continue
+		}
+		v, err := strconv.ParseUint(s, 0, 64) // Reported in hex, e.g., "0x1f"
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to parse SCSI device error count")
+		}
+		total += v
+		found = true
+	}
+	if !found {
+		return 0, errors.NewNotFound("no SCSI device error counters for "+host, nil)
+	}
+	return total, nil
+}
+
+// readHostFirmware returns the firmware version a RAID driver reports for its host.
+func (r *sysfsReader) readHostFirmware(host string) (string, error) {
+	for _, attr := range firmwareAttributes {
+		if v, err := r.readString(filepath.Join("class", "scsi_host", host, attr)); err == nil && v != "" {
+			return v, nil
+		}
+	}
+	return "", errors.NewNotFound("no firmware version for "+host, nil)
+}
+
+// readDir lists the entry names of a sysfs directory; a missing directory yields no entries.
+func (r *sysfsReader) readDir(rel string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.root, rel))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read sysfs directory")
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func (r *sysfsReader) readString(rel string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(r.root, rel))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read sysfs attribute")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (r *sysfsReader) readUint(rel string) (uint64, error) {
+	s, err := r.readString(rel)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse sysfs attribute")
+	}
+	return v, nil
+}
+
+// listMDArrays maps each software RAID array under /sys/block to the disks backing its members.
+func (r *sysfsReader) listMDArrays() (map[string][]string, error) {
+	entries, err := r.readDir("block")
+	if err != nil {
+		return nil, err
+	}
+	arrays := make(map[string][]string)
+	for _, name := range entries {
+		if !strings.HasPrefix(name, "md") {
+			continue
+		}
+		slaves, err := r.readDir(filepath.Join("block", name, "slaves"))
+		if err != nil {
+			return nil, err
+		}
+		for _, slave := range slaves {
+			arrays[name] = append(arrays[name], r.parentDisk(slave))
+		}
+	}
+	return arrays, nil
+}
+
+// parentDisk maps a partition name (sda1, nvme0n1p2) to its disk; whole disks map to themselves.
+func (r *sysfsReader) parentDisk(name string) string {
+	if _, err := os.Stat(filepath.Join(r.root, "block", name)); err == nil {
+		return name
+	}
+	disk := strings.TrimRight(name, "0123456789")
+	if strings.HasSuffix(disk, "p") && len(disk) > 1 && disk[len(disk)-2] >= '0' && disk[len(disk)-2] <= '9' {
+		disk = disk[:len(disk)-1]
+	}
+	return disk
+}
+
+// readDiskModel returns the model string reported for a disk.
+func (r *sysfsReader) readDiskModel(disk string) (string, error) {
+	return r.readString(filepath.Join("block", disk, "device", "model"))
+}
+
+// readHostDriver returns the driver name of a SCSI host.
+func (r *sysfsReader) readHostDriver(host string) (string, error) {
+	return r.readString(filepath.Join("class", "scsi_host", host, "proc_name"))
+}
+
+// listBonds maps each bonding interface to its slave interfaces.
+func (r *sysfsReader) listBonds() (map[string][]string, error) {
+	entries, err := r.readDir(filepath.Join("class", "net"))
+	if err != nil {
+		return nil, err
+	}
+	bonds := make(map[string][]string)
+	for _, name := range entries {
+		slaves, err := r.readString(filepath.Join("class", "net", name, "bonding", "slaves"))
+		if err != nil {
+			continue // Not a bond
+		}
+		if fields := strings.Fields(slaves); len(fields) > 0 {
+			bonds[name] = fields
+		}
+	}
+	return bonds, nil
+}
+
+// readDiskHost returns the SCSI host a disk is attached to, from the hostN element of
+// its resolved /sys/block/<dev>/device path.
+func (r *sysfsReader) readDiskHost(disk string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(filepath.Join(r.root, "block", disk, "device"))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve block device path")
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(resolved), "/") {
+		if strings.HasPrefix(elem, "host") {
+			if _, err := strconv.Atoi(strings.TrimPrefix(elem, "host")); err == nil {
+				return elem, nil
+			}
+		}
+	}
+	return "", errors.New("block device "+disk+" is not attached to a SCSI host", nil)
+}
@@ -5,8 +5,11 @@ import (
 	"github.com/turtacn/ioshelfer/internal/common/logger"
 	"github.com/turtacn/ioshelfer/internal/common/types/enum"
 	"github.com/turtacn/ioshelfer/internal/core/detection"
+	"github.com/turtacn/ioshelfer/internal/core/prediction"
 	"github.com/turtacn/ioshelfer/internal/infra/ebpf"
 	"go.uber.org/zap"
+	"strconv"
+	"time"
 )
 
 // MetricsCollector defines the interface for collecting and exposing metrics.
@@ -59,30 +62,102 @@ func NewPrometheusCollector(detector detection.Detector) *PrometheusCollector {
 
 // Collect gathers metrics from the detection engine and updates Prometheus gauges.
 func (c *PrometheusCollector) Collect(deviceType enum.DeviceType, status detection.HealthStatus) {
+	if comparison, ok := status.Metrics.(detection.PeerComparison); ok {
+		status.Metrics = comparison.Metrics
+	}
 	switch deviceType {
 	case enum.RAID:
 		if metrics, ok := status.Metrics.(*ebpf.RAIDMetrics); ok {
-			c.raidQueueDepth.WithLabelValues("raid-0").Set(float64(metrics.QueueDepth))
+			c.raidQueueDepth.WithLabelValues(status.DeviceID).Set(float64(metrics.QueueDepth))
 			logger.Info("collected RAID queue depth",
 				zap.Int("queue_depth", metrics.QueueDepth),
-				zap.String("controller_id", "raid-0"),
+				zap.String("controller_id", status.DeviceID),
 			)
 		}
 	case enum.Disk:
 		if metrics, ok := status.Metrics.(*ebpf.DiskMetrics); ok {
-			c.diskIOPSVariance.WithLabelValues("disk-0").Set(metrics.IOPSVariance)
+			c.diskIOPSVariance.WithLabelValues(status.DeviceID).Set(metrics.IOPSVariance)
 			logger.Info("collected disk IOPS variance",
 				zap.Float64("iops_variance", metrics.IOPSVariance),
-				zap.String("disk_id", "disk-0"),
+				zap.String("disk_id", status.DeviceID),
 			)
 		}
 	case enum.Network:
 		if metrics, ok := status.Metrics.(*ebpf.NetworkMetrics); ok {
-			c.networkLatencyP95.WithLabelValues("eth0").Set(float64(metrics.LatencyP95.Seconds()))
+			c.networkLatencyP95.WithLabelValues(status.DeviceID).Set(float64(metrics.LatencyP95.Seconds()))
 			logger.Info("collected network latency",
 				zap.Float64("latency_p95_seconds", metrics.LatencyP95.Seconds()),
-				zap.String("interface", "eth0"),
+				zap.String("interface", status.DeviceID),
 			)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// PredictionQualityCollector exports the quality of every model version's resolved
+// predictions, computed from a quality tracker at scrape time.
+type PredictionQualityCollector struct {
+	tracker     *prediction.QualityTracker
+	precision   *prometheus.Desc
+	recall      *prometheus.Desc
+	auc         *prometheus.Desc
+	brier       *prometheus.Desc
+	resolved    *prometheus.Desc
+	pending     *prometheus.Desc
+	calibration *prometheus.Desc
+}
+
+// NewPredictionQualityCollector creates a new PredictionQualityCollector and registers it
+// with Prometheus.
+func NewPredictionQualityCollector(tracker *prediction.QualityTracker) *PredictionQualityCollector {
+	labels := []string{"device_type", "model_version"}
+	collector := &PredictionQualityCollector{
+		tracker: tracker,
+		precision: prometheus.NewDesc("ioshelfer_prediction_precision",
+			"Share of resolved predictions above the threshold whose device failed", labels, nil),
+		recall: prometheus.NewDesc("ioshelfer_prediction_recall",
+			"Share of resolved failures predicted above the threshold", labels, nil),
+		auc: prometheus.NewDesc("ioshelfer_prediction_auc",
+			"Area under the ROC curve of resolved predictions", labels, nil),
+		brier: prometheus.NewDesc("ioshelfer_prediction_brier_score",
+			"Mean squared error of resolved prediction probabilities", labels, nil),
+		resolved: prometheus.NewDesc("ioshelfer_prediction_resolved",
+			"Predictions whose outcome is known", labels, nil),
+		pending: prometheus.NewDesc("ioshelfer_prediction_pending",
+			"Predictions whose outcome is not known yet", labels, nil),
+		calibration: prometheus.NewDesc("ioshelfer_prediction_calibration_observed_rate",
+			"Observed failure rate of resolved predictions per probability bin", append(labels, "bin_upper"), nil),
+	}
+	prometheus.MustRegister(collector)
+	return collector
+}
+
+// Describe implements prometheus.Collector.
+func (c *PredictionQualityCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.precision
+	ch <- c.recall
+	ch <- c.auc
+	ch <- c.brier
+	ch <- c.resolved
+	ch <- c.pending
+	ch <- c.calibration
+}
+
+// Collect implements prometheus.Collector.
+func (c *PredictionQualityCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, r := range c.tracker.Reports(time.Now()) {
+		deviceType := r.DeviceType.String()
+		ch <- prometheus.MustNewConstMetric(c.resolved, prometheus.GaugeValue, float64(r.Samples), deviceType, r.ModelVersion)
+		ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(r.Pending), deviceType, r.ModelVersion)
+		if r.Samples == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.precision, prometheus.GaugeValue, r.Precision, deviceType, r.ModelVersion)
+		ch <- prometheus.MustNewConstMetric(c.recall, prometheus.GaugeValue, r.Recall, deviceType, r.ModelVersion)
+		ch <- prometheus.MustNewConstMetric(c.auc, prometheus.GaugeValue, r.AUC, deviceType, r.ModelVersion)
+		ch <- prometheus.MustNewConstMetric(c.brier, prometheus.GaugeValue, r.Brier, deviceType, r.ModelVersion)
+		for _, b := range r.Calibration {
+			ch <- prometheus.MustNewConstMetric(c.calibration, prometheus.GaugeValue, b.ObservedRate,
+				deviceType, r.ModelVersion, strconv.FormatFloat(b.Upper, 'g', -1, 64))
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// validName guards the file names of record collections and logs.
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// RecordStore persists keyed JSON records, grouped in collections, and append-only logs.
+type RecordStore interface {
+	PutRecord(collection, key string, value interface{}) error
+	DeleteRecord(collection, key string) error
+	Records(collection string) (map[string]json.RawMessage, error)
+	AppendLog(name string, entry interface{}) error
+	ReadLog(name string) ([]json.RawMessage, error)
+}
+
+// PutRecord stores a record under key, replacing any record with the same key. The
+// collection is rewritten atomically.
+func (s *FileStorage) PutRecord(collection, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.NewStorageFailure("failed to marshal record "+key, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records, err := s.readRecords(collection)
+	if err != nil {
+		return err
+	}
+	records[key] = data
+	return s.writeRecords(collection, records)
+}
+
+// DeleteRecord removes a record; deleting a missing record is not an error.
+func (s *FileStorage) DeleteRecord(collection, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records, err := s.readRecords(collection)
+	if err != nil {
+		return err
+	}
+	if _, ok := records[key]; !ok {
+		return nil
+	}
+	delete(records, key)
+	return s.writeRecords(collection, records)
+}
+
+// Records returns every record of a collection by key.
+func (s *FileStorage) Records(collection string) (map[string]json.RawMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readRecords(collection)
+}
+
+// AppendLog appends an entry to a log as a JSON line.
+func (s *FileStorage) AppendLog(name string, entry interface{}) error {
+	path, err := s.path("logs", name, ".jsonl")
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.NewStorageFailure("failed to marshal log entry", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.NewStorageFailure("failed to create log directory", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.NewStorageFailure("failed to open log "+name, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return errors.NewStorageFailure("failed to append to log "+name, err)
+	}
+	return f.Sync()
+}
+
+// ReadLog returns a log's entries, oldest first. Lines that are not valid JSON, e.g., one
+// truncated by a crash mid-append, are skipped.
+func (s *FileStorage) ReadLog(name string) ([]json.RawMessage, error) {
+	path, err := s.path("logs", name, ".jsonl")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.NewStorageFailure("failed to open log "+name, err)
+	}
+	defer f.Close()
+
+	var entries []json.RawMessage
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || !json.Valid(line) {
+			continue
+		}
+		entries = append(entries, append(json.RawMessage(nil), line...))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.NewStorageFailure("failed to read log "+name, err)
+	}
+	return entries, nil
+}
+
+// readRecords reads a collection; the caller holds the lock.
+func (s *FileStorage) readRecords(collection string) (map[string]json.RawMessage, error) {
+	path, err := s.path("records", collection, ".json")
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, errors.NewStorageFailure("failed to read records of "+collection, err)
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, errors.NewStorageFailure("failed to unmarshal records of "+collection, err)
+	}
+	return records, nil
+}
+
+// writeRecords replaces a collection through a temporary file; the caller holds the write
+// lock.
+func (s *FileStorage) writeRecords(collection string, records map[string]json.RawMessage) error {
+	path, err := s.path("records", collection, ".json")
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return errors.NewStorageFailure("failed to marshal records of "+collection, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.NewStorageFailure("failed to create records directory", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.NewStorageFailure("failed to write records of "+collection, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.NewStorageFailure("failed to replace records of "+collection, err)
+	}
+	return nil
+}
+
+// path returns the file of a collection or log.
+func (s *FileStorage) path(dir, name, ext string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", errors.New("invalid storage name "+name, nil)
+	}
+	return filepath.Join(s.baseDir, dir, name+ext), nil
+}
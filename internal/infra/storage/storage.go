@@ -1,13 +1,17 @@
 package storage
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"github.com/turtacn/ioshelfer/internal/common/errors"
 	"github.com/turtacn/ioshelfer/internal/common/logger"
 	"github.com/turtacn/ioshelfer/internal/common/types/enum"
 	"go.uber.org/zap"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -24,6 +28,7 @@ type Metric struct {
 type Storage interface {
 	Store(metric Metric) error
 	Query(deviceType enum.DeviceType, deviceID string, window time.Duration) ([]Metric, error)
+	QueryRange(deviceType enum.DeviceType, deviceID string, from, to time.Time) ([]Metric, error)
 }
 
 // FileStorage implements Storage using a file-based backend.
@@ -47,76 +52,245 @@ func (s *FileStorage) Store(metric Metric) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	filePath := s.getFilePath(metric.DeviceType, metric.DeviceID)
-	var metrics []Metric
+	if err := s.appendMetrics(metric.DeviceType, metric.DeviceID, []Metric{metric}); err != nil {
+		return err
+	}
 
-	// Read existing metrics if file exists
-	if data, err := os.ReadFile(filePath); err == nil {
-		if err := json.Unmarshal(data, &metrics); err != nil {
-			return errors.NewStorageFailure("failed to unmarshal existing metrics", err)
+	logger.Debug("stored metric",
+		zap.String("device_type", metric.DeviceType.String()),
+		zap.String("device_id", metric.DeviceID),
+		zap.Time("timestamp", metric.Timestamp),
+	)
+	return nil
+}
+
+// StoreBatch saves metrics, appending to each device's file once rather than once per metric.
+func (s *FileStorage) StoreBatch(metrics []Metric) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type device struct {
+		deviceType enum.DeviceType
+		deviceID   string
+	}
+	var order []device
+	byDevice := make(map[device][]Metric)
+	for _, m := range metrics {
+		d := device{m.DeviceType, m.DeviceID}
+		if _, ok := byDevice[d]; !ok {
+			order = append(order, d)
+		}
+		byDevice[d] = append(byDevice[d], m)
+	}
+	for _, d := range order {
+		if err := s.appendMetrics(d.deviceType, d.deviceID, byDevice[d]); err != nil {
+			return err
 		}
-	} else if !os.IsNotExist(err) {
-		return errors.NewStorageFailure("failed to read storage file", err)
 	}
 
-	// Append new metric
-	metrics = append(metrics, metric)
+	logger.Info("stored metric batch", zap.Int("metrics", len(metrics)), zap.Int("devices", len(order)))
+	return nil
+}
+
+// appendMetrics appends metrics to a device's file as JSON lines; the caller holds the write
+// lock.
+func (s *FileStorage) appendMetrics(deviceType enum.DeviceType, deviceID string, added []Metric) error {
+	var buf bytes.Buffer
+	for _, m := range added {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return errors.NewStorageFailure("failed to marshal metric", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
 
-	// Write back to file
-	data, err := json.MarshalIndent(metrics, "", "  ")
+	filePath := s.getFilePath(deviceType, deviceID) + ".jsonl"
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return errors.NewStorageFailure("failed to create device type directory", err)
+	}
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		return errors.NewStorageFailure("failed to marshal metrics", err)
+		return errors.NewStorageFailure("failed to open storage file", err)
 	}
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return errors.NewStorageFailure("failed to write metrics to file", err)
+	}
+	if err := f.Close(); err != nil {
 		return errors.NewStorageFailure("failed to write metrics to file", err)
 	}
-
-	logger.Info("stored metric",
-		zap.String("device_type", metric.DeviceType.String()),
-		zap.String("device_id", metric.DeviceID),
-		zap.Time("timestamp", metric.Timestamp),
-	)
 	return nil
 }
 
-// Query retrieves metrics for a device within a time window.
-func (s *FileStorage) Query(deviceType enum.DeviceType, deviceID string, window time.Duration) ([]Metric, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	filePath := s.getFilePath(deviceType, deviceID)
+// readMetrics reads the metrics stored at a device's file path, in the order they were
+// stored; the caller holds the lock. Metrics an earlier version stored as a JSON array are
+// read first, and lines that are not valid JSON, e.g., one truncated by a crash
+// mid-append, are skipped.
+func (s *FileStorage) readMetrics(filePath string) ([]Metric, error) {
 	var metrics []Metric
-
-	// Read metrics from file
-	if data, err := os.ReadFile(filePath); err == nil {
+	if data, err := os.ReadFile(filePath + ".json"); err == nil {
 		if err := json.Unmarshal(data, &metrics); err != nil {
 			return nil, errors.NewStorageFailure("failed to unmarshal metrics", err)
 		}
-	} else if os.IsNotExist(err) {
-		return []Metric{}, nil // No data is not an error
-	} else {
+	} else if !os.IsNotExist(err) {
+		return nil, errors.NewStorageFailure("failed to read storage file", err)
+	}
+
+	f, err := os.Open(filePath + ".jsonl")
+	if os.IsNotExist(err) {
+		return metrics, nil
+	}
+	if err != nil {
+		return nil, errors.NewStorageFailure("failed to read storage file", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var m Metric
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || json.Unmarshal(line, &m) != nil {
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	if err := scanner.Err(); err != nil {
 		return nil, errors.NewStorageFailure("failed to read storage file", err)
 	}
+	return metrics, nil
+}
+
+// Query retrieves metrics for a device within a time window.
+func (s *FileStorage) Query(deviceType enum.DeviceType, deviceID string, window time.Duration) ([]Metric, error) {
+	return s.QueryRange(deviceType, deviceID, time.Now().Add(-window), time.Time{})
+}
+
+// QueryRange retrieves metrics for a device timestamped between from and to, inclusive; a
+// zero to has no upper bound. Unlike Query it reaches history of any age, e.g., imported
+// datasets.
+func (s *FileStorage) QueryRange(deviceType enum.DeviceType, deviceID string, from, to time.Time) ([]Metric, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	// Filter metrics within the time window
-	cutoff := time.Now().Add(-window)
-	var result []Metric
+	metrics, err := s.readDeviceMetrics(deviceType, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Metric{}
 	for _, m := range metrics {
-		if m.Timestamp.After(cutoff) {
+		if !m.Timestamp.Before(from) && (to.IsZero() || !m.Timestamp.After(to)) {
 			result = append(result, m)
 		}
 	}
 
-	logger.Info("queried metrics",
+	logger.Debug("queried metrics",
 		zap.String("device_type", deviceType.String()),
 		zap.String("device_id", deviceID),
 		zap.Int("count", len(result)),
-		zap.Duration("window", window),
+		zap.Time("from", from),
+		zap.Time("to", to),
 	)
 	return result, nil
 }
 
-// getFilePath generates the file path for a device's metrics.
+// ListDevices returns the IDs of the devices of a type that have stored metrics.
+func (s *FileStorage) ListDevices(deviceType enum.DeviceType) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries, err := os.ReadDir(filepath.Join(s.baseDir, deviceType.String()))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, errors.NewStorageFailure("failed to list storage directory", err)
+	}
+
+	// Device IDs are unescaped from the file names. Names flattened by an earlier version
+	// may be shared by several devices, so their IDs are read back from the stored metrics.
+	var ids []string
+	seen := make(map[string]bool, len(entries))
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	read := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".json" && ext != ".jsonl") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if read[name] {
+			continue
+		}
+		read[name] = true
+		if id, err := url.PathUnescape(name); err == nil && !strings.Contains(name, "_") {
+			add(id)
+			continue
+		}
+		metrics, err := s.readMetrics(filepath.Join(s.baseDir, deviceType.String(), name))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range metrics {
+			add(m.DeviceID)
+		}
+	}
+	return ids, nil
+}
+
+// readDeviceMetrics reads a device's metrics, including those an earlier version stored
+// under the flattened file name; the caller holds the lock.
+func (s *FileStorage) readDeviceMetrics(deviceType enum.DeviceType, deviceID string) ([]Metric, error) {
+	var metrics []Metric
+	if legacy := s.legacyFilePath(deviceType, deviceID); legacy != "" {
+		stored, err := s.readMetrics(legacy)
+		if err != nil {
+			return nil, err
+		}
+		// Flattened names are shared by IDs differing only in separators and underscores.
+		for _, m := range stored {
+			if m.DeviceID == deviceID {
+				metrics = append(metrics, m)
+			}
+		}
+	}
+	stored, err := s.readMetrics(s.getFilePath(deviceType, deviceID))
+	if err != nil {
+		return nil, err
+	}
+	return append(metrics, stored...), nil
+}
+
+// getFilePath generates the file path for a device's metrics, without its extension.
+// Device IDs such as "/dev/sda" are escaped so each device maps to its own file.
 func (s *FileStorage) getFilePath(deviceType enum.DeviceType, deviceID string) string {
-	return filepath.Join(s.baseDir, deviceType.String(), deviceID+".json")
+	return filepath.Join(s.baseDir, deviceType.String(), escapeDeviceID(deviceID))
+}
+
+// legacyFilePath returns the file path, without its extension, at which an earlier version
+// stored a device's metrics with separators flattened to "_", or "" if it is getFilePath.
+func (s *FileStorage) legacyFilePath(deviceType enum.DeviceType, deviceID string) string {
+	name := strings.Trim(strings.ReplaceAll(deviceID, string(filepath.Separator), "_"), "_")
+	if name == "" || name == escapeDeviceID(deviceID) {
+		return ""
+	}
+	return filepath.Join(s.baseDir, deviceType.String(), name)
+}
+
+// escapeDeviceID escapes a device ID into a file name that url.PathUnescape reverses.
+// Underscores are escaped too, so names holding one were flattened by an earlier version,
+// and a leading dot is escaped so IDs such as ".." stay inside the directory.
+func escapeDeviceID(deviceID string) string {
+	name := strings.ReplaceAll(url.PathEscape(deviceID), "_", "%5F")
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
 }
@@ -1 +1,162 @@
 package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+)
+
+func TestFileStorageListDevices(t *testing.T) {
+	s, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	ids, err := s.ListDevices(enum.Disk)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	for _, id := range []string{"/dev/sda", "sdb"} {
+		require.NoError(t, s.Store(Metric{Timestamp: time.Now(), DeviceType: enum.Disk, DeviceID: id, Value: 1}))
+	}
+	require.NoError(t, s.Store(Metric{Timestamp: time.Now(), DeviceType: enum.Network, DeviceID: "eth0", Value: 1}))
+
+	ids, err = s.ListDevices(enum.Disk)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/dev/sda", "sdb"}, ids)
+}
+
+func TestFileStorageEscapesDeviceIDs(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStorage(dir)
+	require.NoError(t, err)
+
+	// IDs that flatten to the same name keep separate files.
+	for i, id := range []string{"host0/e252/s3", "host0_e252_s3", ".."} {
+		require.NoError(t, s.Store(Metric{Timestamp: time.Now(), DeviceType: enum.RAID, DeviceID: id, Value: i}))
+	}
+	for _, id := range []string{"host0/e252/s3", "host0_e252_s3", ".."} {
+		metrics, err := s.Query(enum.RAID, id, time.Hour)
+		require.NoError(t, err)
+		require.Len(t, metrics, 1)
+		assert.Equal(t, id, metrics[0].DeviceID)
+	}
+	_, err = os.Stat(filepath.Join(dir, "raid", "%2E..jsonl"))
+	assert.NoError(t, err)
+
+	// Metrics stored under the flattened name by an earlier version are still read.
+	legacy := []byte(`{"timestamp":"` + time.Now().Format(time.RFC3339Nano) + `","device_id":"/dev/sda","value":1}` + "\n" +
+		`{"timestamp":"` + time.Now().Format(time.RFC3339Nano) + `","device_id":"dev_sda","value":2}` + "\n")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "disk"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "disk", "dev_sda.jsonl"), legacy, 0644))
+	require.NoError(t, s.Store(Metric{Timestamp: time.Now(), DeviceType: enum.Disk, DeviceID: "/dev/sda", Value: 3}))
+	metrics, err := s.Query(enum.Disk, "/dev/sda", time.Hour)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, 1.0, metrics[0].Value)
+	assert.Equal(t, 3.0, metrics[1].Value)
+
+	ids, err := s.ListDevices(enum.Disk)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/dev/sda", "dev_sda"}, ids)
+	ids, err = s.ListDevices(enum.RAID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"host0/e252/s3", "host0_e252_s3", ".."}, ids)
+}
+
+func TestFileStorageRecords(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStorage(dir)
+	require.NoError(t, err)
+
+	records, err := s.Records("actions")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	require.NoError(t, s.PutRecord("actions", "a1", map[string]string{"status": "pending"}))
+	require.NoError(t, s.PutRecord("actions", "a2", map[string]string{"status": "isolated"}))
+	require.NoError(t, s.PutRecord("actions", "a1", map[string]string{"status": "recovered"}))
+	require.NoError(t, s.DeleteRecord("actions", "a2"))
+	require.NoError(t, s.DeleteRecord("actions", "missing"))
+
+	// Records survive a restart.
+	s, err = NewFileStorage(dir)
+	require.NoError(t, err)
+	records, err = s.Records("actions")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.JSONEq(t, `{"status":"recovered"}`, string(records["a1"]))
+
+	assert.Error(t, s.PutRecord("../actions", "a1", nil))
+}
+
+func TestFileStorageLog(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStorage(dir)
+	require.NoError(t, err)
+
+	entries, err := s.ReadLog("audit")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	require.NoError(t, s.AppendLog("audit", map[string]int{"n": 1}))
+	require.NoError(t, s.AppendLog("audit", map[string]int{"n": 2}))
+
+	// A line truncated by a crash is skipped.
+	f, err := os.OpenFile(filepath.Join(dir, "logs", "audit.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"n": 3`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	entries, err = s.ReadLog("audit")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.JSONEq(t, `{"n":2}`, string(entries[1]))
+}
+
+func TestFileStorageQueryRange(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStorage(dir)
+	require.NoError(t, err)
+
+	// Metrics stored as a JSON array by earlier versions are still read.
+	old := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "disk"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "disk", "sda.json"),
+		[]byte(`[{"timestamp":"2019-01-01T00:00:00Z","device_type":1,"device_id":"sda","value":1}]`), 0644))
+
+	var batch []Metric
+	for day := 1; day <= 3; day++ {
+		batch = append(batch, Metric{Timestamp: old.AddDate(0, 0, day), DeviceType: enum.Disk, DeviceID: "sda", Value: day + 1})
+	}
+	require.NoError(t, s.StoreBatch(batch))
+	require.NoError(t, s.Store(Metric{Timestamp: time.Now(), DeviceType: enum.Disk, DeviceID: "sda", Value: 5}))
+
+	// A line truncated by a crash is skipped.
+	f, err := os.OpenFile(filepath.Join(dir, "disk", "sda.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"timestamp":"2019`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	recent, err := s.Query(enum.Disk, "sda", time.Hour)
+	require.NoError(t, err)
+	require.Len(t, recent, 1, "imported history is older than the window")
+
+	all, err := s.QueryRange(enum.Disk, "sda", old, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+	assert.Equal(t, old, all[0].Timestamp.UTC())
+
+	imported, err := s.QueryRange(enum.Disk, "sda", old.AddDate(0, 0, 1), old.AddDate(0, 0, 2))
+	require.NoError(t, err)
+	require.Len(t, imported, 2)
+	assert.EqualValues(t, 2, imported[0].Value)
+	assert.EqualValues(t, 3, imported[1].Value)
+
+	ids, err := s.ListDevices(enum.Disk)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sda"}, ids)
+}
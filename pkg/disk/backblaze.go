@@ -0,0 +1,167 @@
+// pkg/disk/backblaze.go
+package disk
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/turtacn/ioshelfer/internal/common/errors"
+)
+
+// smartAttributeNames names the SMART attributes commonly reported in Backblaze drive stats.
+var smartAttributeNames = map[int]string{
+	1:   "Raw_Read_Error_Rate",
+	5:   "Reallocated_Sector_Ct",
+	9:   "Power_On_Hours",
+	187: "Reported_Uncorrect",
+	188: "Command_Timeout",
+	194: "Temperature_Celsius",
+	197: "Current_Pending_Sector",
+	198: "Offline_Uncorrectable",
+	199: "UDMA_CRC_Error_Count",
+}
+
+// BackblazeRecord is one drive-day of a Backblaze drive stats file.
+type BackblazeRecord struct {
+	SMART    *SMARTData
+	Capacity int64 // Capacity in bytes
+	Failed   bool  // Whether the drive failed on this day
+}
+
+// BackblazeReader streams records from a Backblaze drive stats CSV, holding one row in
+// memory at a time so multi-gigabyte files can be imported.
+type BackblazeReader struct {
+	csv        *csv.Reader
+	date       int
+	serial     int
+	model      int
+	capacity   int
+	failure    int
+	normalized map[int]int // SMART ID to column
+	raw        map[int]int
+	line       int
+}
+
+// NewBackblazeReader creates a new BackblazeReader, reading the header row from r.
+func NewBackblazeReader(r io.Reader) (*BackblazeReader, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read Backblaze header")
+	}
+
+	b := &BackblazeReader{
+		csv:        reader,
+		date:       -1,
+		serial:     -1,
+		model:      -1,
+		capacity:   -1,
+		failure:    -1,
+		normalized: make(map[int]int),
+		raw:        make(map[int]int),
+		line:       1,
+	}
+	for i, name := range header {
+		switch name = strings.TrimSpace(name); name {
+		case "date":
+			b.date = i
+		case "serial_number":
+			b.serial = i
+		case "model":
+			b.model = i
+		case "capacity_bytes":
+			b.capacity = i
+		case "failure":
+			b.failure = i
+		default:
+			if !strings.HasPrefix(name, "smart_") {
+				continue
+			}
+			parts := strings.Split(strings.TrimPrefix(name, "smart_"), "_")
+			if len(parts) != 2 {
+				continue
+			}
+			id, err := strconv.Atoi(parts[0])
+			if err != nil {
+				continue
+			}
+			switch parts[1] {
+			case "normalized":
+				b.normalized[id] = i
+			case "raw":
+				b.raw[id] = i
+			}
+		}
+	}
+	if b.date < 0 || b.serial < 0 || b.failure < 0 {
+		return nil, errors.New("Backblaze header lacks date, serial_number or failure column", nil)
+	}
+	return b, nil
+}
+
+// Next returns the next record, or io.EOF after the last one.
+func (b *BackblazeReader) Next() (*BackblazeRecord, error) {
+	row, err := b.csv.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	b.line++
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read Backblaze row")
+	}
+
+	date, err := time.Parse("2006-01-02", row[b.date])
+	if err != nil {
+		return nil, errors.New("Backblaze line "+strconv.Itoa(b.line)+": invalid date", err)
+	}
+	smart := &SMARTData{
+		DeviceID:     row[b.serial],
+		SerialNumber: row[b.serial],
+		Attributes:   make(map[int]SMARTAttribute),
+		Timestamp:    date,
+	}
+	if b.model >= 0 {
+		smart.Model = strings.TrimSpace(row[b.model])
+	}
+	record := &BackblazeRecord{SMART: smart, Failed: row[b.failure] == "1"}
+	if b.capacity >= 0 {
+		record.Capacity, _ = strconv.ParseInt(row[b.capacity], 10, 64)
+	}
+
+	for id, col := range b.raw {
+		if row[col] == "" {
+			continue
+		}
+		raw, err := strconv.ParseFloat(row[col], 64)
+		if err != nil {
+			return nil, errors.New("Backblaze line "+strconv.Itoa(b.line)+": invalid SMART value", err)
+		}
+		attr := SMARTAttribute{ID: id, Name: smartAttributeNames[id], RawValue: int64(raw), Status: "OK"}
+		if attr.Name == "" {
+			attr.Name = "smart_" + strconv.Itoa(id)
+		}
+		if col, ok := b.normalized[id]; ok {
+			attr.Value, _ = strconv.Atoi(row[col])
+		}
+		smart.Attributes[id] = attr
+	}
+
+	if attr, ok := smart.Attributes[5]; ok {
+		smart.ReallocatedSectors = int(attr.RawValue)
+	}
+	if attr, ok := smart.Attributes[1]; ok {
+		// Same scale as ParseSMART
+		smart.ReadErrorRate = float64(attr.RawValue) / 1000000.0
+	}
+	if attr, ok := smart.Attributes[194]; ok {
+		smart.Temperature = int(attr.RawValue)
+	}
+	if attr, ok := smart.Attributes[9]; ok {
+		smart.PowerOnHours = attr.RawValue
+	}
+	smart.OverallStatus = new(SMARTMonitor).assessOverallHealth(smart)
+	return record, nil
+}
@@ -0,0 +1,50 @@
+package disk
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turtacn/ioshelfer/internal/common/types/enum"
+)
+
+const backblazeCSV = `date,serial_number,model,capacity_bytes,failure,smart_1_normalized,smart_1_raw,smart_5_normalized,smart_5_raw,smart_9_raw,smart_194_raw,smart_197_raw
+2024-01-01,ZA1,ST4000DM000,4000787030016,0,117,150000000,100,0,25000,30,0
+2024-01-01,ZA2,ST4000DM000,4000787030016,1,117,,90,200,26000,45,8
+`
+
+func TestBackblazeReader(t *testing.T) {
+	r, err := NewBackblazeReader(strings.NewReader(backblazeCSV))
+	require.NoError(t, err)
+
+	first, err := r.Next()
+	require.NoError(t, err)
+	assert.False(t, first.Failed)
+	assert.Equal(t, int64(4000787030016), first.Capacity)
+	smart := first.SMART
+	assert.Equal(t, "ZA1", smart.DeviceID)
+	assert.Equal(t, "ZA1", smart.SerialNumber)
+	assert.Equal(t, "ST4000DM000", smart.Model)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), smart.Timestamp)
+	assert.Equal(t, 150.0, smart.ReadErrorRate)
+	assert.Equal(t, 30, smart.Temperature)
+	assert.Equal(t, int64(25000), smart.PowerOnHours)
+	assert.Equal(t, SMARTAttribute{ID: 5, Name: "Reallocated_Sector_Ct", Value: 100, Status: "OK"}, smart.Attributes[5])
+
+	second, err := r.Next()
+	require.NoError(t, err)
+	assert.True(t, second.Failed)
+	assert.Equal(t, 200, second.SMART.ReallocatedSectors)
+	assert.Equal(t, int64(8), second.SMART.Attributes[197].RawValue)
+	assert.NotContains(t, second.SMART.Attributes, 1)
+	assert.Equal(t, enum.Failed, second.SMART.OverallStatus)
+
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = NewBackblazeReader(strings.NewReader("serial_number,model\n"))
+	assert.Error(t, err)
+}
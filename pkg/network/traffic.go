@@ -39,11 +39,11 @@ type TrafficAnalyzer interface {
 
 // NetworkTrafficAnalyzer implements the TrafficAnalyzer interface.
 type NetworkTrafficAnalyzer struct {
-	ebpfMonitor *ebpf.Monitor
+	ebpfMonitor ebpf.Monitor
 }
 
 // NewNetworkTrafficAnalyzer creates a new NetworkTrafficAnalyzer instance.
-func NewNetworkTrafficAnalyzer(ebpfMonitor *ebpf.Monitor) *NetworkTrafficAnalyzer {
+func NewNetworkTrafficAnalyzer(ebpfMonitor ebpf.Monitor) *NetworkTrafficAnalyzer {
 	return &NetworkTrafficAnalyzer{
 		ebpfMonitor: ebpfMonitor,
 	}
@@ -56,9 +56,9 @@ func (a *NetworkTrafficAnalyzer) AnalyzeTraffic(interfaceName string) (*TrafficM
 	}
 
 	// Fetch eBPF network metrics
-	ebpfMetrics, err := a.ebpfMonitor.GetNetworkMetrics()
+	ebpfMetrics, err := a.interfaceMetrics(interfaceName)
 	if err != nil {
-		return nil, errors.NewNetworkFailure("failed to collect network metrics", err)
+		return nil, err
 	}
 
 	// Simplified analysis: derive additional metrics from eBPF data
@@ -91,9 +91,9 @@ func (a *NetworkTrafficAnalyzer) MonitorLatency(interfaceName string, window tim
 	}
 
 	// Fetch current eBPF metrics
-	currentMetrics, err := a.ebpfMonitor.GetNetworkMetrics()
+	currentMetrics, err := a.interfaceMetrics(interfaceName)
 	if err != nil {
-		return nil, errors.NewNetworkFailure("failed to collect network metrics for latency monitoring", err)
+		return nil, errors.Wrap(err, "latency monitoring")
 	}
 
 	// Initialize latency metrics
@@ -125,3 +125,16 @@ func (a *NetworkTrafficAnalyzer) MonitorLatency(interfaceName string, window tim
 
 	return metrics, nil
 }
+
+// interfaceMetrics returns the eBPF metrics collected for a single interface.
+func (a *NetworkTrafficAnalyzer) interfaceMetrics(interfaceName string) (*ebpf.NetworkMetrics, error) {
+	all, err := a.ebpfMonitor.GetNetworkMetrics()
+	if err != nil {
+		return nil, errors.NewNetworkPacketLoss("failed to collect network metrics", err)
+	}
+	metrics, ok := all[interfaceName]
+	if !ok {
+		return nil, errors.NewNotFound("network interface "+interfaceName+" not found", nil)
+	}
+	return metrics, nil
+}
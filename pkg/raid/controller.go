@@ -38,11 +38,11 @@ type Controller interface {
 // RAIDController implements the Controller interface.
 type RAIDController struct {
 	config  *Config
-	monitor *ebpf.Monitor
+	monitor ebpf.Monitor
 }
 
 // NewRAIDController creates a new RAIDController instance.
-func NewRAIDController(config *Config, monitor *ebpf.Monitor) *RAIDController {
+func NewRAIDController(config *Config, monitor ebpf.Monitor) *RAIDController {
 	return &RAIDController{
 		config:  config,
 		monitor: monitor,
@@ -115,10 +115,14 @@ func (c *RAIDController) CheckHealth(controllerID string) (HealthStatus, error)
 
 // GetMetrics retrieves RAID controller metrics using eBPF.
 func (c *RAIDController) GetMetrics(controllerID string) (*ebpf.RAIDMetrics, error) {
-	metrics, err := c.monitor.GetRAIDMetrics()
+	all, err := c.monitor.GetRAIDMetrics()
 	if err != nil {
 		return nil, errors.NewQueueOverflow("failed to get RAID metrics", err)
 	}
+	metrics, ok := all[controllerID]
+	if !ok {
+		return nil, errors.NewNotFound("RAID controller "+controllerID+" not found", nil)
+	}
 
 	logger.Info("collected RAID metrics",
 		zap.String("controller_id", controllerID),